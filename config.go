@@ -29,9 +29,16 @@ import (
 )
 
 type Config struct {
-	Servers        []irc.Config
-	LogLevel       string `toml:"log_level"`
-	RunDebugServer bool   `toml:"run_debug_server"`
+	Servers                []irc.Config
+	LogLevel               string   `toml:"log_level"`
+	LogModuleLevels        []string `toml:"log_module_levels"`
+	LogFormat              string   `toml:"log_format"`
+	LogOutput              string   `toml:"log_output"`
+	LogFile                string   `toml:"log_file"`
+	RunDebugServer         bool     `toml:"run_debug_server"`
+	DebugServerAddress     string   `toml:"debug_server_address"`
+	ShutdownTimeoutSeconds int      `toml:"shutdown_timeout"`
+	HealthAddress          string   `toml:"health_address"`
 }
 
 func loadConfig() *Config {
@@ -43,11 +50,35 @@ func loadConfig() *Config {
 	return config
 }
 
+// Load the configuration again for a rehash. Unlike at startup, a bad file can't take the server
+// down.
+func reloadServerConfigs() ([]irc.Config, error) {
+	m := multiconfig.NewWithPath("pyx-irc.toml")
+	config := new(Config)
+	if err := m.Load(config); err != nil {
+		return nil, err
+	}
+	config.EnsureDefaults()
+	return config.Servers, nil
+}
+
 func (config *Config) EnsureDefaults() {
 	for i := range config.Servers {
 		(&config.Servers[i]).EnsureDefaults()
 	}
+	if config.ShutdownTimeoutSeconds <= 0 {
+		config.ShutdownTimeoutSeconds = 10
+	}
 	if config.LogLevel == "" {
 		config.LogLevel = "INFO"
 	}
+	if config.DebugServerAddress == "" {
+		config.DebugServerAddress = "localhost:6680"
+	}
+	if config.LogFormat == "" {
+		config.LogFormat = "text"
+	}
+	if config.LogOutput == "" {
+		config.LogOutput = "stderr"
+	}
 }
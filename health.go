@@ -0,0 +1,125 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/ajanata/pyx-irc/irc"
+	"github.com/ajanata/pyx-irc/pyx"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// don't hit the PYX servers on every single health check
+const healthProbeCacheTime = 30 * time.Second
+
+var started = time.Now()
+
+type healthResponse struct {
+	Status        string         `json:"status"`
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	Clients       int            `json:"clients"`
+	Servers       []serverHealth `json:"servers"`
+}
+
+type serverHealth struct {
+	Port    int         `json:"port"`
+	Clients int         `json:"clients"`
+	Pyx     []pyxHealth `json:"pyx"`
+}
+
+type pyxHealth struct {
+	Name          string `json:"name,omitempty"`
+	Address       string `json:"address"`
+	Ok            bool   `json:"ok"`
+	Error         string `json:"error,omitempty"`
+	ServerStarted int64  `json:"server_started,omitempty"`
+}
+
+type probeResult struct {
+	checked       time.Time
+	serverStarted int64
+	err           error
+}
+
+var probesLock sync.Mutex
+var probes = make(map[string]*probeResult)
+
+// Probes the PYX server, or uses the last probe if it was recent enough.
+func probePyx(config *pyx.Config) *probeResult {
+	probesLock.Lock()
+	defer probesLock.Unlock()
+	result, ok := probes[config.BaseAddress]
+	if !ok || time.Since(result.checked) > healthProbeCacheTime {
+		serverStarted, err := pyx.Probe(config)
+		result = &probeResult{checked: time.Now(), serverStarted: serverStarted, err: err}
+		probes[config.BaseAddress] = result
+	}
+	return result
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{
+		Status:        "ok",
+		UptimeSeconds: int64(time.Since(started).Seconds()),
+		Servers:       []serverHealth{},
+	}
+	for _, status := range irc.Status() {
+		server := serverHealth{
+			Port:    status.Port,
+			Clients: status.Clients,
+		}
+		for _, config := range status.Pyx {
+			probe := probePyx(config)
+			upstream := pyxHealth{
+				Name:          config.Name,
+				Address:       config.BaseAddress,
+				Ok:            probe.err == nil,
+				ServerStarted: probe.serverStarted,
+			}
+			if probe.err != nil {
+				upstream.Error = probe.err.Error()
+				resp.Status = "degraded"
+			}
+			server.Pyx = append(server.Pyx, upstream)
+		}
+		resp.Clients += status.Clients
+		resp.Servers = append(resp.Servers, server)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func runHealthServer(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", handleHealth)
+	mux.HandleFunc("/metrics", handleMetrics)
+	log.Infof("Starting health server on %s", address)
+	log.Error(http.ListenAndServe(address, mux))
+}
@@ -0,0 +1,66 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Letting clients see who is verified with PYX through IRCv3 extended-join and account-tag, instead
+// of having to work it out from voice and the hostname
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+)
+
+// The account name for nick: their nick if PYX has verified them with an id code (or they're an
+// admin), or * if not.
+func (client *Client) accountName(nick string) string {
+	sigil, bareNick := splitSigil(nick)
+	if sigil == pyx.Sigil_NORMAL_USER {
+		sigil = client.sigils.get(client.config.fold(bareNick))
+	}
+	if sigil == pyx.Sigil_ID_CODE || sigil == pyx.Sigil_ADMIN {
+		return bareNick
+	}
+	return "*"
+}
+
+// A JOIN of nick to channel, with their account and real name if the client wants them.
+func (client *Client) joinLine(nick string, channel string) string {
+	if client.hasCap("extended-join") {
+		return newLine(client.getNickUserAtHost(nick), "JOIN").
+			param(channel, client.accountName(nick)).text(nick).String()
+	}
+	return newLine(client.getNickUserAtHost(nick), "JOIN").text(channel).String()
+}
+
+// Tags to put in front of a message from nick, including the trailing space if there are any.
+func (client *Client) accountTag(nick string) string {
+	if !client.hasCap("account-tag") {
+		return ""
+	}
+	account := client.accountName(nick)
+	if account == "*" {
+		// not logged in is shown by leaving it off
+		return ""
+	}
+	return "@account=" + account + " "
+}
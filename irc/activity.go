@@ -0,0 +1,112 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// When we last saw people say something, for WHO, WHOIS, and away-notify
+
+package irc
+
+import (
+	"strings"
+	"time"
+)
+
+// how often to look for people who have gone idle, for away-notify
+const awaySweepInterval = time.Minute
+
+// Someone said something.
+func (r *roster) touch(nick string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.active[strings.ToLower(nick)] = time.Now()
+}
+
+// When we last saw nick say something, or the zero time if we haven't.
+func (r *roster) lastActive(nick string) time.Time {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.active[strings.ToLower(nick)]
+}
+
+// Everyone we've seen talk, who hasn't since before the cutoff.
+func (r *roster) idleSince(cutoff time.Time) []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	idle := []string{}
+	for key, last := range r.active {
+		if last.Before(cutoff) {
+			if name, ok := r.users[key]; ok {
+				_, nick := splitSigil(name)
+				idle = append(idle, nick)
+			}
+		}
+	}
+	return idle
+}
+
+func (client *Client) awayIdle() time.Duration {
+	return time.Duration(client.config.AwayIdleSeconds) * time.Second
+}
+
+// People we've seen talk are away once they've been quiet long enough. We can't tell for anyone
+// else, so they're always here.
+func (client *Client) isAway(nick string) bool {
+	last := client.roster.lastActive(nick)
+	return !last.IsZero() && time.Since(last) > client.awayIdle()
+}
+
+// How long nick has been idle, using what PYX says unless we've seen them talk more recently.
+func (client *Client) idleTime(nick string, pyxIdle time.Duration) time.Duration {
+	last := client.roster.lastActive(nick)
+	if last.IsZero() {
+		return pyxIdle
+	}
+	if seen := time.Since(last); seen < pyxIdle {
+		return seen
+	}
+	return pyxIdle
+}
+
+// Someone talked. If we told the client they were away, they're back now.
+func (client *Client) noteActivity(nick string) {
+	client.roster.touch(nick)
+	key := client.config.fold(nick)
+	if client.awayNotified[key] {
+		delete(client.awayNotified, key)
+		client.data.push(newLine(client.getNickUserAtHost(nick), "AWAY").String())
+	}
+}
+
+// Tell away-notify clients about people who have gone quiet since the last time we looked.
+func (client *Client) sweepAway() {
+	if !client.hasCap("away-notify") {
+		return
+	}
+	for _, nick := range client.roster.idleSince(time.Now().Add(-client.awayIdle())) {
+		key := client.config.fold(nick)
+		if client.awayNotified[key] || client.config.equalFold(nick, client.nick) {
+			continue
+		}
+		client.awayNotified[key] = true
+		client.data.push(newLine(client.getNickUserAtHost(nick), "AWAY").text("Idle").String())
+	}
+}
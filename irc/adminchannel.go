@@ -0,0 +1,278 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// The local channel where bridge operators see what the bridge is doing
+
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const AdminChannelTopic = "Bridge status and admin commands"
+
+type AdminCommand struct {
+	handler BotCommandFunc
+	// arguments, if any, shown in HELP
+	usage string
+	help  string
+}
+
+// Commands operators can give in the admin channel, with the bot command prefix.
+var AdminCommands map[string]AdminCommand
+
+// Have to do this in init since HELP needs to look at the map.
+func init() {
+	AdminCommands = map[string]AdminCommand{
+		"CLIENTS": {
+			handler: adminClients,
+			help:    "List every connection to the bridge.",
+		},
+		"HELP": {
+			handler: adminHelp,
+			help:    "Show the available admin commands.",
+		},
+		"KILL": {
+			handler: adminKill,
+			usage:   "<nick> [reason]",
+			help:    "Disconnect someone from the bridge.",
+		},
+		"KLINE": {
+			handler: adminKline,
+			usage:   "<ip, cidr, or nick mask> [reason]",
+			help:    "Ban someone from the bridge.",
+		},
+		"KLINES": {
+			handler: adminKlines,
+			help:    "List the bridge bans.",
+		},
+		"STATUS": {
+			handler: adminStatus,
+			help:    "Show how many clients are connected to each listener.",
+		},
+		"UNKLINE": {
+			handler: adminUnkline,
+			usage:   "<mask>",
+			help:    "Remove a bridge ban.",
+		},
+	}
+}
+
+// Everyone in the admin channel, on every listener, with the nick they had when they joined.
+// Clients are removed when they unregister, so nobody keeps sending to a closed connection.
+var adminChannel = struct {
+	lock    sync.Mutex
+	members map[*Client]string
+}{members: make(map[*Client]string)}
+
+func (client *Client) isAdminChannel(channel string) bool {
+	return client.config.equalFold(channel, client.config.AdminChannel)
+}
+
+func (client *Client) joinAdminChannel() {
+	adminChannel.lock.Lock()
+	if _, ok := adminChannel.members[client]; ok {
+		adminChannel.lock.Unlock()
+		return
+	}
+	adminChannel.members[client] = client.nick
+	adminChannel.lock.Unlock()
+
+	channel := client.config.AdminChannel
+	client.data.push(client.joinLine(client.nick, channel))
+	client.handleTopicImpl(channel)
+	client.adminChannelNames()
+}
+
+// Returns false if they weren't in it.
+func (client *Client) partAdminChannel() bool {
+	adminChannel.lock.Lock()
+	defer adminChannel.lock.Unlock()
+	_, ok := adminChannel.members[client]
+	delete(adminChannel.members, client)
+	return ok
+}
+
+func (client *Client) inAdminChannel() bool {
+	adminChannel.lock.Lock()
+	defer adminChannel.lock.Unlock()
+	_, ok := adminChannel.members[client]
+	return ok
+}
+
+func (client *Client) adminChannelNames() {
+	adminChannel.lock.Lock()
+	names := []string{"@" + client.config.BotNick}
+	for _, nick := range adminChannel.members {
+		names = append(names, "@"+nick)
+	}
+	adminChannel.lock.Unlock()
+	sort.Strings(names)
+	for _, line := range joinIntoLines(300, names, " ") {
+		client.data.push(client.n.format(RplNames, client.nick, "= %s :%s",
+			client.config.AdminChannel, line))
+	}
+	client.data.push(client.n.format(RplEndNames, client.nick, "%s :End of /NAMES list",
+		client.config.AdminChannel))
+}
+
+// Send a line to everyone in the admin channel, made by format with their config and nick.
+func sendToAdminChannel(except *Client, format func(member *Client, nick string) string) {
+	adminChannel.lock.Lock()
+	defer adminChannel.lock.Unlock()
+	for member, nick := range adminChannel.members {
+		if member != except {
+			member.data.push(format(member, nick))
+		}
+	}
+}
+
+// Tell the operators about something, both in the admin channel and with a server notice to
+// anyone whose snomask includes the category.
+func announceToAdmins(category byte, format string, args ...interface{}) {
+	text := fmt.Sprintf(format, args...)
+	sendToAdminChannel(nil, func(member *Client, nick string) string {
+		return fmt.Sprintf(":%s PRIVMSG %s :%s", member.botNickUserAtHost(),
+			member.config.AdminChannel, text)
+	})
+	sendSnotice(category, text)
+}
+
+// Handle something said in the admin channel: either a command, or chat for the other operators.
+func (client *Client) adminChannelPrivmsg(text string) {
+	if !client.inAdminChannel() {
+		client.data.push(client.n.format(ErrCannotSendToChan, client.nick,
+			"%s :Cannot send to channel", client.config.AdminChannel))
+		return
+	}
+	if strings.HasPrefix(text, BotCommandPrefix) {
+		words := strings.Fields(text[len(BotCommandPrefix):])
+		if len(words) > 0 {
+			if command, ok := AdminCommands[strings.ToUpper(words[0])]; ok {
+				log.Infof("Running admin command %s for %s with %v", words[0], client.nick,
+					words[1:])
+				command.handler(client, client.botReplyTo(client.config.AdminChannel), words[1:])
+				return
+			}
+		}
+	}
+	prefix := client.getNickUserAtHost(client.nick)
+	sendToAdminChannel(client, func(member *Client, nick string) string {
+		return fmt.Sprintf(":%s PRIVMSG %s :%s", prefix, member.config.AdminChannel, text)
+	})
+}
+
+func adminHelp(client *Client, reply BotReplyFunc, args []string) {
+	names := []string{}
+	for name := range AdminCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		command := AdminCommands[name]
+		reply("%s%s %s: %s", BotCommandPrefix, name, command.usage, command.help)
+	}
+}
+
+func adminStatus(client *Client, reply BotReplyFunc, args []string) {
+	for _, status := range Status() {
+		addresses := []string{}
+		for _, config := range status.Pyx {
+			addresses = append(addresses, config.BaseAddress)
+		}
+		reply("Port %d: %d clients, PYX %s", status.Port, status.Clients,
+			strings.Join(addresses, ", "))
+	}
+}
+
+func adminClients(client *Client, reply BotReplyFunc, args []string) {
+	clients := ListClients()
+	sort.Slice(clients, func(i, j int) bool {
+		return strings.ToLower(clients[i].Nick) < strings.ToLower(clients[j].Nick)
+	})
+	for _, info := range clients {
+		nick := info.Nick
+		if !info.Registered {
+			nick = nick + " (unregistered)"
+		}
+		reply("%s from %s on %d, idle %s, PYX session %s", nick, info.Addr, info.Port,
+			info.Idle.Round(time.Second), info.PyxSession)
+	}
+	reply("%d connections.", len(clients))
+}
+
+func adminKill(client *Client, reply BotReplyFunc, args []string) {
+	if len(args) == 0 {
+		reply("Usage: %sKILL <nick> [reason]", BotCommandPrefix)
+		return
+	}
+	if !client.kill(args[0], strings.Join(args[1:], " ")) {
+		reply("%s is not connected to the bridge.", args[0])
+	}
+}
+
+// Disconnect someone, telling the other operators about it. Returns false if they aren't
+// connected.
+func (client *Client) kill(nick string, reason string) bool {
+	if reason == "" {
+		reason = "No reason given"
+	}
+	if !KillClient(nick, fmt.Sprintf("Killed by %s: %s", client.nick, reason)) {
+		return false
+	}
+	announceToAdmins(SnoKill, "%s killed %s: %s", client.nick, nick, reason)
+	return true
+}
+
+func adminKline(client *Client, reply BotReplyFunc, args []string) {
+	if len(args) == 0 {
+		reply("Usage: %sKLINE <ip, cidr, or nick mask> [reason]", BotCommandPrefix)
+		return
+	}
+	reason := strings.Join(args[1:], " ")
+	if reason == "" {
+		reason = "No reason given"
+	}
+	client.kline(args[0], reason, reply)
+}
+
+func adminUnkline(client *Client, reply BotReplyFunc, args []string) {
+	if len(args) == 0 {
+		reply("Usage: %sUNKLINE <mask>", BotCommandPrefix)
+		return
+	}
+	client.unkline(args[0], reply)
+}
+
+func adminKlines(client *Client, reply BotReplyFunc, args []string) {
+	klines := getKlines(client.config).all()
+	for _, kline := range klines {
+		reply("%s by %s on %s: %s", kline.Mask, kline.SetBy, kline.Set.Format(time.RFC1123),
+			kline.Reason)
+	}
+	reply("%d K-lines.", len(klines))
+}
@@ -0,0 +1,510 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Bot command handlers
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"sort"
+	"strings"
+)
+
+// Prefix for bot commands given in a channel instead of in a private message to the bot.
+const BotCommandPrefix = "!"
+
+// PYX uses the chat message length limit for blank cards as well.
+const MaxWriteInLength = 200
+
+// Send a reply to a bot command to wherever the command came from.
+type BotReplyFunc func(format string, args ...interface{})
+type BotCommandFunc func(*Client, BotReplyFunc, []string)
+type BotTextCommandFunc func(*Client, BotReplyFunc, string)
+
+type BotCommand struct {
+	handler BotCommandFunc
+	// used instead of handler by commands that need their arguments exactly as they were sent
+	textHandler BotTextCommandFunc
+	// arguments, if any, shown in HELP
+	usage string
+	help  string
+	// if the user has to be in a game to use this command
+	needsGame bool
+}
+
+// Names may be more than one word. The longest match is used.
+var BotCommands map[string]BotCommand
+
+// Have to do this in init since HELP needs to look at the map.
+func init() {
+	BotCommands = map[string]BotCommand{
+		"ADD DECK": {
+			handler:   botAddDeck,
+			usage:     "<code>",
+			help:      "Add a Cardcast deck to the game you are hosting.",
+			needsGame: true,
+		},
+		"CARD SETS": {
+			handler: botCardSets,
+			help:    "List the card sets on the server, for use in game options.",
+		},
+		"DECKS": {
+			handler:   botDecks,
+			help:      "List the Cardcast decks in the game you are in.",
+			needsGame: true,
+		},
+		"GAME INFO": {
+			handler:   botGameInfo,
+			help:      "Show information about the game you are in.",
+			needsGame: true,
+		},
+		"HAND": {
+			handler:   botHand,
+			help:      "Show the cards in your hand.",
+			needsGame: true,
+		},
+		"HELP": {
+			handler: botHelp,
+			usage:   "[command]",
+			help:    "Show the available commands, or help for one command.",
+		},
+		"IGNORE": {
+			handler: botIgnore,
+			usage:   "[nick ...]",
+			help:    "Stop seeing chat from someone, or list who you are ignoring.",
+		},
+		"PLAY": {
+			textHandler: botPlay,
+			usage:       "<card> [<card> ...] | <card> [\"text for a blank card\"]",
+			help:        "Play cards from your hand, in order for black cards that pick several.",
+			needsGame:   true,
+		},
+		"PLAYBACK": {
+			handler: botPlayback,
+			usage:   "[minutes]",
+			help:    "Show what was said in your channels recently.",
+		},
+		"PREFERENCES": {
+			handler: botPreferences,
+			help:    "Show your preferences.",
+		},
+		"REMOVE DECK": {
+			handler:   botRemoveDeck,
+			usage:     "<code>",
+			help:      "Remove a Cardcast deck from the game you are hosting.",
+			needsGame: true,
+		},
+		"SCORES": {
+			handler:   botScores,
+			help:      "Show the current scores for the game you are in.",
+			needsGame: true,
+		},
+		"SET": {
+			handler: botSet,
+			usage:   "<preference> [value]",
+			help:    "Change one of your preferences. Leave out the value to reset it.",
+		},
+		"TRANSCRIPT": {
+			handler:   botTranscript,
+			help:      "Show the history of each round of the current game.",
+			needsGame: true,
+		},
+		"UNIGNORE": {
+			handler: botUnignore,
+			usage:   "<nick> [nick ...]",
+			help:    "Start seeing chat from someone again.",
+		},
+		"WHO'S JUDGE": {
+			handler:   botWhosJudge,
+			help:      "Show who is judging the current round.",
+			needsGame: true,
+		},
+	}
+}
+
+// Find the bot command at the start of text. Returns the command name and the remaining
+// arguments, both split into words and as they were sent, or an empty name if there isn't a
+// command there.
+func parseBotCommand(text string) (string, []string, string) {
+	words := strings.Fields(text)
+	for n := len(words); n > 0; n-- {
+		name := strings.ToUpper(strings.Join(words[:n], " "))
+		if _, ok := BotCommands[name]; ok {
+			rest := text
+			for i := 0; i < n; i++ {
+				_, rest = nextWord(rest)
+			}
+			return name, words[n:], strings.TrimSpace(rest)
+		}
+	}
+	return "", words, strings.TrimSpace(text)
+}
+
+// Split the first word off text, returning it and everything after it.
+func nextWord(text string) (string, string) {
+	text = strings.TrimLeft(text, " \t")
+	if end := strings.IndexAny(text, " \t"); end >= 0 {
+		return text[:end], text[end:]
+	}
+	return text, ""
+}
+
+// Handle a bot command sent in a private message to the bot. Anything that isn't a command gets a
+// pointer to HELP.
+func (client *Client) handleBotPrivmsg(text string) {
+	reply := client.botReplyTo(client.nick)
+	name, args, rest := parseBotCommand(text)
+	if name == "" {
+		reply("Unknown command. Try HELP.")
+		return
+	}
+	client.runBotCommand(name, reply, args, rest)
+}
+
+// Handle a possible bot command said in a channel. Returns true if it was a command, otherwise the
+// text should be relayed as normal chat.
+func (client *Client) handleBotChannelCommand(channel string, text string) bool {
+	if !strings.HasPrefix(text, BotCommandPrefix) {
+		return false
+	}
+	if !client.config.equalFold(channel, client.config.GlobalChannel) &&
+		!client.config.equalFold(channel, client.getGameChannel()) {
+		// let the normal handling complain about it
+		return false
+	}
+	name, args, rest := parseBotCommand(text[len(BotCommandPrefix):])
+	if name == "" {
+		return false
+	}
+	client.runBotCommand(name, client.botReplyTo(channel), args, rest)
+	return true
+}
+
+func (client *Client) runBotCommand(name string, reply BotReplyFunc, args []string,
+	rest string) {
+	command := BotCommands[name]
+	if command.needsGame && !client.inAnyGame() {
+		reply("You are not in a game.")
+		return
+	}
+	log.Debugf("Running bot command %s for %s with %v", name, client.nick, args)
+	if command.textHandler != nil {
+		command.textHandler(client, reply, rest)
+		return
+	}
+	command.handler(client, reply, args)
+}
+
+func (client *Client) botReplyTo(target string) BotReplyFunc {
+	return func(format string, args ...interface{}) {
+		client.data.push(newLine(client.botNickUserAtHost(), "PRIVMSG").param(target).
+			textf(format, args...).String())
+	}
+}
+
+// Something just for us, that shouldn't go in a channel.
+func (client *Client) botNotice(format string, args ...interface{}) {
+	client.data.push(newLine(client.botNickUserAtHost(), "NOTICE").param(client.nick).
+		textf(format, args...).String())
+}
+
+func botHelp(client *Client, reply BotReplyFunc, args []string) {
+	if len(args) > 0 {
+		name, _, _ := parseBotCommand(strings.Join(args, " "))
+		if name == "" {
+			reply("No such command %s.", strings.Join(args, " "))
+			return
+		}
+		command := BotCommands[name]
+		reply("%s %s: %s", name, command.usage, command.help)
+		return
+	}
+
+	names := []string{}
+	for name := range BotCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reply("Message me with a command, or say it in a channel starting with %s. Commands:",
+		BotCommandPrefix)
+	for _, name := range names {
+		command := BotCommands[name]
+		if command.usage != "" {
+			reply("  %s %s: %s", name, command.usage, command.help)
+		} else {
+			reply("  %s: %s", name, command.help)
+		}
+	}
+}
+
+func botScores(client *Client, reply BotReplyFunc, args []string) {
+	err := client.showScoreboard(reply)
+	if err != nil {
+		reply("Unable to retrieve the scores: %s", err)
+	}
+}
+
+func botWhosJudge(client *Client, reply BotReplyFunc, args []string) {
+	resp, err := client.gameInfo()
+	if err != nil {
+		reply("Unable to retrieve game information: %s", err)
+		return
+	}
+	if resp.GameInfo.State == pyx.GameState_LOBBY {
+		reply("The game has not started yet.")
+		return
+	}
+	_, judge, err := client.gameJudge()
+	if err != nil {
+		reply("Unable to retrieve game information: %s", err)
+		return
+	}
+	if judge == client.pyx().User.Name {
+		reply("You are judging this round.")
+	} else {
+		reply("The judge this round is %s.", judge)
+	}
+}
+
+func botGameInfo(client *Client, reply BotReplyFunc, args []string) {
+	resp, err := client.gameInfo()
+	if err != nil {
+		reply("Unable to retrieve game information: %s", err)
+		return
+	}
+	client.describeGame(reply, client.getGameChannel(), &resp.GameInfo, client.gameCustomDecks)
+}
+
+func (client *Client) describeGame(reply BotReplyFunc, channel string, game *pyx.GameInfo,
+	customDecks []pyx.CardSetData) {
+	reply("%s: %s", channel, makeGameTopic(game, client.pyx().CardSetNames(game.GameOptions.CardSets),
+		customDecks))
+	// TODO a proper length based on 512 minus broilerplate
+	if len(game.Players) > 0 {
+		for _, line := range joinIntoLines(300, game.Players, ", ") {
+			reply("Players: %s", line)
+		}
+	}
+	if len(game.Spectators) > 0 {
+		for _, line := range joinIntoLines(300, game.Spectators, ", ") {
+			reply("Spectators: %s", line)
+		}
+	}
+}
+
+func botCardSets(client *Client, reply BotReplyFunc, args []string) {
+	for _, cardSet := range client.pyx().CardSets() {
+		base := ""
+		if cardSet.BaseDeck {
+			base = ", base deck"
+		}
+		reply("%d: %s (%d black cards, %d white cards%s)", cardSet.Id, cardSet.CardSetName,
+			cardSet.BlackCardsInDeck, cardSet.WhiteCardsInDeck, base)
+	}
+}
+
+func botDecks(client *Client, reply BotReplyFunc, args []string) {
+	client.refreshCustomDecks()
+	if len(client.gameCustomDecks) == 0 {
+		reply("There are no Cardcast decks in this game.")
+		return
+	}
+	for _, deck := range client.gameCustomDecks {
+		reply("%s: %s (%d black cards, %d white cards)", deck.CardcastCode(), deck.CardSetName,
+			deck.BlackCardsInDeck, deck.WhiteCardsInDeck)
+	}
+}
+
+func botAddDeck(client *Client, reply BotReplyFunc, args []string) {
+	code, ok := cardcastCodeArg(reply, args)
+	if !ok {
+		return
+	}
+	gameId, ok := client.botGame(reply)
+	if !ok {
+		return
+	}
+	// the server tells everyone in the game about it, so we'll find out via the event
+	_, err := client.pyx().CardcastAddCardset(gameId, code)
+	if err != nil {
+		reply("Unable to add Cardcast deck %s: %s", code, cardcastError(err))
+	}
+}
+
+func botRemoveDeck(client *Client, reply BotReplyFunc, args []string) {
+	code, ok := cardcastCodeArg(reply, args)
+	if !ok {
+		return
+	}
+	gameId, ok := client.botGame(reply)
+	if !ok {
+		return
+	}
+	_, err := client.pyx().CardcastRemoveCardset(gameId, code)
+	if err != nil {
+		reply("Unable to remove Cardcast deck %s: %s", code, cardcastError(err))
+	}
+}
+
+// runBotCommand already checked, but PYX could have taken us out of the game since then.
+func (client *Client) botGame(reply BotReplyFunc) (int, bool) {
+	gameId, ok := client.currentGame()
+	if !ok {
+		reply("You are not in a game.")
+	}
+	return gameId, ok
+}
+
+// Validate the Cardcast deck code argument, replying with an error if it is not valid.
+func cardcastCodeArg(reply BotReplyFunc, args []string) (string, bool) {
+	if len(args) != 1 {
+		reply("You must specify exactly one Cardcast deck code.")
+		return "", false
+	}
+	code := strings.ToUpper(args[0])
+	if len(code) != 5 {
+		reply(pyx.ErrorCodeMsgs[pyx.ErrorCode_CARDCAST_INVALID_ID])
+		return "", false
+	}
+	return code, true
+}
+
+func cardcastError(err error) string {
+	switch pyx.ErrorCode(err) {
+	case pyx.ErrorCode_NOT_GAME_HOST:
+		return "You're not the game host."
+	case pyx.ErrorCode_ALREADY_STARTED:
+		return "Decks cannot be changed while the game is in progress."
+	default:
+		return err.Error()
+	}
+}
+
+// Show the cards in our hand, with the number to use to play each.
+func (client *Client) showHand(reply BotReplyFunc) {
+	if len(client.gameHand) == 0 {
+		reply("You have no cards in your hand.")
+		return
+	}
+	reply("Your hand:")
+	for i, card := range client.gameHand {
+		reply("(Card %d) %s", i, client.whiteCardText(card))
+	}
+}
+
+func botHand(client *Client, reply BotReplyFunc, args []string) {
+	if client.gameIsSpectate {
+		reply("You are spectating this game.")
+		return
+	}
+	client.showHand(reply)
+}
+
+func botPlay(client *Client, reply BotReplyFunc, args string) {
+	if client.gameIsSpectate {
+		reply("You are spectating this game.")
+		return
+	}
+	if args == "" {
+		reply("You must specify which card to play.")
+		return
+	}
+	indexes, text, err := parsePlayArgs(args, client.gameHand)
+	if err != nil {
+		reply(err.Error())
+		return
+	}
+	if len(text) > MaxWriteInLength {
+		reply(pyx.ErrorCodeMsgs[pyx.ErrorCode_MESSAGE_TOO_LONG])
+		return
+	}
+	remaining := client.gameSelection.remaining()
+	if remaining == 0 {
+		reply("You have already played all of your cards this round.")
+		return
+	}
+	if remaining > 0 && len(indexes) > remaining {
+		reply("You can only play %s more this round.", countCards(remaining))
+		return
+	}
+
+	// the indexes are for the hand as they saw it, which changes as each card is played
+	cards := []pyx.WhiteCardData{}
+	for _, index := range indexes {
+		cards = append(cards, client.gameHand[index])
+	}
+	gameId, ok := client.botGame(reply)
+	if !ok {
+		return
+	}
+	played := []string{}
+	for _, card := range cards {
+		_, err := client.pyx().PlayCard(gameId, card.Id, text)
+		if err != nil {
+			client.playFailed(reply, err)
+			break
+		}
+		client.removeFromHand(card.Id)
+		if card.WriteIn {
+			played = append(played, "["+text+"]")
+		} else {
+			played = append(played, "["+card.Text+"]")
+		}
+		if client.gameSelection != nil {
+			client.gameSelection.played = append(client.gameSelection.played, played[len(played)-1])
+		}
+	}
+	if len(played) == 0 {
+		return
+	}
+	reply("You played %s.", strings.Join(played, " "))
+	if remaining := client.gameSelection.remaining(); remaining > 0 {
+		reply("Play %s more with PLAY <card>.", countCards(remaining))
+	} else if remaining == 0 && len(client.gameSelection.played) > len(played) {
+		reply("Your cards this round are %s.", strings.Join(client.gameSelection.played, " "))
+	}
+}
+
+func (client *Client) playFailed(reply BotReplyFunc, err error) {
+	switch pyx.ErrorCode(err) {
+	case pyx.ErrorCode_NOT_YOUR_TURN:
+		reply("It is not your turn to play a card.")
+	case pyx.ErrorCode_DO_NOT_HAVE_CARD:
+		// we're out of sync with the server, so get the real hand
+		client.refreshHand()
+		reply("You don't have that card. Use HAND to see your cards.")
+	default:
+		reply("Unable to play card: %s", err)
+	}
+}
+
+func botTranscript(client *Client, reply BotReplyFunc, args []string) {
+	if client.gameTranscript == nil || len(client.gameTranscript.rounds) == 0 {
+		reply("No rounds have been played yet.")
+		return
+	}
+	for _, line := range client.gameTranscript.lines() {
+		reply(line)
+	}
+}
@@ -0,0 +1,81 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"testing"
+)
+
+type botCommandTestPair struct {
+	input string
+	name  string
+	args  []string
+	rest  string
+}
+
+var botCommandTests = []botCommandTestPair{
+	{"", "", []string{}, ""},
+	{"help", "HELP", []string{}, ""},
+	{"HELP scores", "HELP", []string{"scores"}, "scores"},
+	{"  scores  ", "SCORES", []string{}, ""},
+	{"who's judge", "WHO'S JUDGE", []string{}, ""},
+	{"game info now", "GAME INFO", []string{"now"}, "now"},
+	{"game  info   two  spaces ", "GAME INFO", []string{"two", "spaces"}, "two  spaces"},
+	{"game", "", []string{"game"}, "game"},
+	{"hello there", "", []string{"hello", "there"}, "hello there"},
+}
+
+func TestParseBotCommand(t *testing.T) {
+	for _, test := range botCommandTests {
+		name, args, rest := parseBotCommand(test.input)
+		if name != test.name {
+			t.Error("For", test.input,
+				"expected name", test.name,
+				"got", name,
+			)
+		}
+		if rest != test.rest {
+			t.Error("For", test.input,
+				"expected rest", test.rest,
+				"got", rest,
+			)
+		}
+		if len(test.args) != len(args) {
+			t.Error("For", test.input,
+				"expected arg length", len(test.args),
+				"got", len(args),
+			)
+		} else {
+			for i := range test.args {
+				if test.args[i] != args[i] {
+					t.Error("For", test.input,
+						"expected arg", i,
+						"to be", test.args[i],
+						"got", args[i],
+					)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,335 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// IRCv3 capability negotiation
+
+package irc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Capabilities we support.
+var SupportedCaps = map[string]bool{
+	"account-tag":      true,
+	"away-notify":      true,
+	"batch":            true,
+	"cap-notify":       true,
+	"chghost":          true,
+	"echo-message":     true,
+	"extended-join":    true,
+	"labeled-response": true,
+	"sasl":             true,
+	"server-time":      true,
+	"setname":          true,
+	// lets bouncers ask for what was said while they were gone with PRIVMSG *playback
+	"znc.in/playback": true,
+	// what ZNC asks for to get the messages its users send from somewhere else
+	"znc.in/self-message": true,
+}
+
+// LS lines get split up so they stay well under the line length limit.
+const MaxCapLsLength = 400
+
+// The parts of what a listener offers that can change on REHASH.
+type capSet struct {
+	lock            sync.RWMutex
+	disabled        map[string]bool
+	saslRequiresTls bool
+	// bumped every time something is turned back on, so clients that had it before have to ask
+	// for it again
+	generation int
+	enabledAt  map[string]int
+}
+
+func newCapSet(config *Config) *capSet {
+	caps := &capSet{enabledAt: make(map[string]int)}
+	caps.update(config)
+	return caps
+}
+
+// Take the settings from config.
+func (caps *capSet) update(config *Config) {
+	disabled := make(map[string]bool)
+	for _, name := range config.DisabledCaps {
+		disabled[strings.ToLower(name)] = true
+	}
+	caps.lock.Lock()
+	defer caps.lock.Unlock()
+	caps.generation++
+	for name := range SupportedCaps {
+		if !disabled[name] && caps.disabled[name] {
+			caps.enabledAt[name] = caps.generation
+		}
+	}
+	if caps.saslRequiresTls && !config.SaslRequiresTls {
+		caps.enabledAt["sasl"] = caps.generation
+	}
+	caps.disabled = disabled
+	caps.saslRequiresTls = config.SaslRequiresTls
+}
+
+// lock must be held
+func (caps *capSet) offers(name string, secure bool) bool {
+	if caps.disabled[name] {
+		return false
+	}
+	// nobody should be sending their id code in the clear
+	return name != "sasl" || secure || !caps.saslRequiresTls
+}
+
+// The caps this client can have, with their values.
+func (client *Client) availableCaps() map[string]string {
+	client.offered.lock.RLock()
+	defer client.offered.lock.RUnlock()
+	available := make(map[string]string)
+	for name := range SupportedCaps {
+		if !client.offered.offers(name, client.secure) {
+			continue
+		}
+		available[name] = ""
+		if name == "sasl" {
+			available[name] = strings.Join(client.saslMechanisms(), ",")
+		}
+	}
+	return available
+}
+
+func handleCap(client *Client, msg Message) {
+	if len(msg.args) == 0 {
+		client.data.push(client.n.format(ErrNeedMoreParams, client.capTarget(),
+			"CAP :Not enough parameters"))
+		return
+	}
+
+	switch strings.ToUpper(msg.args[0]) {
+	case "LS":
+		if !client.registered {
+			// registration has to wait until they're done with this
+			client.capNegotiating = true
+		}
+		if len(msg.args) > 1 {
+			if version, err := strconv.Atoi(msg.args[1]); err == nil && version > client.capVersion {
+				client.capVersion = version
+			}
+		}
+		if client.capVersion >= 302 {
+			// 302 clients get told about changes without asking
+			client.setCap("cap-notify")
+		}
+		client.sendCapLs()
+	case "LIST":
+		client.sendCapReply("LIST", strings.Join(sortedCaps(client.enabledCaps()), " "))
+	case "REQ":
+		if !client.registered {
+			client.capNegotiating = true
+		}
+		requested := ""
+		if len(msg.args) > 1 {
+			requested = msg.args[1]
+		}
+		client.handleCapReq(requested)
+	case "END":
+		client.capNegotiating = false
+	default:
+		client.data.push(client.n.format(ErrInvalidCapCmd, client.capTarget(),
+			"%s :Invalid CAP command", msg.args[0]))
+	}
+}
+
+// 302 clients get the values, and the list split over as many lines as it takes.
+func (client *Client) sendCapLs() {
+	available := client.availableCaps()
+	var entries []string
+	for _, name := range sortedCaps(available) {
+		if client.capVersion >= 302 && available[name] != "" {
+			entries = append(entries, name+"="+available[name])
+		} else {
+			entries = append(entries, name)
+		}
+	}
+	if client.capVersion < 302 {
+		client.sendCapReply("LS", strings.Join(entries, " "))
+		return
+	}
+	line := ""
+	for _, entry := range entries {
+		if line != "" && len(line)+1+len(entry) > MaxCapLsLength {
+			client.data.push(client.n.format("CAP", client.capTarget(), "LS * :%s", line))
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += entry
+	}
+	client.sendCapReply("LS", line)
+}
+
+// Requests are all-or-nothing: if any of the requested capabilities aren't supported, none of them
+// are changed.
+func (client *Client) handleCapReq(requested string) {
+	caps := strings.Fields(requested)
+	available := client.availableCaps()
+	for _, c := range caps {
+		if _, ok := available[strings.TrimPrefix(c, "-")]; !ok {
+			client.sendCapReply("NAK", requested)
+			return
+		}
+	}
+	for _, c := range caps {
+		if strings.HasPrefix(c, "-") {
+			client.caps.remove(c[1:])
+		} else {
+			client.setCap(c)
+		}
+	}
+	client.sendCapReply("ACK", requested)
+}
+
+func (client *Client) setCap(name string) {
+	client.offered.lock.RLock()
+	defer client.offered.lock.RUnlock()
+	client.caps.set(name, client.offered.generation)
+}
+
+func (client *Client) sendCapReply(subcommand string, caps string) {
+	client.data.push(client.n.format("CAP", client.capTarget(), "%s :%s", subcommand, caps))
+}
+
+// CAP replies go to * if the client hasn't told us their nick yet.
+func (client *Client) capTarget() string {
+	if client.nick == "" {
+		return "*"
+	}
+	return client.nick
+}
+
+// A cap only counts if it hasn't been turned off since they asked for it.
+func (client *Client) hasCap(name string) bool {
+	generation, ok := client.caps.generation(name)
+	if !ok {
+		return false
+	}
+	client.offered.lock.RLock()
+	defer client.offered.lock.RUnlock()
+	return client.offered.offers(name, client.secure) && generation >= client.offered.enabledAt[name]
+}
+
+func (client *Client) enabledCaps() map[string]string {
+	enabled := make(map[string]string)
+	for _, name := range client.caps.names() {
+		if client.hasCap(name) {
+			enabled[name] = ""
+		}
+	}
+	return enabled
+}
+
+// If the client wants to know, tell it what changed between before and what's available now.
+func (client *Client) notifyCapChanges(before map[string]string) {
+	if !client.hasCap("cap-notify") {
+		return
+	}
+	after := client.availableCaps()
+	var removed, added []string
+	for _, name := range sortedCaps(before) {
+		if _, ok := after[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	for _, name := range sortedCaps(after) {
+		value, ok := before[name]
+		if ok && value == after[name] {
+			continue
+		}
+		if client.capVersion >= 302 && after[name] != "" {
+			added = append(added, name+"="+after[name])
+		} else {
+			added = append(added, name)
+		}
+	}
+	if len(removed) > 0 {
+		client.sendCapReply("DEL", strings.Join(removed, " "))
+	}
+	if len(added) > 0 {
+		client.sendCapReply("NEW", strings.Join(added, " "))
+	}
+}
+
+func sortedCaps(caps map[string]string) []string {
+	names := []string{}
+	for name := range caps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Either way of asking works for getting their own messages back once PYX has them.
+func (client *Client) wantsOwnMessages() bool {
+	return client.hasCap("echo-message") || client.hasCap("znc.in/self-message")
+}
+
+// What the client asked for, and the generation of what was offered when they did. CAP REQ can
+// come in after registration while PYX events are checking these, so they have their own lock.
+type requestedCaps struct {
+	lock sync.Mutex
+	caps map[string]int
+}
+
+func newRequestedCaps() *requestedCaps {
+	return &requestedCaps{caps: make(map[string]int)}
+}
+
+func (requested *requestedCaps) set(name string, generation int) {
+	requested.lock.Lock()
+	defer requested.lock.Unlock()
+	requested.caps[name] = generation
+}
+
+func (requested *requestedCaps) remove(name string) {
+	requested.lock.Lock()
+	defer requested.lock.Unlock()
+	delete(requested.caps, name)
+}
+
+func (requested *requestedCaps) generation(name string) (int, bool) {
+	requested.lock.Lock()
+	defer requested.lock.Unlock()
+	generation, ok := requested.caps[name]
+	return generation, ok
+}
+
+func (requested *requestedCaps) names() []string {
+	requested.lock.Lock()
+	defer requested.lock.Unlock()
+	names := make([]string, 0, len(requested.caps))
+	for name := range requested.caps {
+		names = append(names, name)
+	}
+	return names
+}
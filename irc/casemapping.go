@@ -0,0 +1,78 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Comparing nicks and channel names the way we tell clients we do, with CASEMAPPING
+
+package irc
+
+import (
+	"strings"
+)
+
+const (
+	CaseMapping_ASCII          = "ascii"
+	CaseMapping_RFC1459        = "rfc1459"
+	CaseMapping_STRICT_RFC1459 = "strict-rfc1459"
+)
+
+// Fold s to lower case according to mapping. Only ASCII is ever changed, so unlike strings.ToLower,
+// the result is always the same length.
+func foldCase(mapping string, s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		case mapping == CaseMapping_ASCII:
+			return r
+		// rfc1459 considers []\ to be the upper case of {}|
+		case r == '[' || r == ']' || r == '\\':
+			return r + '{' - '['
+		// and the non-strict version adds ~ as the upper case of ^
+		case r == '~' && mapping == CaseMapping_RFC1459:
+			return '^'
+		}
+		return r
+	}, s)
+}
+
+func validCaseMapping(mapping string) bool {
+	switch mapping {
+	case CaseMapping_ASCII, CaseMapping_RFC1459, CaseMapping_STRICT_RFC1459:
+		return true
+	}
+	return false
+}
+
+// The key to use for a nick or channel in maps.
+func (config *Config) fold(s string) string {
+	return foldCase(config.CaseMapping, s)
+}
+
+// Whether two nicks or channels are the same.
+func (config *Config) equalFold(left string, right string) bool {
+	return config.fold(left) == config.fold(right)
+}
+
+func (config *Config) hasPrefixFold(s string, prefix string) bool {
+	return len(s) >= len(prefix) && config.equalFold(s[:len(prefix)], prefix)
+}
@@ -0,0 +1,68 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"testing"
+)
+
+type foldCaseTestPair struct {
+	mapping string
+	s       string
+	folded  string
+}
+
+var foldCaseTests = []foldCaseTestPair{
+	{CaseMapping_ASCII, "#GLOBAL", "#global"},
+	{CaseMapping_ASCII, "Bob[]\\~", "bob[]\\~"},
+	{CaseMapping_ASCII, "ÄBC", "Äbc"},
+	{CaseMapping_RFC1459, "Bob[]\\~", "bob{}|^"},
+	{CaseMapping_STRICT_RFC1459, "Bob[]\\~", "bob{}|~"},
+}
+
+func TestFoldCase(t *testing.T) {
+	for _, pair := range foldCaseTests {
+		folded := foldCase(pair.mapping, pair.s)
+		if folded != pair.folded {
+			t.Error("For", pair.mapping, pair.s,
+				"expected", pair.folded,
+				"got", folded,
+			)
+		}
+	}
+}
+
+func TestGameChannelCase(t *testing.T) {
+	config := &Config{}
+	config.EnsureDefaults()
+	client := &Client{config: config}
+	id, spectate, err := client.getGameFromChannel("#GAME-12")
+	if err != nil || id != 12 || spectate {
+		t.Error("For #GAME-12 expected 12 false <nil> got", id, spectate, err)
+	}
+	id, spectate, err = client.getGameFromChannel("#Watch-3")
+	if err != nil || id != 3 || !spectate {
+		t.Error("For #Watch-3 expected 3 true <nil> got", id, spectate, err)
+	}
+}
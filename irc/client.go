@@ -28,34 +28,130 @@ import (
 	"fmt"
 	"github.com/ajanata/pyx-irc/pyx"
 	"net"
-	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-var validNickRegex = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]{2,29}$")
-
 // it'd probably be better if this didn't talk directly to the pyx stuff from here...
 type Client struct {
-	socket     net.Conn
-	addr       string
-	reader     *bufio.Scanner
-	writer     *bufio.Writer
-	data       chan string
-	close      chan bool
+	socket net.Conn
+	// the user's real address, which may have been provided by a web gateway
+	addr string
+	// the web gateway the user is connecting through, if any
+	gateway string
+	// shared with the manager to track connections per IP
+	limiter *connectionLimiter
+	// where they're connected, for finding other clients by nick
+	manager *Manager
+	// when we last heard anything from the client, in unix nanoseconds; use atomically
+	lastActivity int64
+	// raw I/O trace, toggled by admins
+	trace  *tracer
+	reader *bufio.Scanner
+	writer *bufio.Writer
+	data   *sendQueue
+	// asks the manager to close the connection
+	close chan bool
+	// closed when the connection has been torn down
+	done chan bool
+	// set once we've started disconnecting them; use atomically
+	disconnecting int32
+	// set while they've parted the global channel but are still on PYX; use atomically
+	partedGlobal int32
+	// holding back PYX events while a labeled command is handled
+	labeling   *labelState
 	registered bool
-	password   string
-	nick       string
-	hasUser    bool
-	pyx        *pyx.Client
+	// only the goroutine handling commands changes nick, registered, addr, and gateway, but
+	// anything else looking at them has to hold this
+	infoLock sync.Mutex
+	// registration is held until capability negotiation is over
+	capNegotiating bool
+	// the generation of what was offered when they asked for each one
+	caps *requestedCaps
+	// highest CAP LS version they've sent
+	capVersion int
+	// what's offered on the listener they're connected to
+	offered *capSet
+	// connected with TLS
+	secure   bool
+	password string
+	// what they gave for the bridge's own password, if it has one
+	bridgePassword string
+	// their PASS had an id code PYX won't accept
+	badIdCode bool
+	// SASL state
+	saslMechanism string
+	saslBuffer    strings.Builder
+	saslAccount   string
+	saslDone      bool
+	// SHA-256 of their TLS client certificate, if they have one
+	certFingerprint string
+	// the PYX server they picked, or the default one
+	pyxConfig *pyx.Config
+	nick      string
+	hasUser   bool
+	realname  string
+	// use pyx(), since logging back in after PYX restarts replaces it from the event goroutine
+	pyxSession *pyx.Client
+	pyxLock    sync.Mutex
 	config     *Config
 	n          *numerics
-	gameId     *int
+	// which game we're in; see gamemembership.go
+	game gameMembership
 	// if we are spectating the game we are in
 	gameIsSpectate bool
 	// the host of the game we are in, so we can notice if they leave
 	gameHost       string
 	gameInProgress bool
-	// the cards played in the most recently completed round
-	gamePlayedCards *[][]pyx.WhiteCardData
+	// Cardcast decks added to the game we are in
+	gameCustomDecks []pyx.CardSetData
+	// our hand, if we are playing
+	gameHand []pyx.WhiteCardData
+	// what we've played for the current black card
+	gameSelection *cardSelection
+	// fires shortly before the current round's timer runs out, if enabled
+	roundWarning    *time.Timer
+	roundWarningMsg string
+	// history of the current (or most recently finished) game
+	gameTranscript *transcript
+	gameState      string
+	// the black card for the current round, for the topic
+	gameBlackCard *pyx.BlackCardData
+	// status of each player in the current round
+	gamePlayerStatus map[string]string
+	// players we took voice away from because they have played this round
+	gameDevoiced []string
+	gameScores   scoreboard
+	gameRounds   roundCounter
+	// who we gave +a to for judging this round
+	gameJudgeMode string
+	// the last sigil we saw for each user, by lowercase nick
+	sigils *sigilMap
+	// when we last sent a KNOCK
+	lastKnock time.Time
+	prefs     Preferences
+	// guards prefs.Silence, which other clients check when whispering to us
+	silenceLock sync.Mutex
+	// people whose global channel join we haven't shown yet, by lowercase nick
+	quietNicks *nickSet
+	// everyone on the PYX server we're using
+	roster *roster
+	// nicks to tell them about coming and going, by lowercase nick
+	monitor *monitorSet
+	// info about the game we are in
+	gameCache *gameState
+	// games we're watching without a seat, by id
+	watching *watchList
+	// so we can tell when the global channel's topic changes
+	globalTopic globalTopic
+	// PYX went away, so log back in instead of disconnecting when the session ends, and hold off
+	// on commands until then; use atomically
+	pyxDown int32
+	// people we told an away-notify client are away, by lowercase nick
+	awayNotified map[string]bool
 }
 
 type ChannelInfo struct {
@@ -67,19 +163,53 @@ type ChannelInfo struct {
 func NewClient(connection net.Conn, config *Config) *Client {
 	addr, _, _ := net.SplitHostPort(connection.RemoteAddr().String())
 	return &Client{
-		socket: connection,
-		addr:   addr,
-		reader: bufio.NewScanner(connection),
-		writer: bufio.NewWriter(connection),
-		data:   make(chan string),
-		close:  make(chan bool),
-		config: config,
-		n:      newNumerics(config),
+		socket:       connection,
+		addr:         addr,
+		lastActivity: time.Now().UnixNano(),
+		trace:        newTracer(),
+		reader:       newLineScanner(connection),
+		writer:       bufio.NewWriterSize(connection, config.WriteBufferSize),
+		data:         newSendQueue(config.SendQueueLength, config.SendQueueDropOldest),
+		close:        make(chan bool, 1),
+		done:         make(chan bool),
+		labeling:     newLabelState(),
+		config:       config,
+		pyxConfig:    &config.Pyx,
+		n:            newNumerics(config),
+		caps:         newRequestedCaps(),
+		offered:      newCapSet(config),
+		sigils:       newSigilMap(),
+		quietNicks:   newNickSet(),
+		monitor:      newMonitorSet(),
+		gameCache:    &gameState{},
+		watching:     newWatchList(),
+		awayNotified: make(map[string]bool),
+	}
+}
+
+// The user's address for logging, including the gateway if they are using one.
+func (client *Client) remoteAddr() string {
+	if client.gateway != "" {
+		return fmt.Sprintf("%s (via %s %s)", client.addr, client.gateway,
+			client.socket.RemoteAddr())
 	}
+	return client.socket.RemoteAddr().String()
+}
+
+func (client *Client) touch() {
+	atomic.StoreInt64(&client.lastActivity, time.Now().UnixNano())
+}
+
+func (client *Client) idleSince() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&client.lastActivity))
 }
 
 func (client *Client) handleIncoming(raw string) {
 	msg := NewMessage(raw)
+	if label, _ := msg.tag("label"); label != "" && client.hasCap("labeled-response") {
+		client.startLabel(label)
+		defer client.endLabel()
+	}
 	if !client.registered {
 		client.handleIncomingUnregistered(msg)
 	} else {
@@ -90,69 +220,257 @@ func (client *Client) handleIncoming(raw string) {
 func (client *Client) handleIncomingUnregistered(msg Message) {
 	handler, ok := UnregisteredHandlers[msg.cmd]
 	if !ok {
-		client.data <- client.n.formatSimpleReply(ErrNotRegistered, msg.cmd,
-			"You have not registered")
+		client.data.push(client.n.formatSimpleReply(ErrNotRegistered, msg.cmd,
+			"You have not registered"))
 	} else {
 		handler(client, msg)
-		if client.nick != "" && client.hasUser {
-			log.Debugf("Client %s has fully registered as %s", client.socket.RemoteAddr(),
+		if client.nick != "" && client.hasUser && !client.capNegotiating {
+			log.Debugf("Client %s has fully registered as %s", client.remoteAddr(),
 				client.nick)
+			if kline := getKlines(client.config).find(client.nick, client.addr); kline != nil {
+				log.Infof("Rejecting %s from %s, K-lined by %s", client.nick, client.remoteAddr(),
+					kline.Mask)
+				announceToAdmins(SnoKill, "Rejected %s from %s, K-lined by %s", client.nick, client.addr,
+					kline.Mask)
+				client.disconnect("K-lined: " + kline.Reason)
+				return
+			}
+			if !client.checkBridgePassword() {
+				log.Infof("Rejecting %s from %s, wrong bridge password", client.nick,
+					client.remoteAddr())
+				announceToAdmins(SnoFailedLogin, "Rejected %s from %s, wrong bridge password",
+					client.nick, client.addr)
+				client.disconnect("Password incorrect")
+				return
+			}
+			if client.badIdCode {
+				client.disconnect(idCodeRules(client.pyxConfig))
+				return
+			}
+			client.useCertificateLogin()
+			// PASS can pick a different server after NICK, with different rules
+			if err := client.pyxConfig.ValidateNick(client.nick); err != nil {
+				client.rejectNick(err)
+				return
+			}
+			if isDraining(client.pyxConfig) {
+				client.disconnect("PYX is restarting, try again in a minute.")
+				return
+			}
+			client.roster = getRoster(client.pyxConfig)
 			err := client.logInToPyx()
+			if err != nil && client.rejectNick(err) {
+				// they can try another one
+				return
+			}
 			if err != nil {
 				log.Errorf("Unable to log in to PYX for %s: %v", client.nick, err)
+				announceToAdmins(SnoFailedLogin, "Unable to log in to PYX for %s from %s: %v", client.nick,
+					client.addr, err)
 				client.disconnect(err.Error())
 			} else {
-				client.registered = true
+				client.setRegistered()
+				client.roster.attach()
+				client.roster.setRealname(client.nick, client.realname)
+				client.manager.addNick(client)
+				client.setReachable()
+				client.loadPreferences()
+				announceToAdmins(SnoConnect, "%s connected from %s on %d", client.nick, client.addr,
+					client.config.Port)
 				client.sendWelcome()
 			}
 		}
 	}
 }
 
+// If PYX didn't like the nick, tell the client so it can pick another one instead of disconnecting.
+func (client *Client) rejectNick(err error) bool {
+	switch pyx.ErrorCode(err) {
+	case pyx.ErrorCode_NICK_IN_USE:
+		client.data.push(client.n.format(ErrNicknameInUse, "*", "%s :Nickname is already in use",
+			client.nick))
+	case pyx.ErrorCode_INVALID_NICK, pyx.ErrorCode_RESERVED_NICK:
+		client.data.push(client.n.format(ErrErroneousNickname, "*", "%s :Erroneous Nickname: %s",
+			client.nick, err))
+	default:
+		return false
+	}
+	log.Infof("PYX rejected nick %s for %s: %v", client.nick, client.remoteAddr(), err)
+	client.setNick("")
+	return true
+}
+
+func (client *Client) setNick(nick string) {
+	client.infoLock.Lock()
+	defer client.infoLock.Unlock()
+	client.nick = nick
+}
+
+func (client *Client) setRegistered() {
+	client.infoLock.Lock()
+	defer client.infoLock.Unlock()
+	client.registered = true
+}
+
+func (client *Client) setGateway(gateway string, addr string) {
+	client.infoLock.Lock()
+	defer client.infoLock.Unlock()
+	client.gateway = gateway
+	client.addr = addr
+}
+
+// What anything but the command goroutine can see about the connection.
+type clientSnapshot struct {
+	nick       string
+	registered bool
+	addr       string
+	remoteAddr string
+}
+
+func (client *Client) snapshot() clientSnapshot {
+	client.infoLock.Lock()
+	defer client.infoLock.Unlock()
+	return clientSnapshot{
+		nick:       client.nick,
+		registered: client.registered,
+		addr:       client.addr,
+		remoteAddr: client.remoteAddr(),
+	}
+}
+
+func (client *Client) isRegistered() bool {
+	return client.snapshot().registered
+}
+
+// The current PYX session, or nil before they've logged in.
+func (client *Client) pyx() *pyx.Client {
+	client.pyxLock.Lock()
+	defer client.pyxLock.Unlock()
+	return client.pyxSession
+}
+
+func (client *Client) setPyx(pyxClient *pyx.Client) {
+	client.pyxLock.Lock()
+	defer client.pyxLock.Unlock()
+	client.pyxSession = pyxClient
+}
+
 func (client *Client) logInToPyx() error {
 	log.Debugf("Attempting to log into PYX for %s", client.nick)
-	pyxClient, err := pyx.NewClient(client.nick, client.password, &client.config.Pyx)
+	pyxClient, err := pyx.NewClient(client.nick, client.password, client.pyxConfig)
 	if err != nil {
 		return err
 	}
 
-	client.pyx = pyxClient
-	go client.dispatchPyxEvents()
+	client.setPyx(pyxClient)
+	pyxClient.Trace = client.trace.printf
+	pyxClient.PollFailed = func(failures int, err error) {
+		announceToAdmins(SnoPyx, "PYX long poll for %s failed (%d/%d): %v", client.nick,
+			failures, client.pyxConfig.MaxPollFailures, err)
+	}
+	events := pyxClient.Subscribe()
+	pyxClient.Start()
+	go client.dispatchPyxEvents(pyxClient, events)
 	log.Infof("Logged in to PYX for %s", client.nick)
 	return nil
 }
 
 func (client *Client) handleIncomingRegistered(msg Message) {
 	handler, ok := RegisteredHandlers[msg.cmd]
-	if !ok {
-		client.data <- client.n.formatSimpleReply(ErrUnknownCommand, msg.cmd, "Unknown command")
+	if ok && client.isPyxDown() && !OfflineCommands[msg.cmd] {
+		// the session they'd be using is gone, and the new one isn't there yet
+		client.data.push(client.n.format(RplTryAgain, client.nick,
+			"%s :PYX is unavailable, please wait a while and try again.", msg.cmd))
+	} else if !ok {
+		client.data.push(client.n.formatSimpleReply(ErrUnknownCommand, msg.cmd, "Unknown command"))
 	} else {
 		handler(client, msg)
 	}
 }
 
-func (client *Client) dispatchPyxEvents() {
-	defer func() {
-		// this is dumb and really should be refactored to avoid
-		// this is also really bad cuz it'll eat segfaults
-		if r := recover(); r != nil {
-			log.Warningf("Recovered from panic, probably due to user quitting: %v", r)
-		}
-	}()
+func (client *Client) dispatchPyxEvents(pyxClient *pyx.Client, events <-chan pyx.Event) {
+	awaySweep := time.NewTicker(awaySweepInterval)
+	defer awaySweep.Stop()
+	var topicRefresh <-chan time.Time
+	if interval := client.config.globalTopicInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		topicRefresh = ticker.C
+	}
 	for {
-		event, ok := <-client.pyx.IncomingEvents
-		if !ok {
-			log.Infof("PYX event channel closed for %s", client.nick)
-			client.disconnect("Disconnected from PYX.")
+		select {
+		case event, ok := <-events:
+			if !ok {
+				if pyxClient.IsDetached() || pyxClient.IsLoggedOut() {
+					// the connection is already gone, or we're logging back in
+					return
+				}
+				if client.isPyxDown() {
+					client.logBackIn()
+					return
+				}
+				log.Infof("PYX event channel closed for %s", client.nick)
+				announceToAdmins(SnoPyx, "Lost PYX session for %s", client.nick)
+				client.disconnect("Disconnected from PYX.")
+				return
+			}
+
+			client.whenUnlabeled(func() {
+				client.handlePyxEvent(event)
+			})
+		case <-client.roundWarningChan():
+			client.roundWarning = nil
+			msg := client.roundWarningMsg
+			client.whenUnlabeled(func() {
+				client.sendBotMessageToGame(msg)
+				client.warnIfHoldingUp()
+			})
+		case <-awaySweep.C:
+			client.whenUnlabeled(client.sweepAway)
+		case <-topicRefresh:
+			client.whenUnlabeled(func() {
+				client.refreshGlobalTopic(true)
+			})
+		case <-client.labeling.flush:
+			client.runDeferred()
+		case <-client.done:
+			// anything still coming from PYX has nowhere to go
 			return
 		}
+	}
+}
 
-		handler, ok := EventHandlers[event.Event]
-		if !ok {
-			client.data <- fmt.Sprintf(":%s PRIVMSG %s :%+v", client.botNickUserAtHost(),
-				client.nick, event)
-		} else {
-			handler(client, *event)
+func (client *Client) handlePyxEvent(event pyx.Event) {
+	handler, ok := EventHandlers[event.Type()]
+	if !ok {
+		client.data.push(fmt.Sprintf(":%s PRIVMSG %s :%+v", client.botNickUserAtHost(),
+			client.nick, event))
+	} else {
+		client.runEventHandler(handler, event)
+	}
+}
+
+// A bug in one handler shouldn't take down the whole event loop with it, so log it, let the user
+// know, and carry on with the next event.
+func (client *Client) runEventHandler(handler EventHandlerFunc, event pyx.Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Panic handling PYX event %s for %s: %v\n%s", event.Type(), client.nick, r,
+				debug.Stack())
+			announceToAdmins(SnoPyx, "Panic handling PYX event %s for %s: %v", event.Type(),
+				client.nick, r)
+			client.data.push(fmt.Sprintf(":%s PRIVMSG %s :Something went wrong handling an "+
+				"event from PYX (%s), things may be out of sync.", client.botNickUserAtHost(),
+				client.nick, event.Type()))
 		}
+	}()
+	handler(client, event)
+}
+
+// The channel for the round timer warning, or nil (which blocks forever) if there isn't one.
+func (client *Client) roundWarningChan() <-chan time.Time {
+	if client.roundWarning == nil {
+		return nil
 	}
+	return client.roundWarning.C
 }
@@ -0,0 +1,51 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDisconnectTwice(t *testing.T) {
+	config := &Config{}
+	config.EnsureDefaults()
+	connection, other := net.Pipe()
+	defer other.Close()
+	client := NewClient(connection, config)
+
+	client.disconnect("first")
+	client.disconnect("second")
+	// the manager isn't running, so the close request is still there
+	if len(client.close) != 1 {
+		t.Error("For two disconnects expected 1 close request, got", len(client.close))
+	}
+	client.data.push("too late")
+	lines, _, done := client.data.next()
+	final, _ := client.data.finalLine()
+	if len(lines) != 0 || !done || !strings.Contains(final, "(first)") {
+		t.Error("For two disconnects expected [] true (first), got", lines, done, final)
+	}
+}
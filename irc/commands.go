@@ -26,184 +26,338 @@
 package irc
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"github.com/ajanata/pyx-irc/pyx"
 	"github.com/ajanata/pyx-irc/util"
+	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type IrcHandlerFunc func(*Client, Message)
 
 var UnregisteredHandlers = map[string]IrcHandlerFunc{
-	"CAP":  handleCap,
-	"NICK": handleUnregisteredNick,
-	"PASS": handleUnregisteredPass,
-	"USER": handleUnregisteredUser,
+	"AUTHENTICATE": handleAuthenticate,
+	"CAP":          handleCap,
+	"NICK":         handleUnregisteredNick,
+	"PASS":         handleUnregisteredPass,
+	"PING":         handlePing,
+	"PONG":         handlePong,
+	"QUIT":         handleQuit,
+	"USER":         handleUnregisteredUser,
+	"WEBIRC":       handleWebIrc,
 }
 var RegisteredHandlers = map[string]IrcHandlerFunc{
-	"CAP":     handleCap,
-	"JOIN":    handleJoin,
-	"LIST":    handleList,
-	"LUSERS":  handleLUsers,
-	"MODE":    handleMode,
-	"MOTD":    handleMotd,
-	"NAMES":   handleNames,
-	"NICK":    handleRegisteredNick,
-	"PART":    handlePart,
-	"PASS":    handleRegisteredPassOrUser,
-	"PING":    handlePing,
-	"PRIVMSG": handlePrivmsg,
-	"QUIT":    handleQuit,
-	"TOPIC":   handleTopic,
-	"USER":    handleRegisteredPassOrUser,
-	"WHO":     handleWho,
-	"WHOIS":   handleWhois,
-	"WHOWAS":  handleWhowas,
-}
-
-func handleCap(client *Client, msg Message) {
-	// we don't support capabilities at all right now
-	// we do this explicitly instead of the default handler since that replies 451 not registered
-	client.data <- client.n.formatSimpleReply(ErrUnknownCommand, msg.cmd, "Unsupported command")
+	"ADMIN":        handleAdmin,
+	"AUTHENTICATE": handleAuthenticate,
+	"CAP":          handleCap,
+	"GAMESERV":     handleGameServ,
+	"GS":           handleGameServ,
+	"INFO":         handleInfo,
+	"INVITE":       handleInvite,
+	"ISON":         handleIson,
+	"JOIN":         handleJoin,
+	"KILL":         handleKill,
+	"KLINE":        handleKline,
+	"KNOCK":        handleKnock,
+	"LIST":         handleList,
+	"LUSERS":       handleLUsers,
+	"MODE":         handleMode,
+	"MONITOR":      handleMonitor,
+	"MOTD":         handleMotd,
+	"NAMES":        handleNames,
+	"NICK":         handleRegisteredNick,
+	"NICKSERV":     handleNickServ,
+	"NS":           handleNickServ,
+	"PART":         handlePart,
+	"PASS":         handleRegisteredPassOrUser,
+	"PING":         handlePing,
+	"PONG":         handlePong,
+	"PRIVMSG":      handlePrivmsg,
+	"QUIT":         handleQuit,
+	"RAWTRACE":     handleRawTrace,
+	"REHASH":       handleRehash,
+	"SETNAME":      handleSetname,
+	"SILENCE":      handleSilence,
+	"STATS":        handleStats,
+	"TIME":         handleTime,
+	"TOPIC":        handleTopic,
+	"UNKLINE":      handleUnkline,
+	"USER":         handleRegisteredPassOrUser,
+	"VERSION":      handleVersion,
+	"WHO":          handleWho,
+	"WHOIS":        handleWhois,
+	"WHOWAS":       handleWhowas,
 }
 
 func handleUnregisteredNick(client *Client, msg Message) {
 	if len(msg.args) < 1 {
-		client.data <- client.n.formatSimpleReply(ErrNoNicknameGiven, msg.cmd, "No nickname given")
+		client.data.push(client.n.formatSimpleReply(ErrNoNicknameGiven, msg.cmd,
+			"No nickname given"))
 	} else {
-		// TODO talk to pyx anyway so we can get the error message it gives?
-		if validNickRegex.MatchString(msg.args[0]) {
-			client.nick = msg.args[0]
-			// TODO talk to pyx to verify it?
-		} else {
-			client.data <- client.n.formatSimpleReply(ErrErroneousNickname, msg.cmd,
-				"Erroneous Nickname")
+		nick := msg.args[0]
+		if err := client.pyxConfig.ValidateNick(nick); err != nil {
+			client.data.push(client.n.format(ErrErroneousNickname, "*", "%s :%s", nick,
+				pyx.ErrorCodeMsgs[pyx.ErrorCode(err)]))
+			return
+		}
+		// SASL before NICK already decided who they are
+		if client.saslAccount != "" && !client.config.equalFold(nick, client.saslAccount) {
+			client.data.push(client.n.format(ErrErroneousNickname, "*",
+				"%s :Nickname doesn't match your SASL account %s", nick, client.saslAccount))
+			return
 		}
+		if client.nickInUse(nick) {
+			client.data.push(client.n.format(ErrNicknameInUse, "*",
+				"%s :Nickname is already in use", nick))
+			return
+		}
+		client.setNick(nick)
+	}
+}
+
+// What we can tell without logging in. PYX gets the final say when we do.
+func (client *Client) nickInUse(nick string) bool {
+	if client.isPseudoClient(nick) {
+		return true
 	}
+	if findClient(nick, client.pyxConfig) != nil {
+		return true
+	}
+	// they may be coming back to a session they left
+	return !pyx.HasSavedSession(client.pyxConfig, nick) && getRoster(client.pyxConfig).known(nick)
 }
 
 func handleRegisteredNick(client *Client, msg Message) {
-	client.data <- client.n.formatSimpleReply(ErrNoNickChange, msg.cmd,
-		"Nickname change not supported.")
+	client.data.push(client.n.formatSimpleReply(ErrNoNickChange, msg.cmd,
+		"Nickname change not supported."))
 }
 
 func handleUnregisteredPass(client *Client, msg Message) {
 	if len(msg.args) < 1 {
-		client.data <- client.n.formatSimpleReply(ErrNeedMoreParams, msg.cmd,
-			"Not enough parameters")
+		client.data.push(client.n.formatSimpleReply(ErrNeedMoreParams, msg.cmd,
+			"Not enough parameters"))
 	} else {
-		// FIXME pyx has a length requirement on this, we probably should check it here and report
-		// the error now instead of after the nick/pass combination
-		client.password = msg.args[0]
+		client.bridgePassword, client.pyxConfig, client.password =
+			client.config.parsePass(msg.args[0])
+		// tell them now, instead of after we've tried to log in with it
+		client.badIdCode = client.pyxConfig.ValidateIdCode(client.password) != nil
+		if client.badIdCode {
+			client.data.push(client.n.formatSimpleReply(ErrPasswdMismatch, "*",
+				idCodeRules(client.pyxConfig)))
+		}
+	}
+}
+
+func idCodeRules(pyxConfig *pyx.Config) string {
+	return fmt.Sprintf("Identification code must be between %d and %d characters",
+		pyxConfig.MinIdCodeLength, pyxConfig.MaxIdCodeLength)
+}
+
+func (client *Client) checkBridgePassword() bool {
+	if client.config.Password == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(client.bridgePassword),
+		[]byte(client.config.Password)) == 1
+}
+
+// WEBIRC password gateway hostname ip
+// Lets a trusted web gateway tell us the real address of the user.
+func handleWebIrc(client *Client, msg Message) {
+	if len(msg.args) < 4 {
+		client.data.push(client.n.formatSimpleReply(ErrNeedMoreParams, msg.cmd,
+			"Not enough parameters"))
+		return
+	}
+	if client.nick != "" || client.hasUser || client.gateway != "" {
+		// this has to be the first thing the gateway sends
+		client.disconnect("WEBIRC must be sent before registration")
+		return
+	}
+	trusted := false
+	for _, password := range client.config.WebIrcPasswords {
+		if subtle.ConstantTimeCompare([]byte(password), []byte(msg.args[0])) == 1 {
+			trusted = true
+			break
+		}
+	}
+	if !trusted || net.ParseIP(msg.args[3]) == nil {
+		log.Warningf("Invalid WEBIRC from %s for gateway %s", client.remoteAddr(), msg.args[1])
+		client.disconnect("Invalid WEBIRC")
+		return
+	}
+	if err := client.limiter.move(client.addr, msg.args[3]); err != nil {
+		log.Infof("Rejecting connection from %s via %s: %v", msg.args[3], msg.args[1], err)
+		client.disconnect(err.Error())
+		return
 	}
+	client.setGateway(msg.args[1], msg.args[3])
+	log.Infof("Connection is from %s", client.remoteAddr())
 }
 
 func handleRegisteredPassOrUser(client *Client, msg Message) {
-	client.data <- client.n.formatSimpleReply(ErrAlreadyRegistered, msg.cmd, "Already registered")
+	client.data.push(client.n.formatSimpleReply(ErrAlreadyRegistered, msg.cmd,
+		"Already registered"))
+}
+
+// Answering our PING. Hearing from them at all is what counts, and that's already been noted.
+func handlePong(client *Client, msg Message) {
 }
 
 func handleUnregisteredUser(client *Client, msg Message) {
-	// we don't care about anything in this message, other than requiring it for flow
+	// USER <username> <mode> <unused> :<realname>, only the realname matters to us
+	if len(msg.args) < 4 {
+		client.data.push(client.n.format(ErrNeedMoreParams, "*", "USER :Not enough parameters"))
+		return
+	}
+	client.realname = msg.args[3]
+	if len(client.realname) > MaxRealnameLength {
+		client.realname = client.realname[:MaxRealnameLength]
+	}
 	client.hasUser = true
 }
 
 func handleMotd(client *Client, msg Message) {
-	client.data <- client.n.formatSimpleReply(ErrNoMotd, client.nick, "No MOTD configured.")
+	client.data.push(client.n.formatSimpleReply(ErrNoMotd, client.nick, "No MOTD configured."))
 }
 
+// Safe to call from anywhere, any number of times. Only the first reason is sent.
 func (client *Client) disconnect(why string) {
-	s := fmt.Sprintf("ERROR :Closing Link: %s[%s] (%s)", client.nick, client.addr, why)
-	// have to do this differently to ensure the client actually gets this before we close the
-	// connection
-	client.writer.WriteString(s + "\r\n")
-	client.writer.Flush()
-
+	if !atomic.CompareAndSwapInt32(&client.disconnecting, 0, 1) {
+		return
+	}
+	// this goes out after everything that's already waiting, and then the connection is closed
+	client.data.finish(fmt.Sprintf("ERROR :Closing Link: %s[%s] (%s)", client.nick, client.addr,
+		why))
+	// this can't block, since it's buffered and nothing else sends to it
 	client.close <- true
 
-	if client.pyx != nil {
-		client.pyx.LogOut()
+	if client.pyx() != nil {
+		client.pyx().LogOut()
 	}
 }
 
+func (client *Client) isDisconnecting() bool {
+	return atomic.LoadInt32(&client.disconnecting) != 0
+}
+
 func handleQuit(client *Client, msg Message) {
 	client.disconnect(fmt.Sprintf("Quit: %s", client.nick))
 }
 
 func (client *Client) sendWelcome() {
-	client.data <- client.n.format(RplWelcome, client.nick,
-		":Welcome to the PYX IRC network %s!%s@%s", client.nick, client.nick, client.addr)
-	client.data <- client.n.format(RplYourHost, client.nick,
+	client.data.push(client.n.format(RplWelcome, client.nick,
+		":Welcome to the PYX IRC network %s!%s@%s", client.nick, client.nick, client.addr))
+	client.data.push(client.n.format(RplYourHost, client.nick,
 		":Your host is %s, running version pyx-irc-%s-%s", client.config.AdvertisedName,
-		util.GitBranch, util.GitSummary)
+		util.GitBranch, util.GitSummary))
 	// user modes, channel modes
-	client.data <- client.n.format(RplMyInfo, client.nick, "%s pyx-irc-%s-%s Bor alvontk",
-		client.config.AdvertisedName, util.GitBranch, util.GitSummary)
-	client.data <- client.n.format(RplISupport, client.nick,
-		"MAXCHANNELS=2 CHANLIMIT=#:2 NICKLEN=30 "+
-			"CHANNELLEN=9 TOPICLEN=307 AWAYLEN=0 MAXTARGETS=1 MODES=1 CHANTYPES=# PREFIX=(aov)&@+ "+
-			"CHANMODES=,k,lL,voantk NETWORK=PYX CASEMAPPING=ascii :are supported by this server")
+	client.data.push(client.n.format(RplMyInfo, client.nick, "%s pyx-irc-%s-%s Bors alvontk",
+		client.config.AdvertisedName, util.GitBranch, util.GitSummary))
+	client.sendISupport()
 
 	client.sendLUsers()
 	handleMotd(client, Message{})
 
 	// this is NOT the same as just handleModeImpl: We are explicitly setting the mode
-	modes := "+"
-	if client.pyx.User.IsAdmin() {
-		modes = modes + "o"
-	}
-	if len(client.pyx.User.IdCode) > 0 {
-		modes = modes + "r"
+	if client.pyx().User.IsAdmin() {
+		client.setSnomask("")
 	}
+	modes := client.userModes()
 	if "+" != modes {
-		client.data <- fmt.Sprintf(":%s MODE %s :%s", client.nick, client.nick, modes)
+		client.data.push(fmt.Sprintf(":%s MODE %s :%s", client.nick, client.nick, modes))
 	}
 
+	client.sigils.set(client.config.fold(client.nick), client.pyx().User.Sigil)
 	client.joinChannel(client.config.GlobalChannel)
-}
+	if client.pyx().User.IsAdmin() {
+		client.joinAdminChannel()
+	}
 
-func handleLUsers(client *Client, msg Message) {
-	client.sendLUsers()
+	if client.pyx().ResumedGameId != nil {
+		client.rejoinResumedGame(*client.pyx().ResumedGameId)
+	}
+	client.applyAutoJoin()
 }
 
-func (client *Client) sendLUsers() {
-	channels, err := client.getChannels()
+// The PYX session we picked up was already in a game, so put the user back in its channel.
+func (client *Client) rejoinResumedGame(gameId int) {
+	resp, err := client.pyx().GameInfo(gameId)
 	if err != nil {
-		log.Errorf("Unable to retrieve game list for /lusers: %v", err)
-		client.data <- client.n.format(ErrServiceConfused, client.nick,
-			":Error retrieving game list: %s", err)
+		log.Errorf("Unable to get info for resumed game %d for %s: %v", gameId, client.nick, err)
 		return
 	}
-	channelCount := len(channels)
+	spectate := true
+	for _, player := range resp.GameInfo.Players {
+		if player == client.pyx().User.Name {
+			spectate = false
+			break
+		}
+	}
+	client.setCurrentGame(gameId)
+	client.gameIsSpectate = spectate
+	client.gameState = resp.GameInfo.State
+	client.gameInProgress = resp.GameInfo.State != pyx.GameState_LOBBY
+	client.refreshCustomDecks()
+	client.refreshHand()
+	client.joinChannel(client.getGameChannel())
+}
 
-	names, err := client.pyx.Names()
-	if err != nil {
-		log.Errorf("Unable to retrieve user list for /lusers: %v", err)
-		client.data <- client.n.format(ErrServiceConfused, client.nick,
-			":Error retrieving user list: %s", err)
+func handleVersion(client *Client, msg Message) {
+	client.data.push(client.n.format(RplVersion, client.nick, "pyx-irc-%s-%s %s :%s",
+		util.GitBranch, util.GitSummary, client.config.AdvertisedName,
+		client.pyxConfig.BaseAddress))
+	client.sendISupport()
+}
+
+func handleAdmin(client *Client, msg Message) {
+	if client.config.AdminLocation == "" && client.config.AdminLocation2 == "" &&
+		client.config.AdminEmail == "" {
+		client.data.push(client.n.format(ErrNoAdminInfo, client.nick,
+			"%s :No administrative info available", client.config.AdvertisedName))
 		return
 	}
-	userCount := len(names)
+	client.data.push(client.n.format(RplAdminMe, client.nick, "%s :Administrative info",
+		client.config.AdvertisedName))
+	client.data.push(client.n.formatSimpleReply(RplAdminLoc1, client.nick,
+		client.config.AdminLocation))
+	client.data.push(client.n.formatSimpleReply(RplAdminLoc2, client.nick,
+		client.config.AdminLocation2))
+	client.data.push(client.n.formatSimpleReply(RplAdminEmail, client.nick,
+		client.config.AdminEmail))
+}
 
-	// TODO maybe keep track of how many users are using the bridge and count them as "local"
-	// and everyone else as "global"?
-	client.data <- client.n.format(RplLUserClient, client.nick, ":There are %d users on 1 server",
-		userCount)
-	client.data <- client.n.format(RplLUserOp, client.nick, "%d :operator(s) online", 0)
-	client.data <- client.n.format(RplLUserChannels, client.nick, "%d :channels formed",
-		channelCount)
-	client.data <- client.n.format(RplLUserMe, client.nick,
-		":I have %d clients and %d servers", userCount, 0)
-	client.data <- client.n.format(RplLocalUsers, client.nick,
-		":Current Local Users: %d  Max: %d", userCount, userCount)
-	client.data <- client.n.format(RplGlobalUsers, client.nick,
-		":Current Global Users: %d  Max: %d", userCount, userCount)
+func handleInfo(client *Client, msg Message) {
+	lines := []string{
+		fmt.Sprintf("pyx-irc %s-%s", util.GitBranch, util.GitSummary),
+		"An IRC bridge for Pretend You're Xyzzy.",
+		"https://github.com/ajanata/pyx-irc",
+		fmt.Sprintf("Connected to PYX at %s", client.pyxConfig.BaseAddress),
+	}
+	for _, line := range lines {
+		client.data.push(client.n.formatSimpleReply(RplInfo, client.nick, line))
+	}
+	client.data.push(client.n.formatSimpleReply(RplEndOfInfo, client.nick, "End of /INFO list."))
+}
+
+func handleTime(client *Client, msg Message) {
+	now := time.Now()
+	client.data.push(client.n.format(RplTime, client.nick, "%s %d 0 :%s",
+		client.config.AdvertisedName, now.Unix(), now.Format(time.RFC1123)))
+}
+
+func handleLUsers(client *Client, msg Message) {
+	client.sendLUsers()
 }
 
 // Send the stuff to the IRC client required when joining a channel. Assumes that the channel is
 // valid to join.
 func (client *Client) joinChannel(channel string) {
-	client.data <- fmt.Sprintf(":%s JOIN :%s", client.getNickUserAtHost(client.nick), channel)
+	client.data.push(client.joinLine(client.nick, channel))
 
 	client.handleTopicImpl(channel)
 	client.handleNamesImpl(channel)
@@ -215,36 +369,59 @@ func handleNames(client *Client, msg Message) {
 
 func (client *Client) handleNamesImpl(args ...string) {
 	if len(args) == 0 {
-		client.data <- client.n.format(ErrNeedMoreParams, client.nick,
-			"NAMES :Not enough parameters")
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"NAMES :Not enough parameters"))
+		return
+	}
+	if client.isAdminChannel(args[0]) {
+		client.adminChannelNames()
 		return
 	}
 
-	if strEqCI(args[0], client.config.GlobalChannel) {
-		names, err := client.pyx.Names()
+	if client.config.equalFold(args[0], client.config.GlobalChannel) {
+		names, err := client.roster.names(client.pyx())
 		if err != nil {
 			log.Errorf("Unable to retrieve names for %s: %v", args[0], err)
 		}
+		for _, name := range names {
+			sigil, nick := splitSigil(name)
+			client.updateSigil(nick, sigil)
+		}
+		// everyone is in the list now, quiet or not
+		client.quietNicks.clear()
 		// TODO a proper length based on 512 minus broilerplate
 		for _, line := range joinIntoLines(300, append(names, "&"+client.config.BotNick), " ") {
-			client.data <- client.n.format(RplNames, client.nick, "= %s :%s", args[0], line)
+			client.data.push(client.n.format(RplNames, client.nick, "= %s :%s", args[0], line))
 		}
+	} else if _, watched := client.watchedGame(args[0]); watched != nil {
+		// this sends its own end of names
+		client.watchNames(watched)
+		return
 	} else {
 		gameId, _, err := client.getGameFromChannel(args[0])
-		if err != nil || gameId != *client.gameId {
-			client.data <- client.n.format(ErrNotOnChannel, client.nick, "%s :Not in channel",
-				args[0])
+		if err != nil {
+			client.data.push(client.n.format(ErrNotOnChannel, client.nick, "%s :Not in channel",
+				args[0]))
+			return
+		}
+		if !client.inGame(gameId) {
+			client.otherGameNames(args[0], gameId)
 			return
 		}
-		resp, err := client.pyx.GameInfo(gameId)
+		resp, err := client.gameInfo()
 		if err != nil {
-			client.data <- client.n.format(ErrServiceConfused, client.nick,
-				"%s :Cannot retrieve names: %s", args[0], err)
+			client.data.push(client.n.format(ErrServiceConfused, client.nick,
+				"%s :Cannot retrieve names: %s", args[0], err))
 			return
 		}
 		players := []string{}
 		for _, player := range resp.GameInfo.Players {
-			if player == resp.GameInfo.Host {
+			if player == client.gameJudgeMode {
+				players = append(players, "&"+player)
+				if player == resp.GameInfo.Host {
+					client.gameHost = player
+				}
+			} else if player == resp.GameInfo.Host {
 				players = append(players, "@"+player)
 				// this is a dumb place to do it, but we have the required info here...
 				client.gameHost = player
@@ -255,10 +432,10 @@ func (client *Client) handleNamesImpl(args ...string) {
 		// TODO a proper length based on 512 minus broilerplate
 		for _, line := range joinIntoLines(300, append(append(players, resp.GameInfo.Spectators...),
 			"&"+client.config.BotNick), " ") {
-			client.data <- client.n.format(RplNames, client.nick, "= %s :%s", args[0], line)
+			client.data.push(client.n.format(RplNames, client.nick, "= %s :%s", args[0], line))
 		}
 	}
-	client.data <- client.n.format(RplEndNames, client.nick, "%s :End of /NAMES list", args[0])
+	client.data.push(client.n.format(RplEndNames, client.nick, "%s :End of /NAMES list", args[0]))
 }
 
 func handleTopic(client *Client, msg Message) {
@@ -268,69 +445,80 @@ func handleTopic(client *Client, msg Message) {
 func (client *Client) handleTopicImpl(args ...string) {
 	if len(args) == 0 {
 		// error to not specify channel
-		client.data <- client.n.format(ErrNeedMoreParams, client.nick,
-			"TOPIC :Not enough parameters")
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"TOPIC :Not enough parameters"))
 	} else if len(args) == 1 {
 		// show topic
 		var topic string
 		var set int64
 		var setBy string
-		if strEqCI(args[0], client.config.GlobalChannel) {
+		if client.isAdminChannel(args[0]) {
+			topic = AdminChannelTopic
+			set = client.pyx().ServerStarted
+			setBy = client.botNickUserAtHost()
+		} else if client.config.equalFold(args[0], client.config.GlobalChannel) {
 			topic = client.getTopic(args[0], nil)
-			set = client.pyx.ServerStarted
+			set = client.pyx().ServerStarted
 			setBy = client.botNickUserAtHost()
-		} else if client.gameId == nil {
+		} else if _, watched := client.watchedGame(args[0]); watched != nil {
+			client.watchTopicReply(watched)
+			return
+		} else if !client.inAnyGame() {
 			// user isn't in a game so they can't request a topic for a game
-			client.data <- client.n.format(ErrNotOnChannel, client.nick, "%s :Not in channel.",
-				args[0])
+			client.data.push(client.n.format(ErrNotOnChannel, client.nick, "%s :Not in channel.",
+				args[0]))
 			return
 		} else {
 			requestedId, _, err := client.getGameFromChannel(args[0])
 			if err != nil {
-				client.data <- client.n.format(ErrNotOnChannel, client.nick, "%s :%s", args[0], err)
+				client.data.push(client.n.format(ErrNotOnChannel, client.nick, "%s :%s", args[0],
+					err))
 				return
 			}
-			if requestedId != *client.gameId {
+			if !client.inGame(requestedId) {
 				// user isn't in the game they asked for so they can't see it
-				client.data <- client.n.format(ErrNotOnChannel, client.nick, "%s :Not in channel.",
-					args[0])
+				client.data.push(client.n.format(ErrNotOnChannel, client.nick,
+					"%s :Not in channel.", args[0]))
 				return
 			}
 			// okay, so the user is definitely in this game, so we can actually ask the pyx server
 			// for the information we need
-			resp, err := client.pyx.GameInfo(requestedId)
+			resp, err := client.gameInfo()
 			if err != nil {
 				log.Errorf("Unable to retrieve game %d info for /topic request: %s", requestedId,
 					err)
-				client.data <- client.n.format(ErrNotOnChannel, client.nick, "%s :%s", args[0], err)
+				client.data.push(client.n.format(ErrNotOnChannel, client.nick, "%s :%s", args[0],
+					err))
 				return
 			}
 			topic = client.getTopic(args[0], &resp.GameInfo)
 			set = resp.GameInfo.Created
 			setBy = client.getNickUserAtHost(resp.GameInfo.Host)
 		}
-		client.data <- client.n.format(RplTopic, client.nick, "%s :%s", args[0], topic)
-		client.data <- client.n.format(RplTopicWhoTime, client.nick, "%s %s %d", args[0], setBy,
-			set/1000)
+		client.data.push(client.n.format(RplTopic, client.nick, "%s :%s", args[0], topic))
+		client.data.push(client.n.format(RplTopicWhoTime, client.nick, "%s %s %d", args[0], setBy,
+			set/1000))
 	} else {
 		// error to try to change topic
 		// TODO is there a better numeric for this? we don't want to let ANYONE change it like this
-		client.data <- client.n.format(ErrChanOpPrivsNeeded, client.nick,
-			"TOPIC :You can't do that.")
+		client.data.push(client.n.format(ErrChanOpPrivsNeeded, client.nick,
+			"TOPIC :You can't do that."))
 	}
 }
 
 // Make the topic for a channel. gameInfo may be nil if the channel being passed is known to be
 // the global channel.
 func (client *Client) getTopic(channel string, gameInfo *pyx.GameInfo) string {
-	if strEqCI(channel, client.config.GlobalChannel) {
-		if client.pyx.GlobalChatEnabled {
-			return "Global chat"
-		} else {
-			return "Global chat (disabled)"
-		}
+	if client.config.equalFold(channel, client.config.GlobalChannel) {
+		return client.currentGlobalTopic()
 	} else if gameInfo != nil {
-		return makeGameTopic(gameInfo)
+		topic := makeGameTopic(gameInfo, client.pyx().CardSetNames(gameInfo.GameOptions.CardSets),
+			client.gameCustomDecks)
+		if client.gameBlackCard != nil && !client.prefs.HideTopicCard &&
+			client.inGame(gameInfo.Id) {
+			topic = addTopicCard(topic, *client.gameBlackCard)
+		}
+		return topic
 	} else {
 		log.Errorf("Topic for channel %s requested but gameInfo is nil!", channel)
 		return "(error generating topic)"
@@ -344,47 +532,47 @@ func handleMode(client *Client, msg Message) {
 func (client *Client) handleModeImpl(args ...string) {
 	// TODO handle if the user is trying to change modes
 	if len(args) == 0 {
-		client.data <- client.n.format(ErrNeedMoreParams, client.nick,
-			"MODE :Not enough parameters")
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"MODE :Not enough parameters"))
 	} else if strings.HasPrefix(args[0], "#") {
 		if len(args) == 1 {
 			var modes string
 			var created int64
-			if strEqCI(args[0], client.config.GlobalChannel) {
-				created = client.pyx.ServerStarted
+			if client.config.equalFold(args[0], client.config.GlobalChannel) {
+				created = client.pyx().ServerStarted
 				modes = "+t"
-				if !client.pyx.GlobalChatEnabled {
+				if !client.pyx().GlobalChatEnabled || client.prefs.MuteGlobal {
 					modes = modes + "m"
 				}
-				if client.pyx.BroadcastingUsers {
+				if client.pyx().BroadcastingUsers {
 					modes = modes + "n"
 				}
-			} else if client.gameId == nil {
+			} else if !client.inAnyGame() {
 				// user isn't in a game so they can't view modes for a game
-				client.data <- client.n.format(ErrNotOnChannel, client.nick,
-					"%s :Not in channel.", args[0])
+				client.data.push(client.n.format(ErrNotOnChannel, client.nick,
+					"%s :Not in channel.", args[0]))
 				return
 			} else {
 				requestedId, _, err := client.getGameFromChannel(args[0])
 				if err != nil {
-					client.data <- client.n.format(ErrNotOnChannel, client.nick, "%s :%s", args[0],
-						err)
+					client.data.push(client.n.format(ErrNotOnChannel, client.nick, "%s :%s",
+						args[0], err))
 					return
 				}
-				if requestedId != *client.gameId {
+				if !client.inGame(requestedId) {
 					// user isn't in the game they asked for so they can't see it
-					client.data <- client.n.format(ErrNotOnChannel, client.nick,
-						"%s :Not in channel.", args[0])
+					client.data.push(client.n.format(ErrNotOnChannel, client.nick,
+						"%s :Not in channel.", args[0]))
 					return
 				}
 				// okay, so the user is definitely in this game, so we can actually ask the pyx server
 				// for the information we need
-				resp, err := client.pyx.GameInfo(requestedId)
+				resp, err := client.gameInfo()
 				if err != nil {
 					log.Errorf("Unable to retrieve game %d info for /mode request: %s", requestedId,
 						err)
-					client.data <- client.n.format(ErrNotOnChannel, client.nick, "%s :%s", args[0],
-						err)
+					client.data.push(client.n.format(ErrNotOnChannel, client.nick, "%s :%s",
+						args[0], err))
 					return
 				}
 				created = resp.GameInfo.Created
@@ -396,64 +584,234 @@ func (client *Client) handleModeImpl(args ...string) {
 				modes = fmt.Sprintf("%slL %d %d", modes, resp.GameInfo.GameOptions.PlayerLimit+1,
 					resp.GameInfo.GameOptions.SpectatorLimit+1)
 			}
-			client.data <- client.n.format(RplChannelModeIs, client.nick, "%s %s", args[0], modes)
-			client.data <- client.n.format(RplCreationTime, client.nick, "%s %d", args[0],
-				created/1000)
+			client.data.push(client.n.format(RplChannelModeIs, client.nick, "%s %s", args[0],
+				modes))
+			client.data.push(client.n.format(RplCreationTime, client.nick, "%s %d", args[0],
+				created/1000))
 		} else {
 			if args[1] == "b" {
 				// irssi likes to request the ban list
-				client.data <- client.n.format(RplEndOfBanList, client.nick,
-					"%s :End of Channel Ban List", args[0])
+				client.data.push(client.n.format(RplEndOfBanList, client.nick,
+					"%s :End of Channel Ban List", args[0]))
+			} else if client.config.equalFold(args[0], client.config.GlobalChannel) {
+				client.changeGlobalModes(args[1])
 			} else {
-				// TODO handle if the user is trying to change modes
-				// TODO but if they are the game host, they could change some of the settings
-				client.data <- client.n.format(ErrChanOpPrivsNeeded, client.nick,
-					"MODE :You can't do that.")
+				client.changeGameModes(args[0], args[1], args[2:])
 			}
 		}
-	} else if strEqCI(args[0], client.nick) {
+	} else if client.config.equalFold(args[0], client.nick) {
 		if len(args) == 1 {
-			// show modes
-			// default to no modes. this is how unreal reports it
-			modes := "+"
-			if client.pyx.User.IsAdmin() {
-				modes = modes + "o"
+			client.data.push(client.n.format(RplUModeIs, client.nick, client.userModes()))
+		} else {
+			client.changeUserModes(args[1:]...)
+		}
+	} else if !client.config.QuietIgnores {
+		// unreal doesn't reply _at all_ for this, but then clients never hear back
+		if len(args) == 1 {
+			client.data.push(client.n.formatSimpleReply(ErrUsersDontMatch, client.nick,
+				"Can't view modes for other users"))
+		} else {
+			client.data.push(client.n.formatSimpleReply(ErrUsersDontMatch, client.nick,
+				"Can't change mode for other users"))
+		}
+	}
+}
+
+// default to no modes. this is how unreal reports it
+func (client *Client) userModes() string {
+	modes := "+"
+	if client.pyx().User.IsAdmin() {
+		modes = modes + "o"
+	}
+	if len(client.pyx().User.IdCode) > 0 {
+		modes = modes + "r"
+	}
+	if client.hasSnomask() {
+		modes = modes + "s"
+	}
+	return modes
+}
+
+// The only mode anyone can change is +s, and only operators. Unreal doesn't reply _at all_ for
+// bad mode changes, so we only do if quiet_ignores is off, and only once per kind of error.
+func (client *Client) changeUserModes(args ...string) {
+	adding := true
+	deniedSent, unknownSent := client.config.QuietIgnores, client.config.QuietIgnores
+	for _, c := range args[0] {
+		switch c {
+		case '+':
+			adding = true
+		case '-':
+			adding = false
+		case 's':
+			if !client.pyx().User.IsAdmin() {
+				if !deniedSent {
+					client.data.push(client.n.formatSimpleReply(ErrNoPrivileges, client.nick,
+						"Permission Denied- You're not a PYX administrator"))
+					deniedSent = true
+				}
+				continue
 			}
-			if len(client.pyx.User.IdCode) > 0 {
-				modes = modes + "r"
+			if adding {
+				changes := ""
+				if len(args) > 1 {
+					changes = args[1]
+				}
+				hadMask := client.hasSnomask()
+				client.setSnomask(changes)
+				if !hadMask {
+					client.data.push(fmt.Sprintf(":%s MODE %s :+s", client.nick, client.nick))
+				}
+			} else if client.clearSnomask() {
+				client.data.push(fmt.Sprintf(":%s MODE %s :-s", client.nick, client.nick))
+			}
+		default:
+			// o and r come from pyx, so they're just as unchangeable as anything we don't know
+			if !unknownSent {
+				client.data.push(client.n.formatSimpleReply(ErrUModeUnknownFlag, client.nick,
+					"Unknown MODE flag"))
+				unknownSent = true
 			}
-			client.data <- client.n.format(RplUModeIs, client.nick, modes)
-		} else {
-			// error to change modes
-			// but unreal doesn't reply _at all_ for bad mode changes
 		}
-	} else {
-		// error to look at someone else's modes
-		// but unreal doesn't reply _at all_ for this
 	}
 }
 
+// Change modes on a game channel. Only the game host can do this, and only for the modes that map
+// onto game options.
+func (client *Client) changeGameModes(channel string, modeStr string, params []string) {
+	gameId, _, err := client.getGameFromChannel(channel)
+	if err != nil || !client.inGame(gameId) {
+		client.data.push(client.n.format(ErrNotOnChannel, client.nick, "%s :Not in channel.",
+			channel))
+		return
+	}
+	resp, err := client.gameInfo()
+	if err != nil {
+		log.Errorf("Unable to retrieve game %d info for mode change: %s", gameId, err)
+		client.data.push(client.n.format(ErrServiceConfused, client.nick,
+			"%s :Cannot change modes: %s", channel, err))
+		return
+	}
+	if resp.GameInfo.Host != client.pyx().User.Name {
+		client.data.push(client.n.format(ErrChanOpPrivsNeeded, client.nick,
+			"%s :You're not the game host.", channel))
+		return
+	}
+
+	options := resp.GameInfo.GameOptions
+	adding := true
+	changed := ""
+	changedParams := []string{}
+	for _, mode := range modeStr {
+		switch mode {
+		case '+':
+			adding = true
+		case '-':
+			adding = false
+		case 'k':
+			if adding {
+				if len(params) == 0 {
+					client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+						"MODE :Not enough parameters"))
+					return
+				}
+				options.Password = params[0]
+				params = params[1:]
+				changed = changed + "+k"
+				changedParams = append(changedParams, options.Password)
+			} else {
+				options.Password = ""
+				changed = changed + "-k"
+				changedParams = append(changedParams, "*")
+			}
+		case 'l', 'L':
+			// there's no such thing as an unlimited game, so these can only be set
+			if !adding {
+				client.data.push(client.n.format(ErrUnknownMode, client.nick,
+					"%c :cannot be removed from %s", mode, channel))
+				return
+			}
+			if len(params) == 0 {
+				client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+					"MODE :Not enough parameters"))
+				return
+			}
+			// the limits we advertise include the bot, so take that back out
+			limit, err := strconv.Atoi(params[0])
+			min, max := client.pyxConfig.MinPlayerLimit, client.pyxConfig.MaxPlayerLimit
+			if mode == 'L' {
+				min, max = client.pyxConfig.MinSpectatorLimit, client.pyxConfig.MaxSpectatorLimit
+			}
+			// pyx would quietly change it to the nearest one it allows
+			if err != nil || limit-1 < min || limit-1 > max {
+				client.data.push(client.n.format(ErrInvalidModeParam, client.nick,
+					"%s %c %s :The limit must be between %d and %d", channel, mode, params[0],
+					min+1, max+1))
+				return
+			}
+			params = params[1:]
+			if mode == 'l' {
+				options.PlayerLimit = limit - 1
+			} else {
+				options.SpectatorLimit = limit - 1
+			}
+			changed = changed + "+" + string(mode)
+			changedParams = append(changedParams, strconv.Itoa(limit))
+		default:
+			client.data.push(client.n.format(ErrUnknownMode, client.nick,
+				"%c :is unknown mode char to me for %s", mode, channel))
+			return
+		}
+	}
+	if changed == "" {
+		return
+	}
+
+	_, err = client.pyx().ChangeGameOptions(gameId, options)
+	client.gameCache.invalidate()
+	if err != nil {
+		switch pyx.ErrorCode(err) {
+		case pyx.ErrorCode_NOT_GAME_HOST:
+			client.data.push(client.n.format(ErrChanOpPrivsNeeded, client.nick,
+				"%s :You're not the game host.", channel))
+		case pyx.ErrorCode_ALREADY_STARTED:
+			client.data.push(client.n.format(ErrChanOpPrivsNeeded, client.nick,
+				"%s :Modes cannot be changed while the game is in progress.", channel))
+		default:
+			client.data.push(client.n.format(ErrServiceConfused, client.nick,
+				"%s :Cannot change modes: %s", channel, err))
+		}
+		return
+	}
+	client.data.push(fmt.Sprintf(":%s MODE %s %s %s", client.getNickUserAtHost(client.nick),
+		channel, changed, strings.Join(changedParams, " ")))
+}
+
 func handlePing(client *Client, msg Message) {
 	arg := ""
 	if len(msg.args) > 0 {
 		arg = msg.args[0]
 	}
-	client.data <- fmt.Sprintf(":%s PONG %s :%s", client.config.AdvertisedName,
-		client.config.AdvertisedName, arg)
+	client.data.push(fmt.Sprintf(":%s PONG %s :%s", client.config.AdvertisedName,
+		client.config.AdvertisedName, arg))
 }
 
 func handleWho(client *Client, msg Message) {
-	if len(msg.args) == 0 || strEqCI(msg.args[0], client.config.GlobalChannel) {
-		names, err := client.pyx.Names()
+	if len(msg.args) == 0 || client.config.equalFold(msg.args[0], client.config.GlobalChannel) {
+		names, err := client.roster.names(client.pyx())
 		if err != nil {
 			log.Errorf("Unable to retrieve names for %s: %v", client.config.GlobalChannel, err)
 		}
 
-		client.data <- client.n.format(RplWho, client.nick, "%s %s %s %s %s HrB& :0 %s",
+		client.data.push(client.n.format(RplWho, client.nick, "%s %s %s %s %s HrB& :0 %s",
 			client.config.GlobalChannel, client.config.BotUsername, client.config.AdvertisedName,
-			client.config.AdvertisedName, client.config.BotNick, client.config.BotNick)
+			client.config.AdvertisedName, client.config.BotNick, client.config.BotNick))
 		for _, name := range names {
+			_, bare := splitSigil(name)
 			modes := "H"
+			if client.isAway(bare) {
+				modes = "G"
+			}
 			if name[0:1] == pyx.Sigil_ADMIN {
 				// technically admins might not be using an id code but we can't tell the difference
 				// here
@@ -468,123 +826,192 @@ func handleWho(client *Client, msg Message) {
 				name = name[1:]
 			}
 
-			client.data <- client.n.format(RplWho, client.nick, "%s %s %s %s %s %s :0 %s",
+			client.data.push(client.n.format(RplWho, client.nick, "%s %s %s %s %s %s :0 %s",
 				client.config.GlobalChannel, getUser(name), client.getHost(name),
-				client.config.AdvertisedName, name, modes, name)
+				client.config.AdvertisedName, name, modes, client.roster.realname(bare)))
 		}
 
 		target := "*"
 		if len(msg.args) > 0 {
 			target = client.config.GlobalChannel
 		}
-		client.data <- client.n.format(RplEndOfWho, client.nick, "%s :End of /WHO list", target)
-	} else if strEqCI(msg.args[0], client.getGameChannel()) {
-		// TODO per-game channels, send something so irssi doesn't keep waiting
-		client.data <- client.n.format(RplEndOfWho, client.nick, "%s :End of /WHO list",
-			msg.args[0])
+		client.data.push(client.n.format(RplEndOfWho, client.nick, "%s :End of /WHO list", target))
+	} else if gameId, _, err := client.getGameFromChannel(msg.args[0]); err == nil {
+		client.gameWho(msg.args[0], gameId)
 	} else {
-		client.data <- client.n.format(ErrNotOnChannel, client.nick, "%s :Not in channel",
-			msg.args[0])
+		client.data.push(client.n.format(ErrNotOnChannel, client.nick, "%s :Not in channel",
+			msg.args[0]))
 	}
 }
 
 func handlePrivmsg(client *Client, msg Message) {
 	if len(msg.args) == 0 {
-		client.data <- client.n.format(ErrNeedMoreParams, client.nick,
-			"PRIVMSG :Not enough parameters")
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"PRIVMSG :Not enough parameters"))
 		return
 	}
 	if len(msg.args) == 1 || len(msg.args[1]) == 0 {
-		client.data <- client.n.format(ErrNoTextToSend, client.nick, ":No text to send")
+		client.data.push(client.n.format(ErrNoTextToSend, client.nick, ":No text to send"))
 		return
 	}
 
 	channel := msg.args[0]
+	if client.isAdminChannel(channel) {
+		client.adminChannelPrivmsg(msg.args[1])
+		return
+	}
+	if client.config.equalFold(channel, client.config.GlobalChannel) && !client.inGlobalChannel() {
+		client.data.push(client.n.format(ErrCannotSendToChan, client.nick,
+			"%s :Cannot send to channel (you have left it)", channel))
+		return
+	}
 	isEmote, text := isEmote(msg.args[1])
-	var err error
-	if strEqCI(channel, client.config.GlobalChannel) {
-		err = client.pyx.SendGlobalChat(text, isEmote)
-	} else if !strings.HasPrefix(channel, "#") {
-		// trying to send a private message... we don't support that
-		// unreal uses this for either
-		client.data <- client.n.format(ErrNoSuchNick, client.nick, "%s :No such nick/channel",
-			channel)
+	if client.isPseudoClient(channel) && client.hasCap("echo-message") {
+		// these never go to PYX, so there's nothing else to wait for
+		client.data.push(fmt.Sprintf("%s:%s PRIVMSG %s :%s", client.accountTag(client.nick),
+			client.getNickUserAtHost(client.nick), channel, msg.args[1]))
+	}
+	if client.config.equalFold(channel, client.config.BotNick) {
+		client.handleBotPrivmsg(text)
+		return
+	}
+	if client.isNickServ(channel) {
+		client.handleNickServPrivmsg(text)
+		return
+	}
+	if client.isGameServ(channel) {
+		client.handleGameServPrivmsg(text)
 		return
+	}
+	if channel == PlaybackNick {
+		client.handlePlaybackPrivmsg(text)
+		return
+	}
+	if !isEmote && client.handleBotChannelCommand(channel, text) {
+		return
+	}
+	if !strings.HasPrefix(channel, "#") {
+		client.sendWhisper(channel, msg.args[1])
+		return
+	}
+	// people on the web would just see the control codes
+	if stripped := stripFormatting(text); stripped != text {
+		if client.config.RejectFormatting {
+			client.data.push(client.n.format(ErrCannotSendToChan, client.nick,
+				"%s :Cannot send to channel (colors and formatting are not allowed)", channel))
+			return
+		}
+		text = stripped
+		if strings.TrimSpace(text) == "" {
+			client.data.push(client.n.format(ErrNoTextToSend, client.nick, ":No text to send"))
+			return
+		}
+	}
+	var err error
+	if client.config.equalFold(channel, client.config.GlobalChannel) {
+		err = client.pyx().SendGlobalChat(text, isEmote)
 	} else {
 		// we need to let err belong to the outer scope
 		var gameId int
+		if _, watched := client.watchedGame(channel); watched != nil {
+			client.data.push(client.n.format(ErrCannotSendToChan, client.nick,
+				"%s :Cannot send to channel (you are only watching this game)", channel))
+			return
+		}
 		gameId, _, err = client.getGameFromChannel(channel)
-		if err != nil || gameId != *client.gameId {
+		if err != nil || !client.inGame(gameId) {
 			// unreal uses this for either
-			client.data <- client.n.format(ErrNoSuchNick, client.nick, "%s :No such nick/channel",
-				channel)
+			client.data.push(client.n.format(ErrNoSuchNick, client.nick, "%s :No such nick/channel",
+				channel))
 			return
 		}
-		err = client.pyx.SendGameChat(gameId, text, isEmote)
+		err = client.pyx().SendGameChat(gameId, text, isEmote)
 	}
 
-	if err != nil {
-		client.data <- client.n.format(ErrCannotSendToChan, client.nick,
-			"%s :Cannot send to channel: %s", channel, err)
+	if err == pyx.ErrRateLimited {
+		client.data.push(client.n.format(ErrCannotSendToChan, client.nick,
+			"%s :Message not sent, you are sending messages too quickly", channel))
+	} else if err != nil {
+		client.data.push(client.n.format(ErrCannotSendToChan, client.nick,
+			"%s :Cannot send to channel: %s", channel, err))
 	}
 }
 
 func handleWhois(client *Client, msg Message) {
 	if len(msg.args) == 0 {
-		client.data <- client.n.format(ErrNeedMoreParams, client.nick,
-			"WHOIS :Not enough parameters")
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"WHOIS :Not enough parameters"))
 		return
 	}
 
-	if strEqCI(client.config.BotNick, msg.args[0]) {
-		client.data <- client.n.format(RplWhoisUser, client.nick, "%s %s %s * %s",
+	if client.config.equalFold(client.config.BotNick, msg.args[0]) {
+		client.data.push(client.n.format(RplWhoisUser, client.nick, "%s %s %s * %s",
 			client.config.BotNick, client.config.BotUsername, client.config.BotHostname,
-			client.config.BotNick)
+			client.config.BotNick))
 		channels := "&" + client.config.GlobalChannel
-		if client.gameId != nil {
+		if client.inAnyGame() {
 			channels = channels + " &" + client.getGameChannel()
 		}
-		client.data <- client.n.format(RplWhoisChannels, client.nick, "%s :%s",
-			client.config.BotNick, channels)
-		client.data <- client.n.format(RplWhoisServer, client.nick, "%s %s :%s",
-			client.config.BotNick, client.config.AdvertisedName, client.config.Pyx.BaseAddress)
-		client.data <- client.n.format(RplWhoisOperator, client.nick, "%s :is an Administrator",
-			client.config.BotNick)
-		client.data <- client.n.format(RplWhoisBot, client.nick, "%s :is a Bot",
-			client.config.BotNick)
+		client.data.push(client.n.format(RplWhoisChannels, client.nick, "%s :%s",
+			client.config.BotNick, channels))
+		client.data.push(client.n.format(RplWhoisServer, client.nick, "%s %s :%s",
+			client.config.BotNick, client.config.AdvertisedName, client.pyxConfig.BaseAddress))
+		client.data.push(client.n.format(RplWhoisOperator, client.nick, "%s :is an Administrator",
+			client.config.BotNick))
+		client.data.push(client.n.format(RplWhoisBot, client.nick, "%s :is a Bot",
+			client.config.BotNick))
+
+		client.data.push(client.n.format(RplEndOfWhois, client.nick, "%s :End of /WHOIS list.",
+			client.config.BotNick))
+		return
+	}
 
-		client.data <- client.n.format(RplEndOfWhois, client.nick, "%s :End of /WHOIS list.",
-			client.config.BotNick)
+	if client.isNickServ(msg.args[0]) {
+		client.whoisService(client.config.NickServNick, "Nickname Services")
+		return
+	}
+	if client.isGameServ(msg.args[0]) {
+		client.whoisService(client.config.GameServNick, "Game Services")
 		return
 	}
 
 	// TODO special case for bot nick
-	resp, err := client.pyx.Whois(msg.args[0])
+	resp, err := client.pyx().Whois(msg.args[0])
 	if err != nil {
-		if resp.ErrorCode == pyx.ErrorCode_NO_SUCH_USER {
-			client.data <- client.n.format(ErrNoSuchNick, client.nick, "%s :No such nick/channel",
-				msg.args[0])
+		if pyx.ErrorCode(err) == pyx.ErrorCode_NO_SUCH_USER {
+			client.data.push(client.n.format(ErrNoSuchNick, client.nick, "%s :No such nick/channel",
+				msg.args[0]))
 		} else {
 			// I don't think we'd ever get here without something that would abort the connection
-			client.data <- client.n.format(ErrNoSuchNick, client.nick, "%s :%s", msg.args[0], err)
+			client.data.push(client.n.format(ErrNoSuchNick, client.nick, "%s :%s", msg.args[0],
+				err))
 		}
-		client.data <- client.n.format(RplEndOfWhois, client.nick, "%s :End of /WHOIS list.",
-			msg.args[0])
+		client.data.push(client.n.format(RplEndOfWhois, client.nick, "%s :End of /WHOIS list.",
+			msg.args[0]))
 		return
 	}
 
 	nick := resp.Nickname
 	sigil := resp.Sigil
+	client.updateSigil(nick, sigil)
 
-	client.data <- client.n.format(RplWhoisUser, client.nick, "%s %s %s * :%s", nick,
-		getUser(nick), client.getHost(nick), nick)
-	if len(resp.IpAddress) > 0 {
-		client.data <- client.n.format(RplWhoisHost, client.nick, "%s :is connecting from %s", nick,
-			resp.IpAddress)
+	client.data.push(client.n.format(RplWhoisUser, client.nick, "%s %s %s * :%s", nick,
+		getUser(nick), client.getHost(nick), client.roster.realname(nick)))
+	ipAddress := resp.IpAddress
+	if client.config.equalFold(nick, client.nick) {
+		// the server only knows about the bridge's address
+		ipAddress = client.addr
+	}
+	if len(ipAddress) > 0 {
+		client.data.push(client.n.format(RplWhoisHost, client.nick, "%s :is connecting from %s",
+			nick, ipAddress))
 	}
 
 	channels := sigil + client.config.GlobalChannel
-	if resp.GameId != nil {
+	if client.config.equalFold(nick, client.nick) && !client.inGlobalChannel() {
+		channels = ""
+	}
+	if resp.GameInfo != nil {
 		channel := ""
 		if resp.GameInfo.Host == nick {
 			channel = "@"
@@ -599,153 +1026,247 @@ func handleWhois(client *Client, msg Message) {
 		channel = channel + prefix + strconv.Itoa(*resp.GameId)
 		channels = channels + " " + channel
 	}
-	client.data <- client.n.format(RplWhoisChannels, client.nick, "%s :%s", nick, channels)
+	if channels = strings.TrimSpace(channels); channels != "" {
+		client.data.push(client.n.format(RplWhoisChannels, client.nick, "%s :%s", nick, channels))
+	}
 
-	client.data <- client.n.format(RplWhoisServer, client.nick, "%s %s :%s", nick,
-		client.config.AdvertisedName, client.config.Pyx.BaseAddress)
+	client.data.push(client.n.format(RplWhoisServer, client.nick, "%s %s :%s", nick,
+		client.config.AdvertisedName, client.pyxConfig.BaseAddress))
 	if sigil == pyx.Sigil_ADMIN {
-		client.data <- client.n.format(RplWhoisOperator, client.nick, "%s :is an Administrator",
-			nick)
+		client.data.push(client.n.format(RplWhoisOperator, client.nick, "%s :is an Administrator",
+			nick))
 	}
 	if len(resp.IdCode) > 0 {
-		client.data <- client.n.format(RplWhoisSpecial, client.nick, "%s :Verification code: %s",
-			nick, resp.IdCode)
+		client.data.push(client.n.format(RplWhoisSpecial, client.nick, "%s :Verification code: %s",
+			nick, resp.IdCode))
 	}
 	if len(resp.ClientName) > 0 {
-		client.data <- client.n.format(RplWhoisSpecial, client.nick, "%s :Client: %s", nick,
-			resp.ClientName)
-	}
-	client.data <- client.n.format(RplWhoisIdle, client.nick, "%s %d %d :seconds idle, signon time",
-		nick, resp.Idle/1000, resp.ConnectedAt/1000)
-	client.data <- client.n.format(RplEndOfWhois, client.nick, "%s :/End of /WHOIS list.", nick)
-}
-
-func handleList(client *Client, msg Message) {
-	channels, err := client.getChannels()
-	if err != nil {
-		log.Errorf("Unable to retrieve game list for /list: %v", err)
-		client.data <- client.n.format(ErrServiceConfused, client.nick,
-			":Error retrieving game list: %s", err)
-		return
+		client.data.push(client.n.format(RplWhoisSpecial, client.nick, "%s :Client: %s", nick,
+			resp.ClientName))
 	}
-
-	client.data <- client.n.format(RplListStart, client.nick, "Channel :Users  Name")
-	for _, channel := range channels {
-		client.data <- client.n.format(RplList, client.nick, "%s %d :%s", channel.name,
-			channel.totalUsers, channel.topic)
+	if client.isAway(nick) {
+		client.data.push(client.n.format(RplAway, client.nick, "%s :Idle", nick))
 	}
-	client.data <- client.n.format(RplListEnd, client.nick, ":End of /LIST")
+	idle := client.idleTime(nick, time.Duration(resp.Idle)*time.Millisecond)
+	client.data.push(client.n.format(RplWhoisIdle, client.nick,
+		"%s %d %d :seconds idle, signon time", nick, int64(idle.Seconds()), resp.ConnectedAt/1000))
+	client.data.push(client.n.format(RplEndOfWhois, client.nick, "%s :End of /WHOIS list.", nick))
 }
 
 func handlePart(client *Client, msg Message) {
 	if len(msg.args) == 0 {
-		client.data <- client.n.format(ErrNeedMoreParams, client.nick,
-			"PART :Not enough parameters")
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"PART :Not enough parameters"))
+		return
+	}
+	if client.isAdminChannel(msg.args[0]) {
+		if client.partAdminChannel() {
+			client.data.push(fmt.Sprintf(":%s PART %s", client.getNickUserAtHost(client.nick),
+				client.config.AdminChannel))
+		} else {
+			client.data.push(client.n.format(ErrNotOnChannel, client.nick, "%s :Not in channel",
+				msg.args[0]))
+		}
 		return
 	}
-	if strEqCI(msg.args[0], client.config.GlobalChannel) {
-		// don't let them do that. might have to send a response to the irc client?
-		log.Debugf("User %s tried to leave %s", client.nick, client.config.GlobalChannel)
+	if client.config.equalFold(msg.args[0], client.config.GlobalChannel) {
+		// they stay on PYX, we just stop showing them what goes on in there
+		if client.partGlobalChannel() {
+			client.data.push(newLine(client.getNickUserAtHost(client.nick), "PART").
+				param(client.config.GlobalChannel).String())
+		} else {
+			client.data.push(client.n.format(ErrNotOnChannel, client.nick, "%s :Not in channel",
+				msg.args[0]))
+		}
+		return
+	}
+	if gameId, watched := client.watchedGame(msg.args[0]); watched != nil {
+		client.unwatchGame(gameId)
+		client.data.push(newLine(client.getNickUserAtHost(client.nick), "PART").
+			param(watched.channel).String())
 		return
 	}
 	game, _, err := client.getGameFromChannel(msg.args[0])
-	if err != nil || game != *client.gameId {
-		client.data <- client.n.format(ErrNoSuchChannel, client.nick, "%s :No such channel",
-			msg.args[0])
+	if err != nil || !client.inGame(game) {
+		client.data.push(client.n.format(ErrNoSuchChannel, client.nick, "%s :No such channel",
+			msg.args[0]))
 		return
 	}
 
-	resp, err := client.pyx.LeaveGame(game)
+	_, err = client.pyx().LeaveGame(game)
+	code := pyx.ErrorCode(err)
 	// if the server thinks they're not in the game, then we want to process a successful removal
 	// because this is a really weird state that shouldn't happen but we need to synchronize.
 	// We probably would only ever see INVALID_GAME here
-	if err != nil && resp.ErrorCode != pyx.ErrorCode_NOT_IN_THAT_GAME &&
-		resp.ErrorCode != pyx.ErrorCode_INVALID_GAME {
-		client.data <- client.n.format(ErrServiceConfused, client.nick,
-			"%s :Unable to leave channel: %s", msg.args[0], err)
+	if err != nil && code != pyx.ErrorCode_NOT_IN_THAT_GAME && code != pyx.ErrorCode_INVALID_GAME {
+		client.data.push(client.n.format(ErrServiceConfused, client.nick,
+			"%s :Unable to leave channel: %s", msg.args[0], err))
 	} else {
-		client.gameId = nil
-		client.data <- fmt.Sprintf(":%s PART %s", client.getNickUserAtHost(client.nick),
-			msg.args[0])
+		client.leftGame()
+		client.data.push(fmt.Sprintf(":%s PART %s", client.getNickUserAtHost(client.nick),
+			msg.args[0]))
+		if code == pyx.ErrorCode_NOT_IN_THAT_GAME {
+			// they might be in some other game we don't know about
+			client.resync("PYX said they weren't in the game they left")
+		}
 	}
 }
 
 func handleJoin(client *Client, msg Message) {
 	if len(msg.args) == 0 {
-		client.data <- client.n.format(ErrNeedMoreParams, client.nick,
-			"JOIN :Not enough parameters")
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"JOIN :Not enough parameters"))
+		return
+	}
+	if client.isAdminChannel(msg.args[0]) {
+		if client.pyx().User.IsAdmin() {
+			client.joinAdminChannel()
+		} else {
+			client.data.push(client.n.formatSimpleReply(ErrNoPrivileges, client.nick,
+				"Permission Denied- You're not a PYX administrator"))
+		}
 		return
 	}
-	if client.gameId != nil {
-		// only allowed to have one game at a time
-		client.data <- client.n.format(ErrTooManyChannels, client.nick,
-			"%s :Too many joined channels.", msg.args[0])
+	if client.config.equalFold(msg.args[0], client.config.GlobalChannel) {
+		// nothing to do if they never left
+		client.rejoinGlobalChannel()
+		return
+	}
+	if preset, ok := client.config.newGamePreset(msg.args[0]); ok {
+		if client.inAnyGame() {
+			client.data.push(client.n.format(ErrTooManyChannels, client.nick,
+				"%s :Too many joined channels.", msg.args[0]))
+		} else {
+			client.createGame(client.sendServerNotice, preset, nil)
+		}
 		return
 	}
 
 	gameId, spectate, err := client.getGameFromChannel(msg.args[0])
 	if err != nil {
-		client.data <- client.n.format(ErrForbiddenChannel, client.nick,
-			"%s :Forbidden channel: %s", msg.args[0], err)
+		client.data.push(client.n.format(ErrForbiddenChannel, client.nick,
+			"%s :Forbidden channel: %s", msg.args[0], err))
 		return
 	}
 
 	key := ""
 	if len(msg.args) >= 2 {
 		key = msg.args[1]
+	} else if password, ok := client.takeInvite(gameId); ok {
+		key = password
 	}
 
-	// TODO create a new game
-	var resp *pyx.AjaxResponse
+	if current, ok := client.currentGame(); ok {
+		if gameId == current && spectate != client.gameIsSpectate {
+			client.switchGameRole(msg.args[0], gameId, spectate, key)
+		} else if gameId != current && spectate {
+			// PYX won't let us, but we can still show them what's going on
+			client.watchGame(gameId)
+		} else if gameId != current {
+			// only allowed to have one game at a time
+			client.data.push(client.n.format(ErrTooManyChannels, client.nick,
+				"%s :Too many joined channels.", msg.args[0]))
+		}
+		// otherwise they're already in that channel
+		return
+	}
+
+	client.joinGame(msg.args[0], gameId, spectate, key)
+}
+
+// Join or spectate a game on the server, and send the channel join to the client if that worked.
+// Sends the appropriate error to the client and returns false otherwise.
+func (client *Client) joinGame(channel string, gameId int, spectate bool, key string) bool {
+	var err error
 	if spectate {
-		resp, err = client.pyx.SpectateGame(gameId, key)
-		// TODO move this out to be common code once playable games are supported
-		if err != nil {
-			switch resp.ErrorCode {
-			case pyx.ErrorCode_CANNOT_JOIN_ANOTHER_GAME:
-				// we're in a desynchronized state at this point, since we didn't know the user was
-				// in a game...
-				log.Errorf("Desync detected: User %s, pyx server said they're already in a game",
-					client.nick)
-				client.data <- client.n.format(ErrTooManyChannels, client.nick,
-					"%s :Too many joined channels", msg.args[0])
-			case pyx.ErrorCode_GAME_FULL:
-				client.data <- client.n.format(ErrChannelIsFull, client.nick, "%s :Channel is full",
-					msg.args[0])
-			case pyx.ErrorCode_INVALID_GAME:
-				// we will support a special channel name to create a new game, since the server
-				// assigns the game IDs
-				client.data <- client.n.format(ErrNoSuchChannel, client.nick, "%s :No such channel",
-					msg.args[0])
-			case pyx.ErrorCode_WRONG_PASSWORD:
-				client.data <- client.n.format(ErrBadChannelKey, client.nick, "%s :Wrong key",
-					msg.args[0])
-			default:
-				client.data <- client.n.format(ErrServiceConfused, client.nick,
-					"%s :Cannot join game: %s", msg.args[0], err)
-			}
+		_, err = client.pyx().SpectateGame(gameId, key)
+	} else {
+		_, err = client.pyx().JoinGame(gameId, key)
+	}
+	if err != nil {
+		switch pyx.ErrorCode(err) {
+		case pyx.ErrorCode_CANNOT_JOIN_ANOTHER_GAME:
+			// we didn't know the user was in a game, so find out which one and put them there
+			client.resync("PYX said they're already in a game")
+			client.data.push(client.n.format(ErrTooManyChannels, client.nick,
+				"%s :Too many joined channels", channel))
+		case pyx.ErrorCode_GAME_FULL:
+			client.data.push(client.n.format(ErrChannelIsFull, client.nick, "%s :Channel is full",
+				channel))
+		case pyx.ErrorCode_INVALID_GAME:
+			// we will support a special channel name to create a new game, since the server
+			// assigns the game IDs
+			client.data.push(client.n.format(ErrNoSuchChannel, client.nick, "%s :No such channel",
+				channel))
+		case pyx.ErrorCode_WRONG_PASSWORD:
+			client.data.push(client.n.format(ErrBadChannelKey, client.nick, "%s :Wrong key",
+				channel))
+		default:
+			client.data.push(client.n.format(ErrServiceConfused, client.nick,
+				"%s :Cannot join game: %s", channel, err))
+		}
+		return false
+	}
+	client.enteredGame(channel, gameId, spectate)
+	client.setGameKey(key)
+	return true
+}
+
+// Set up for a game the server has put us in, and send the channel join to the client.
+func (client *Client) enteredGame(channel string, gameId int, spectate bool) {
+	if watched, ok := client.watching.get(gameId); ok {
+		// no need to watch it from the outside anymore
+		client.unwatchGame(gameId)
+		client.data.push(newLine(client.getNickUserAtHost(client.nick), "PART").
+			param(watched.channel).String())
+	}
+	client.setCurrentGame(gameId)
+	client.gameIsSpectate = spectate
+	client.gameInProgress = false
+	client.gameScores.forget()
+	client.gameRounds = roundCounter{}
+	if resp, err := client.gameInfo(); err == nil && resp.GameInfo.State == pyx.GameState_LOBBY {
+		client.resetRounds()
+	}
+	client.refreshCustomDecks()
+	client.refreshHand()
+	client.joinChannel(channel)
+}
+
+// Move between playing and spectating the game we are in. The server only lets us be in one seat,
+// so we have to leave and come back. If we can't get the new seat, try to get the old one back.
+func (client *Client) switchGameRole(channel string, gameId int, spectate bool, key string) {
+	oldChannel := client.getGameChannel()
+	_, err := client.pyx().LeaveGame(gameId)
+	if code := pyx.ErrorCode(err); err != nil && code != pyx.ErrorCode_NOT_IN_THAT_GAME &&
+		code != pyx.ErrorCode_INVALID_GAME {
+		client.data.push(client.n.format(ErrServiceConfused, client.nick,
+			"%s :Unable to leave channel %s: %s", channel, oldChannel, err))
+		return
+	}
+	// the rounds played so far are still the same game
+	transcript := client.gameTranscript
+	client.leftGame()
+	client.data.push(fmt.Sprintf(":%s PART %s :Switching to %s",
+		client.getNickUserAtHost(client.nick), oldChannel, channel))
+
+	if !client.joinGame(channel, gameId, spectate, key) {
+		log.Debugf("User %s unable to switch to %s, trying to rejoin %s", client.nick, channel,
+			oldChannel)
+		if !client.joinGame(oldChannel, gameId, !spectate, key) {
 			return
 		}
-		client.gameId = &gameId
-		// TODO move
-		client.gameIsSpectate = spectate
-		client.gameInProgress = false
-		client.joinChannel(msg.args[0])
-	} else {
-		// TODO support playable games
-		// resp, err := client.pyx.JoinGame(gameId, key)
-		client.data <- client.n.format(ErrForbiddenChannel, client.nick,
-			"%s :Cannot join game playing channels", msg.args[0])
 	}
+	client.gameTranscript = transcript
 }
 
 func (client *Client) getChannels() ([]ChannelInfo, error) {
-	resp, err := client.pyx.GameList()
+	resp, err := client.pyx().GameList()
 	if err != nil {
 		return []ChannelInfo{}, err
 	}
 
-	names, err := client.pyx.Names()
+	names, err := client.roster.names(client.pyx())
 	if err != nil {
 		return []ChannelInfo{}, err
 	}
@@ -754,20 +1275,21 @@ func (client *Client) getChannels() ([]ChannelInfo, error) {
 	games := []ChannelInfo{{
 		name:       client.config.GlobalChannel,
 		totalUsers: userCount + 1,
-		topic:      client.getTopic(client.config.GlobalChannel, nil),
+		topic:      client.makeGlobalTopic(),
 	}}
 	for _, game := range resp.Games {
+		cardSets := client.pyx().CardSetNames(game.GameOptions.CardSets)
 		info := ChannelInfo{
 			name:       client.config.GameChannelPrefix + strconv.Itoa(game.Id),
 			totalUsers: totalUserCount(&game),
-			topic:      makeGameTopic(&game),
+			topic:      makeGameTopic(&game, cardSets, nil),
 		}
 		games = append(games, info)
 		if game.GameOptions.SpectatorLimit > 0 {
 			info = ChannelInfo{
 				name:       client.config.SpectateGameChannelPrefix + strconv.Itoa(game.Id),
 				totalUsers: totalUserCount(&game),
-				topic:      "SPECTATE: " + makeGameTopic(&game),
+				topic:      "SPECTATE: " + makeGameTopic(&game, cardSets, nil),
 			}
 			games = append(games, info)
 		}
@@ -775,13 +1297,23 @@ func (client *Client) getChannels() ([]ChannelInfo, error) {
 	return games, nil
 }
 
-func handleWhowas(client *Client, msg Message) {
+func handleKill(client *Client, msg Message) {
+	if !client.pyx().User.IsAdmin() {
+		client.data.push(client.n.formatSimpleReply(ErrNoPrivileges, client.nick,
+			"Permission Denied- You're not a PYX administrator"))
+		return
+	}
 	if len(msg.args) == 0 {
-		client.data <- client.n.format(ErrNeedMoreParams, client.nick,
-			"WHOWAS :Not enough parameters")
+		client.data.push(client.n.formatSimpleReply(ErrNeedMoreParams, msg.cmd,
+			"Not enough parameters"))
 		return
 	}
-	client.data <- client.n.format(ErrWasNoSuchNick, client.nick, "%s :WHOWAS is not supported.",
-		msg.args[0])
-	client.data <- client.n.format(RplEndOfWhowas, client.nick, "%s :/End of WHOWAS", msg.args[0])
+	reason := ""
+	if len(msg.args) > 1 {
+		reason = msg.args[1]
+	}
+	if !client.kill(msg.args[0], reason) {
+		client.data.push(client.n.format(ErrNoSuchNick, client.nick, "%s :No such nick",
+			msg.args[0]))
+	}
 }
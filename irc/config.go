@@ -25,21 +25,70 @@ package irc
 
 import (
 	"github.com/ajanata/pyx-irc/pyx"
+	"strings"
 )
 
 type Config struct {
 	BindAddress               string `toml:"bind_address"`
 	Port                      int
-	AdvertisedName            string `toml:"advertised_name"`
-	NetworkName               string `toml:"network_name"`
-	BotNick                   string `toml:"bot_nick"`
-	BotUsername               string `toml:"bot_username"`
-	BotHostname               string `toml:"bot_hostname"`
-	UserHostname              string `toml:"user_hostname"`
-	GlobalChannel             string `toml:"global_channel"`
-	GameChannelPrefix         string `toml:"game_channel_prefix"`
-	SpectateGameChannelPrefix string `toml:"spectate_game_channel_prefix"`
-	Pyx                       pyx.Config
+	AdvertisedName            string   `toml:"advertised_name"`
+	NetworkName               string   `toml:"network_name"`
+	AdminLocation             string   `toml:"admin_location"`
+	AdminLocation2            string   `toml:"admin_location2"`
+	AdminEmail                string   `toml:"admin_email"`
+	BotNick                   string   `toml:"bot_nick"`
+	BotUsername               string   `toml:"bot_username"`
+	BotHostname               string   `toml:"bot_hostname"`
+	NickServNick              string   `toml:"nickserv_nick"`
+	GameServNick              string   `toml:"gameserv_nick"`
+	UserHostname              string   `toml:"user_hostname"`
+	GlobalChannel             string   `toml:"global_channel"`
+	AdminChannel              string   `toml:"admin_channel"`
+	GameChannelPrefix         string   `toml:"game_channel_prefix"`
+	SpectateGameChannelPrefix string   `toml:"spectate_game_channel_prefix"`
+	MaxWatchedGames           int      `toml:"max_watched_games"`
+	GlobalTopicSeconds        int      `toml:"global_topic_interval"`
+	CaseMapping               string   `toml:"casemapping"`
+	RoundTimerWarning         bool     `toml:"round_timer_warning"`
+	RejectFormatting          bool     `toml:"reject_formatting"`
+	QuietIgnores              bool     `toml:"quiet_ignores"`
+	AutoJoin                  []string `toml:"auto_join"`
+	NewGameChannel            string   `toml:"new_game_channel"`
+	TranscriptDirectory       string   `toml:"transcript_directory"`
+	WebIrcPasswords           []string `toml:"webirc_passwords"`
+	Password                  string   `toml:"password"`
+	ProxyProtocol             bool     `toml:"proxy_protocol"`
+	MaxConnectionsPerIp       int      `toml:"max_connections_per_ip"`
+	ConnectionsPerIpPerMinute int      `toml:"connections_per_ip_per_minute"`
+	FloodBurst                int      `toml:"flood_burst"`
+	FloodLinesPerMinute       int      `toml:"flood_lines_per_minute"`
+	RecvQueueBytes            int      `toml:"recvq"`
+	DisconnectLongLines       bool     `toml:"disconnect_long_lines"`
+	LegacyEncoding            string   `toml:"legacy_encoding"`
+	SendQueueLength           int      `toml:"sendq"`
+	SendQueueDropOldest       bool     `toml:"sendq_drop_oldest"`
+	WriteBufferSize           int      `toml:"write_buffer"`
+	PingIntervalSeconds       int      `toml:"ping_interval"`
+	PingTimeoutSeconds        int      `toml:"ping_timeout"`
+	TraceDirectory            string   `toml:"trace_directory"`
+	KlineFile                 string   `toml:"kline_file"`
+	PreferencesFile           string   `toml:"preferences_file"`
+	WhowasHistorySize         int      `toml:"whowas_history"`
+	ChatHistorySize           int      `toml:"chat_history"`
+	AwayIdleSeconds           int      `toml:"away_idle"`
+	TlsCertFile               string   `toml:"tls_cert"`
+	TlsKeyFile                string   `toml:"tls_key"`
+	// this and disabled_caps are picked up again on REHASH
+	SaslRequiresTls bool     `toml:"sasl_requires_tls"`
+	DisabledCaps    []string `toml:"disabled_caps"`
+	// "sha256 fingerprint=nick:idcode", to log in with a client certificate
+	CertificateLogins []string `toml:"certificate_logins"`
+	Pyx               pyx.Config
+	// other servers users can pick with PASS name:idcode
+	PyxServers []pyx.Config `toml:"pyx_servers"`
+	// options for games created from IRC, and named ones to pick with JOIN #new-name
+	DefaultGame GameProfile            `toml:"default_game"`
+	GamePresets map[string]GameProfile `toml:"game_presets"`
 }
 
 func (config *Config) EnsureDefaults() {
@@ -61,6 +110,12 @@ func (config *Config) EnsureDefaults() {
 	if config.BotUsername == "" {
 		config.BotUsername = "xyzzy"
 	}
+	if config.NickServNick == "" {
+		config.NickServNick = "NickServ"
+	}
+	if config.GameServNick == "" {
+		config.GameServNick = "GameServ"
+	}
 	if config.BotHostname == "" {
 		config.BotHostname = "localhost"
 	}
@@ -70,11 +125,117 @@ func (config *Config) EnsureDefaults() {
 	if config.GlobalChannel == "" {
 		config.GlobalChannel = "#global"
 	}
+	if config.AdminChannel == "" {
+		config.AdminChannel = "&bridge"
+	}
 	if config.GameChannelPrefix == "" {
 		config.GameChannelPrefix = "#game-"
 	}
 	if config.SpectateGameChannelPrefix == "" {
 		config.SpectateGameChannelPrefix = "#watch-"
 	}
+	// negative means they can only be in their own game
+	if config.MaxWatchedGames == 0 {
+		config.MaxWatchedGames = 3
+	}
+	if config.GlobalTopicSeconds == 0 {
+		config.GlobalTopicSeconds = 5 * 60
+	}
+	if config.NewGameChannel == "" {
+		config.NewGameChannel = "#new"
+	}
+	if !validCaseMapping(config.CaseMapping) {
+		if config.CaseMapping != "" {
+			log.Warningf("Unknown casemapping %s, using ascii", config.CaseMapping)
+		}
+		config.CaseMapping = CaseMapping_ASCII
+	}
+	if !validLegacyEncoding(config.LegacyEncoding) {
+		log.Warningf("Unknown legacy encoding %s, only accepting UTF-8", config.LegacyEncoding)
+		config.LegacyEncoding = LegacyEncoding_NONE
+	}
+	// negative values turn the connection limits off
+	if config.MaxConnectionsPerIp == 0 {
+		config.MaxConnectionsPerIp = 5
+	}
+	if config.ConnectionsPerIpPerMinute == 0 {
+		config.ConnectionsPerIpPerMinute = 10
+	}
+	// negative burst turns off flood protection
+	if config.FloodBurst == 0 {
+		config.FloodBurst = 10
+	}
+	if config.FloodLinesPerMinute <= 0 {
+		config.FloodLinesPerMinute = 30
+	}
+	// how much can be waiting while someone's being slowed down before they're disconnected
+	if config.RecvQueueBytes <= 0 {
+		config.RecvQueueBytes = 8192
+	}
+	if config.SendQueueLength <= 0 {
+		config.SendQueueLength = 1000
+	}
+	if config.WriteBufferSize <= 0 {
+		config.WriteBufferSize = 4096
+	}
+	if config.PingIntervalSeconds <= 0 {
+		config.PingIntervalSeconds = 90
+	}
+	if config.PingTimeoutSeconds <= 0 {
+		config.PingTimeoutSeconds = 60
+	}
+	if config.AwayIdleSeconds <= 0 {
+		config.AwayIdleSeconds = 30 * 60
+	}
+	if config.WhowasHistorySize <= 0 {
+		config.WhowasHistorySize = 100
+	}
+	if config.ChatHistorySize <= 0 {
+		config.ChatHistorySize = 500
+	}
 	config.Pyx.EnsureDefaults()
+	for i := range config.PyxServers {
+		(&config.PyxServers[i]).EnsureDefaults()
+	}
+}
+
+// Splits a PASS into the bridge password, the PYX server to use, and the id code. PASS can be
+// "idcode", "server:idcode", or "user:idcode" for bouncers that send a username, which is ignored.
+// If the bridge has a password, everyone has to give it first, like "bridgepass/server:idcode".
+// This means id codes with a colon in them can only be used with SASL or a certificate login.
+func (config *Config) parsePass(pass string) (string, *pyx.Config, string) {
+	bridgePass := ""
+	if config.Password != "" {
+		parts := strings.SplitN(pass, "/", 2)
+		bridgePass = parts[0]
+		pass = ""
+		if len(parts) == 2 {
+			pass = parts[1]
+		}
+	}
+	pyxConfig, idcode := config.splitPyxServerPass(pass)
+	if idcode == pass {
+		if i := strings.Index(pass, ":"); i >= 0 {
+			idcode = pass[i+1:]
+		}
+	}
+	return bridgePass, pyxConfig, idcode
+}
+
+// Splits a PASS into the PYX server to use and the id code. The server can be chosen by prefixing
+// the id code with its name and a colon; otherwise, the default server is used and the whole
+// thing is the id code.
+func (config *Config) splitPyxServerPass(pass string) (*pyx.Config, string) {
+	parts := strings.SplitN(pass, ":", 2)
+	if len(parts) == 2 {
+		for i := range config.PyxServers {
+			if strEqCI(parts[0], config.PyxServers[i].Name) {
+				return &config.PyxServers[i], parts[1]
+			}
+		}
+		if strEqCI(parts[0], config.Pyx.Name) {
+			return &config.Pyx, parts[1]
+		}
+	}
+	return &config.Pyx, pass
 }
@@ -0,0 +1,1709 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// End-to-end tests that drive the bridge over a real socket against the mock PYX server
+
+package irc
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"github.com/ajanata/pyx-irc/pyx"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// how long to wait for an expected line before giving up
+const e2eTimeout = 5 * time.Second
+
+// a line from the server, split apart
+type serverLine struct {
+	raw     string
+	tags    map[string]string
+	prefix  string
+	command string
+	params  []string
+}
+
+func parseServerLine(raw string) serverLine {
+	line := serverLine{raw: raw, tags: make(map[string]string)}
+	rest := raw
+	if strings.HasPrefix(rest, "@") {
+		parts := strings.SplitN(rest[1:], " ", 2)
+		for _, tag := range strings.Split(parts[0], ";") {
+			kv := strings.SplitN(tag, "=", 2)
+			if len(kv) == 2 {
+				line.tags[kv[0]] = kv[1]
+			} else {
+				line.tags[kv[0]] = ""
+			}
+		}
+		rest = ""
+		if len(parts) > 1 {
+			rest = parts[1]
+		}
+	}
+	if strings.HasPrefix(rest, ":") {
+		parts := strings.SplitN(rest[1:], " ", 2)
+		line.prefix = parts[0]
+		rest = ""
+		if len(parts) > 1 {
+			rest = parts[1]
+		}
+	}
+	for len(rest) > 0 {
+		if strings.HasPrefix(rest, ":") {
+			line.params = append(line.params, rest[1:])
+			break
+		}
+		parts := strings.SplitN(rest, " ", 2)
+		if line.command == "" {
+			line.command = parts[0]
+		} else {
+			line.params = append(line.params, parts[0])
+		}
+		rest = ""
+		if len(parts) > 1 {
+			rest = parts[1]
+		}
+	}
+	return line
+}
+
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	nick   string
+}
+
+// Start a bridge listening on a random port, talking to a fresh mock PYX server.
+// configure can change the config before the bridge starts using it.
+func startBridge(t *testing.T, configure ...func(*Config)) (*mockPyx, *Config) {
+	mock := newMockPyx(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		BindAddress: "127.0.0.1",
+		Port:        listener.Addr().(*net.TCPAddr).Port,
+		// everything comes from the same address in tests
+		MaxConnectionsPerIp:       -1,
+		ConnectionsPerIpPerMinute: -1,
+		FloodBurst:                -1,
+		// so settings from one test don't leak into the next
+		PreferencesFile: filepath.Join(t.TempDir(), "preferences.json"),
+	}
+	config.Pyx.BaseAddress = mock.baseAddress()
+	for _, f := range configure {
+		f(config)
+	}
+	config.EnsureDefaults()
+	go NewManager(listener, config)
+	t.Cleanup(func() { listener.Close() })
+	return mock, config
+}
+
+func dial(t *testing.T, config *Config) *testClient {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", config.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (tc *testClient) send(format string, args ...interface{}) {
+	tc.t.Helper()
+	if _, err := fmt.Fprintf(tc.conn, format+"\r\n", args...); err != nil {
+		tc.t.Fatalf("unable to send: %v", err)
+	}
+}
+
+// Read the next line from the server, answering any PINGs along the way.
+func (tc *testClient) read() serverLine {
+	tc.t.Helper()
+	for {
+		tc.conn.SetReadDeadline(time.Now().Add(e2eTimeout))
+		raw, err := tc.reader.ReadString('\n')
+		if err != nil {
+			tc.t.Fatalf("unable to read: %v", err)
+		}
+		line := parseServerLine(strings.TrimRight(raw, "\r\n"))
+		if line.command == "PING" {
+			tc.send("PONG :%s", strings.Join(line.params, " "))
+			continue
+		}
+		return line
+	}
+}
+
+// Skip lines until one with the given command or numeric shows up.
+func (tc *testClient) expect(command string) serverLine {
+	tc.t.Helper()
+	var skipped []string
+	deadline := time.Now().Add(e2eTimeout)
+	for time.Now().Before(deadline) {
+		line := tc.read()
+		if line.command == command {
+			return line
+		}
+		skipped = append(skipped, line.raw)
+	}
+	tc.t.Fatalf("never got %s, skipped:\n%s", command, strings.Join(skipped, "\n"))
+	return serverLine{}
+}
+
+// Expect the given commands in order, with anything else allowed in between.
+func (tc *testClient) expectSequence(commands ...string) []serverLine {
+	tc.t.Helper()
+	var lines []serverLine
+	for _, command := range commands {
+		lines = append(lines, tc.expect(command))
+	}
+	return lines
+}
+
+// Register with the given nick and wait until we've joined the global channel.
+func (tc *testClient) register(nick string) {
+	tc.t.Helper()
+	tc.nick = nick
+	tc.send("NICK %s", nick)
+	tc.send("USER %s 0 * :%s", nick, nick)
+	tc.expectSequence(RplWelcome, RplYourHost, RplMyInfo, RplISupport)
+	join := tc.expect("JOIN")
+	if !strings.HasPrefix(join.prefix, nick+"!") {
+		tc.t.Fatalf("expected own join, got %s", join.raw)
+	}
+	tc.expect(RplEndNames)
+}
+
+func TestE2eRegister(t *testing.T) {
+	_, config := startBridge(t)
+	tc := dial(t, config)
+	tc.send("NICK %s", "alice")
+	tc.send("USER %s 0 * :%s", "alice", "alice")
+	lines := tc.expectSequence(RplWelcome, "JOIN", RplNames, RplEndNames)
+	if lines[0].params[0] != "alice" {
+		t.Errorf("welcome sent to %s", lines[0].params[0])
+	}
+	if lines[1].params[0] != config.GlobalChannel {
+		t.Errorf("joined %s instead of %s", lines[1].params[0], config.GlobalChannel)
+	}
+	if !strings.Contains(lines[2].params[3], "alice") {
+		t.Errorf("names didn't include alice: %s", lines[2].raw)
+	}
+}
+
+func TestE2eNickInUse(t *testing.T) {
+	_, config := startBridge(t)
+	alice := dial(t, config)
+	alice.register("alice")
+
+	other := dial(t, config)
+	other.send("NICK %s", "alice")
+	line := other.expect(ErrNicknameInUse)
+	if line.params[1] != "alice" {
+		t.Errorf("wrong nick in use: %s", line.raw)
+	}
+	// and they can pick another one
+	other.register("alice_")
+}
+
+func TestE2eNickInUseOnPyx(t *testing.T) {
+	mock, config := startBridge(t)
+	// somebody using the web client, who the bridge hasn't seen yet
+	mock.addUser("bob")
+	tc := dial(t, config)
+	tc.send("NICK %s", "bob")
+	tc.send("USER %s 0 * :%s", "bob", "bob")
+	line := tc.expect(ErrNicknameInUse)
+	if line.params[1] != "bob" {
+		t.Errorf("wrong nick in use: %s", line.raw)
+	}
+	// the rest of registration carries on with the new nick
+	tc.send("NICK %s", "bob_")
+	line = tc.expect(RplWelcome)
+	if line.params[0] != "bob_" {
+		t.Errorf("registered with the wrong nick: %s", line.raw)
+	}
+}
+
+func TestE2eInvalidNick(t *testing.T) {
+	_, config := startBridge(t)
+	tc := dial(t, config)
+	for _, nick := range []string{"a", "1alice", "xyzzy"} {
+		tc.send("NICK %s", nick)
+		line := tc.expect(ErrErroneousNickname)
+		if line.params[1] != nick {
+			t.Errorf("wrong nick rejected: %s", line.raw)
+		}
+	}
+	tc.register("alice")
+}
+
+func TestE2eInvalidIdCode(t *testing.T) {
+	_, config := startBridge(t)
+	tc := dial(t, config)
+	tc.send("PASS short")
+	line := tc.expect(ErrPasswdMismatch)
+	if !strings.Contains(line.raw, "between 8 and 100") {
+		t.Errorf("unexpected reason: %s", line.raw)
+	}
+	tc.send("NICK alice")
+	tc.send("USER alice 0 * :alice")
+	tc.expect("ERROR")
+}
+
+func TestE2eList(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "bob")
+	tc := dial(t, config)
+	tc.register("alice")
+
+	tc.send("LIST")
+	lines := tc.expectSequence(RplListStart, RplList)
+	found := false
+	for {
+		if lines[len(lines)-1].params[1] == config.GameChannelPrefix+"1" {
+			found = true
+		}
+		line := tc.read()
+		if line.command == RplListEnd {
+			break
+		}
+		if line.command == RplList {
+			lines = append(lines, line)
+		}
+	}
+	if !found {
+		t.Errorf("%s1 wasn't listed", config.GameChannelPrefix)
+	}
+}
+
+func TestE2eJoinGame(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "bob")
+	tc := dial(t, config)
+	tc.register("alice")
+
+	channel := config.GameChannelPrefix + "1"
+	tc.send("JOIN %s", channel)
+	join := tc.expect("JOIN")
+	if !strEqCI(join.params[0], channel) {
+		t.Fatalf("joined %s instead of %s", join.params[0], channel)
+	}
+	names := tc.expect(RplNames)
+	if !strings.Contains(names.params[3], "bob") || !strings.Contains(names.params[3], "alice") {
+		t.Errorf("names didn't include both players: %s", names.raw)
+	}
+	tc.expect(RplEndNames)
+
+	tc.send("PART %s", channel)
+	part := tc.expect("PART")
+	if !strEqCI(part.params[0], channel) {
+		t.Errorf("parted %s instead of %s", part.params[0], channel)
+	}
+}
+
+func TestE2eWhois(t *testing.T) {
+	_, config := startBridge(t)
+	alice := dial(t, config)
+	alice.register("alice")
+	bob := dial(t, config)
+	bob.register("bob")
+
+	alice.send("WHOIS bob")
+	lines := alice.expectSequence(RplWhoisUser, RplEndOfWhois)
+	if lines[0].params[1] != "bob" {
+		t.Errorf("whois for the wrong user: %s", lines[0].raw)
+	}
+
+	alice.send("WHOIS nobody")
+	alice.expect(ErrNoSuchNick)
+}
+
+func TestE2eServerQueries(t *testing.T) {
+	_, config := startBridge(t)
+	tc := dial(t, config)
+	tc.register("alice")
+
+	tc.send("VERSION")
+	tc.expectSequence(RplVersion, RplISupport)
+	tc.send("TIME")
+	tc.expect(RplTime)
+	tc.send("ADMIN")
+	tc.expect(ErrNoAdminInfo)
+	tc.send("INFO")
+	tc.expectSequence(RplInfo, RplEndOfInfo)
+}
+
+func TestE2eSaslPlain(t *testing.T) {
+	_, config := startBridge(t)
+	tc := dial(t, config)
+	tc.send("CAP LS")
+	tc.send("NICK alice")
+	tc.send("USER alice 0 * :alice")
+	tc.expect("CAP")
+	tc.send("CAP REQ :sasl")
+	tc.expect("CAP")
+	tc.send("AUTHENTICATE PLAIN")
+	tc.expect("AUTHENTICATE")
+	tc.send("AUTHENTICATE %s", base64.StdEncoding.EncodeToString([]byte("\x00alice\x00secretcode")))
+	lines := tc.expectSequence(RplLoggedIn, RplSaslSuccess)
+	if lines[0].params[2] != "alice" {
+		t.Errorf("logged in as the wrong account: %s", lines[0].raw)
+	}
+	tc.send("CAP END")
+	tc.expect(RplWelcome)
+}
+
+func TestE2eSaslBeforeNick(t *testing.T) {
+	_, config := startBridge(t)
+	tc := dial(t, config)
+	tc.send("CAP REQ :sasl")
+	tc.expect("CAP")
+	tc.send("AUTHENTICATE PLAIN")
+	tc.expect("AUTHENTICATE")
+	tc.send("AUTHENTICATE %s", base64.StdEncoding.EncodeToString([]byte("\x00alice\x00secretcode")))
+	tc.expectSequence(RplLoggedIn, RplSaslSuccess)
+
+	// the account is checked against the nick whichever comes first
+	tc.send("NICK bob")
+	if reply := tc.expect(ErrErroneousNickname); reply.params[1] != "bob" {
+		t.Errorf("For a nick that isn't the SASL account expected it refused, got %s", reply.raw)
+	}
+	tc.send("NICK alice")
+	tc.send("USER alice 0 * :alice")
+	tc.send("CAP END")
+	if welcome := tc.expect(RplWelcome); welcome.params[0] != "alice" {
+		t.Errorf("For the SASL account's nick expected to be welcomed, got %s", welcome.raw)
+	}
+}
+
+func TestE2eNickServIdentify(t *testing.T) {
+	_, config := startBridge(t)
+	tc := dial(t, config)
+	tc.register("alice")
+	tc.send("PRIVMSG NickServ :IDENTIFY secretcode")
+	// the sigil change comes first as +v in the global channel
+	tc.expect("MODE")
+	mode := tc.expect("MODE")
+	if mode.params[0] != "alice" || mode.params[len(mode.params)-1] != "+r" {
+		t.Errorf("expected +r, got %s", mode.raw)
+	}
+	notice := tc.expect("NOTICE")
+	if !strings.Contains(notice.raw, "identified") {
+		t.Errorf("unexpected reply from NickServ: %s", notice.raw)
+	}
+	tc.send("NS IDENTIFY secretcode")
+	notice = tc.expect("NOTICE")
+	if !strings.Contains(notice.raw, "already identified") {
+		t.Errorf("unexpected reply from NickServ: %s", notice.raw)
+	}
+}
+
+func TestE2eGameServ(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "bob")
+	tc := dial(t, config)
+	tc.register("alice")
+
+	tc.send("PRIVMSG GameServ :LIST lobby bob")
+	list := tc.expect("NOTICE")
+	if !strings.Contains(list.raw, config.GameChannelPrefix+"1") {
+		t.Errorf("game wasn't listed: %s", list.raw)
+	}
+	tc.expect("NOTICE")
+
+	tc.send("GS CREATE score=5")
+	join := tc.expect("JOIN")
+	if !strEqCI(join.params[0], config.GameChannelPrefix+"2") {
+		t.Errorf("joined %s instead of the new game", join.params[0])
+	}
+	tc.expectSequence(RplNames, RplEndNames, "NOTICE", "NOTICE")
+	mock.lock.Lock()
+	if mock.games[2].GameOptions.ScoreLimit != 5 {
+		t.Errorf("options weren't changed: %v", mock.games[2].GameOptions)
+	}
+	mock.lock.Unlock()
+
+	tc.send("GS JOIN 1")
+	notice := tc.expect("NOTICE")
+	if !strings.Contains(notice.raw, "already in") {
+		t.Errorf("unexpected reply from GameServ: %s", notice.raw)
+	}
+}
+
+func TestE2eQuietJoins(t *testing.T) {
+	_, config := startBridge(t)
+	alice := dial(t, config)
+	alice.register("alice")
+	alice.send("PRIVMSG %s :SET QUIETJOINS on", config.BotNick)
+	alice.expect("PRIVMSG")
+
+	bob := dial(t, config)
+	bob.register("bob")
+	// give bob's join time to get to alice, then make sure it didn't
+	time.Sleep(500 * time.Millisecond)
+	alice.send("PRIVMSG %s :HELP SET", config.BotNick)
+	for {
+		line := alice.read()
+		if line.command == "JOIN" {
+			t.Fatalf("bob's join wasn't held back: %s", line.raw)
+		}
+		if line.command == "PRIVMSG" {
+			break
+		}
+	}
+
+	bob.send("PRIVMSG %s :hello", config.GlobalChannel)
+	lines := alice.expectSequence("JOIN", "PRIVMSG")
+	if !strings.HasPrefix(lines[0].prefix, "bob!") || !strings.HasPrefix(lines[1].prefix, "bob!") {
+		t.Errorf("expected bob to join and then talk, got %s and %s", lines[0].raw, lines[1].raw)
+	}
+}
+
+func TestE2eAccounts(t *testing.T) {
+	_, config := startBridge(t)
+	alice := dial(t, config)
+	alice.send("CAP REQ :extended-join account-tag")
+	alice.expect("CAP")
+	alice.send("CAP END")
+	alice.register("alice")
+
+	bob := dial(t, config)
+	bob.send("PASS secretcode")
+	bob.register("bob")
+	join := alice.expect("JOIN")
+	if len(join.params) != 3 || join.params[1] != "bob" || join.params[2] != "bob" {
+		t.Errorf("expected bob's account in the join, got %s", join.raw)
+	}
+	bob.send("PRIVMSG %s :hello", config.GlobalChannel)
+	msg := alice.expect("PRIVMSG")
+	if msg.tags["account"] != "bob" {
+		t.Errorf("expected bob's account on the message, got %s", msg.raw)
+	}
+
+	carol := dial(t, config)
+	carol.register("carol")
+	join = alice.expect("JOIN")
+	if len(join.params) != 3 || join.params[1] != "*" {
+		t.Errorf("expected no account in carol's join, got %s", join.raw)
+	}
+	carol.send("PRIVMSG %s :hi", config.GlobalChannel)
+	msg = alice.expect("PRIVMSG")
+	if _, ok := msg.tags["account"]; ok {
+		t.Errorf("expected no account on carol's message, got %s", msg.raw)
+	}
+}
+
+func TestE2eLabeledResponse(t *testing.T) {
+	_, config := startBridge(t)
+	tc := dial(t, config)
+	tc.send("CAP REQ :labeled-response batch")
+	tc.expect("CAP")
+	tc.send("CAP END")
+	tc.register("alice")
+
+	tc.send("@label=one PING :hello")
+	pong := tc.expect("PONG")
+	if pong.tags["label"] != "one" {
+		t.Errorf("PONG wasn't labeled: %s", pong.raw)
+	}
+
+	tc.send("@label=two WHOIS alice")
+	start := tc.read()
+	if start.command != "BATCH" || start.tags["label"] != "two" ||
+		start.params[1] != "labeled-response" {
+		t.Fatalf("expected a labeled batch, got %s", start.raw)
+	}
+	ref := start.params[0][1:]
+	for {
+		line := tc.read()
+		if line.command == "BATCH" {
+			if line.params[0] != "-"+ref {
+				t.Errorf("wrong batch ended: %s", line.raw)
+			}
+			break
+		}
+		if line.tags["batch"] != ref {
+			t.Errorf("line outside of the batch: %s", line.raw)
+		}
+	}
+
+	// nothing to say, but they still get told we're done
+	tc.send("@label=three MONITOR - bob")
+	ack := tc.read()
+	if ack.command != "ACK" || ack.tags["label"] != "three" {
+		t.Errorf("expected a labeled ACK, got %s", ack.raw)
+	}
+}
+
+func TestE2eCapNotify(t *testing.T) {
+	_, config := startBridge(t)
+	tc := dial(t, config)
+	tc.send("CAP LS 302")
+	ls := tc.expect("CAP")
+	if !strings.Contains(ls.params[2], "sasl=PLAIN") || strings.Contains(ls.params[2], "EXTERNAL") {
+		t.Errorf("expected sasl with its mechanisms, got %s", ls.raw)
+	}
+	tc.send("CAP REQ :chghost")
+	tc.expect("CAP")
+	tc.send("CAP END")
+	tc.register("alice")
+
+	changed := *config
+	changed.DisabledCaps = []string{"chghost"}
+	Rehash([]Config{changed})
+	del := tc.expect("CAP")
+	if del.params[1] != "DEL" || del.params[2] != "chghost" {
+		t.Errorf("expected chghost to be removed, got %s", del.raw)
+	}
+	tc.send("CAP LIST")
+	list := tc.expect("CAP")
+	if strings.Contains(list.params[2], "chghost") {
+		t.Errorf("removed cap is still enabled: %s", list.raw)
+	}
+
+	Rehash([]Config{*config})
+	added := tc.expect("CAP")
+	if added.params[1] != "NEW" || added.params[2] != "chghost" {
+		t.Errorf("expected chghost to be back, got %s", added.raw)
+	}
+	// they have to ask for it again
+	tc.send("CAP LIST")
+	list = tc.expect("CAP")
+	if strings.Contains(list.params[2], "chghost") {
+		t.Errorf("re-added cap was enabled without asking: %s", list.raw)
+	}
+}
+
+func TestE2eBouncer(t *testing.T) {
+	_, config := startBridge(t)
+	tc := dial(t, config)
+	// roughly what ZNC does
+	tc.send("CAP LS 302")
+	tc.send("PASS secretcode")
+	tc.send("NICK alice")
+	tc.expect("CAP")
+	tc.send("PONG :%s", config.AdvertisedName)
+	tc.send("CAP REQ :znc.in/self-message")
+	tc.expect("CAP")
+	tc.send("CAP END")
+	// registration waits for USER
+	tc.send("PING :before")
+	pong := tc.read()
+	if pong.command != "PONG" {
+		t.Fatalf("expected PONG before registration, got %s", pong.raw)
+	}
+	tc.nick = "alice"
+	tc.send("USER alice 0 * :alice")
+	tc.expectSequence(RplWelcome, RplEndNames)
+
+	tc.send("PRIVMSG %s :hello", config.GlobalChannel)
+	msg := tc.expect("PRIVMSG")
+	if !strings.HasPrefix(msg.prefix, "alice!") || msg.params[1] != "hello" {
+		t.Errorf("expected our own message back, got %s", msg.raw)
+	}
+}
+
+func TestE2eEchoMessage(t *testing.T) {
+	_, config := startBridge(t)
+	tc := dial(t, config)
+	tc.send("CAP REQ :echo-message")
+	tc.expect("CAP")
+	tc.send("CAP END")
+	tc.register("alice")
+	tc.send("PRIVMSG %s :HELP", config.BotNick)
+	msg := tc.expect("PRIVMSG")
+	if !strings.HasPrefix(msg.prefix, "alice!") || msg.params[0] != config.BotNick {
+		t.Errorf("expected our own message back first, got %s", msg.raw)
+	}
+}
+
+func TestE2eIsonMonitor(t *testing.T) {
+	_, config := startBridge(t)
+	alice := dial(t, config)
+	alice.register("alice")
+	alice.send("ISON alice bob %s", config.BotNick)
+	ison := alice.expect(RplIson)
+	if ison.params[1] != "alice "+config.BotNick {
+		t.Errorf("wrong ISON reply: %s", ison.raw)
+	}
+
+	alice.send("MONITOR + bob")
+	alice.expect(RplMonOffline)
+	bob := dial(t, config)
+	bob.register("bob")
+	online := alice.expect(RplMonOnline)
+	if !strings.HasPrefix(online.params[1], "bob!") {
+		t.Errorf("wrong MONITOR notification: %s", online.raw)
+	}
+}
+
+func TestE2ePlayback(t *testing.T) {
+	_, config := startBridge(t)
+	alice := dial(t, config)
+	alice.register("alice")
+	alice.send("PRIVMSG %s :hello", config.GlobalChannel)
+
+	bob := dial(t, config)
+	bob.send("CAP REQ :server-time znc.in/playback")
+	bob.expect("CAP")
+	bob.send("CAP END")
+	bob.register("bob")
+	bob.send("PRIVMSG *playback :PLAY * 0")
+	msg := bob.expect("PRIVMSG")
+	if !strings.HasPrefix(msg.prefix, "alice!") || msg.params[1] != "hello" || msg.tags["time"] == "" {
+		t.Errorf("expected alice's message with a time, got %s", msg.raw)
+	}
+
+	bob.send("PRIVMSG %s :PLAYBACK", config.BotNick)
+	msg = bob.expect("PRIVMSG")
+	if msg.params[1] != "hello" {
+		t.Errorf("expected the bot to play back alice's message, got %s", msg.raw)
+	}
+}
+
+func TestE2eSetname(t *testing.T) {
+	_, config := startBridge(t)
+	tc := dial(t, config)
+	tc.send("CAP REQ :setname")
+	tc.expect("CAP")
+	tc.send("CAP END")
+	tc.nick = "alice"
+	tc.send("NICK alice")
+	tc.send("USER alice 0 * :Alice Liddell")
+	tc.expectSequence(RplWelcome, RplEndNames)
+
+	tc.send("WHOIS alice")
+	whois := tc.expect(RplWhoisUser)
+	if whois.params[len(whois.params)-1] != "Alice Liddell" {
+		t.Errorf("expected the realname from USER, got %s", whois.raw)
+	}
+
+	tc.send("SETNAME :Through the Looking-Glass")
+	setname := tc.expect("SETNAME")
+	if !strings.HasPrefix(setname.prefix, "alice!") || setname.params[0] != "Through the Looking-Glass" {
+		t.Errorf("wrong SETNAME reply: %s", setname.raw)
+	}
+	tc.send("WHO %s", config.GlobalChannel)
+	for {
+		who := tc.expect(RplWho)
+		if who.params[5] == "alice" {
+			if who.params[len(who.params)-1] != "0 Through the Looking-Glass" {
+				t.Errorf("expected the new realname, got %s", who.raw)
+			}
+			break
+		}
+	}
+}
+
+func TestE2eJudgeMode(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "bob")
+	tc := dial(t, config)
+	tc.register("alice")
+	channel := config.GameChannelPrefix + "1"
+	tc.send("JOIN %s", channel)
+	tc.expect(RplEndNames)
+
+	mock.startRound(1, "bob", pyx.BlackCardData{Pick: 1, Text: "Why can't I sleep at night?"})
+	mode := tc.expect("MODE")
+	if mode.params[1] != "+a" || mode.params[2] != "bob" {
+		t.Errorf("expected +a for the judge, got %s", mode.raw)
+	}
+	tc.send("NAMES %s", channel)
+	names := tc.expect(RplNames)
+	if !strings.Contains(names.params[3], "&bob") {
+		t.Errorf("expected the judge to be shown with &, got %s", names.raw)
+	}
+}
+
+func TestE2eHurryUp(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "bob")
+	tc := dial(t, config)
+	tc.register("alice")
+	channel := config.GameChannelPrefix + "1"
+	tc.send("JOIN %s", channel)
+	tc.expect(RplEndNames)
+
+	gameId := 1
+	mock.lock.Lock()
+	mock.broadcast(&gameId, map[string]interface{}{"E": pyx.LongPollEvent_HURRY_UP, "gid": 1})
+	mock.lock.Unlock()
+	notice := tc.expect("NOTICE")
+	if notice.params[0] != "alice" || !strings.Contains(notice.params[1], "Hurry up") {
+		t.Errorf("expected a hurry up notice, got %s", notice.raw)
+	}
+
+	mock.lock.Lock()
+	mock.broadcast(&gameId, map[string]interface{}{"E": pyx.LongPollEvent_KICKED_FROM_GAME_IDLE,
+		"gid": 1})
+	mock.lock.Unlock()
+	kick := tc.expect("KICK")
+	if !strEqCI(kick.params[0], channel) || kick.params[1] != "alice" {
+		t.Errorf("expected to be kicked from %s, got %s", channel, kick.raw)
+	}
+}
+
+func TestE2eRejoinAfterReconnect(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "bob")
+	tc := dial(t, config)
+	tc.register("alice")
+	channel := config.GameChannelPrefix + "1"
+	tc.send("JOIN %s", channel)
+	tc.expect(RplEndNames)
+
+	// a proxy gave up on the poll after PYX answered it, so alice never heard she was dropped
+	mock.dropFromGame("alice")
+	if notice := tc.expect("NOTICE"); !strings.Contains(notice.params[1], "may have been missed") {
+		t.Errorf("expected to hear things may have been missed, got %s", notice.raw)
+	}
+	topic := tc.expect("TOPIC")
+	if !strEqCI(topic.params[0], channel) {
+		t.Errorf("expected the topic for %s again, got %s", channel, topic.raw)
+	}
+	names := tc.expect(RplNames)
+	if !strings.Contains(names.params[3], "alice") {
+		t.Errorf("expected to be back in the game, got %s", names.raw)
+	}
+}
+
+func TestE2eAutoJoin(t *testing.T) {
+	mock, config := startBridge(t, func(config *Config) {
+		config.AutoJoin = []string{"#watch-1", "#game-2"}
+	})
+	mock.addGame(1, "bob")
+	mock.addGame(2, "carol")
+	tc := dial(t, config)
+	tc.register("alice")
+	join := tc.expect("JOIN")
+	if !strEqCI(join.params[0], "#watch-1") {
+		t.Errorf("expected to spectate game 1, got %s", join.raw)
+	}
+	tc.expect(RplEndNames)
+
+	// only one game at a time, so #game-2 is skipped
+	tc.send("PING :done")
+	for {
+		line := tc.read()
+		if line.command == "PONG" {
+			break
+		}
+		if line.command == "JOIN" || line.command == ErrTooManyChannels {
+			t.Errorf("expected nothing else to be joined, got %s", line.raw)
+		}
+	}
+}
+
+func TestE2eJoinNewGamePreset(t *testing.T) {
+	mock, config := startBridge(t, func(config *Config) {
+		config.DefaultGame = GameProfile{ScoreLimit: 5, CardSets: []int{1}}
+		config.GamePresets = map[string]GameProfile{"casual": {ScoreLimit: 3, PlayerLimit: 4}}
+	})
+	tc := dial(t, config)
+	tc.register("alice")
+
+	tc.send("JOIN #new-bogus")
+	notice := tc.expect("NOTICE")
+	if !strings.Contains(notice.params[1], "casual") {
+		t.Errorf("expected the presets to be listed, got %s", notice.raw)
+	}
+
+	tc.send("JOIN #NEW-Casual")
+	join := tc.expect("JOIN")
+	if !strEqCI(join.params[0], "#game-1") {
+		t.Errorf("expected to join the new game, got %s", join.raw)
+	}
+	for {
+		notice = tc.expect("NOTICE")
+		if strings.Contains(notice.params[1], "Options set") {
+			break
+		}
+	}
+
+	mock.lock.Lock()
+	options := mock.games[1].GameOptions
+	mock.lock.Unlock()
+	expected := pyx.GameOptionData{ScoreLimit: 3, PlayerLimit: 4, SpectatorLimit: 10,
+		CardSets: []int{1}}
+	if !reflect.DeepEqual(options, expected) {
+		t.Errorf("expected options %+v, got %+v", expected, options)
+	}
+
+	tc.send("JOIN #new")
+	tc.expect(ErrTooManyChannels)
+}
+
+func TestE2eKnockAndInvite(t *testing.T) {
+	_, config := startBridge(t)
+	alice := dial(t, config)
+	alice.register("alice")
+	bob := dial(t, config)
+	bob.register("bob")
+
+	alice.send("GS CREATE password=secret")
+	alice.expect("JOIN")
+	for {
+		notice := alice.expect("NOTICE")
+		if strings.Contains(notice.params[1], "Options set") {
+			break
+		}
+	}
+
+	bob.send("JOIN #game-1")
+	bob.expect(ErrBadChannelKey)
+	bob.send("KNOCK #game-1 :let me in")
+	bob.expect(RplKnockDlvr)
+	knock := alice.expect(RplKnock)
+	if len(knock.params) < 4 || knock.params[1] != "#game-1" ||
+		!strings.HasPrefix(knock.params[2], "bob!") || knock.params[3] != "let me in" {
+		t.Errorf("expected bob's knock on #game-1, got %s", knock.raw)
+	}
+	bob.send("KNOCK #game-1")
+	bob.expect(ErrTooManyKnock)
+
+	alice.send("INVITE bob #game-1")
+	alice.expect(RplInviting)
+	invite := bob.expect("INVITE")
+	if !strings.HasPrefix(invite.prefix, "alice!") || invite.params[1] != "#game-1" {
+		t.Errorf("expected an invite from alice to #game-1, got %s", invite.raw)
+	}
+	bob.send("JOIN #game-1")
+	join := bob.expect("JOIN")
+	if !strEqCI(join.params[0], "#game-1") {
+		t.Errorf("expected to join #game-1, got %s", join.raw)
+	}
+}
+
+func TestE2eWhisper(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addUser("carol")
+	alice := dial(t, config)
+	alice.register("alice")
+	bob := dial(t, config)
+	bob.register("bob")
+
+	alice.send("PRIVMSG Bob :psst")
+	msg := bob.expect("PRIVMSG")
+	if !strings.HasPrefix(msg.prefix, "alice!") || msg.params[0] != "bob" || msg.params[1] != "psst" {
+		t.Errorf("expected a whisper from alice, got %s", msg.raw)
+	}
+
+	// only people using the bridge can get them
+	alice.send("PRIVMSG carol :hello?")
+	alice.expect(ErrNoSuchNick)
+}
+
+func TestE2eQuitDuringEvents(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addUser("bob")
+	alice := dial(t, config)
+	alice.register("alice")
+
+	sent := make(chan bool)
+	go func() {
+		for i := 0; i < 200; i++ {
+			mock.lock.Lock()
+			mock.broadcast(nil, map[string]interface{}{"E": pyx.LongPollEvent_CHAT, "f": "bob",
+				"m": fmt.Sprintf("line %d", i)})
+			mock.lock.Unlock()
+		}
+		close(sent)
+	}()
+	alice.send("QUIT :bye")
+	alice.expect("ERROR")
+	<-sent
+
+	// nothing fell over, so everyone else can still use the bridge
+	carol := dial(t, config)
+	carol.register("carol")
+}
+
+func TestE2eWatchAnotherGame(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "carol")
+	mock.addGame(2, "dave")
+	alice := dial(t, config)
+	alice.register("alice")
+	bob := dial(t, config)
+	bob.register("bob")
+	alice.send("JOIN #game-1")
+	alice.expect(RplEndNames)
+	bob.send("JOIN #game-2")
+	bob.expect(RplEndNames)
+
+	// PYX only gives bob one seat, but he can still watch
+	bob.send("JOIN #watch-1")
+	join := bob.expect("JOIN")
+	if join.params[0] != "#watch-1" {
+		t.Errorf("expected to watch game 1, got %s", join.raw)
+	}
+	names := bob.expect(RplNames)
+	if !strings.Contains(names.params[3], "@carol") || !strings.Contains(names.params[3], "+alice") {
+		t.Errorf("expected carol and alice in the names, got %s", names.raw)
+	}
+	bob.expect(RplEndNames)
+
+	alice.send("PRIVMSG #game-1 :hello watchers")
+	msg := bob.expect("PRIVMSG")
+	if !strings.HasPrefix(msg.prefix, "alice!") || msg.params[0] != "#watch-1" ||
+		msg.params[1] != "hello watchers" {
+		t.Errorf("expected alice's game chat in #watch-1, got %s", msg.raw)
+	}
+	bob.send("PRIVMSG #watch-1 :can I play?")
+	bob.expect(ErrCannotSendToChan)
+
+	alice.send("PART #game-1")
+	part := bob.expect("PART")
+	if !strings.HasPrefix(part.prefix, "alice!") || part.params[0] != "#watch-1" {
+		t.Errorf("expected alice to leave #watch-1, got %s", part.raw)
+	}
+	bob.send("PART #watch-1")
+	part = bob.expect("PART")
+	if !strings.HasPrefix(part.prefix, "bob!") || part.params[0] != "#watch-1" {
+		t.Errorf("expected to stop watching, got %s", part.raw)
+	}
+}
+
+func TestE2eListPages(t *testing.T) {
+	mock, config := startBridge(t)
+	for id := 1; id <= 3; id++ {
+		mock.addGame(id, "bob")
+	}
+	tc := dial(t, config)
+	tc.register("alice")
+
+	// the global channel, and a game and spectate channel for each game
+	for _, page := range []struct {
+		args  string
+		count int
+		more  bool
+	}{{"2", 2, true}, {"3 2", 3, true}, {"3 3", 1, false}, {"3 4", 0, false}} {
+		tc.send("LIST %s", page.args)
+		tc.expect(RplListStart)
+		count := 0
+		for {
+			line := tc.read()
+			if line.command == RplList {
+				count++
+			} else if line.command == RplListEnd {
+				if more := strings.Contains(line.params[1], "next page"); more != page.more {
+					t.Errorf("For LIST %s expected more %v, got %s", page.args, page.more, line.raw)
+				}
+				break
+			}
+		}
+		if count != page.count {
+			t.Errorf("For LIST %s expected %d channels, got %d", page.args, page.count, count)
+		}
+	}
+}
+
+func TestE2eLUsers(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addUser("carol")
+	alice := dial(t, config)
+	alice.register("alice")
+	bob := dial(t, config)
+	bob.register("bob")
+
+	alice.send("LUSERS")
+	local := alice.expect(RplLocalUsers)
+	if local.params[1] != "2" || local.params[2] != "2" {
+		t.Errorf("expected 2 local users, got %s", local.raw)
+	}
+	global := alice.expect(RplGlobalUsers)
+	if global.params[1] != "3" || global.params[2] != "3" {
+		t.Errorf("expected 3 global users, got %s", global.raw)
+	}
+
+	alice.send("STATS u")
+	alice.expectSequence(RplStatsUptime, RplStatsConn, RplEndOfStats)
+}
+
+func TestE2ePreviewGame(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "bob")
+	mock.addGame(2, "carol")
+	mock.lock.Lock()
+	mock.games[2].HasPassword = true
+	mock.lock.Unlock()
+	tc := dial(t, config)
+	tc.register("alice")
+
+	tc.send("NAMES #game-1")
+	names := tc.expect(RplNames)
+	if names.params[1] != "=" || names.params[3] != "@bob" {
+		t.Errorf("expected bob hosting a public game, got %s", names.raw)
+	}
+	tc.expect(RplEndNames)
+	tc.send("NAMES #game-2")
+	names = tc.expect(RplNames)
+	if names.params[1] != "@" || names.params[3] != "@carol" {
+		t.Errorf("expected carol hosting a secret game, got %s", names.raw)
+	}
+	tc.expect(RplEndNames)
+
+	tc.send("WHO #game-1")
+	who := tc.expect(RplWho)
+	if who.params[1] != "#game-1" || who.params[5] != "bob" || who.params[6] != "H@" {
+		t.Errorf("expected bob in #game-1, got %s", who.raw)
+	}
+	tc.expect(RplEndOfWho)
+	tc.send("WHO #game-2")
+	who = tc.expect(RplWho)
+	if who.params[1] != "*" || who.params[5] != "carol" {
+		t.Errorf("expected carol in a hidden channel, got %s", who.raw)
+	}
+	tc.expect(RplEndOfWho)
+
+	tc.send("NAMES #game-9")
+	tc.expect(ErrNoSuchChannel)
+}
+
+func TestE2eIgnoredCommandsReply(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addUser("bob")
+	tc := dial(t, config)
+	tc.register("alice")
+
+	tc.send("MODE bob")
+	tc.expect(ErrUsersDontMatch)
+	tc.send("MODE bob +s")
+	tc.expect(ErrUsersDontMatch)
+	tc.send("MODE alice +sx")
+	tc.expectSequence(ErrNoPrivileges, ErrUModeUnknownFlag)
+
+	// and nothing at all if they're turned off
+	_, quiet := startBridge(t, func(c *Config) { c.QuietIgnores = true })
+	tc = dial(t, quiet)
+	tc.register("carol")
+	tc.send("MODE bob")
+	tc.send("MODE carol +s")
+	tc.send("PING :done")
+	for line := tc.read(); line.command != "PONG"; line = tc.read() {
+		if line.command == ErrUsersDontMatch || line.command == ErrNoPrivileges {
+			t.Errorf("expected no reply, got %s", line.raw)
+		}
+	}
+}
+
+func TestE2ePartGlobal(t *testing.T) {
+	mock, config := startBridge(t)
+	alice := dial(t, config)
+	alice.register("alice")
+	bob := dial(t, config)
+	bob.register("bob")
+	alice.expect("JOIN")
+
+	alice.send("PART %s", config.GlobalChannel)
+	if part := alice.expect("PART"); part.params[0] != config.GlobalChannel {
+		t.Errorf("expected to leave %s, got %s", config.GlobalChannel, part.raw)
+	}
+	alice.send("PRIVMSG %s :hello", config.GlobalChannel)
+	alice.expect(ErrCannotSendToChan)
+
+	// nothing from the global channel should show up until they come back
+	bob.send("PRIVMSG %s :anyone?", config.GlobalChannel)
+	bob.send("PING :sent")
+	bob.expect("PONG")
+	// but notices to everyone on PYX still do, and come after bob's chat
+	mock.lock.Lock()
+	mock.broadcast(nil, map[string]interface{}{"E": pyx.LongPollEvent_CHAT, "f": "admin",
+		"m": "maintenance soon", "wall": true})
+	mock.lock.Unlock()
+	for line := alice.read(); line.command != "NOTICE"; line = alice.read() {
+		if line.command == "PRIVMSG" {
+			t.Errorf("expected nothing from %s, got %s", config.GlobalChannel, line.raw)
+		}
+	}
+
+	alice.send("JOIN %s", config.GlobalChannel)
+	alice.expectSequence("JOIN", RplTopic, RplEndNames)
+	bob.send("PRIVMSG %s :welcome back", config.GlobalChannel)
+	if msg := alice.expect("PRIVMSG"); msg.params[1] != "welcome back" {
+		t.Errorf("expected only chat from after coming back, got %s", msg.raw)
+	}
+}
+
+func TestE2eMuteGlobal(t *testing.T) {
+	mock, config := startBridge(t)
+	alice := dial(t, config)
+	alice.register("alice")
+	bob := dial(t, config)
+	bob.register("bob")
+
+	alice.send("MODE %s +m", config.GlobalChannel)
+	if mode := alice.expect("MODE"); mode.params[1] != "+m" {
+		t.Errorf("expected global chat to be muted, got %s", mode.raw)
+	}
+	bob.send("PRIVMSG %s :noise", config.GlobalChannel)
+	bob.send("PING :sent")
+	bob.expect("PONG")
+	mock.lock.Lock()
+	mock.broadcast(nil, map[string]interface{}{"E": pyx.LongPollEvent_CHAT, "f": "admin",
+		"m": "maintenance soon", "wall": true})
+	mock.lock.Unlock()
+	for line := alice.read(); line.command != "NOTICE"; line = alice.read() {
+		if line.command == "PRIVMSG" {
+			t.Errorf("expected global chat to be muted, got %s", line.raw)
+		}
+	}
+
+	// it sticks around for next time
+	if !getPreferenceStore(config).get("nick:alice", "").MuteGlobal {
+		t.Error("expected muting global chat to be saved")
+	}
+	alice.send("MODE %s", config.GlobalChannel)
+	if modes := alice.expect(RplChannelModeIs); !strings.Contains(modes.params[2], "m") {
+		t.Errorf("expected +m, got %s", modes.raw)
+	}
+
+	alice.send("MODE %s -m", config.GlobalChannel)
+	alice.expect("MODE")
+	bob.send("PRIVMSG %s :signal", config.GlobalChannel)
+	if msg := alice.expect("PRIVMSG"); msg.params[1] != "signal" {
+		t.Errorf("expected global chat again, got %s", msg.raw)
+	}
+	alice.send("MODE %s +t", config.GlobalChannel)
+	alice.expect(ErrChanOpPrivsNeeded)
+}
+
+func TestE2eGlobalTopic(t *testing.T) {
+	mock, config := startBridge(t, func(config *Config) {
+		config.GlobalTopicSeconds = 1
+		config.Pyx.CacheTtlSeconds = -1
+	})
+	tc := dial(t, config)
+	tc.register("alice")
+	tc.send("TOPIC %s", config.GlobalChannel)
+	topic := tc.expect(RplTopic)
+	if !strings.HasSuffix(topic.params[2], "0 games (0 in progress)") {
+		t.Errorf("expected no games in the topic, got %s", topic.raw)
+	}
+
+	mock.addGame(1, "bob")
+	topic = tc.expect("TOPIC")
+	if !strings.HasSuffix(topic.params[1], "1 games (0 in progress)") {
+		t.Errorf("expected a game in the topic, got %s", topic.raw)
+	}
+}
+
+func TestE2ePyxRestart(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "bob")
+	tc := dial(t, config)
+	tc.register("alice")
+	channel := config.GameChannelPrefix + "1"
+	tc.send("JOIN %s", channel)
+	tc.expect(RplEndNames)
+
+	// going down for long enough keeps new people out until it's back
+	mock.lock.Lock()
+	for _, session := range mock.sessions {
+		if session.nick == "alice" {
+			session.failPolls = 2
+		}
+	}
+	mock.lock.Unlock()
+	notice := tc.expect("NOTICE")
+	if notice.params[0] != config.GlobalChannel || !strings.Contains(notice.params[1], "restarting") {
+		t.Errorf("expected to hear PYX is down in %s, got %s", config.GlobalChannel, notice.raw)
+	}
+	if !isDraining(&config.Pyx) {
+		t.Error("expected nobody new to be let in while PYX is down")
+	}
+	// the same in the game channel
+	tc.expect("NOTICE")
+	// there's no session to send it to, but the connection itself still works
+	tc.send("LIST")
+	if reply := tc.expect(RplTryAgain); reply.params[1] != "LIST" {
+		t.Errorf("expected to be told to try LIST again later, got %s", reply.raw)
+	}
+	tc.send("PING :still here")
+	tc.expect("PONG")
+	tc.expect("NOTICE")
+	if isDraining(&config.Pyx) {
+		t.Error("expected people to be let in again once PYX is back")
+	}
+
+	mock.restart()
+	tc.expectSequence("NOTICE", "NOTICE")
+	kick := tc.expect("KICK")
+	if !strEqCI(kick.params[0], channel) || kick.params[1] != "alice" {
+		t.Errorf("expected to be kicked from the game, got %s", kick.raw)
+	}
+	if notice := tc.expect("NOTICE"); notice.params[1] != "Logged back in to PYX." {
+		t.Errorf("expected to be logged back in, got %s", notice.raw)
+	}
+	bob := dial(t, config)
+	bob.register("bob")
+	bob.send("PRIVMSG %s :welcome back", config.GlobalChannel)
+	if msg := tc.expect("PRIVMSG"); msg.params[1] != "welcome back" {
+		t.Errorf("expected global chat after logging back in, got %s", msg.raw)
+	}
+}
+
+func TestE2eResync(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "bob")
+	mock.addGame(2, "carol")
+	tc := dial(t, config)
+	tc.register("alice")
+
+	// PYX won't let us into another game, so we must already be in one
+	mock.moveToGame("alice", 1)
+	tc.send("JOIN %s2", config.GameChannelPrefix)
+	join := tc.expect("JOIN")
+	if !strEqCI(join.params[0], config.GameChannelPrefix+"1") {
+		t.Errorf("expected to be put in the game PYX says we're in, got %s", join.raw)
+	}
+	tc.expect(ErrTooManyChannels)
+
+	// and chat from a game we didn't know we'd moved to
+	mock.moveToGame("alice", 2)
+	mock.lock.Lock()
+	gameId := 2
+	mock.broadcast(&gameId, map[string]interface{}{"E": pyx.LongPollEvent_CHAT, "f": "carol",
+		"m": "hi alice", "gid": 2})
+	mock.lock.Unlock()
+	lines := tc.expectSequence("PART", "JOIN", "PRIVMSG")
+	for i, channel := range []string{"1", "2", "2"} {
+		if !strEqCI(lines[i].params[0], config.GameChannelPrefix+channel) {
+			t.Errorf("expected %s for %s%s, got %s", lines[i].command, config.GameChannelPrefix,
+				channel, lines[i].raw)
+		}
+	}
+}
+
+func TestE2eEventHandlerPanic(t *testing.T) {
+	chat := EventHandlers[pyx.LongPollEvent_CHAT]
+	EventHandlers[pyx.LongPollEvent_CHAT] = func(client *Client, e pyx.Event) {
+		if e.(*pyx.ChatEvent).Message == "boom" {
+			panic("boom")
+		}
+		chat(client, e)
+	}
+	defer func() { EventHandlers[pyx.LongPollEvent_CHAT] = chat }()
+
+	mock, config := startBridge(t)
+	alice := dial(t, config)
+	alice.register("alice")
+
+	mock.lock.Lock()
+	mock.broadcast(nil, map[string]interface{}{"E": pyx.LongPollEvent_CHAT, "f": "admin",
+		"m": "boom", "wall": true})
+	mock.broadcast(nil, map[string]interface{}{"E": pyx.LongPollEvent_CHAT, "f": "admin",
+		"m": "still here", "wall": true})
+	mock.lock.Unlock()
+	if msg := alice.expect("PRIVMSG"); !strings.Contains(msg.params[1], "went wrong") {
+		t.Errorf("expected to be told about the panic, got %s", msg.raw)
+	}
+	if notice := alice.expect("NOTICE"); !strings.Contains(notice.params[1], "still here") {
+		t.Errorf("expected events to keep flowing after a panic, got %s", notice.raw)
+	}
+}
+
+func TestE2eRoundCompleteWithoutPlays(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "bob")
+	tc := dial(t, config)
+	tc.register("alice")
+	tc.send("JOIN %s1", config.GameChannelPrefix)
+	tc.expect(RplEndNames)
+
+	// we never saw the round get judged, so we don't know what was played
+	mock.lock.Lock()
+	gameId := 1
+	mock.broadcast(&gameId, map[string]interface{}{"E": pyx.LongPollEvent_GAME_ROUND_COMPLETE,
+		"gid": 1, "rw": "bob", "WC": 5})
+	mock.lock.Unlock()
+	msg := tc.expect("PRIVMSG")
+	if !strEqCI(msg.params[0], config.GameChannelPrefix+"1") ||
+		!strings.Contains(msg.params[1], "was won by bob") {
+		t.Errorf("expected the round winner in the game channel, got %s", msg.raw)
+	}
+}
+
+func TestE2eJoinPartRateLimited(t *testing.T) {
+	mock, config := startBridge(t, func(config *Config) {
+		// enough to get registered, then nothing gets through
+		config.Pyx.RequestBurst = 20
+		config.Pyx.RequestsPerMinute = 1
+		config.Pyx.MaxRequestDelayMillis = 1
+	})
+	mock.addGame(1, "bob")
+	tc := dial(t, config)
+	tc.register("alice")
+
+	for i := 0; i < 20; i++ {
+		tc.send("JOIN %s1", config.GameChannelPrefix)
+		tc.send("PART %s1", config.GameChannelPrefix)
+	}
+	tc.send("PING :done")
+	limited := false
+	for line := tc.read(); line.command != "PONG"; line = tc.read() {
+		if strings.Contains(line.raw, pyx.ErrRateLimited.Error()) {
+			limited = true
+		}
+	}
+	if !limited {
+		t.Error("expected to be told about the rate limit")
+	}
+}
+
+func TestE2eFloodRecvQ(t *testing.T) {
+	_, config := startBridge(t, func(config *Config) {
+		config.FloodBurst = 10
+		config.FloodLinesPerMinute = 600
+	})
+	tc := dial(t, config)
+	tc.register("alice")
+
+	// a burst like the one a client sends right after connecting only gets slowed down
+	for i := 0; i < 25; i++ {
+		tc.send("PING :%d", i)
+	}
+	for i := 0; i < 25; i++ {
+		tc.expect("PONG")
+	}
+
+	// but they're gone once too much of it piles up
+	filler := strings.Repeat("x", 100)
+	for i := 0; i < 200; i++ {
+		tc.send("PING :%s", filler)
+	}
+	if line := tc.expect("ERROR"); !strings.Contains(line.raw, "Excess Flood") {
+		t.Error("For a flood expected Excess Flood, got", line.raw)
+	}
+}
+
+func TestE2ePingIdle(t *testing.T) {
+	_, config := startBridge(t, func(config *Config) {
+		config.PingIntervalSeconds = 1
+		config.PingTimeoutSeconds = 2
+	})
+	tc := dial(t, config)
+	tc.register("alice")
+
+	// answering keeps an idle client connected, without any complaint about the PONG
+	for pings := 0; pings < 2; {
+		tc.conn.SetReadDeadline(time.Now().Add(e2eTimeout))
+		raw, err := tc.reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read: %v", err)
+		}
+		line := parseServerLine(strings.TrimRight(raw, "\r\n"))
+		switch line.command {
+		case "PING":
+			pings++
+			tc.send("PONG :%s", line.params[0])
+		case ErrUnknownCommand, "ERROR":
+			t.Fatalf("For an answered PING expected to stay connected, got %s", line.raw)
+		}
+	}
+
+	// but not answering gets them dropped, after waiting out both the interval and the timeout
+	tc.conn.SetReadDeadline(time.Now().Add(2 * e2eTimeout))
+	for {
+		raw, err := tc.reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read: %v", err)
+		}
+		if line := parseServerLine(strings.TrimRight(raw, "\r\n")); line.command == "ERROR" {
+			if !strings.Contains(line.raw, "Ping timeout") {
+				t.Errorf("For an unanswered PING expected a ping timeout, got %s", line.raw)
+			}
+			break
+		}
+	}
+}
+
+func TestE2eRawTraceDisconnect(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("can't see which files are open here")
+	}
+	mock, config := startBridge(t, func(config *Config) {
+		config.TraceDirectory = t.TempDir()
+	})
+	mock.lock.Lock()
+	mock.admins["alice"] = true
+	mock.lock.Unlock()
+	alice := dial(t, config)
+	alice.register("alice")
+	bob := dial(t, config)
+	bob.register("bob")
+
+	alice.send("RAWTRACE bob ON")
+	// admins hear about connections too
+	for notice := alice.expect("NOTICE"); !strings.Contains(notice.params[1], "Tracing bob"); {
+		notice = alice.expect("NOTICE")
+	}
+	traceOpen := func() bool {
+		fds, _ := ioutil.ReadDir("/proc/self/fd")
+		for _, fd := range fds {
+			target, _ := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+			if strings.HasPrefix(target, config.TraceDirectory) {
+				return true
+			}
+		}
+		return false
+	}
+	if !traceOpen() {
+		t.Fatal("expected the trace file to be open")
+	}
+
+	// nobody turned it off, but the file still has to be closed once bob is gone
+	bob.send("QUIT")
+	// admins hear about it once bob has been cleaned up
+	for notice := alice.expect("NOTICE"); !strings.Contains(notice.params[1], "bob"); {
+		notice = alice.expect("NOTICE")
+	}
+	if traceOpen() {
+		t.Error("For a traced client that disconnected expected the trace file to be closed")
+	}
+}
+
+func TestE2ePersistentIds(t *testing.T) {
+	mock, config := startBridge(t, func(config *Config) {
+		config.Pyx.PersistentIdFile = filepath.Join(t.TempDir(), "ids.json")
+		// alice has to be gone from the names when she comes back
+		config.Pyx.CacheTtlSeconds = -1
+	})
+	alice := dial(t, config)
+	alice.register("alice")
+	alice.send("QUIT")
+	alice.expect("ERROR")
+	// bob being saved rewrites the file alice is in
+	bob := dial(t, config)
+	bob.register("bob")
+
+	deadline := time.Now().Add(e2eTimeout)
+	for {
+		loggedOut := true
+		mock.lock.Lock()
+		for _, session := range mock.sessions {
+			loggedOut = loggedOut && session.nick != "alice"
+		}
+		mock.lock.Unlock()
+		if loggedOut {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected alice to be logged out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	alice = dial(t, config)
+	alice.register("alice")
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	if pid := mock.persistentIds["alice"]; pid != "pid-alice" {
+		t.Errorf("For alice coming back expected her persistent ID, got %q", pid)
+	}
+	if _, err := os.Stat(config.Pyx.PersistentIdFile + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be left behind from saving, got %v", err)
+	}
+}
+
+func TestE2eFloodDisconnect(t *testing.T) {
+	mock, config := startBridge(t, func(config *Config) {
+		config.FloodBurst = 5
+		config.FloodLinesPerMinute = 60
+	})
+	tc := dial(t, config)
+	tc.register("alice")
+
+	// what's still waiting when they hang up shouldn't keep going to PYX once a second
+	for i := 0; i < 20; i++ {
+		tc.send("PRIVMSG %s :%d", config.GlobalChannel, i)
+	}
+	tc.conn.Close()
+	time.Sleep(500 * time.Millisecond)
+	mock.lock.Lock()
+	chats := mock.requests[pyx.AjaxOperation_CHAT]
+	mock.lock.Unlock()
+	time.Sleep(2 * time.Second)
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	if later := mock.requests[pyx.AjaxOperation_CHAT]; later != chats {
+		t.Errorf("For a closed connection expected nothing else to be sent, got %d more chats",
+			later-chats)
+	}
+}
+
+func TestE2eGameLimitModes(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "alice")
+	tc := dial(t, config)
+	tc.register("alice")
+	channel := config.GameChannelPrefix + "1"
+	tc.send("JOIN %s", channel)
+	tc.expect(RplEndNames)
+
+	// PYX wants at least 3 players and at most 20 spectators, plus one for the bot here
+	for _, mode := range []string{"+l 1", "+l x", "+L 22"} {
+		tc.send("MODE %s %s", channel, mode)
+		if line := tc.expect(ErrInvalidModeParam); line.params[1] != channel {
+			t.Errorf("For %s expected %s for %s, got %s", mode, ErrInvalidModeParam, channel,
+				line.raw)
+		}
+	}
+	mock.lock.Lock()
+	if limit := mock.games[1].GameOptions.PlayerLimit; limit != 10 {
+		t.Errorf("For invalid limits expected the game to be left alone, got a limit of %d", limit)
+	}
+	mock.lock.Unlock()
+
+	tc.send("MODE %s +lL 4 1", channel)
+	if mode := tc.expect("MODE"); mode.params[1] != "+l+L" || mode.params[2] != "4" {
+		t.Errorf("For +lL 4 1 expected the change, got %s", mode.raw)
+	}
+}
+
+func TestE2ePyxOutage(t *testing.T) {
+	mock, config := startBridge(t)
+	mock.addGame(1, "bob")
+	tc := dial(t, config)
+	tc.register("alice")
+	tc.send("JOIN %s1", config.GameChannelPrefix)
+	tc.expect(RplEndNames)
+
+	// error pages from a proxy aren't PYX saying the session is gone
+	mock.setDown(true)
+	if notice := tc.expect("NOTICE"); !strings.Contains(notice.params[1], "restarting") {
+		t.Errorf("expected to hear PYX is down, got %s", notice.raw)
+	}
+	// the same in the game channel
+	tc.expect("NOTICE")
+	mock.setDown(false)
+	if notice := tc.expect("NOTICE"); !strings.Contains(notice.params[1], "PYX is back") {
+		t.Errorf("expected to hear PYX is back, got %s", notice.raw)
+	}
+	// PYX never saw the failed polls, so nothing was lost and there's nothing to catch up on
+	tc.send("PING :caught up")
+	for {
+		line := tc.read()
+		if line.command == "PONG" {
+			break
+		}
+		if line.command == "TOPIC" || line.command == RplNames {
+			t.Errorf("expected no resync without a gap, got %s", line.raw)
+		}
+	}
+	tc.send("LIST")
+	tc.expect(RplListEnd)
+}
+
+func TestE2eKeepalive(t *testing.T) {
+	mock, config := startBridge(t, func(config *Config) {
+		config.Pyx.KeepaliveSeconds = 1
+	})
+	tc := dial(t, config)
+	tc.register("alice")
+	tc.send("PING :idle")
+	tc.expect("PONG")
+
+	mock.lock.Lock()
+	names, firstLoads := mock.requests[pyx.AjaxOperation_NAMES],
+		mock.requests[pyx.AjaxOperation_FIRST_LOAD]
+	mock.lock.Unlock()
+	time.Sleep(2500 * time.Millisecond)
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	// FIRST_LOAD sends every card set, so it's not the one to make over and over
+	if mock.requests[pyx.AjaxOperation_NAMES] <= names {
+		t.Error("For an idle session expected a NAMES keepalive, got none")
+	}
+	if got := mock.requests[pyx.AjaxOperation_FIRST_LOAD]; got != firstLoads {
+		t.Errorf("For an idle session expected no more FIRST_LOADs, got %d", got-firstLoads)
+	}
+}
+
+func TestPyxCloseConcurrently(t *testing.T) {
+	_, config := startBridge(t)
+	client, err := pyx.NewClient("alice", "", &config.Pyx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := client.Subscribe()
+	client.Start()
+	go func() {
+		for range events {
+		}
+	}()
+
+	// the long poll, the keepalive, and the IRC side can all get here at once
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Close()
+		}()
+	}
+	wg.Wait()
+	client.Close()
+}
+
+func TestPyxCloseFromSubscriber(t *testing.T) {
+	mock, config := startBridge(t)
+	client, err := pyx.NewClient("alice", "", &config.Pyx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both come back from the same long poll
+	mock.lock.Lock()
+	mock.broadcast(nil, map[string]interface{}{"E": pyx.LongPollEvent_KICKED})
+	mock.broadcast(nil, map[string]interface{}{"E": pyx.LongPollEvent_NEW_PLAYER, "n": "bob"})
+	mock.lock.Unlock()
+	events := client.Subscribe()
+	client.Start()
+
+	// like being kicked, which logs out from the event goroutine
+	<-events
+	closed := make(chan bool)
+	go func() {
+		client.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(e2eTimeout):
+		t.Fatal("For Close while the long poll had another event, expected it to return, got stuck")
+	}
+}
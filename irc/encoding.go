@@ -0,0 +1,84 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+// Making sure everything is UTF-8, since that's all PYX understands
+
+package irc
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	LegacyEncoding_NONE   = ""
+	LegacyEncoding_LATIN1 = "latin1"
+	LegacyEncoding_CP1252 = "cp1252"
+)
+
+// what cp1252 has in 0x80 to 0x9f, where latin-1 only has control characters. the holes aren't
+// anything in cp1252 either.
+var cp1252High = [32]rune{
+	0x20ac, utf8.RuneError, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, utf8.RuneError, 0x017d, utf8.RuneError,
+	utf8.RuneError, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, utf8.RuneError, 0x017e, 0x0178,
+}
+
+func validLegacyEncoding(encoding string) bool {
+	switch encoding {
+	case LegacyEncoding_NONE, LegacyEncoding_LATIN1, LegacyEncoding_CP1252:
+		return true
+	}
+	return false
+}
+
+// Turn a line from a client into UTF-8. A line that isn't valid UTF-8 is assumed to be in the
+// legacy encoding, if there is one; otherwise whatever isn't valid is replaced.
+func decodeLine(line string, legacy string) string {
+	if utf8.ValidString(line) {
+		return line
+	}
+	if legacy == LegacyEncoding_NONE {
+		return strings.ToValidUTF8(line, string(utf8.RuneError))
+	}
+	var decoded strings.Builder
+	for i := 0; i < len(line); i++ {
+		b := line[i]
+		if legacy == LegacyEncoding_CP1252 && b >= 0x80 && b <= 0x9f {
+			decoded.WriteRune(cp1252High[b-0x80])
+		} else {
+			// latin-1 is the first 256 code points
+			decoded.WriteRune(rune(b))
+		}
+	}
+	return decoded.String()
+}
+
+// Lines to clients should already be UTF-8, but anything that isn't gets fixed up rather than
+// confusing them.
+func encodeLine(line string) string {
+	if utf8.ValidString(line) {
+		return line
+	}
+	return strings.ToValidUTF8(line, string(utf8.RuneError))
+}
@@ -0,0 +1,64 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"testing"
+)
+
+type decodeLineTestPair struct {
+	input   string
+	legacy  string
+	decoded string
+}
+
+var decodeLineTests = []decodeLineTestPair{
+	{"privmsg #test :héllo", LegacyEncoding_NONE, "privmsg #test :héllo"},
+	{"privmsg #test :h\xe9llo", LegacyEncoding_NONE, "privmsg #test :h�llo"},
+	{"privmsg #test :h\xe9llo", LegacyEncoding_LATIN1, "privmsg #test :héllo"},
+	{"privmsg #test :h\xe9llo", LegacyEncoding_CP1252, "privmsg #test :héllo"},
+	{"privmsg #test :\x93hi\x94 \x80", LegacyEncoding_CP1252, "privmsg #test :“hi” €"},
+	{"privmsg #test :\x93hi\x94", LegacyEncoding_LATIN1, "privmsg #test :\u0093hi\u0094"},
+	{"privmsg #test :\x81", LegacyEncoding_CP1252, "privmsg #test :�"},
+	// valid UTF-8 is left alone, even with a legacy encoding
+	{"privmsg #test :“hi”", LegacyEncoding_CP1252, "privmsg #test :“hi”"},
+}
+
+func TestDecodeLine(t *testing.T) {
+	for _, test := range decodeLineTests {
+		decoded := decodeLine(test.input, test.legacy)
+		if decoded != test.decoded {
+			t.Error("For", test.input, test.legacy,
+				"expected", test.decoded,
+				"got", decoded,
+			)
+		}
+	}
+}
+
+func TestEncodeLine(t *testing.T) {
+	if encoded := encodeLine("a\xffb"); encoded != "a�b" {
+		t.Error("For a\\xffb expected a�b got", encoded)
+	}
+}
@@ -30,20 +30,27 @@ import (
 	"github.com/ajanata/pyx-irc/pyx"
 	"strconv"
 	"strings"
+	"time"
 )
 
-type Event = pyx.LongPollResponse
-type EventHandlerFunc func(*Client, Event)
+// How long before the round timer runs out to warn about it.
+const RoundTimerWarningTime = 30 * time.Second
+
+// Handlers are only registered for the event types they know the concrete type of, so they can
+// assert it without checking.
+type EventHandlerFunc func(*Client, pyx.Event)
 
 var EventHandlers = map[string]EventHandlerFunc{
-	pyx.LongPollEvent_BANNED:               eventBanned,
-	pyx.LongPollEvent_CHAT:                 eventChat,
-	pyx.LongPollEvent_KICKED:               eventKicked,
-	pyx.LongPollEvent_FILTERED_CHAT:        eventFilteredChat,
-	pyx.LongPollEvent_GAME_BLACK_RESHUFFLE: eventGameBlackShuffle,
-	pyx.LongPollEvent_GAME_LIST_REFRESH:    eventIgnore,
-	// TODO implement this? We can say when players played a card, if we want to...
-	pyx.LongPollEvent_GAME_PLAYER_INFO_CHANGE: eventIgnore,
+	pyx.LongPollEvent_BANNED:                  eventBanned,
+	pyx.LongPollEvent_CARDCAST_ADD_CARDSET:    eventCardcastAddCardset,
+	pyx.LongPollEvent_CARDCAST_REMOVE_CARDSET: eventCardcastRemoveCardset,
+	pyx.LongPollEvent_CHAT:                    eventChat,
+	pyx.LongPollEvent_KICKED:                  eventKicked,
+	pyx.LongPollEvent_FILTERED_CHAT:           eventFilteredChat,
+	pyx.LongPollEvent_GAME_BLACK_RESHUFFLE:    eventGameBlackShuffle,
+	pyx.LongPollEvent_GAME_OPTIONS_CHANGED:    eventGameOptionsChanged,
+	pyx.LongPollEvent_GAME_LIST_REFRESH:       eventGameListRefresh,
+	pyx.LongPollEvent_GAME_PLAYER_INFO_CHANGE: eventGamePlayerInfoChange,
 	pyx.LongPollEvent_GAME_PLAYER_JOIN:        eventGamePlayerJoin,
 	pyx.LongPollEvent_GAME_PLAYER_KICKED_IDLE: eventGamePlayerKickedIdle,
 	pyx.LongPollEvent_GAME_PLAYER_LEAVE:       eventGamePlayerLeave,
@@ -53,109 +60,274 @@ var EventHandlers = map[string]EventHandlerFunc{
 	pyx.LongPollEvent_GAME_SPECTATOR_LEAVE:    eventGamePlayerLeave,
 	pyx.LongPollEvent_GAME_STATE_CHANGE:       eventGameStateChange,
 	pyx.LongPollEvent_GAME_WHITE_RESHUFFLE:    eventGameWhiteShuffle,
+	pyx.LongPollEvent_HAND_DEAL:               eventHandDeal,
+	pyx.LongPollEvent_HURRY_UP:                eventHurryUp,
+	pyx.LongPollEvent_KICKED_FROM_GAME_IDLE:   eventKickedFromGameIdle,
 	pyx.LongPollEvent_NEW_PLAYER:              eventNewPlayer,
 	pyx.LongPollEvent_PLAYER_LEAVE:            eventPlayerQuit,
+	pyx.LocalEvent_RECONNECTED:                eventReconnected,
+	pyx.LocalEvent_SERVER_DOWN:                eventServerDown,
+	pyx.LocalEvent_SERVER_RESTARTED:           eventServerRestarted,
 }
 
-func eventNewPlayer(client *Client, event Event) {
-	if event.Nickname == client.pyx.User.Name {
+func eventNewPlayer(client *Client, e pyx.Event) {
+	event := e.(*pyx.PlayerEvent)
+	client.roster.add(event.Nickname, event.Sigil)
+	client.refreshGlobalTopic(false)
+	if event.Nickname == client.pyx().User.Name {
 		// we don't care about seeing ourselves connect
 		return
 	}
-	client.data <- fmt.Sprintf(":%s JOIN :%s", client.getNickUserAtHost(event.Nickname),
-		client.config.GlobalChannel)
+	client.notifyMonitor(event.Nickname, true)
+	// they just showed up, so there's nothing to change
+	client.sigils.set(client.config.fold(event.Nickname), event.Sigil)
+	if client.prefs.QuietJoins {
+		// wait until they say something
+		client.quietNicks.add(client.config.fold(event.Nickname))
+		return
+	}
+	if !client.inGlobalChannel() {
+		return
+	}
+	client.sendGlobalJoin(event.Nickname, event.Sigil, len(event.IdCode) > 0)
+}
+
+func (client *Client) sendGlobalJoin(nick string, sigil string, verified bool) {
+	client.data.push(client.joinLine(nick, client.config.GlobalChannel))
 	mode := "+"
 	modeNames := ""
-	if event.Sigil == pyx.Sigil_ADMIN {
+	if sigil == pyx.Sigil_ADMIN {
 		mode = mode + "o"
-		modeNames = event.Nickname
+		modeNames = nick
 	}
-	if len(event.IdCode) > 0 {
+	if verified {
 		mode = mode + "v"
-		modeNames = modeNames + " " + event.Nickname
+		modeNames = modeNames + " " + nick
 	}
 	if len(mode) > 1 {
-		client.data <- fmt.Sprintf(":%s MODE %s %s %s", client.botNickUserAtHost(),
-			client.config.GlobalChannel, mode, strings.TrimSpace(modeNames))
+		client.data.push(newLine(client.botNickUserAtHost(), "MODE").
+			param(client.config.GlobalChannel, mode).
+			param(strings.Fields(modeNames)...).String())
+	}
+}
+
+// Someone whose join we held back in quiet mode is talking, so they have to show up first.
+func (client *Client) revealQuietNick(nick string) {
+	key := client.config.fold(nick)
+	if !client.quietNicks.take(key) {
+		return
 	}
+	sigil := client.sigils.get(key)
+	client.sendGlobalJoin(nick, sigil, sigil == pyx.Sigil_ID_CODE)
 }
 
-func eventPlayerQuit(client *Client, event Event) {
-	if event.Nickname == client.pyx.User.Name {
+func eventPlayerQuit(client *Client, e pyx.Event) {
+	event := e.(*pyx.PlayerEvent)
+	delete(client.awayNotified, client.config.fold(event.Nickname))
+	client.roster.recordQuit(event.Nickname, pyx.DisconnectReasonMsgs[event.Reason],
+		client.config.WhowasHistorySize)
+	client.roster.remove(event.Nickname)
+	client.refreshGlobalTopic(false)
+	if event.Nickname == client.pyx().User.Name {
 		// we don't care about seeing ourselves disconnect
 		// TODO unless we got kicked or banned
 		// actually those are different events entirely
 		return
 	}
-	client.data <- fmt.Sprintf(":%s QUIT :%s", client.getNickUserAtHost(event.Nickname),
-		pyx.DisconnectReasonMsgs[event.Reason])
+	client.notifyMonitor(event.Nickname, false)
+	key := client.config.fold(event.Nickname)
+	// people who were already there when we joined still have to leave the nick list
+	if !client.quietNicks.take(key) && client.inGlobalChannel() {
+		client.data.push(newLine(client.getNickUserAtHost(event.Nickname), "QUIT").
+			text(pyx.DisconnectReasonMsgs[event.Reason]).String())
+	}
+	client.sigils.remove(key)
 }
 
-func eventFilteredChat(client *Client, event Event) {
-	if event.GameId != nil && (client.gameId == nil || *event.GameId != *client.gameId) {
+func eventFilteredChat(client *Client, e pyx.Event) {
+	// don't change the event, other subscribers get the same one
+	event := *e.(*pyx.ChatEvent)
+	otherGame := event.GameId != nil && !client.inGame(*event.GameId)
+	switch client.prefs.FilteredChat {
+	case FilteredChat_NONE:
+		return
+	case FilteredChat_MINE:
+		if otherGame {
+			return
+		}
+	}
+	if otherGame {
 		event.Message = fmt.Sprintf("(In game %d) %s", *event.GameId, event.Message)
 		event.GameId = nil
 	}
 	event.Message = "(Filtered) " + event.Message
-	eventChat(client, event)
+	eventChat(client, &event)
 }
 
-func eventChat(client *Client, event Event) {
-	if event.From == client.pyx.User.Name {
+func eventChat(client *Client, e pyx.Event) {
+	event := e.(*pyx.ChatEvent)
+	if !event.Wall {
+		client.noteActivity(event.From)
+		if !event.Filtered {
+			getChatHistory(client.pyxConfig).add(event, client.config.ChatHistorySize)
+			if event.GameId != nil && client.inGame(*event.GameId) {
+				client.relayGameChat(event)
+			}
+		}
+	}
+	if event.From == client.pyx().User.Name && (event.Wall || !client.wantsOwnMessages()) {
 		// don't show our own chat
 		return
 	}
+	if !event.Wall && client.isSilenced(event.From) {
+		return
+	}
 	if event.Wall {
 		// global notice from admin, handle this completely differently
-		client.data <- fmt.Sprintf(":%s NOTICE %s :Global notice: %s",
-			client.getNickUserAtHost(event.From), client.nick, event.Message)
+		client.data.push(newLine(client.getNickUserAtHost(event.From), "NOTICE").
+			withTags(client.accountTag(event.From)).
+			param(client.nick).text("Global notice: " + event.Message).String())
 		return
 	}
 
 	var target string
 	// game chat is the same event, but has the game id field
 	if event.GameId != nil {
-		if *event.GameId == *client.gameId {
-			target = client.config.GameChannelPrefix + strconv.Itoa(*event.GameId)
-			if client.gameIsSpectate {
-				target = client.config.SpectateGameChannelPrefix + strconv.Itoa(*event.GameId)
+		if !client.inGame(*event.GameId) {
+			// PYX only sends game chat to people in the game, so we're the ones who are wrong
+			client.resync(fmt.Sprintf("Game chat for un-joined game %d", *event.GameId))
+			if !client.inGame(*event.GameId) {
+				return
 			}
-		} else {
-			// uhhh wtf??
-			log.Errorf("Received game chat for un-joined gamed %d (joined %d)", *event.GameId,
-				*client.gameId)
-			return
 		}
+		target = client.getGameChannel()
 	} else {
+		if !client.inGlobalChannel() ||
+			(client.prefs.MuteGlobal && event.From != client.pyx().User.Name) {
+			return
+		}
 		target = client.config.GlobalChannel
+		if event.From != client.pyx().User.Name {
+			client.revealQuietNick(event.From)
+		}
 	}
 	text := event.Message
 	if event.Emote {
 		text = makeEmote(text)
 	}
-	client.data <- fmt.Sprintf(":%s PRIVMSG %s :%s", client.getNickUserAtHost(event.From), target,
-		text)
+	client.data.push(newLine(client.getNickUserAtHost(event.From), "PRIVMSG").
+		withTags(client.accountTag(event.From)).param(target).text(text).String())
 }
 
-func eventIgnore(client *Client, event Event) {
+func eventGameListRefresh(client *Client, event pyx.Event) {
+	client.refreshWatches()
+	client.refreshGlobalTopic(false)
+}
+
+func eventIgnore(client *Client, event pyx.Event) {
 	// do nothing with this event.
 }
 
-func eventBanned(client *Client, event Event) {
+func eventReconnected(client *Client, e pyx.Event) {
+	event := e.(*pyx.ReconnectedEvent)
+	log.Infof("PYX long poll for %s recovered after %s, gap: %t", client.nick, event.Down,
+		event.Gap)
+	client.setPyxDown(false)
+	setDraining(client.pyxConfig, false)
+	down := event.Down.Round(time.Second)
+	if !event.Gap {
+		// PYX never saw the polls that failed, so it held on to everything for us
+		client.sendServerNotice("PYX is back after %s.", down)
+		return
+	}
+	client.roster.invalidate()
+	client.gameCache.invalidate()
+	announceToAdmins(SnoPyx, "PYX long poll for %s recovered after %s, they may be out of sync",
+		client.nick, down)
+	since := "we lost contact"
+	if event.LastTimestamp > 0 {
+		since = time.Unix(0, event.LastTimestamp*int64(time.Millisecond)).UTC().
+			Format("15:04:05 MST")
+	}
+	client.sendServerNotice("Lost contact with PYX for %s, anything that happened since %s "+
+		"may have been missed.", down, since)
+	client.resyncGame()
+}
+
+// After losing contact with PYX, make sure we're still in the game our channel is for, getting back
+// in if the server dropped us, and show where things stand now.
+func (client *Client) resyncGame() {
+	gameId, ok := client.currentGame()
+	if !ok {
+		return
+	}
+	channel := client.getGameChannel()
+	resp, err := client.gameInfo()
+	if err != nil {
+		if pyx.ErrorCode(err) == pyx.ErrorCode_INVALID_GAME {
+			client.data.push(newLine(client.botNickUserAtHost(), "KICK").
+				param(channel, client.nick).
+				text("The game ended while we lost contact with PYX.").String())
+			client.leftGame()
+		} else {
+			log.Errorf("Unable to retrieve game %d info for %s after reconnecting: %s", gameId,
+				client.nick, err)
+		}
+		return
+	}
+	inGame := false
+	for _, nick := range append(resp.GameInfo.Players, resp.GameInfo.Spectators...) {
+		inGame = inGame || nick == client.pyx().User.Name
+	}
+	if !inGame {
+		log.Infof("Rejoining game %d for %s after reconnecting", gameId, client.nick)
+		if client.gameIsSpectate {
+			_, err = client.pyx().SpectateGame(gameId, client.gameKey())
+		} else {
+			_, err = client.pyx().JoinGame(gameId, client.gameKey())
+		}
+		if err != nil {
+			client.data.push(newLine(client.botNickUserAtHost(), "KICK").
+				param(channel, client.nick).
+				textf("Unable to rejoin the game after losing contact with PYX: %s", err).String())
+			client.leftGame()
+			return
+		}
+		client.gameCache.invalidate()
+		client.gameScores.forget()
+		client.gameRounds = roundCounter{}
+	}
+
+	if resp.GameInfo.State != client.gameState {
+		// whatever we knew about the round is out of date
+		client.gameBlackCard = nil
+		client.gameSelection = nil
+		client.gamePlayerStatus = nil
+		client.stopRoundTimer()
+	}
+	client.gameState = resp.GameInfo.State
+	client.gameInProgress = resp.GameInfo.State != pyx.GameState_LOBBY
+	client.refreshCustomDecks()
+	client.refreshHand()
+	client.sendTopicChange()
+	client.handleNamesImpl(channel)
+	if client.gameBlackCard != nil {
+		client.sendBotMessageToGame("The black card for this round is: %s",
+			client.blackCardText(*client.gameBlackCard))
+	}
+}
+
+func eventBanned(client *Client, event pyx.Event) {
 	doKickOrBan(client, "You have been banned by the server administrator.")
 }
 
-func eventKicked(client *Client, event Event) {
+func eventKicked(client *Client, event pyx.Event) {
 	doKickOrBan(client, "You have been kicked by the server administrator.")
 }
 
 func doKickOrBan(client *Client, msg string) {
-	s := fmt.Sprintf(":%s KILL %s :%s!%s (%s)", client.botNickUserAtHost(), client.nick,
-		client.config.AdvertisedName, client.config.BotNick, msg)
-	// have to do this differently to ensure the client actually gets this in the right order
-	client.writer.WriteString(s + "\r\n")
-	client.writer.Flush()
-
+	client.data.push(newLine(client.botNickUserAtHost(), "KILL").param(client.nick).
+		textf("%s!%s (%s)", client.config.AdvertisedName, client.config.BotNick, msg).String())
 	client.disconnect(fmt.Sprintf("%s (Killed (%s (%s)))", client.config.AdvertisedName,
 		client.config.BotNick, msg))
 }
@@ -171,107 +343,203 @@ func (client *Client) sendTopicChangeForStartedGame() {
 
 func (client *Client) sendTopicChange() {
 	channel := client.getGameChannel()
-	resp, err := client.pyx.GameInfo(*client.gameId)
+	resp, err := client.gameInfo()
 	if err != nil {
-		log.Errorf("Unable to retrieve game %d info for player join topic update: %s",
-			*client.gameId, err)
+		log.Errorf("Unable to retrieve %s info for player join topic update: %s", channel, err)
 		return
 	}
 	topic := client.getTopic(channel, &resp.GameInfo)
-	client.data <- fmt.Sprintf(":%s TOPIC %s :%s", client.botNickUserAtHost(), channel, topic)
+	client.data.push(newLine(client.botNickUserAtHost(), "TOPIC").param(channel).text(topic).
+		String())
 }
 
 func (client *Client) sendBotMessageToGame(format string, args ...interface{}) {
-	// TODO deal with messages that are long than the IRC length limit?
-	client.data <- fmt.Sprintf(":%s PRIVMSG %s :%s", client.botNickUserAtHost(),
-		client.getGameChannel(), fmt.Sprintf(format, args...))
+	// TODO split up messages that are longer than the IRC length limit instead of cutting them off?
+	client.data.push(newLine(client.botNickUserAtHost(), "PRIVMSG").param(client.getGameChannel()).
+		textf(format, args...).String())
 }
 
 // also handles Game Spectator Join
-func eventGamePlayerJoin(client *Client, event Event) {
-	if event.Nickname == client.pyx.User.Name {
+func eventGamePlayerJoin(client *Client, e pyx.Event) {
+	event := e.(*pyx.GamePlayerEvent)
+	if event.Nickname == client.pyx().User.Name {
 		// ignore join events for ourselves
 		return
 	}
 	nick := event.Nickname
+	if event.GameId != nil {
+		client.roster.joinedGame(nick, *event.GameId)
+	}
+	client.gameCache.playerJoined(event.GameId, nick,
+		event.Type() == pyx.LongPollEvent_GAME_SPECTATOR_JOIN)
+	if event.Type() == pyx.LongPollEvent_GAME_PLAYER_JOIN {
+		client.gameScores.playerJoined(nick)
+	}
 	channel := client.getGameChannel()
-	client.data <- fmt.Sprintf(":%s JOIN %s", client.getNickUserAtHost(nick), channel)
-	if event.Event == pyx.LongPollEvent_GAME_PLAYER_JOIN {
-		client.data <- fmt.Sprintf(":%s MODE %s +v %s", client.botNickUserAtHost(), channel, nick)
+	client.data.push(client.joinLine(nick, channel))
+	if event.Type() == pyx.LongPollEvent_GAME_PLAYER_JOIN {
+		client.data.push(newLine(client.botNickUserAtHost(), "MODE").param(channel, "+v", nick).
+			String())
 	}
 
 	client.sendTopicChange()
 }
 
 // also handles Game Spectator Leave
-func eventGamePlayerLeave(client *Client, event Event) {
-	if event.Nickname == client.pyx.User.Name {
+func eventGamePlayerLeave(client *Client, e pyx.Event) {
+	event := e.(*pyx.GamePlayerEvent)
+	if event.Nickname == client.pyx().User.Name {
 		// ignore leave for ourselves
 		return
 	}
-	client.data <- fmt.Sprintf(":%s PART %s :Leaving", client.getNickUserAtHost(event.Nickname),
-		client.getGameChannel())
-	client.processPlayerLeave(event)
+	client.data.push(newLine(client.getNickUserAtHost(event.Nickname), "PART").
+		param(client.getGameChannel()).text("Leaving").String())
+	client.processPlayerLeave(event.Nickname)
 }
 
-func eventGamePlayerKickedIdle(client *Client, event Event) {
+func eventGamePlayerKickedIdle(client *Client, e pyx.Event) {
+	event := e.(*pyx.GamePlayerEvent)
 	// TODO handle us being kicked for idle once we can play in games
-	client.data <- fmt.Sprintf(":%s KICK %s %s :Idle for too many rounds",
-		client.botNickUserAtHost(), client.getGameChannel(), event.Nickname)
-	client.processPlayerLeave(event)
+	client.data.push(newLine(client.botNickUserAtHost(), "KICK").
+		param(client.getGameChannel(), event.Nickname).text("Idle for too many rounds").String())
+	client.processPlayerLeave(event.Nickname)
+}
+
+// Forget everything about the game we were in.
+func (client *Client) leftGame() {
+	client.stopRoundTimer()
+	client.stopRelaying()
+	client.clearCurrentGame()
+	client.gameCustomDecks = nil
+	client.gameHand = nil
+	client.gameSelection = nil
+	client.gameBlackCard = nil
+	client.gameTranscript = nil
+	client.gameCache.invalidate()
+	client.gameState = ""
+	client.gamePlayerStatus = nil
+	client.gameDevoiced = nil
+	client.gameJudgeMode = ""
+	client.gameScores.forget()
+	client.gameRounds = roundCounter{}
 }
 
-func (client *Client) processPlayerLeave(event Event) {
-	if event.Nickname == client.gameHost {
-		resp, err := client.pyx.GameInfo(*client.gameId)
+func (client *Client) processPlayerLeave(nickname string) {
+	client.gameCache.playerLeft(client.currentGamePtr(), nickname)
+	client.gameScores.playerLeft(nickname)
+	if client.gamePlayerStatus != nil {
+		delete(client.gamePlayerStatus, nickname)
+	}
+	devoiced := []string{}
+	for _, nick := range client.gameDevoiced {
+		if nick != nickname {
+			devoiced = append(devoiced, nick)
+		}
+	}
+	client.gameDevoiced = devoiced
+
+	if nickname == client.gameHost {
+		resp, err := client.gameInfo()
 		if err != nil {
-			if resp.ErrorCode == pyx.ErrorCode_INVALID_GAME {
+			if pyx.ErrorCode(err) == pyx.ErrorCode_INVALID_GAME {
 				// the game has been destroyed since all non-spectators left. yes, the server
 				// doesn't actually tell spectators about this...
-				log.Debugf("We got kicked from game %d!", *client.gameId)
-				client.data <- fmt.Sprintf(":%s KICK %s %s :Forcibly removed by server.",
-					client.botNickUserAtHost(), client.getGameChannel(), client.nick)
-				client.gameId = nil
+				log.Debugf("We got kicked from %s!", client.getGameChannel())
+				client.data.push(newLine(client.botNickUserAtHost(), "KICK").
+					param(client.getGameChannel(), client.nick).
+					text("Forcibly removed by server.").String())
+				client.leftGame()
 				return
 			} else {
-				log.Errorf("Cannot retrieve game info for game %d to determine new host",
-					*client.gameId)
+				log.Errorf("Cannot retrieve game info for %s to determine new host: %s",
+					client.getGameChannel(), err)
 			}
 		} else {
-			client.data <- fmt.Sprintf(":%s MODE %s +o %s", client.botNickUserAtHost(),
-				client.getGameChannel(), resp.GameInfo.Host)
+			client.data.push(newLine(client.botNickUserAtHost(), "MODE").
+				param(client.getGameChannel(), "+o", resp.GameInfo.Host).String())
 		}
 	}
 	client.sendTopicChange()
 }
 
-func eventGameStateChange(client *Client, event Event) {
+func eventGameStateChange(client *Client, e pyx.Event) {
+	event := e.(*pyx.GameStateChangeEvent)
+	client.gameState = event.GameState
+	client.gameCache.stateChanged(event.GameId, event.GameState)
+	if event.GameState != pyx.GameState_PLAYING {
+		client.revoicePlayers()
+	}
+	if event.GameState != pyx.GameState_JUDGING {
+		// the round is over, or a new one with a new judge is starting
+		client.clearJudgeMode()
+	}
 	switch event.GameState {
 	case pyx.GameState_LOBBY:
 		client.sendTopicChange()
 		client.sendBotMessageToGame("The game has been reset to the lobby state.")
 		client.gameInProgress = false
+		client.gameHand = nil
+		client.gameSelection = nil
+		client.gameBlackCard = nil
+		client.resetRounds()
+		client.stopRoundTimer()
 	case pyx.GameState_PLAYING:
-		client.sendTopicChangeForStartedGame()
-		client.sendBotMessageToGame("The black card for the next round is: %s",
-			blackCardText(event.BlackCard))
-		resp, err := client.pyx.GameInfo(*event.GameId)
+		blackCard := event.BlackCard
+		client.gameBlackCard = &blackCard
+		if client.prefs.HideTopicCard {
+			client.sendTopicChangeForStartedGame()
+		} else {
+			// it's different every round
+			client.gameInProgress = true
+			client.sendTopicChange()
+		}
+		client.roundStarted()
+		announcement := "The black card for the next round is: " +
+			client.blackCardText(event.BlackCard)
+		if number := client.roundNumber(); number > 0 {
+			announcement = fmt.Sprintf("Round %d has started. The black card is: %s", number,
+				client.blackCardText(event.BlackCard))
+		}
+		if left := client.blackCardsLeft(); left >= 0 {
+			announcement += fmt.Sprintf(" (%d black cards left)", left)
+		}
+		client.sendBotMessageToGame("%s", announcement)
+		client.transcriptNewRound(blackCardText(event.BlackCard))
+		client.newSelection(event.BlackCard)
+		client.gameScores.roundStarted()
+		resp, judge, err := client.gameJudge()
 		if err != nil {
 			log.Errorf("Unable to obtain status for game %d after state change", *event.GameId)
 			return
 		}
-		judge := getJudge(&resp.PlayerInfo)
-		if judge == client.pyx.User.Name {
+		client.gamePlayerStatus = make(map[string]string)
+		for _, info := range resp.PlayerInfo {
+			client.gamePlayerStatus[info.Name] = info.Status
+		}
+		client.setJudgeMode(judge)
+		if judge == client.pyx().User.Name {
 			client.sendBotMessageToGame("You are judging this round.")
 		} else {
 			client.sendBotMessageToGame("The judge this round is %s.", judge)
+		}
+		client.startRoundTimer(event.PlayTimer, "Players have %d seconds to play their cards.",
+			"Players have %d seconds left to play their cards!")
+		if judge != client.pyx().User.Name {
 			if !client.gameIsSpectate {
-				// TODO show hand and ask for plays, and include the PLAY_TIMER
+				reply := client.botReplyTo(client.nick)
+				client.showHand(reply)
+				if event.BlackCard.Pick > 1 {
+					reply("Pick %d: use PLAY <card> for each one in order, or PLAY <card> <card> ...",
+						event.BlackCard.Pick)
+				} else {
+					reply("Use PLAY <card> to play a card.")
+				}
 			}
 		}
 	case pyx.GameState_JUDGING:
 		// save these for later
-		client.gamePlayedCards = &event.WhiteCards
+		client.setPlayedCards(event.WhiteCards)
+		client.transcriptPlays(event.WhiteCards)
 		cardPlural := ""
 		if len(event.WhiteCards[0]) > 1 {
 			cardPlural = "s"
@@ -280,89 +548,362 @@ func eventGameStateChange(client *Client, event Event) {
 		for i, cards := range event.WhiteCards {
 			msg := fmt.Sprintf("(Selection %d)", i)
 			for _, card := range cards {
-				msg = fmt.Sprintf("%s [%s]", msg, whiteCardText(card))
+				msg = fmt.Sprintf("%s [%s]", msg, client.whiteCardText(card))
 			}
 			client.sendBotMessageToGame(msg)
 		}
-		resp, err := client.pyx.GameInfo(*event.GameId)
+		_, judge, err := client.gameJudge()
 		if err != nil {
 			log.Errorf("Unable to obtain status for game %d after state change", *event.GameId)
 			return
 		}
-		judge := getJudge(&resp.PlayerInfo)
-		if judge == client.pyx.User.Name {
+		if judge == client.pyx().User.Name {
 			// TODO ask for judging
 		} else {
 			client.sendBotMessageToGame("Please wait while %s selects the winning card%s.", judge,
 				cardPlural)
 		}
+		client.startRoundTimer(event.PlayTimer, "The judge has %d seconds to pick a winner.",
+			"The judge has %d seconds left to pick a winner!")
 	default:
 		log.Errorf("Unknown game state %s", event.GameState)
 	}
 }
 
-func eventGameRoundComplete(client *Client, event Event) {
+// Announce how long this part of the round lasts. The formats take the number of seconds. If
+// warnings are enabled, warningFormat will be sent to the game channel shortly before time is up.
+func (client *Client) startRoundTimer(timer int, announceFormat string, warningFormat string) {
+	client.stopRoundTimer()
+	if timer <= 0 {
+		return
+	}
+	duration := time.Duration(timer) * time.Millisecond
+	client.sendBotMessageToGame(announceFormat, int(duration.Seconds()))
+	if client.config.RoundTimerWarning && duration > RoundTimerWarningTime {
+		client.roundWarning = time.NewTimer(duration - RoundTimerWarningTime)
+		client.roundWarningMsg = fmt.Sprintf(warningFormat, int(RoundTimerWarningTime.Seconds()))
+	}
+}
+
+func (client *Client) stopRoundTimer() {
+	if client.roundWarning != nil {
+		client.roundWarning.Stop()
+		client.roundWarning = nil
+	}
+}
+
+func eventGameRoundComplete(client *Client, e pyx.Event) {
+	event := e.(*pyx.GameRoundCompleteEvent)
+	client.stopRoundTimer()
+	client.gameScores.roundWon(event.RoundWinner)
 	// so the white card winning ID is only one of the cards if it's a pick-multiple...
 	winningCard := ""
-	for _, cards := range *client.gamePlayedCards {
+	// the same thing, but formatted for the channel
+	shownCard := ""
+	// we might not have seen them get played, if we just showed up
+	for _, cards := range client.playedCards() {
 		// the provided ID will always be the first card that a player played, so we can just check
 		// that one
 		if cards[0].Id == event.WinningCard {
 			for _, card := range cards {
 				winningCard = fmt.Sprintf("%s [%s]", winningCard, whiteCardText(card))
+				shownCard = fmt.Sprintf("%s [%s]", shownCard, client.whiteCardText(card))
 			}
 			break
 		}
 	}
 	// yes that missing space is intentional, it'll be provided by the above formatting
-	client.sendBotMessageToGame("The round was won by %s by playing%s.", event.RoundWinner,
-		winningCard)
-	client.showScoreboard()
+	client.sendBotMessageToGame("%s was won by %s by playing%s.", client.roundName(),
+		client.winnerText(event.RoundWinner), shownCard)
+	scores, winner, err := client.getScores()
+	if err != nil {
+		return
+	}
+	client.displayScores(client.sendBotMessageToGame, scores, winner)
+	client.transcriptRoundComplete(event.RoundWinner, strings.TrimSpace(winningCard), scores,
+		winner)
 }
 
-func (client *Client) showScoreboard() error {
-	resp, err := client.pyx.GameInfo(*client.gameId)
+// Show the scores for the current game, using the provided function to send each line.
+func (client *Client) showScoreboard(reply BotReplyFunc) error {
+	scores, winner, err := client.getScores()
 	if err != nil {
-		log.Errorf("Unable to obtain info about game %d to display scoreboard", *client.gameId)
 		return err
 	}
+	client.displayScores(reply, scores, winner)
+	return nil
+}
 
-	scores := []string{}
-	winner := ""
-	for _, info := range resp.PlayerInfo {
-		if info.Status == pyx.GamePlayerStatus_WINNER {
-			winner = info.Name
-		}
-		plural := "s"
-		if info.Score == 1 {
-			plural = ""
-		}
-		scores = append(scores, fmt.Sprintf("%s with %d point%s", info.Name, info.Score, plural))
+// Retrieve the scores for the current game, and the winner if the game is over.
+func (client *Client) getScores() ([]string, string, error) {
+	if scores, winner, ok := client.gameScores.lines(); ok {
+		return scores, winner, nil
 	}
+	// we've lost track, so start over from the server
+	resp, err := client.gameInfo()
+	if err != nil {
+		log.Errorf("Unable to obtain info about %s to display scoreboard: %s",
+			client.getGameChannel(), err)
+		return []string{}, "", err
+	}
+	client.gameScores.sync(resp.PlayerInfo)
+	scores, winner, _ := client.gameScores.lines()
+	return scores, winner, nil
+}
+
+func (client *Client) displayScores(reply BotReplyFunc, scores []string, winner string) {
 	// TODO a proper length based on 512 minus broilerplate
 	scoresAssembled := joinIntoLines(300, scores, ", ")
 	if winner != "" {
-		client.sendBotMessageToGame("The game was won by %s! The final scores are: %s.", winner,
+		reply("The game was won by %s! The final scores are: %s.", client.winnerText(winner),
 			scoresAssembled[0])
 	} else {
-		client.sendBotMessageToGame("The current scores are: %s.", scoresAssembled[0])
+		reply("The current scores are: %s.", scoresAssembled[0])
 	}
 	if len(scoresAssembled) > 1 {
 		for i := 1; i < len(scoresAssembled); i++ {
-			client.sendBotMessageToGame(scoresAssembled[i])
+			reply(scoresAssembled[i])
 		}
 	}
-	return nil
 }
 
-func eventGamePlayerSkipped(client *Client, event Event) {
-	client.sendBotMessageToGame("%s was skipped this round for being idle.", event.Nickname)
+// Players who have played this round are devoiced until the round is over, so spectators can see
+// who we are waiting for.
+func eventGamePlayerInfoChange(client *Client, e pyx.Event) {
+	event := e.(*pyx.GamePlayerInfoChangeEvent)
+	client.gameCache.playerInfoChanged(event.GameId, event.PlayerInfo)
+	if event.GameId != nil && client.inGame(*event.GameId) {
+		client.gameScores.playerInfoChanged(event.PlayerInfo)
+	}
+	if !client.inAnyGame() || client.gamePlayerStatus == nil {
+		return
+	}
+	info := event.PlayerInfo
+	previous := client.gamePlayerStatus[info.Name]
+	client.gamePlayerStatus[info.Name] = info.Status
+	if client.gameState != pyx.GameState_PLAYING || previous != pyx.GamePlayerStatus_PLAYING ||
+		info.Status != pyx.GamePlayerStatus_IDLE {
+		return
+	}
+
+	played := 0
+	total := 0
+	for _, status := range client.gamePlayerStatus {
+		if status == pyx.GamePlayerStatus_IDLE {
+			played++
+			total++
+		} else if status == pyx.GamePlayerStatus_PLAYING {
+			total++
+		}
+	}
+	client.data.push(newLine(client.botNickUserAtHost(), "MODE").
+		param(client.getGameChannel(), "-v", info.Name).String())
+	client.gameDevoiced = append(client.gameDevoiced, info.Name)
+	client.sendBotMessageToGame("%s has played. %d/%d players have played.", info.Name, played,
+		total)
+}
+
+// Give voice back to the players that played this round.
+func (client *Client) revoicePlayers() {
+	if client.inAnyGame() {
+		for _, nick := range client.gameDevoiced {
+			client.data.push(newLine(client.botNickUserAtHost(), "MODE").
+				param(client.getGameChannel(), "+v", nick).String())
+		}
+	}
+	client.gameDevoiced = nil
+}
+
+// The judge gets +a (shown as &) for the round, so clients show who it is.
+func (client *Client) setJudgeMode(judge string) {
+	if judge == "" || !client.inAnyGame() {
+		return
+	}
+	client.data.push(newLine(client.botNickUserAtHost(), "MODE").
+		param(client.getGameChannel(), "+a", judge).String())
+	client.gameJudgeMode = judge
+}
+
+func (client *Client) clearJudgeMode() {
+	if client.gameJudgeMode != "" && client.inAnyGame() {
+		client.data.push(newLine(client.botNickUserAtHost(), "MODE").
+			param(client.getGameChannel(), "-a", client.gameJudgeMode).String())
+	}
+	client.gameJudgeMode = ""
+}
+
+// The server is about to skip us for taking too long.
+func eventHurryUp(client *Client, e pyx.Event) {
+	if !client.inAnyGame() {
+		return
+	}
+	if client.gameState == pyx.GameState_JUDGING {
+		client.botNotice("Hurry up and pick a winner, or you will be skipped!")
+	} else {
+		client.botNotice("Hurry up and play with PLAY <card>, or you will be skipped!")
+	}
+}
+
+// We took too long too many times, and the server took us out of the game.
+func eventKickedFromGameIdle(client *Client, e pyx.Event) {
+	gameId, ok := client.currentGame()
+	if !ok {
+		return
+	}
+	client.botNotice("You were removed from game %d for being idle for too many rounds.", gameId)
+	client.data.push(newLine(client.botNickUserAtHost(), "KICK").
+		param(client.getGameChannel(), client.nick).text("Idle for too many rounds").String())
+	client.leftGame()
+}
+
+// Shortly before time runs out, remind us personally if we're the one holding up the round.
+func (client *Client) warnIfHoldingUp() {
+	if !client.inAnyGame() || client.gameIsSpectate || client.gamePlayerStatus == nil {
+		return
+	}
+	switch client.gamePlayerStatus[client.pyx().User.Name] {
+	case pyx.GamePlayerStatus_PLAYING:
+		client.botNotice("You haven't played yet! Use PLAY <card> before time runs out.")
+	case pyx.GamePlayerStatus_JUDGING:
+		client.botNotice("You haven't picked a winner yet, and time is almost up!")
+	}
 }
 
-func eventGameWhiteShuffle(client *Client, event Event) {
+func eventGamePlayerSkipped(client *Client, e pyx.Event) {
+	event := e.(*pyx.GamePlayerEvent)
+	if number := client.roundNumber(); number > 0 {
+		client.sendBotMessageToGame("%s was skipped in round %d for being idle.", event.Nickname,
+			number)
+	} else {
+		client.sendBotMessageToGame("%s was skipped this round for being idle.", event.Nickname)
+	}
+}
+
+func eventGameWhiteShuffle(client *Client, event pyx.Event) {
 	client.sendBotMessageToGame("The discarded white cards have been re-shuffled into a new deck.")
 }
 
-func eventGameBlackShuffle(client *Client, event Event) {
+func eventGameBlackShuffle(client *Client, event pyx.Event) {
+	client.blackCardsShuffled()
 	client.sendBotMessageToGame("The discarded black cards have been re-shuffled into a new deck.")
 }
+
+// The host changed the game's options, probably on the web.
+func eventGameOptionsChanged(client *Client, e pyx.Event) {
+	event := e.(*pyx.GameOptionsChangedEvent)
+	if event.GameId == nil || !client.inGame(*event.GameId) {
+		return
+	}
+	// what it was before, so we know which modes changed
+	before, err := client.gameInfo()
+	client.gameCache.optionsChanged(event.GameId, event.GameInfo)
+	if err != nil {
+		log.Errorf("Unable to retrieve game %d info for options change: %s", *event.GameId, err)
+	} else if modes, params := gameModeChanges(before.GameInfo, event.GameInfo); modes != "" {
+		client.data.push(newLine(client.botNickUserAtHost(), "MODE").
+			param(client.getGameChannel(), modes).param(params...).String())
+	}
+	client.sendTopicChange()
+}
+
+// The MODE changes that go with going from one set of options to another, the same way MODE shows
+// them.
+func gameModeChanges(before pyx.GameInfo, after pyx.GameInfo) (string, []string) {
+	plus := ""
+	minus := ""
+	params := []string{}
+	if after.HasPassword && !before.HasPassword {
+		plus += "k"
+		// only the host gets to see it
+		key := after.GameOptions.Password
+		if key == "" {
+			key = "*"
+		}
+		params = append(params, key)
+	} else if before.HasPassword && !after.HasPassword {
+		// unreal wants the key to remove it, but there's no way to know what it was
+		minus += "k"
+	}
+	if after.GameOptions.PlayerLimit != before.GameOptions.PlayerLimit {
+		plus += "l"
+		params = append(params, strconv.Itoa(after.GameOptions.PlayerLimit+1))
+	}
+	if after.GameOptions.SpectatorLimit != before.GameOptions.SpectatorLimit {
+		plus += "L"
+		params = append(params, strconv.Itoa(after.GameOptions.SpectatorLimit+1))
+	}
+	modes := ""
+	if minus != "" {
+		modes += "-" + minus
+	}
+	if plus != "" {
+		modes += "+" + plus
+	}
+	return modes, params
+}
+
+func eventCardcastAddCardset(client *Client, e pyx.Event) {
+	if !client.inAnyGame() {
+		return
+	}
+	deck := e.(*pyx.CardcastEvent).CardSet
+	client.gameCustomDecks = append(client.gameCustomDecks, deck)
+	client.sendBotMessageToGame("Cardcast deck %s (%s) has been added to the game.",
+		deck.CardcastCode(), deck.CardSetName)
+	client.sendTopicChange()
+}
+
+func eventCardcastRemoveCardset(client *Client, e pyx.Event) {
+	if !client.inAnyGame() {
+		return
+	}
+	deck := e.(*pyx.CardcastEvent).CardSet
+	decks := []pyx.CardSetData{}
+	for _, existing := range client.gameCustomDecks {
+		if existing.Id != deck.Id {
+			decks = append(decks, existing)
+		}
+	}
+	client.gameCustomDecks = decks
+	client.sendBotMessageToGame("Cardcast deck %s (%s) has been removed from the game.",
+		deck.CardcastCode(), deck.CardSetName)
+	client.sendTopicChange()
+}
+
+// Retrieve the Cardcast decks for the game we are in. Failing to do so isn't fatal, the topic just
+// won't include them.
+func (client *Client) refreshCustomDecks() {
+	client.gameCustomDecks = nil
+	gameId, ok := client.currentGame()
+	if !ok {
+		return
+	}
+	resp, err := client.pyx().CardcastListCardsets(gameId)
+	if err != nil {
+		log.Errorf("Unable to retrieve Cardcast decks for game %d: %s", gameId, err)
+		return
+	}
+	client.gameCustomDecks = resp.CardSets
+}
+
+func eventHandDeal(client *Client, e pyx.Event) {
+	client.gameHand = append(client.gameHand, e.(*pyx.HandDealEvent).Hand...)
+}
+
+// Retrieve our hand from the server, in case we missed some deals or got out of sync.
+func (client *Client) refreshHand() {
+	client.gameHand = nil
+	gameId, ok := client.currentGame()
+	if !ok || client.gameIsSpectate {
+		return
+	}
+	resp, err := client.pyx().GetCards(gameId)
+	if err != nil {
+		log.Errorf("Unable to retrieve hand for game %d: %s", gameId, err)
+		return
+	}
+	client.gameHand = resp.Hand
+	if resp.BlackCard != nil {
+		client.gameBlackCard = resp.BlackCard
+	}
+}
@@ -0,0 +1,99 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// mIRC formatting for card announcements, for people who turn it on
+
+package irc
+
+import (
+	"fmt"
+	"github.com/ajanata/pyx-irc/pyx"
+	"regexp"
+)
+
+const (
+	formatBold      = "\x02"
+	formatColor     = "\x03"
+	formatUnderline = "\x1f"
+)
+
+// everything clients can send: bold, colors with their numbers, hex colors, reset, monospace,
+// reverse, italics, strikethrough and underline
+var formattingRegex = regexp.MustCompile(
+	`\x02|\x03(\d{1,2}(,\d{1,2})?)?|\x04([0-9a-fA-F]{6}(,[0-9a-fA-F]{6})?)?|[\x0f\x11\x16\x1d\x1e\x1f]`)
+
+// mIRC color numbers, always two digits so text after them can't be mistaken for part of the number
+const (
+	colorRed  = "04"
+	colorBlue = "12"
+	colorGrey = "14"
+)
+
+// the blanks in black cards
+var blankRegex = regexp.MustCompile("_{2,}")
+
+// What to send to PYX instead of what an IRC client sent.
+func stripFormatting(s string) string {
+	return formattingRegex.ReplaceAllString(s, "")
+}
+
+func bold(s string) string {
+	return formatBold + s + formatBold
+}
+
+func underline(s string) string {
+	return formatUnderline + s + formatUnderline
+}
+
+func colored(color string, s string) string {
+	// a bare color code followed by a digit would start a new color, so break it up with an empty
+	// bold
+	return formatColor + color + s + formatColor + formatBold + formatBold
+}
+
+func (client *Client) blackCardText(card pyx.BlackCardData) string {
+	if !client.prefs.Colors {
+		return blackCardText(card)
+	}
+	text := blankRegex.ReplaceAllStringFunc(card.Text, func(blank string) string {
+		return colored(colorRed, blank)
+	})
+	return fmt.Sprintf("%s %s", colored(colorBlue, fmt.Sprintf("(Pick %d, source %s)", card.Pick,
+		card.Watermark)), bold(text))
+}
+
+func (client *Client) whiteCardText(card pyx.WhiteCardData) string {
+	if !client.prefs.Colors {
+		return whiteCardText(card)
+	}
+	return fmt.Sprintf("%s %s", bold(card.Text), colored(colorGrey,
+		fmt.Sprintf("(source %s)", card.Watermark)))
+}
+
+// Whoever won the round or game.
+func (client *Client) winnerText(nick string) string {
+	if !client.prefs.Colors {
+		return nick
+	}
+	return underline(nick)
+}
@@ -0,0 +1,76 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"testing"
+)
+
+func TestBlackCardFormatting(t *testing.T) {
+	card := pyx.BlackCardData{Pick: 1, Watermark: "US", Text: "____ 2 go."}
+	client := &Client{}
+	if text := client.blackCardText(card); text != blackCardText(card) {
+		t.Error("For colors off expected", blackCardText(card), "got", text)
+	}
+	client.prefs.Colors = true
+	expected := "\x0312(Pick 1, source US)\x03\x02\x02 \x02\x0304____\x03\x02\x02 2 go.\x02"
+	if text := client.blackCardText(card); text != expected {
+		t.Errorf("For colors on expected %q got %q", expected, text)
+	}
+}
+
+func TestWinnerFormatting(t *testing.T) {
+	client := &Client{}
+	if text := client.winnerText("alice"); text != "alice" {
+		t.Error("For colors off expected alice got", text)
+	}
+	client.prefs.Colors = true
+	if text := client.winnerText("alice"); text != "\x1falice\x1f" {
+		t.Errorf("For colors on expected underlined alice got %q", text)
+	}
+}
+
+type stripFormattingTestPair struct {
+	input  string
+	output string
+}
+
+var stripFormattingTests = []stripFormattingTestPair{
+	{"plain text", "plain text"},
+	{"\x02bold\x02 and \x1funderline\x1f", "bold and underline"},
+	{"\x0304red\x03 \x034,12red on blue\x03", "red red on blue"},
+	{"\x03" + "12" + "3 apples", "3 apples"},
+	{"\x04FF0000hex\x04 \x1ditalic\x0f", "hex italic"},
+	{"\x0312,05,not a color", ",not a color"},
+}
+
+func TestStripFormatting(t *testing.T) {
+	for _, pair := range stripFormattingTests {
+		stripped := stripFormatting(pair.input)
+		if stripped != pair.output {
+			t.Errorf("For %q expected %q got %q", pair.input, pair.output, stripped)
+		}
+	}
+}
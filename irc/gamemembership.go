@@ -0,0 +1,114 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Which game the client is in, kept behind a lock since commands and PYX events both look at it
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"sync"
+)
+
+// Commands and PYX events are handled on different goroutines, so an event can show up right as
+// the user PARTs. Everything goes through the methods below so nobody dereferences a game that
+// just went away.
+type gameMembership struct {
+	lock sync.Mutex
+	// nil if we're not in a game
+	id *int
+	// the cards played in the most recently completed round, nil if there hasn't been one
+	playedCards [][]pyx.WhiteCardData
+	// the key we joined with, to get back in if PYX drops us
+	key string
+}
+
+// The game we're in, and false if we aren't in one.
+func (client *Client) currentGame() (int, bool) {
+	client.game.lock.Lock()
+	defer client.game.lock.Unlock()
+	if client.game.id == nil {
+		return 0, false
+	}
+	return *client.game.id, true
+}
+
+// A copy of the game we're in, or nil, for things that track games by pointer.
+func (client *Client) currentGamePtr() *int {
+	gameId, ok := client.currentGame()
+	if !ok {
+		return nil
+	}
+	return &gameId
+}
+
+func (client *Client) inAnyGame() bool {
+	_, ok := client.currentGame()
+	return ok
+}
+
+func (client *Client) inGame(gameId int) bool {
+	current, ok := client.currentGame()
+	return ok && current == gameId
+}
+
+// Anything left over from a previous game doesn't apply to this one.
+func (client *Client) setCurrentGame(gameId int) {
+	client.game.lock.Lock()
+	defer client.game.lock.Unlock()
+	client.game.id = &gameId
+	client.game.playedCards = nil
+}
+
+func (client *Client) clearCurrentGame() {
+	client.game.lock.Lock()
+	defer client.game.lock.Unlock()
+	client.game.id = nil
+	client.game.playedCards = nil
+	client.game.key = ""
+}
+
+func (client *Client) setGameKey(key string) {
+	client.game.lock.Lock()
+	defer client.game.lock.Unlock()
+	client.game.key = key
+}
+
+func (client *Client) gameKey() string {
+	client.game.lock.Lock()
+	defer client.game.lock.Unlock()
+	return client.game.key
+}
+
+func (client *Client) setPlayedCards(cards [][]pyx.WhiteCardData) {
+	client.game.lock.Lock()
+	defer client.game.lock.Unlock()
+	client.game.playedCards = cards
+}
+
+// nil if we didn't see the cards get played, or we've since left the game.
+func (client *Client) playedCards() [][]pyx.WhiteCardData {
+	client.game.lock.Lock()
+	defer client.game.lock.Unlock()
+	return client.game.playedCards
+}
@@ -0,0 +1,101 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGameMembership(t *testing.T) {
+	config := &Config{}
+	config.EnsureDefaults()
+	connection, other := net.Pipe()
+	defer other.Close()
+	client := NewClient(connection, config)
+
+	if client.inAnyGame() || client.currentGamePtr() != nil || client.getGameChannel() != "" {
+		t.Error("For a new client expected no game, got", client.currentGamePtr())
+	}
+	client.setCurrentGame(3)
+	client.setPlayedCards([][]pyx.WhiteCardData{{{Id: 1}}})
+	if !client.inGame(3) || client.inGame(4) {
+		t.Error("For game 3 expected inGame(3) and not inGame(4)")
+	}
+	// callers get their own copy, so they can't change which game we're in
+	*client.currentGamePtr() = 4
+	if gameId, _ := client.currentGame(); gameId != 3 {
+		t.Error("For changing the returned pointer expected game 3, got", gameId)
+	}
+	client.setCurrentGame(4)
+	if client.playedCards() != nil {
+		t.Error("For a new game expected no played cards, got", client.playedCards())
+	}
+	client.setPlayedCards([][]pyx.WhiteCardData{{{Id: 1}}})
+	client.clearCurrentGame()
+	if client.inAnyGame() || client.playedCards() != nil || client.getGameChannel() != "" {
+		t.Error("For leaving expected no game or played cards, got", client.currentGamePtr(),
+			client.playedCards())
+	}
+}
+
+// Commands and events race to look at the game; run with -race to catch anything unguarded.
+func TestGameMembershipInterleaved(t *testing.T) {
+	config := &Config{}
+	config.EnsureDefaults()
+	connection, other := net.Pipe()
+	defer other.Close()
+	client := NewClient(connection, config)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	// the command side, joining and parting over and over
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			client.setCurrentGame(i % 5)
+			client.clearCurrentGame()
+		}
+	}()
+	// the event side, which has to cope with the game going away at any moment
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			client.setPlayedCards([][]pyx.WhiteCardData{{{Id: i}}})
+			for range client.playedCards() {
+			}
+			channel := client.getGameChannel()
+			if channel != "" && !strings.HasPrefix(channel, config.GameChannelPrefix) {
+				t.Error("For a game channel expected the game prefix, got", channel)
+			}
+			if gameId, ok := client.currentGame(); ok && gameId > 4 {
+				t.Error("For interleaved joins expected a game from 0 to 4, got", gameId)
+			}
+		}
+	}()
+	wg.Wait()
+}
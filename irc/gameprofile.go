@@ -0,0 +1,102 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+// Game option profiles from the config, applied when a game is created from IRC
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"sort"
+	"strings"
+)
+
+type GameProfile struct {
+	ScoreLimit      int    `toml:"score_limit"`
+	PlayerLimit     int    `toml:"player_limit"`
+	SpectatorLimit  int    `toml:"spectator_limit"`
+	BlanksLimit     int    `toml:"blanks_limit"`
+	CardSets        []int  `toml:"card_sets"`
+	TimerMultiplier string `toml:"timer_multiplier"`
+}
+
+// Copy whatever the profile sets onto the options. Zero values leave the server's default alone.
+func (profile *GameProfile) apply(options *pyx.GameOptionData) {
+	if profile.ScoreLimit > 0 {
+		options.ScoreLimit = profile.ScoreLimit
+	}
+	if profile.PlayerLimit > 0 {
+		options.PlayerLimit = profile.PlayerLimit
+	}
+	if profile.SpectatorLimit > 0 {
+		options.SpectatorLimit = profile.SpectatorLimit
+	}
+	if profile.BlanksLimit > 0 {
+		options.BlanksLimit = profile.BlanksLimit
+	}
+	if len(profile.CardSets) > 0 {
+		options.CardSets = append([]int{}, profile.CardSets...)
+	}
+	if profile.TimerMultiplier != "" {
+		options.TimerMultiplier = profile.TimerMultiplier
+	}
+}
+
+func (profile *GameProfile) isEmpty() bool {
+	return profile.ScoreLimit <= 0 && profile.PlayerLimit <= 0 && profile.SpectatorLimit <= 0 &&
+		profile.BlanksLimit <= 0 && len(profile.CardSets) == 0 && profile.TimerMultiplier == ""
+}
+
+// Preset names are matched without regard to case, since they end up in channel names.
+func (config *Config) gamePreset(name string) (*GameProfile, bool) {
+	for presetName, profile := range config.GamePresets {
+		if strings.EqualFold(presetName, name) {
+			return &profile, true
+		}
+	}
+	return nil, false
+}
+
+func (config *Config) gamePresetNames() []string {
+	names := make([]string, 0, len(config.GamePresets))
+	for name := range config.GamePresets {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Figure out if a JOIN is for the new game channel, and which preset it wants if so. The preset
+// goes after a dash, like #new-casual.
+func (config *Config) newGamePreset(channel string) (string, bool) {
+	if !config.hasPrefixFold(channel, config.NewGameChannel) {
+		return "", false
+	}
+	rest := channel[len(config.NewGameChannel):]
+	if rest == "" {
+		return "", true
+	}
+	if rest[0] != '-' || len(rest) == 1 {
+		return "", false
+	}
+	return rest[1:], true
+}
@@ -0,0 +1,71 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"testing"
+)
+
+type newGamePresetTestPair struct {
+	channel string
+	preset  string
+	ok      bool
+}
+
+var newGamePresetTests = []newGamePresetTestPair{
+	{"#new", "", true},
+	{"#NEW", "", true},
+	{"#new-casual", "casual", true},
+	{"#new-", "", false},
+	{"#newbies", "", false},
+	{"#game-1", "", false},
+	{"#ne", "", false},
+}
+
+func TestNewGamePreset(t *testing.T) {
+	config := Config{}
+	config.EnsureDefaults()
+	for _, pair := range newGamePresetTests {
+		preset, ok := config.newGamePreset(pair.channel)
+		if preset != pair.preset || ok != pair.ok {
+			t.Error("For", pair.channel,
+				"expected", pair.preset, pair.ok,
+				"got", preset, ok,
+			)
+		}
+	}
+}
+
+func TestGamePreset(t *testing.T) {
+	config := Config{GamePresets: map[string]GameProfile{"Casual": {ScoreLimit: 3}, "long": {}}}
+	if profile, ok := config.gamePreset("casual"); !ok || profile.ScoreLimit != 3 {
+		t.Error("For casual expected score limit 3, got", profile, ok)
+	}
+	if _, ok := config.gamePreset("short"); ok {
+		t.Error("For short expected no preset")
+	}
+	if names := config.gamePresetNames(); len(names) != 2 || names[0] != "casual" {
+		t.Error("For preset names expected [casual long], got", names)
+	}
+}
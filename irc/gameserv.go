@@ -0,0 +1,340 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// A GameServ pseudo-service, for finding and managing games without channel name tricks
+
+package irc
+
+import (
+	"fmt"
+	"github.com/ajanata/pyx-irc/pyx"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var GameServCommands map[string]BotCommand
+
+// Have to do this in init since HELP needs to look at the map.
+func init() {
+	GameServCommands = map[string]BotCommand{
+		"CREATE": {
+			handler: gameServCreate,
+			usage:   "[preset] [option=value ...]",
+			help: "Create a new game and join it as the host, optionally starting from one of the " +
+				"server's presets. Options are score, players, spectators, blanks, timer, password, " +
+				"and sets (card set ids, separated by commas).",
+		},
+		"HELP": {
+			handler: gameServHelp,
+			usage:   "[command]",
+			help:    "Show the available commands, or help for one command.",
+		},
+		"INFO": {
+			handler: gameServInfo,
+			usage:   "<game>",
+			help:    "Show information about a game.",
+		},
+		"JOIN": {
+			handler: gameServJoin,
+			usage:   "<game> [password]",
+			help:    "Join a game as a player.",
+		},
+		"LIST": {
+			handler: gameServList,
+			usage:   "[filter ...]",
+			help: "List the games on the server. Filters are lobby, playing, nopass, spectate, " +
+				"or anything else to match the host's nick.",
+		},
+	}
+}
+
+// GAMESERV and GS commands, for clients that have them as shortcuts.
+func handleGameServ(client *Client, msg Message) {
+	client.handleGameServPrivmsg(strings.Join(msg.args, " "))
+}
+
+func (client *Client) handleGameServPrivmsg(text string) {
+	reply := client.serviceReplyFunc(client.config.GameServNick)
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		reply("Try HELP.")
+		return
+	}
+	name := strings.ToUpper(words[0])
+	command, ok := GameServCommands[name]
+	if !ok {
+		reply("Unknown command %s. Try HELP.", words[0])
+		return
+	}
+	log.Debugf("Running GameServ command %s for %s with %v", name, client.nick, words[1:])
+	command.handler(client, reply, words[1:])
+}
+
+func (client *Client) isGameServ(nick string) bool {
+	return client.config.equalFold(nick, client.config.GameServNick)
+}
+
+func gameServHelp(client *Client, reply BotReplyFunc, args []string) {
+	if len(args) > 0 {
+		command, ok := GameServCommands[strings.ToUpper(args[0])]
+		if !ok {
+			reply("No such command %s.", args[0])
+			return
+		}
+		reply("%s %s: %s", strings.ToUpper(args[0]), command.usage, command.help)
+		return
+	}
+
+	names := []string{}
+	for name := range GameServCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reply("Games can also be joined as channels, like %s1. Commands:",
+		client.config.GameChannelPrefix)
+	for _, name := range names {
+		command := GameServCommands[name]
+		reply("  %s %s: %s", name, command.usage, command.help)
+	}
+}
+
+// Games have to match all of the filters to be listed.
+func gameMatchesFilters(game *pyx.GameInfo, filters []string) bool {
+	for _, filter := range filters {
+		switch strings.ToLower(filter) {
+		case "lobby":
+			if game.State != pyx.GameState_LOBBY {
+				return false
+			}
+		case "playing":
+			if game.State == pyx.GameState_LOBBY {
+				return false
+			}
+		case "nopass":
+			if game.HasPassword {
+				return false
+			}
+		case "spectate":
+			if len(game.Spectators) >= game.GameOptions.SpectatorLimit {
+				return false
+			}
+		default:
+			if !strings.Contains(strings.ToLower(game.Host), strings.ToLower(filter)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func gameServList(client *Client, reply BotReplyFunc, args []string) {
+	resp, err := client.pyx().GameList()
+	if err != nil {
+		reply("Unable to retrieve the game list: %s", err)
+		return
+	}
+	count := 0
+	for _, game := range resp.Games {
+		if !gameMatchesFilters(&game, args) {
+			continue
+		}
+		count++
+		reply("%s%d: %s", client.config.GameChannelPrefix, game.Id, makeGameTopic(&game,
+			client.pyx().CardSetNames(game.GameOptions.CardSets), nil))
+	}
+	reply("%d of %d games listed.", count, len(resp.Games))
+}
+
+// Game ids can be given as just the number or as the channel name.
+func (client *Client) parseGameArg(arg string) (int, error) {
+	if id, _, err := client.getGameFromChannel(arg); err == nil {
+		return id, nil
+	}
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return -1, fmt.Errorf("%s is not a game", arg)
+	}
+	return id, nil
+}
+
+func gameServInfo(client *Client, reply BotReplyFunc, args []string) {
+	if len(args) != 1 {
+		reply("Usage: INFO <game>")
+		return
+	}
+	gameId, err := client.parseGameArg(args[0])
+	if err != nil {
+		reply("%s", err)
+		return
+	}
+	var resp *pyx.GameInfoResult
+	var customDecks []pyx.CardSetData
+	if client.inGame(gameId) {
+		resp, err = client.gameInfo()
+		// we can only see these for our own game
+		customDecks = client.gameCustomDecks
+	} else {
+		resp, err = client.pyx().GameInfo(gameId)
+	}
+	if err != nil {
+		reply("Unable to retrieve game information: %s", err)
+		return
+	}
+	client.describeGame(reply, client.config.GameChannelPrefix+strconv.Itoa(gameId),
+		&resp.GameInfo, customDecks)
+}
+
+func gameServJoin(client *Client, reply BotReplyFunc, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		reply("Usage: JOIN <game> [password]")
+		return
+	}
+	if client.inAnyGame() {
+		reply("You are already in %s.", client.getGameChannel())
+		return
+	}
+	gameId, err := client.parseGameArg(args[0])
+	if err != nil {
+		reply("%s", err)
+		return
+	}
+	password := ""
+	if len(args) == 2 {
+		password = args[1]
+	}
+	// any problems get the same numerics as a channel join
+	client.joinGame(client.config.GameChannelPrefix+strconv.Itoa(gameId), gameId, false, password)
+}
+
+// Apply option=value settings from CREATE on top of the game's defaults.
+func parseGameOptions(options *pyx.GameOptionData, args []string) error {
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Options must look like option=value, not %s", arg)
+		}
+		name, value := strings.ToLower(parts[0]), parts[1]
+		var err error
+		switch name {
+		case "password":
+			options.Password = value
+		case "timer":
+			options.TimerMultiplier = value
+		case "score":
+			options.ScoreLimit, err = strconv.Atoi(value)
+		case "players":
+			options.PlayerLimit, err = strconv.Atoi(value)
+		case "spectators":
+			options.SpectatorLimit, err = strconv.Atoi(value)
+		case "blanks":
+			options.BlanksLimit, err = strconv.Atoi(value)
+		case "sets":
+			options.CardSets = []int{}
+			for _, id := range strings.Split(value, ",") {
+				var set int
+				set, err = strconv.Atoi(id)
+				if err != nil {
+					break
+				}
+				options.CardSets = append(options.CardSets, set)
+			}
+		default:
+			return fmt.Errorf("Unknown option %s", name)
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid value for %s: %s", name, value)
+		}
+	}
+	return nil
+}
+
+func gameServCreate(client *Client, reply BotReplyFunc, args []string) {
+	if client.inAnyGame() {
+		reply("You are already in %s.", client.getGameChannel())
+		return
+	}
+	// a leading argument that isn't an option picks a preset
+	preset := ""
+	if len(args) > 0 && !strings.Contains(args[0], "=") {
+		preset, args = args[0], args[1:]
+	}
+	client.createGame(reply, preset, args)
+}
+
+// Create a game with the configured defaults, then the preset, then the given options (or the
+// user's saved ones) on top.
+func (client *Client) createGame(reply BotReplyFunc, preset string, args []string) {
+	profiles := []*GameProfile{&client.config.DefaultGame}
+	if preset != "" {
+		presetProfile, ok := client.config.gamePreset(preset)
+		if !ok {
+			names := client.config.gamePresetNames()
+			if len(names) == 0 {
+				reply("Unknown preset %s. There are no presets.", preset)
+			} else {
+				reply("Unknown preset %s. Presets are: %s", preset, strings.Join(names, ", "))
+			}
+			return
+		}
+		profiles = append(profiles, presetProfile)
+	}
+	if len(args) == 0 {
+		args = client.prefs.GameOptions
+	}
+	// check the options before making a game we'd have to leave again
+	if err := parseGameOptions(&pyx.GameOptionData{}, args); err != nil {
+		reply("%s", err)
+		return
+	}
+
+	gameId, err := client.pyx().CreateGame()
+	if err != nil {
+		reply("Unable to create a game: %s", err)
+		return
+	}
+	channel := client.config.GameChannelPrefix + strconv.Itoa(gameId)
+	client.enteredGame(channel, gameId, false)
+	reply("Created %s.", channel)
+	if len(args) == 0 && len(profiles) == 1 && profiles[0].isEmpty() {
+		return
+	}
+
+	resp, err := client.gameInfo()
+	if err != nil {
+		reply("Unable to retrieve the new game's options: %s", err)
+		return
+	}
+	options := resp.GameInfo.GameOptions
+	for _, profile := range profiles {
+		profile.apply(&options)
+	}
+	parseGameOptions(&options, args)
+	_, err = client.pyx().ChangeGameOptions(gameId, options)
+	client.gameCache.invalidate()
+	if err != nil {
+		reply("Unable to change the new game's options: %s", err)
+		return
+	}
+	reply("Options set for %s.", channel)
+}
@@ -0,0 +1,96 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"reflect"
+	"testing"
+)
+
+type gameFilterTestPair struct {
+	filters []string
+	matches bool
+}
+
+var gameFilterGame = pyx.GameInfo{
+	Host:        "Alice",
+	State:       pyx.GameState_LOBBY,
+	HasPassword: true,
+	Spectators:  []string{"bob"},
+	GameOptions: pyx.GameOptionData{SpectatorLimit: 2},
+}
+
+var gameFilterTests = []gameFilterTestPair{
+	{[]string{}, true},
+	{[]string{"lobby"}, true},
+	{[]string{"playing"}, false},
+	{[]string{"nopass"}, false},
+	{[]string{"spectate"}, true},
+	{[]string{"ali"}, true},
+	{[]string{"LOBBY", "alice"}, true},
+	{[]string{"lobby", "bob"}, false},
+}
+
+func TestGameMatchesFilters(t *testing.T) {
+	for _, pair := range gameFilterTests {
+		matches := gameMatchesFilters(&gameFilterGame, pair.filters)
+		if matches != pair.matches {
+			t.Error("For", pair.filters,
+				"expected", pair.matches,
+				"got", matches,
+			)
+		}
+	}
+}
+
+type gameOptionsTestPair struct {
+	args    []string
+	options pyx.GameOptionData
+	ok      bool
+}
+
+var gameOptionsTests = []gameOptionsTestPair{
+	{[]string{}, pyx.GameOptionData{ScoreLimit: 8}, true},
+	{[]string{"score=10", "players=6"}, pyx.GameOptionData{ScoreLimit: 10, PlayerLimit: 6}, true},
+	{[]string{"password=a=b"}, pyx.GameOptionData{ScoreLimit: 8, Password: "a=b"}, true},
+	{[]string{"sets=1,2"}, pyx.GameOptionData{ScoreLimit: 8, CardSets: []int{1, 2}}, true},
+	{[]string{"sets=1,x"}, pyx.GameOptionData{}, false},
+	{[]string{"score"}, pyx.GameOptionData{}, false},
+	{[]string{"score=lots"}, pyx.GameOptionData{}, false},
+	{[]string{"colour=blue"}, pyx.GameOptionData{}, false},
+}
+
+func TestParseGameOptions(t *testing.T) {
+	for _, pair := range gameOptionsTests {
+		options := pyx.GameOptionData{ScoreLimit: 8}
+		err := parseGameOptions(&options, pair.args)
+		if (err == nil) != pair.ok || (pair.ok && !reflect.DeepEqual(options, pair.options)) {
+			t.Error("For", pair.args,
+				"expected", pair.options, pair.ok,
+				"got", options, err,
+			)
+		}
+	}
+}
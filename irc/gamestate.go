@@ -0,0 +1,187 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// What we know about the game we are in, kept up to date from events so we don't have to ask the
+// server every time something happens
+
+package irc
+
+import (
+	"errors"
+	"github.com/ajanata/pyx-irc/pyx"
+	"sync"
+)
+
+type gameState struct {
+	lock   sync.Mutex
+	gameId int
+	// nil until we've asked the server, or after something didn't add up
+	result *pyx.GameInfoResult
+}
+
+// Callers may hang on to what they get back, so they can't share slices with the cache.
+func copyGameInfoResult(result *pyx.GameInfoResult) *pyx.GameInfoResult {
+	c := *result
+	c.GameInfo.Players = append([]string{}, result.GameInfo.Players...)
+	c.GameInfo.Spectators = append([]string{}, result.GameInfo.Spectators...)
+	c.GameInfo.GameOptions.CardSets = append([]int{}, result.GameInfo.GameOptions.CardSets...)
+	c.PlayerInfo = append([]pyx.GamePlayerInfo{}, result.PlayerInfo...)
+	return &c
+}
+
+// Information about the game we are in, only asking the server if we don't already know it.
+func (client *Client) gameInfo() (*pyx.GameInfoResult, error) {
+	gameId, ok := client.currentGame()
+	if !ok {
+		return nil, errors.New("Not in a game")
+	}
+	state := client.gameCache
+	state.lock.Lock()
+	if state.result != nil && state.gameId == gameId {
+		result := copyGameInfoResult(state.result)
+		state.lock.Unlock()
+		return result, nil
+	}
+	state.lock.Unlock()
+
+	resp, err := client.pyx().GameInfo(gameId)
+	if err != nil {
+		return nil, err
+	}
+	state.lock.Lock()
+	state.gameId = gameId
+	state.result = copyGameInfoResult(resp)
+	state.lock.Unlock()
+	return resp, nil
+}
+
+func (state *gameState) invalidate() {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.result = nil
+}
+
+// Apply a change from an event. If change returns false, the event didn't match what we know, so
+// the next caller will ask the server.
+func (state *gameState) update(gameId *int, change func(*pyx.GameInfoResult) bool) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.result == nil || gameId == nil || *gameId != state.gameId {
+		return
+	}
+	if !change(state.result) {
+		log.Debugf("Cached info for game %d is out of date", state.gameId)
+		state.result = nil
+	}
+}
+
+func (state *gameState) playerJoined(gameId *int, nick string, spectator bool) {
+	state.update(gameId, func(result *pyx.GameInfoResult) bool {
+		if spectator {
+			result.GameInfo.Spectators = append(result.GameInfo.Spectators, nick)
+			return true
+		}
+		result.GameInfo.Players = append(result.GameInfo.Players, nick)
+		result.PlayerInfo = append(result.PlayerInfo,
+			pyx.GamePlayerInfo{Name: nick, Status: pyx.GamePlayerStatus_IDLE})
+		return true
+	})
+}
+
+func (state *gameState) playerLeft(gameId *int, nick string) {
+	state.update(gameId, func(result *pyx.GameInfoResult) bool {
+		if nick == result.GameInfo.Host {
+			// the server picks a new host, and doesn't tell us who
+			return false
+		}
+		result.GameInfo.Players = removeNick(result.GameInfo.Players, nick)
+		result.GameInfo.Spectators = removeNick(result.GameInfo.Spectators, nick)
+		info := []pyx.GamePlayerInfo{}
+		for _, player := range result.PlayerInfo {
+			if player.Name != nick {
+				info = append(info, player)
+			}
+		}
+		result.PlayerInfo = info
+		return true
+	})
+}
+
+func (state *gameState) stateChanged(gameId *int, gameState string) {
+	state.update(gameId, func(result *pyx.GameInfoResult) bool {
+		result.GameInfo.State = gameState
+		return true
+	})
+}
+
+// Everything but the players' status comes with the event.
+func (state *gameState) optionsChanged(gameId *int, info pyx.GameInfo) {
+	state.update(gameId, func(result *pyx.GameInfoResult) bool {
+		result.GameInfo = info
+		result.GameInfo.Players = append([]string{}, info.Players...)
+		result.GameInfo.Spectators = append([]string{}, info.Spectators...)
+		result.GameInfo.GameOptions.CardSets = append([]int{}, info.GameOptions.CardSets...)
+		return true
+	})
+}
+
+func (state *gameState) playerInfoChanged(gameId *int, info pyx.GamePlayerInfo) {
+	state.update(gameId, func(result *pyx.GameInfoResult) bool {
+		for i := range result.PlayerInfo {
+			if result.PlayerInfo[i].Name == info.Name {
+				result.PlayerInfo[i] = info
+				return true
+			}
+		}
+		// someone we didn't know was in the game
+		return false
+	})
+}
+
+// The judge for the current round. The server tells us about the new judge separately from the
+// round starting, so if we don't have one yet, ask.
+func (client *Client) gameJudge() (*pyx.GameInfoResult, string, error) {
+	resp, err := client.gameInfo()
+	if err != nil {
+		return nil, "", err
+	}
+	if judge := findJudge(resp.PlayerInfo); judge != "" {
+		return resp, judge, nil
+	}
+	client.gameCache.invalidate()
+	resp, err = client.gameInfo()
+	if err != nil {
+		return nil, "", err
+	}
+	return resp, getJudge(&resp.PlayerInfo), nil
+}
+
+func removeNick(nicks []string, nick string) []string {
+	ret := []string{}
+	for _, n := range nicks {
+		if n != nick {
+			ret = append(ret, n)
+		}
+	}
+	return ret
+}
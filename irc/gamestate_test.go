@@ -0,0 +1,144 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"reflect"
+	"testing"
+)
+
+func newTestGameState() *gameState {
+	return &gameState{
+		gameId: 1,
+		result: &pyx.GameInfoResult{
+			GameInfo: pyx.GameInfo{
+				Id:      1,
+				Host:    "alice",
+				Players: []string{"alice", "bob"},
+				State:   pyx.GameState_LOBBY,
+			},
+			PlayerInfo: []pyx.GamePlayerInfo{
+				{Name: "alice", Status: pyx.GamePlayerStatus_HOST},
+				{Name: "bob", Status: pyx.GamePlayerStatus_IDLE},
+			},
+		},
+	}
+}
+
+func TestGameStateUpdates(t *testing.T) {
+	gameId := 1
+	state := newTestGameState()
+	state.playerJoined(&gameId, "carol", false)
+	state.playerJoined(&gameId, "dave", true)
+	state.playerLeft(&gameId, "bob")
+	state.stateChanged(&gameId, pyx.GameState_PLAYING)
+	state.playerInfoChanged(&gameId,
+		pyx.GamePlayerInfo{Name: "carol", Status: pyx.GamePlayerStatus_JUDGE, Score: 2})
+
+	info := state.result.GameInfo
+	if !reflect.DeepEqual(info.Players, []string{"alice", "carol"}) ||
+		!reflect.DeepEqual(info.Spectators, []string{"dave"}) || info.State != pyx.GameState_PLAYING {
+		t.Error("For", "updates", "expected", "alice and carol playing, dave watching", "got", info)
+	}
+	if judge := findJudge(state.result.PlayerInfo); judge != "carol" {
+		t.Error("For", "judge", "expected", "carol", "got", judge)
+	}
+
+	// other games don't count
+	otherGame := 2
+	state.playerJoined(&otherGame, "erin", false)
+	if len(state.result.GameInfo.Players) != 2 {
+		t.Error("For", "other game", "expected", 2, "got", len(state.result.GameInfo.Players))
+	}
+}
+
+type gameStateInvalidateTestPair struct {
+	name   string
+	change func(*gameState, *int)
+}
+
+var gameStateInvalidateTests = []gameStateInvalidateTestPair{
+	{"host left", func(state *gameState, gameId *int) { state.playerLeft(gameId, "alice") }},
+	{"unknown player", func(state *gameState, gameId *int) {
+		state.playerInfoChanged(gameId, pyx.GamePlayerInfo{Name: "zed"})
+	}},
+	{"reconnected", func(state *gameState, gameId *int) { state.invalidate() }},
+}
+
+func TestGameStateInvalidates(t *testing.T) {
+	gameId := 1
+	for _, pair := range gameStateInvalidateTests {
+		state := newTestGameState()
+		pair.change(state, &gameId)
+		if state.result != nil {
+			t.Error("For", pair.name, "expected", nil, "got", state.result)
+		}
+	}
+}
+
+func TestGameStateOptionsChanged(t *testing.T) {
+	gameId := 1
+	state := newTestGameState()
+	info := state.result.GameInfo
+	info.HasPassword = true
+	info.GameOptions.ScoreLimit = 10
+	state.optionsChanged(&gameId, info)
+	if !state.result.GameInfo.HasPassword || state.result.GameInfo.GameOptions.ScoreLimit != 10 ||
+		len(state.result.PlayerInfo) != 2 {
+		t.Error("For", "options", "expected", "password, score limit 10 and 2 players", "got",
+			state.result)
+	}
+}
+
+type gameModeChangesTestPair struct {
+	before pyx.GameInfo
+	after  pyx.GameInfo
+	modes  string
+	params []string
+}
+
+var gameModeChangesTests = []gameModeChangesTestPair{
+	{pyx.GameInfo{}, pyx.GameInfo{}, "", []string{}},
+	{pyx.GameInfo{}, pyx.GameInfo{HasPassword: true}, "+k", []string{"*"}},
+	{pyx.GameInfo{}, pyx.GameInfo{HasPassword: true,
+		GameOptions: pyx.GameOptionData{Password: "hunter2"}}, "+k", []string{"hunter2"}},
+	{pyx.GameInfo{HasPassword: true}, pyx.GameInfo{GameOptions: pyx.GameOptionData{
+		PlayerLimit: 6, SpectatorLimit: 0}}, "-k+l", []string{"7"}},
+	{pyx.GameInfo{GameOptions: pyx.GameOptionData{PlayerLimit: 6, SpectatorLimit: 4}},
+		pyx.GameInfo{GameOptions: pyx.GameOptionData{PlayerLimit: 8, SpectatorLimit: 10}}, "+lL",
+		[]string{"9", "11"}},
+}
+
+func TestGameModeChanges(t *testing.T) {
+	for _, pair := range gameModeChangesTests {
+		modes, params := gameModeChanges(pair.before, pair.after)
+		if modes != pair.modes || !reflect.DeepEqual(params, pair.params) {
+			t.Error("For", pair.before, pair.after,
+				"expected", pair.modes, pair.params,
+				"got", modes, params,
+			)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+// NAMES and WHO for game channels, including ones we aren't in so people can see who is in a game
+// before joining it
+
+package irc
+
+import (
+	"errors"
+	"github.com/ajanata/pyx-irc/pyx"
+)
+
+var errNoSuchGame = errors.New("No such game")
+
+// Who is in a game. Our own game comes from its info, and any other game from the game list,
+// which is public.
+func (client *Client) gameMembers(gameId int) (*pyx.GameInfo, error) {
+	if client.inGame(gameId) {
+		resp, err := client.gameInfo()
+		if err != nil {
+			return nil, err
+		}
+		return &resp.GameInfo, nil
+	}
+	games, err := client.pyx().GameList()
+	if err != nil {
+		return nil, err
+	}
+	if info := findGame(games.Games, gameId); info != nil {
+		return info, nil
+	}
+	return nil, errNoSuchGame
+}
+
+// NAMES for a game we aren't in. Games with a password are shown as secret.
+func (client *Client) otherGameNames(channel string, gameId int) {
+	info, err := client.gameMembers(gameId)
+	if err == errNoSuchGame {
+		client.data.push(client.n.format(ErrNoSuchChannel, client.nick, "%s :No such channel",
+			channel))
+		return
+	} else if err != nil {
+		client.data.push(client.n.format(ErrServiceConfused, client.nick,
+			"%s :Cannot retrieve names: %s", channel, err))
+		return
+	}
+	symbol := "="
+	if info.HasPassword {
+		symbol = "@"
+	}
+	names := []string{}
+	for _, player := range info.Players {
+		if player == info.Host {
+			names = append(names, "@"+player)
+		} else {
+			names = append(names, "+"+player)
+		}
+	}
+	names = append(names, info.Spectators...)
+	// TODO a proper length based on 512 minus broilerplate
+	for _, line := range joinIntoLines(300, names, " ") {
+		client.data.push(client.n.format(RplNames, client.nick, "%s %s :%s", symbol, channel,
+			line))
+	}
+	client.data.push(client.n.format(RplEndNames, client.nick, "%s :End of /NAMES list",
+		channel))
+}
+
+// WHO for any game channel. Channels of games with a password that we aren't in are shown as *,
+// like secret channels.
+func (client *Client) gameWho(channel string, gameId int) {
+	info, err := client.gameMembers(gameId)
+	if err == errNoSuchGame {
+		client.data.push(client.n.format(ErrNoSuchChannel, client.nick, "%s :No such channel",
+			channel))
+		return
+	} else if err != nil {
+		client.data.push(client.n.format(ErrServiceConfused, client.nick,
+			"%s :Cannot retrieve who: %s", channel, err))
+		return
+	}
+	inGame := client.inGame(gameId)
+	shown := channel
+	if info.HasPassword && !inGame {
+		shown = "*"
+	}
+	if inGame {
+		client.data.push(client.n.format(RplWho, client.nick, "%s %s %s %s %s HrB& :0 %s",
+			shown, client.config.BotUsername, client.config.AdvertisedName,
+			client.config.AdvertisedName, client.config.BotNick, client.config.BotNick))
+	}
+	whoLine := func(nick string, prefix string) {
+		flags := "H"
+		if client.isAway(nick) {
+			flags = "G"
+		}
+		switch client.sigils.get(client.config.fold(nick)) {
+		case pyx.Sigil_ADMIN, pyx.Sigil_ID_CODE:
+			flags = flags + "r"
+		}
+		client.data.push(client.n.format(RplWho, client.nick, "%s %s %s %s %s %s :0 %s",
+			shown, getUser(nick), client.getHost(nick), client.config.AdvertisedName, nick,
+			flags+prefix, client.roster.realname(nick)))
+	}
+	for _, player := range info.Players {
+		if player == info.Host {
+			whoLine(player, "@")
+		} else {
+			whoLine(player, "+")
+		}
+	}
+	for _, spectator := range info.Spectators {
+		whoLine(spectator, "")
+	}
+	client.data.push(client.n.format(RplEndOfWho, client.nick, "%s :End of /WHO list", channel))
+}
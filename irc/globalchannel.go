@@ -0,0 +1,84 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// What people can do to the global channel just for themselves: leave it without leaving PYX, and
+// mute its chat
+
+package irc
+
+import (
+	"sync/atomic"
+)
+
+// +m is the only mode anyone can change here, and it only affects what they see, so it's saved
+// with their preferences.
+func (client *Client) changeGlobalModes(modeStr string) {
+	adding := true
+	mute := client.prefs.MuteGlobal
+	for _, mode := range modeStr {
+		switch mode {
+		case '+':
+			adding = true
+		case '-':
+			adding = false
+		case 'm':
+			mute = adding
+		default:
+			client.data.push(client.n.format(ErrChanOpPrivsNeeded, client.nick,
+				"MODE :You can't do that."))
+			return
+		}
+	}
+	if mute == client.prefs.MuteGlobal {
+		return
+	}
+	client.prefs.MuteGlobal = mute
+	if err := client.savePreferences(); err != nil {
+		log.Errorf("Unable to save preferences for %s: %v", client.nick, err)
+	}
+	change := "-m"
+	if mute {
+		change = "+m"
+	}
+	client.data.push(newLine(client.getNickUserAtHost(client.nick), "MODE").
+		param(client.config.GlobalChannel, change).String())
+}
+
+// Returns false if they had already left it.
+func (client *Client) partGlobalChannel() bool {
+	return atomic.CompareAndSwapInt32(&client.partedGlobal, 0, 1)
+}
+
+// Returns false if they never left it.
+func (client *Client) rejoinGlobalChannel() bool {
+	if !atomic.CompareAndSwapInt32(&client.partedGlobal, 1, 0) {
+		return false
+	}
+	client.joinChannel(client.config.GlobalChannel)
+	return true
+}
+
+// Whether to relay global chat, joins, quits and mode changes to them.
+func (client *Client) inGlobalChannel() bool {
+	return atomic.LoadInt32(&client.partedGlobal) == 0
+}
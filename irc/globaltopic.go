@@ -0,0 +1,122 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// The global channel's topic, which doubles as a status line for the PYX server
+
+package irc
+
+import (
+	"fmt"
+	"github.com/ajanata/pyx-irc/pyx"
+	"sync"
+	"time"
+)
+
+// What the global channel's topic was when we last showed it to them.
+type globalTopic struct {
+	lock sync.Mutex
+	text string
+	// when we last worked out what it should be, so events don't do it constantly
+	checked time.Time
+}
+
+// 0 if the topic shouldn't have the counts in it at all.
+func (config *Config) globalTopicInterval() time.Duration {
+	if config.GlobalTopicSeconds < 0 {
+		return 0
+	}
+	return time.Duration(config.GlobalTopicSeconds) * time.Second
+}
+
+func (client *Client) makeGlobalTopic() string {
+	topic := "Global chat"
+	if !client.pyx().GlobalChatEnabled {
+		topic = topic + " (disabled)"
+	}
+	if client.config.globalTopicInterval() == 0 {
+		return topic
+	}
+	counts, err := client.roster.counts(client.pyx())
+	if err != nil {
+		log.Errorf("Unable to retrieve user list for the %s topic: %v",
+			client.config.GlobalChannel, err)
+		return topic
+	}
+	games, err := client.pyx().GameList()
+	if err != nil {
+		log.Errorf("Unable to retrieve game list for the %s topic: %v",
+			client.config.GlobalChannel, err)
+		return topic
+	}
+	inProgress := 0
+	for _, game := range games.Games {
+		if game.State != pyx.GameState_LOBBY {
+			inProgress++
+		}
+	}
+	return fmt.Sprintf("%s | %d users online, %d games (%d in progress)", topic, counts.Global,
+		len(games.Games), inProgress)
+}
+
+// The topic as of right now, which is what later changes are compared against.
+func (client *Client) currentGlobalTopic() string {
+	topic := client.makeGlobalTopic()
+	client.globalTopic.lock.Lock()
+	defer client.globalTopic.lock.Unlock()
+	client.globalTopic.text = topic
+	client.globalTopic.checked = time.Now()
+	return topic
+}
+
+// Show them the new topic if anything in it changed. This happens on a timer, and also when PYX
+// tells us about something that might change it, as long as we haven't checked too recently, so
+// people coming and going on a busy server don't change it every few seconds.
+func (client *Client) refreshGlobalTopic(timer bool) {
+	interval := client.config.globalTopicInterval()
+	if interval == 0 || !client.inGlobalChannel() {
+		return
+	}
+	client.globalTopic.lock.Lock()
+	recent := time.Since(client.globalTopic.checked) < interval
+	client.globalTopic.lock.Unlock()
+	if recent && !timer {
+		return
+	}
+	if timer {
+		// this is the only thing that tells us global chat was turned on or off
+		if err := client.pyx().RefreshServerConfig(); err != nil {
+			log.Warningf("Unable to refresh server configuration for %s: %v", client.nick, err)
+		}
+	}
+
+	topic := client.makeGlobalTopic()
+	client.globalTopic.lock.Lock()
+	changed := topic != client.globalTopic.text
+	client.globalTopic.text = topic
+	client.globalTopic.checked = time.Now()
+	client.globalTopic.lock.Unlock()
+	if changed {
+		client.data.push(newLine(client.botNickUserAtHost(), "TOPIC").
+			param(client.config.GlobalChannel).text(topic).String())
+	}
+}
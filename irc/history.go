@@ -0,0 +1,253 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Recent chat from each PYX server, so people who drop off can catch up on what they missed,
+// either with znc.in/playback or by asking the bot.
+
+package irc
+
+import (
+	"fmt"
+	"github.com/ajanata/pyx-irc/pyx"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every client on the server sees the same chat, so only one copy is kept.
+const historyDuplicateWindow = 5 * time.Second
+
+// the bot's PLAYBACK goes this far back if they don't say
+const DefaultPlaybackMinutes = 30
+
+// who playback replies come from, like ZNC's module
+const PlaybackNick = "*playback"
+
+type historyEntry struct {
+	at time.Time
+	// from PYX, to tell duplicates apart
+	timestamp int64
+	// 0 for global chat
+	gameId int
+	from   string
+	text   string
+	emote  bool
+}
+
+type chatHistory struct {
+	lock    sync.Mutex
+	entries []historyEntry
+}
+
+var historiesLock sync.Mutex
+
+// by PYX server, like the roster
+var histories = make(map[string]*chatHistory)
+
+func getChatHistory(config *pyx.Config) *chatHistory {
+	historiesLock.Lock()
+	defer historiesLock.Unlock()
+	h, ok := histories[config.BaseAddress]
+	if !ok {
+		h = &chatHistory{}
+		histories[config.BaseAddress] = h
+	}
+	return h
+}
+
+// Remember a chat message, unless another client already did. Newest entries are at the end.
+func (h *chatHistory) add(event *pyx.ChatEvent, size int) {
+	if size <= 0 {
+		return
+	}
+	entry := historyEntry{
+		at:        time.Now(),
+		timestamp: event.Timestamp,
+		from:      event.From,
+		text:      event.Message,
+		emote:     event.Emote,
+	}
+	if event.GameId != nil {
+		entry.gameId = *event.GameId
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		existing := h.entries[i]
+		if entry.at.Sub(existing.at) > historyDuplicateWindow {
+			break
+		}
+		if existing.timestamp == entry.timestamp && existing.gameId == entry.gameId &&
+			existing.from == entry.from && existing.text == entry.text {
+			return
+		}
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > size {
+		h.entries = h.entries[len(h.entries)-size:]
+	}
+}
+
+// Entries after since and before until, oldest first. A zero until means up to now.
+func (h *chatHistory) between(since time.Time, until time.Time) []historyEntry {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	found := []historyEntry{}
+	for _, entry := range h.entries {
+		if entry.at.After(since) && (until.IsZero() || entry.at.Before(until)) {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+// The channel the entry would be shown in for this client, or "" if they aren't in it.
+func (client *Client) historyChannel(entry historyEntry) string {
+	if entry.gameId == 0 {
+		return client.config.GlobalChannel
+	}
+	if client.inGame(entry.gameId) {
+		return client.getGameChannel()
+	}
+	return ""
+}
+
+// Send them the history since then for the channels that match the masks. Returns how many lines
+// were sent.
+func (client *Client) playHistory(masks []string, since time.Time, until time.Time) int {
+	count := 0
+	for _, entry := range getChatHistory(client.pyxConfig).between(since, until) {
+		channel := client.historyChannel(entry)
+		if channel == "" || client.isSilenced(entry.from) {
+			continue
+		}
+		matched := false
+		for _, mask := range masks {
+			matched = matched || matchMask(mask, channel)
+		}
+		if !matched {
+			continue
+		}
+		text := entry.text
+		if entry.emote {
+			text = makeEmote(text)
+		}
+		prefix := ""
+		if client.hasCap("server-time") {
+			prefix = "@time=" + entry.at.UTC().Format("2006-01-02T15:04:05.000Z") + " "
+		} else if entry.emote {
+			// can't put anything in front of the ACTION
+			text = makeEmote(fmt.Sprintf("[%s] %s", entry.at.Format("15:04:05"), entry.text))
+		} else {
+			text = fmt.Sprintf("[%s] %s", entry.at.Format("15:04:05"), text)
+		}
+		client.data.push(fmt.Sprintf("%s:%s PRIVMSG %s :%s", prefix,
+			client.getNickUserAtHost(entry.from), channel, text))
+		count++
+	}
+	return count
+}
+
+// znc.in/playback timestamps are seconds since the epoch, possibly with a fraction.
+func parsePlaybackTime(s string) (time.Time, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), nil
+}
+
+func (client *Client) playbackReply(format string, args ...interface{}) {
+	client.data.push(fmt.Sprintf(":%s!znc@znc.in PRIVMSG %s :%s", PlaybackNick, client.nick,
+		fmt.Sprintf(format, args...)))
+}
+
+// PRIVMSG *playback :PLAY <channels> <from> [<to>]
+func (client *Client) handlePlaybackPrivmsg(text string) {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		client.playbackReply("Try PLAY <channels> <from> [<to>], or LIST.")
+		return
+	}
+	switch strings.ToUpper(words[0]) {
+	case "PLAY":
+		if len(words) < 3 {
+			client.playbackReply("Usage: PLAY <channels> <from> [<to>]")
+			return
+		}
+		since, err := parsePlaybackTime(words[2])
+		if err != nil {
+			client.playbackReply("Invalid timestamp %s", words[2])
+			return
+		}
+		var until time.Time
+		if len(words) > 3 {
+			if until, err = parsePlaybackTime(words[3]); err != nil {
+				client.playbackReply("Invalid timestamp %s", words[3])
+				return
+			}
+		}
+		client.playHistory(strings.Split(words[1], ","), since, until)
+	case "LIST":
+		entries := getChatHistory(client.pyxConfig).between(time.Time{}, time.Time{})
+		first := make(map[string]time.Time)
+		last := make(map[string]time.Time)
+		channels := []string{}
+		for _, entry := range entries {
+			channel := client.historyChannel(entry)
+			if channel == "" {
+				continue
+			}
+			if _, ok := first[channel]; !ok {
+				first[channel] = entry.at
+				channels = append(channels, channel)
+			}
+			last[channel] = entry.at
+		}
+		for _, channel := range channels {
+			client.playbackReply("%s %d %d", channel, first[channel].Unix(), last[channel].Unix())
+		}
+	case "CLEAR":
+		// nothing to do, everyone shares the same history
+	default:
+		client.playbackReply("Unknown command %s.", words[0])
+	}
+}
+
+// PLAYBACK [minutes]
+func botPlayback(client *Client, reply BotReplyFunc, args []string) {
+	minutes := DefaultPlaybackMinutes
+	if len(args) > 0 {
+		var err error
+		minutes, err = strconv.Atoi(args[0])
+		if err != nil || minutes <= 0 {
+			reply("Usage: PLAYBACK [minutes]")
+			return
+		}
+	}
+	since := time.Now().Add(-time.Duration(minutes) * time.Minute)
+	if client.playHistory([]string{"*"}, since, time.Time{}) == 0 {
+		reply("Nothing has been said in the last %d minutes.", minutes)
+	}
+}
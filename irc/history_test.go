@@ -0,0 +1,84 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"testing"
+	"time"
+)
+
+func TestChatHistoryAdd(t *testing.T) {
+	history := &chatHistory{}
+	gameId := 3
+	events := []*pyx.ChatEvent{
+		{EventHeader: pyx.EventHeader{Timestamp: 1}, From: "alice", Message: "hi"},
+		// the same message seen by another client
+		{EventHeader: pyx.EventHeader{Timestamp: 1}, From: "alice", Message: "hi"},
+		{EventHeader: pyx.EventHeader{Timestamp: 1}, From: "alice", Message: "hi", GameId: &gameId},
+		{EventHeader: pyx.EventHeader{Timestamp: 2}, From: "bob", Message: "hello"},
+		{EventHeader: pyx.EventHeader{Timestamp: 3}, From: "bob", Message: "again"},
+	}
+	for _, event := range events {
+		history.add(event, 3)
+	}
+	entries := history.between(time.Time{}, time.Time{})
+	expected := []string{"hi", "hello", "again"}
+	if len(entries) != len(expected) {
+		t.Fatal("expected", len(expected), "entries, got", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.text != expected[i] {
+			t.Error("For", i, "expected", expected[i], "got", entry.text)
+		}
+	}
+	if entries[0].gameId != gameId {
+		t.Error("For 0 expected game", gameId, "got", entries[0].gameId)
+	}
+}
+
+type playbackTimeTestPair struct {
+	s     string
+	nanos int64
+	ok    bool
+}
+
+var playbackTimeTests = []playbackTimeTestPair{
+	{"0", 0, true},
+	{"1546300800", 1546300800000000000, true},
+	{"1546300800.5", 1546300800500000000, true},
+	{"yesterday", 0, false},
+}
+
+func TestParsePlaybackTime(t *testing.T) {
+	for _, pair := range playbackTimeTests {
+		parsed, err := parsePlaybackTime(pair.s)
+		if (err == nil) != pair.ok || (err == nil && parsed.UnixNano() != pair.nanos) {
+			t.Error("For", pair.s,
+				"expected", pair.nanos, pair.ok,
+				"got", parsed.UnixNano(), err,
+			)
+		}
+	}
+}
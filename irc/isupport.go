@@ -0,0 +1,134 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// RPL_ISUPPORT, built from the configuration so it can't disagree with it
+
+package irc
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// what most servers do, which keeps the lines well under the length limit
+const MaxISupportTokensPerLine = 13
+
+// we generate the topics, and they're never longer than this
+const MaxTopicLength = 307
+
+// The longest name a game channel can have.
+func gameChannelLength(prefix string) int {
+	return len(prefix) + len(strconv.Itoa(math.MaxInt32))
+}
+
+// How many channels of each type a user can be in: the global channel, one game channel, the
+// games they're watching, and the admin channel.
+func (client *Client) channelLimits() (string, []string, int) {
+	var types []string
+	limits := make(map[string]int)
+	add := func(channel string, count int) {
+		if channel == "" {
+			return
+		}
+		t := channel[:1]
+		if _, ok := limits[t]; !ok {
+			types = append(types, t)
+		}
+		limits[t] += count
+	}
+	add(client.config.GlobalChannel, 1)
+	add(client.config.GameChannelPrefix, 1)
+	// plus the games they're only watching
+	watching := client.config.watchLimit()
+	// counted twice in the total if it's a different type
+	overlap := 0
+	if client.config.SpectateGameChannelPrefix[:1] != client.config.GameChannelPrefix[:1] {
+		// only one game at a time, but it could be either type
+		watching++
+		overlap = 1
+	}
+	add(client.config.SpectateGameChannelPrefix, watching)
+	add(client.config.AdminChannel, 1)
+
+	total := -overlap
+	var chanlimit []string
+	for _, t := range types {
+		if limits[t] > 0 {
+			chanlimit = append(chanlimit, fmt.Sprintf("%s:%d", t, limits[t]))
+		}
+		total += limits[t]
+	}
+	return strings.Join(types, ""), chanlimit, total
+}
+
+func (client *Client) isupportTokens() []string {
+	chantypes, chanlimit, maxChannels := client.channelLimits()
+	channelLen := len(client.config.GlobalChannel)
+	for _, length := range []int{len(client.config.AdminChannel),
+		gameChannelLength(client.config.GameChannelPrefix),
+		gameChannelLength(client.config.SpectateGameChannelPrefix)} {
+		if length > channelLen {
+			channelLen = length
+		}
+	}
+
+	tokens := []string{
+		"MAXCHANNELS=" + strconv.Itoa(maxChannels),
+		"CHANLIMIT=" + strings.Join(chanlimit, ","),
+	}
+	if nickLen := client.pyxConfig.MaxNickLength(); nickLen > 0 {
+		tokens = append(tokens, "NICKLEN="+strconv.Itoa(nickLen))
+	}
+	return append(tokens,
+		"CHANNELLEN="+strconv.Itoa(channelLen),
+		"TOPICLEN="+strconv.Itoa(MaxTopicLength),
+		"NAMELEN="+strconv.Itoa(MaxRealnameLength),
+		"AWAYLEN=0",
+		"MAXTARGETS=1",
+		"MODES=1",
+		"CHANTYPES="+chantypes,
+		"PREFIX=(aov)&@+",
+		"CHANMODES=,k,lL,voantk",
+		"NETWORK="+client.config.NetworkName,
+		"CASEMAPPING="+client.config.CaseMapping,
+		"SILENCE="+strconv.Itoa(MaxSilenceEntries),
+		"MONITOR="+strconv.Itoa(MaxMonitorEntries),
+		"KNOCK",
+		"SAFELIST",
+	)
+}
+
+func (client *Client) sendISupport() {
+	tokens := client.isupportTokens()
+	for len(tokens) > 0 {
+		count := len(tokens)
+		if count > MaxISupportTokensPerLine {
+			count = MaxISupportTokensPerLine
+		}
+		client.data.push(client.n.format(RplISupport, client.nick,
+			"%s :are supported by this server", strings.Join(tokens[:count], " ")))
+		tokens = tokens[count:]
+	}
+}
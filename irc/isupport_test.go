@@ -0,0 +1,87 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+type isupportTestPair struct {
+	configure func(config *Config)
+	token     string
+}
+
+var isupportTests = []isupportTestPair{
+	{func(config *Config) {}, "CHANTYPES=#&"},
+	{func(config *Config) {}, "CHANLIMIT=#:5,&:1"},
+	{func(config *Config) {}, "MAXCHANNELS=6"},
+	{func(config *Config) { config.MaxWatchedGames = -1 }, "CHANLIMIT=#:2,&:1"},
+	{func(config *Config) { config.MaxWatchedGames = -1 }, "MAXCHANNELS=3"},
+	{func(config *Config) { config.SpectateGameChannelPrefix = "!w" },
+		"CHANLIMIT=#:2,!:4,&:1"},
+	{func(config *Config) { config.SpectateGameChannelPrefix = "!w" }, "MAXCHANNELS=6"},
+	{func(config *Config) {}, "NICKLEN=30"},
+	{func(config *Config) {}, "CHANNELLEN=17"},
+	{func(config *Config) { config.NetworkName = "Xyzzy" }, "NETWORK=Xyzzy"},
+	{func(config *Config) { config.AdminChannel = "#bridge" }, "CHANLIMIT=#:6"},
+	{func(config *Config) { config.GameChannelPrefix = "!g" }, "CHANTYPES=#!&"},
+	{func(config *Config) { config.Pyx.NickPattern = "[a-z]{1,5}_?" }, "NICKLEN=6"},
+	{func(config *Config) { config.Pyx.NickPattern = "[a-z]+" }, "MONITOR=100"},
+	{func(config *Config) { config.CaseMapping = CaseMapping_RFC1459 }, "CASEMAPPING=rfc1459"},
+}
+
+func TestISupportTokens(t *testing.T) {
+	for _, pair := range isupportTests {
+		config := &Config{}
+		pair.configure(config)
+		config.EnsureDefaults()
+		client := &Client{config: config, pyxConfig: &config.Pyx}
+		tokens := client.isupportTokens()
+		found := false
+		for _, token := range tokens {
+			if token == pair.token {
+				found = true
+			}
+		}
+		if !found {
+			t.Error("For", pair.token,
+				"expected", pair.token,
+				"got", strings.Join(tokens, " "),
+			)
+		}
+	}
+}
+
+func TestISupportNoNickLength(t *testing.T) {
+	config := &Config{}
+	config.Pyx.NickPattern = "[a-z]+"
+	config.EnsureDefaults()
+	client := &Client{config: config, pyxConfig: &config.Pyx}
+	for _, token := range client.isupportTokens() {
+		if strings.HasPrefix(token, "NICKLEN=") {
+			t.Error("For unlimited nicks expected no NICKLEN got", token)
+		}
+	}
+}
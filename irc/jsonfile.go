@@ -0,0 +1,81 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// JSON files for what we keep across restarts
+
+package irc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// A JSON file that's read the first time it's needed and rewritten on every change. If it's there
+// but can't be read, nothing gets written to it until it can, so one bad read doesn't replace
+// everything in it with whatever we happened to have in memory. The owner has to do the locking.
+type jsonFile struct {
+	// empty to only keep things in memory
+	path   string
+	loaded bool
+}
+
+// Reads the file into v, the first time it works. A missing file just means there's nothing saved
+// yet.
+func (file *jsonFile) load(v interface{}) error {
+	if file.loaded || file.path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("%s is corrupt: %v", file.path, err)
+		}
+	}
+	file.loaded = true
+	return nil
+}
+
+// Writes v out to a new file and moves it into place, so a crash partway through doesn't leave
+// half of one behind.
+func (file *jsonFile) save(v interface{}) error {
+	if file.path == "" {
+		return nil
+	}
+	if !file.loaded {
+		return fmt.Errorf("%s could not be loaded, so it was not changed", file.path)
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp := file.path + ".tmp"
+	if err = ioutil.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, file.path)
+}
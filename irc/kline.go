@@ -0,0 +1,248 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Bridge-level bans, for people who are abusing the bridge itself
+
+package irc
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Kline struct {
+	// an IP address, a CIDR range, or a nick with * and ? wildcards
+	Mask   string    `json:"mask"`
+	Reason string    `json:"reason"`
+	SetBy  string    `json:"set_by"`
+	Set    time.Time `json:"set"`
+}
+
+// The bans for one file, or kept only in memory if there isn't one.
+type klineList struct {
+	lock   sync.Mutex
+	file   jsonFile
+	klines []Kline
+}
+
+var klineListsLock sync.Mutex
+
+// by file, since servers may share one
+var klineLists = make(map[string]*klineList)
+
+func getKlines(config *Config) *klineList {
+	klineListsLock.Lock()
+	defer klineListsLock.Unlock()
+	list, ok := klineLists[config.KlineFile]
+	if !ok {
+		list = newKlineList(config.KlineFile)
+		klineLists[config.KlineFile] = list
+	}
+	return list
+}
+
+func newKlineList(path string) *klineList {
+	return &klineList{file: jsonFile{path: path}}
+}
+
+// Must be called with the lock held.
+func (list *klineList) load() error {
+	err := list.file.load(&list.klines)
+	if err != nil {
+		log.Errorf("Unable to load K-lines: %v", err)
+	}
+	return err
+}
+
+// Returns the ban that applies to someone with this nick connecting from addr, if there is one.
+func (list *klineList) find(nick string, addr string) *Kline {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	list.load()
+	for _, kline := range list.klines {
+		if kline.matches(nick, addr) {
+			found := kline
+			return &found
+		}
+	}
+	return nil
+}
+
+// Returns false if the bans we already have couldn't be loaded, so it wasn't added. Otherwise it's
+// in effect, even if it couldn't be saved.
+func (list *klineList) add(kline Kline) (bool, error) {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	if err := list.load(); err != nil {
+		return false, err
+	}
+	for i := range list.klines {
+		if strEqCI(list.klines[i].Mask, kline.Mask) {
+			list.klines[i] = kline
+			return true, list.file.save(list.klines)
+		}
+	}
+	list.klines = append(list.klines, kline)
+	return true, list.file.save(list.klines)
+}
+
+// Returns false if there was no such ban, or with an error if the bans couldn't be loaded.
+func (list *klineList) remove(mask string) (bool, error) {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	if err := list.load(); err != nil {
+		return false, err
+	}
+	for i := range list.klines {
+		if strEqCI(list.klines[i].Mask, mask) {
+			list.klines = append(list.klines[:i], list.klines[i+1:]...)
+			return true, list.file.save(list.klines)
+		}
+	}
+	return false, nil
+}
+
+func (list *klineList) all() []Kline {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	list.load()
+	return append([]Kline{}, list.klines...)
+}
+
+func (kline *Kline) matches(nick string, addr string) bool {
+	if _, network, err := net.ParseCIDR(kline.Mask); err == nil {
+		ip := net.ParseIP(addr)
+		return ip != nil && network.Contains(ip)
+	}
+	if ip := net.ParseIP(kline.Mask); ip != nil {
+		return ip.Equal(net.ParseIP(addr))
+	}
+	return matchMask(kline.Mask, nick)
+}
+
+// Case-insensitive match of s against a mask where * matches anything and ? matches one character.
+// Everything else is literal, since nicks can have [ and ] in them.
+func matchMask(mask string, s string) bool {
+	mask = strings.ToLower(mask)
+	s = strings.ToLower(s)
+	// where to go back to if we run into a mismatch after a *
+	star, backtrack := -1, 0
+	m, i := 0, 0
+	for i < len(s) {
+		if m < len(mask) && (mask[m] == '?' || mask[m] == s[i]) {
+			m++
+			i++
+		} else if m < len(mask) && mask[m] == '*' {
+			star = m
+			backtrack = i
+			m++
+		} else if star >= 0 {
+			m = star + 1
+			backtrack++
+			i = backtrack
+		} else {
+			return false
+		}
+	}
+	for m < len(mask) && mask[m] == '*' {
+		m++
+	}
+	return m == len(mask)
+}
+
+func handleKline(client *Client, msg Message) {
+	if !client.pyx().User.IsAdmin() {
+		client.data.push(client.n.formatSimpleReply(ErrNoPrivileges, client.nick,
+			"Permission Denied- You're not a PYX administrator"))
+		return
+	}
+	if len(msg.args) == 0 {
+		client.data.push(client.n.formatSimpleReply(ErrNeedMoreParams, msg.cmd,
+			"Not enough parameters"))
+		return
+	}
+	reason := "No reason given"
+	if len(msg.args) > 1 && msg.args[1] != "" {
+		reason = msg.args[1]
+	}
+	client.kline(msg.args[0], reason, client.sendServerNotice)
+}
+
+func handleUnkline(client *Client, msg Message) {
+	if !client.pyx().User.IsAdmin() {
+		client.data.push(client.n.formatSimpleReply(ErrNoPrivileges, client.nick,
+			"Permission Denied- You're not a PYX administrator"))
+		return
+	}
+	if len(msg.args) == 0 {
+		client.data.push(client.n.formatSimpleReply(ErrNeedMoreParams, msg.cmd,
+			"Not enough parameters"))
+		return
+	}
+	client.unkline(msg.args[0], client.sendServerNotice)
+}
+
+// Ban the mask and disconnect anyone already connected who matches it.
+func (client *Client) kline(mask string, reason string, reply BotReplyFunc) {
+	kline := Kline{Mask: mask, Reason: reason, SetBy: client.nick, Set: time.Now()}
+	added, err := getKlines(client.config).add(kline)
+	if !added {
+		reply("Unable to add K-line for %s: %v", mask, err)
+		return
+	}
+	if err != nil {
+		log.Errorf("Unable to save K-lines: %v", err)
+		reply("K-line for %s added, but couldn't be saved: %v", mask, err)
+	} else {
+		reply("Added K-line for %s.", mask)
+	}
+	log.Infof("%s added a K-line for %s: %s", client.nick, mask, reason)
+	announceToAdmins(SnoKill, "%s added a K-line for %s: %s", client.nick, mask, reason)
+	for _, info := range ListClients() {
+		if info.Registered && kline.matches(info.Nick, info.Ip) {
+			client.kill(info.Nick, "K-lined: "+reason)
+		}
+	}
+}
+
+func (client *Client) unkline(mask string, reply BotReplyFunc) {
+	removed, err := getKlines(client.config).remove(mask)
+	if !removed && err != nil {
+		reply("Unable to remove K-line for %s: %v", mask, err)
+		return
+	}
+	if !removed {
+		reply("There is no K-line for %s.", mask)
+		return
+	}
+	if err != nil {
+		log.Errorf("Unable to save K-lines: %v", err)
+		reply("K-line for %s removed, but couldn't be saved: %v", mask, err)
+	} else {
+		reply("Removed K-line for %s.", mask)
+	}
+	log.Infof("%s removed the K-line for %s", client.nick, mask)
+	announceToAdmins(SnoKill, "%s removed the K-line for %s", client.nick, mask)
+}
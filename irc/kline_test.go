@@ -0,0 +1,135 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type matchMaskTestPair struct {
+	mask  string
+	s     string
+	match bool
+}
+
+var matchMaskTests = []matchMaskTestPair{
+	{"bob", "bob", true},
+	{"bob", "BOB", true},
+	{"bob", "bobby", false},
+	{"bob*", "bobby", true},
+	{"*bob", "bigbob", true},
+	{"*bob*", "abobc", true},
+	{"b?b", "bib", true},
+	{"b?b", "bb", false},
+	{"*", "", true},
+	{"", "", true},
+	{"", "a", false},
+	{"[bob]", "[bob]", true},
+	{"[bob]", "b", false},
+	{"a*b*c", "aXbYbZc", true},
+	{"a*b*c", "aXbYbZ", false},
+}
+
+func TestMatchMask(t *testing.T) {
+	for _, pair := range matchMaskTests {
+		if matchMask(pair.mask, pair.s) != pair.match {
+			t.Error("For", pair.mask, pair.s,
+				"expected", pair.match,
+				"got", !pair.match,
+			)
+		}
+	}
+}
+
+type klineMatchTestPair struct {
+	mask  string
+	nick  string
+	addr  string
+	match bool
+}
+
+var klineMatchTests = []klineMatchTestPair{
+	{"192.0.2.1", "bob", "192.0.2.1", true},
+	{"192.0.2.1", "bob", "192.0.2.2", false},
+	{"192.0.2.0/24", "bob", "192.0.2.200", true},
+	{"192.0.2.0/24", "bob", "198.51.100.1", false},
+	{"2001:db8::/32", "bob", "2001:db8::1", true},
+	{"2001:db8::1", "bob", "2001:0db8:0::1", true},
+	{"spam*", "spammer", "192.0.2.1", true},
+	{"spam*", "bob", "192.0.2.1", false},
+	// nick masks don't match addresses
+	{"192.0.2.*", "bob", "192.0.2.1", false},
+}
+
+func TestKlineMatches(t *testing.T) {
+	for _, pair := range klineMatchTests {
+		kline := Kline{Mask: pair.mask}
+		if kline.matches(pair.nick, pair.addr) != pair.match {
+			t.Error("For", pair.mask, pair.nick, pair.addr,
+				"expected", pair.match,
+				"got", !pair.match,
+			)
+		}
+	}
+}
+
+func TestKlineCorruptFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "klines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "klines.json")
+	corrupt := []byte(`[{"mask": "10.0.0.1", "reason": "spam"`)
+	if err = ioutil.WriteFile(path, corrupt, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	list := newKlineList(path)
+	if added, err := list.add(Kline{Mask: "bob"}); added || err == nil {
+		t.Errorf("For add with a corrupt file, expected an error, got added=%v err=%v", added, err)
+	}
+	if removed, err := list.remove("10.0.0.1"); removed || err == nil {
+		t.Errorf("For remove with a corrupt file, expected an error, got removed=%v err=%v", removed,
+			err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != string(corrupt) {
+		t.Errorf("For a corrupt file, expected it to be left alone, got %s", data)
+	}
+
+	// once it's fixed, the bans in it come back
+	if err = ioutil.WriteFile(path, append(corrupt, '}', ']'), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if added, err := list.add(Kline{Mask: "bob"}); !added || err != nil {
+		t.Fatalf("For add after fixing the file, expected it to work, got added=%v err=%v", added,
+			err)
+	}
+	if kline := newKlineList(path).find("alice", "10.0.0.1"); kline == nil {
+		t.Errorf("For the ban that was already there, expected it to be kept, got nothing")
+	}
+}
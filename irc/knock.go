@@ -0,0 +1,208 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+// KNOCK and INVITE, so people can get into passworded games without asking around for the key
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long someone has to wait between knocks
+const KnockIntervalSeconds = 60
+
+// how long an invite can go unused before it's forgotten
+const InviteExpireMinutes = 10
+
+type pendingInvite struct {
+	gameId   int
+	password string
+	expires  time.Time
+}
+
+// Everyone who can be reached from another connection for knocks, invites, and whispers, and
+// their invites, by PYX server and lowercase nick. Like the admin channel, clients are removed
+// when they unregister.
+var reachable = struct {
+	lock    sync.Mutex
+	clients map[string]*Client
+	invites map[string]pendingInvite
+}{clients: make(map[string]*Client), invites: make(map[string]pendingInvite)}
+
+func reachableKey(pyxConfig *pyx.Config, nick string) string {
+	return pyxConfig.BaseAddress + " " + strings.ToLower(nick)
+}
+
+func (client *Client) setReachable() {
+	reachable.lock.Lock()
+	defer reachable.lock.Unlock()
+	reachable.clients[reachableKey(client.pyxConfig, client.nick)] = client
+}
+
+func (client *Client) clearReachable() {
+	reachable.lock.Lock()
+	defer reachable.lock.Unlock()
+	key := reachableKey(client.pyxConfig, client.nick)
+	if reachable.clients[key] == client {
+		delete(reachable.clients, key)
+		delete(reachable.invites, key)
+	}
+}
+
+// Send a line built for the target to someone else using the bridge, if they are connected. An
+// empty line isn't sent.
+func (client *Client) sendToUser(nick string, format func(target *Client) string) bool {
+	reachable.lock.Lock()
+	defer reachable.lock.Unlock()
+	target, ok := reachable.clients[reachableKey(client.pyxConfig, nick)]
+	if !ok {
+		return false
+	}
+	if line := format(target); line != "" {
+		target.data.push(line)
+	}
+	return true
+}
+
+// Uses up our invite to the game, if there is one, and returns the password that came with it.
+func (client *Client) takeInvite(gameId int) (string, bool) {
+	reachable.lock.Lock()
+	defer reachable.lock.Unlock()
+	key := reachableKey(client.pyxConfig, client.nick)
+	invite, ok := reachable.invites[key]
+	if !ok || invite.gameId != gameId {
+		return "", false
+	}
+	delete(reachable.invites, key)
+	return invite.password, time.Now().Before(invite.expires)
+}
+
+// KNOCK <channel> [message]
+// Asks the host of a passworded game for an invite. PYX doesn't have private messages, so this
+// only works if the host is using the bridge.
+func handleKnock(client *Client, msg Message) {
+	if len(msg.args) == 0 {
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"KNOCK :Not enough parameters"))
+		return
+	}
+	channel := msg.args[0]
+	gameId, _, err := client.getGameFromChannel(channel)
+	if err != nil {
+		client.data.push(client.n.format(ErrNoSuchChannel, client.nick, "%s :No such channel",
+			channel))
+		return
+	}
+	if client.inGame(gameId) {
+		client.data.push(client.n.format(ErrKnockOnChan, client.nick,
+			"%s :You are already on that channel", channel))
+		return
+	}
+	if time.Since(client.lastKnock) < KnockIntervalSeconds*time.Second {
+		client.data.push(client.n.format(ErrTooManyKnock, client.nick,
+			"%s :Too many KNOCKs (user)", channel))
+		return
+	}
+	resp, err := client.pyx().GameInfo(gameId)
+	if err != nil {
+		client.data.push(client.n.format(ErrNoSuchChannel, client.nick, "%s :No such channel",
+			channel))
+		return
+	}
+	if !resp.GameInfo.HasPassword {
+		client.data.push(client.n.format(ErrChanOpen, client.nick, "%s :Channel is open", channel))
+		return
+	}
+
+	message := "has asked for an invite"
+	if len(msg.args) > 1 && msg.args[1] != "" {
+		message = msg.args[1]
+	}
+	from := client.getNickUserAtHost(client.nick)
+	delivered := client.sendToUser(resp.GameInfo.Host, func(target *Client) string {
+		return target.n.format(RplKnock, resp.GameInfo.Host, "%s %s :%s",
+			target.config.GameChannelPrefix+strconv.Itoa(gameId), from, message)
+	})
+	if !delivered {
+		client.data.push(client.n.format(ErrCannotKnock, client.nick,
+			"%s :Cannot knock on %s (the host isn't using IRC)", channel, channel))
+		return
+	}
+	client.lastKnock = time.Now()
+	client.data.push(client.n.format(RplKnockDlvr, client.nick,
+		"%s :Your KNOCK has been delivered", channel))
+}
+
+// INVITE <nick> <channel>
+// Lets someone else using the bridge into our game. They get the password along with the invite,
+// so they can JOIN without knowing it.
+func handleInvite(client *Client, msg Message) {
+	if len(msg.args) < 2 {
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"INVITE :Not enough parameters"))
+		return
+	}
+	nick, channel := msg.args[0], msg.args[1]
+	gameId, _, err := client.getGameFromChannel(channel)
+	if err != nil || !client.inGame(gameId) {
+		client.data.push(client.n.format(ErrNotOnChannel, client.nick,
+			"%s :You're not on that channel", channel))
+		return
+	}
+	resp, err := client.gameInfo()
+	if err != nil {
+		client.data.push(client.n.format(ErrServiceConfused, client.nick,
+			"%s :Unable to get game info: %s", channel, err))
+		return
+	}
+	for _, member := range append(resp.GameInfo.Players, resp.GameInfo.Spectators...) {
+		if strEqCI(member, nick) {
+			client.data.push(client.n.format(ErrUserOnChannel, client.nick,
+				"%s %s :is already on channel", nick, channel))
+			return
+		}
+	}
+
+	from := client.getNickUserAtHost(client.nick)
+	invite := pendingInvite{
+		gameId:   gameId,
+		password: resp.GameInfo.GameOptions.Password,
+		expires:  time.Now().Add(InviteExpireMinutes * time.Minute),
+	}
+	delivered := client.sendToUser(nick, func(target *Client) string {
+		// the lock is already held
+		reachable.invites[reachableKey(target.pyxConfig, target.nick)] = invite
+		return ":" + from + " INVITE " + target.nick + " " +
+			target.config.GameChannelPrefix + strconv.Itoa(gameId)
+	})
+	if !delivered {
+		client.data.push(client.n.format(ErrNoSuchNick, client.nick,
+			"%s :No such nick (they aren't using IRC)", nick))
+		return
+	}
+	client.data.push(client.n.format(RplInviting, client.nick, "%s %s", nick, channel))
+}
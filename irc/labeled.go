@@ -0,0 +1,160 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// IRCv3 labeled-response: replies to a command the client put a label on are sent back with the
+// same label, so it can tell which replies go with which command.
+
+package irc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// These go through the send queue around a labeled command's replies, so the sending side knows
+// which lines to label. No real line can start with a NUL.
+const labelStartMarker = "\x00label-start "
+const labelEndMarker = "\x00label-end"
+
+// Replies to labeled commands can't have PYX events mixed in, so events that show up while one is
+// being handled wait until it's done.
+type labelState struct {
+	lock     sync.Mutex
+	active   bool
+	deferred []func()
+	// wakes up the event goroutine to run what was deferred
+	flush chan bool
+}
+
+func newLabelState() *labelState {
+	return &labelState{flush: make(chan bool, 1)}
+}
+
+// Run f now, unless a labeled command is being handled, in which case it runs after that.
+func (client *Client) whenUnlabeled(f func()) {
+	state := client.labeling
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.active {
+		state.deferred = append(state.deferred, f)
+		return
+	}
+	state.runDeferred()
+	f()
+}
+
+func (client *Client) runDeferred() {
+	state := client.labeling
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if !state.active {
+		state.runDeferred()
+	}
+}
+
+// lock must be held
+func (state *labelState) runDeferred() {
+	deferred := state.deferred
+	state.deferred = nil
+	for _, f := range deferred {
+		f()
+	}
+}
+
+func (client *Client) startLabel(label string) {
+	state := client.labeling
+	state.lock.Lock()
+	state.active = true
+	state.lock.Unlock()
+	// whether they can take a batch has to be decided here, since caps belong to this goroutine
+	batch := "0"
+	if client.hasCap("batch") {
+		batch = "1"
+	}
+	client.data.push(labelStartMarker + batch + " " + label)
+}
+
+func (client *Client) endLabel() {
+	// there's nobody left to send the replies to if they quit
+	if !client.isDisconnecting() {
+		client.data.push(labelEndMarker)
+	}
+	state := client.labeling
+	state.lock.Lock()
+	state.active = false
+	state.lock.Unlock()
+	select {
+	case state.flush <- true:
+	default:
+		// it's already going to run them
+	}
+}
+
+// The replies to one labeled command, collected by the sending goroutine.
+type labeledResponse struct {
+	label string
+	batch bool
+	lines []string
+}
+
+func parseLabelStart(message string) (*labeledResponse, bool) {
+	if !strings.HasPrefix(message, labelStartMarker) {
+		return nil, false
+	}
+	parts := strings.SplitN(message[len(labelStartMarker):], " ", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	return &labeledResponse{label: parts[1], batch: parts[0] == "1"}, true
+}
+
+// Add tag to the front of line, which may already have some.
+func addTag(line string, tag string) string {
+	if strings.HasPrefix(line, "@") {
+		return "@" + tag + ";" + line[1:]
+	}
+	return "@" + tag + " " + line
+}
+
+// The lines to actually send. batchId has to be unique for the connection.
+func (response *labeledResponse) finish(serverName string, batchId int) []string {
+	labelTag := "label=" + escapeTagValue(response.label)
+	switch {
+	case len(response.lines) == 0:
+		// they still need to know we're done with it
+		return []string{fmt.Sprintf("@%s :%s ACK", labelTag, serverName)}
+	case len(response.lines) == 1:
+		return []string{addTag(response.lines[0], labelTag)}
+	case !response.batch:
+		// there's no way to label more than one line without a batch
+		return response.lines
+	}
+	ref := "labeled" + strconv.Itoa(batchId)
+	lines := []string{fmt.Sprintf("@%s :%s BATCH +%s labeled-response", labelTag, serverName, ref)}
+	for _, line := range response.lines {
+		lines = append(lines, addTag(line, "batch="+ref))
+	}
+	return append(lines, fmt.Sprintf(":%s BATCH -%s", serverName, ref))
+}
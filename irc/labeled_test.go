@@ -0,0 +1,58 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+type labeledTestPair struct {
+	response labeledResponse
+	expected []string
+}
+
+var labeledTests = []labeledTestPair{
+	{labeledResponse{label: "a"}, []string{"@label=a :irc.test ACK"}},
+	{labeledResponse{label: "a b", lines: []string{":irc.test PONG irc.test :x"}},
+		[]string{"@label=a\\sb :irc.test PONG irc.test :x"}},
+	{labeledResponse{label: "a", lines: []string{"@account=bob :bob!b@h PRIVMSG #g :hi"}},
+		[]string{"@label=a;account=bob :bob!b@h PRIVMSG #g :hi"}},
+	{labeledResponse{label: "a", batch: true, lines: []string{"one", "two"}},
+		[]string{"@label=a :irc.test BATCH +labeled7 labeled-response", "@batch=labeled7 one",
+			"@batch=labeled7 two", ":irc.test BATCH -labeled7"}},
+	{labeledResponse{label: "a", lines: []string{"one", "two"}}, []string{"one", "two"}},
+}
+
+func TestLabeledResponse(t *testing.T) {
+	for _, test := range labeledTests {
+		lines := test.response.finish("irc.test", 7)
+		if strings.Join(lines, "\n") != strings.Join(test.expected, "\n") {
+			t.Error("For", test.response,
+				"expected", test.expected,
+				"got", lines,
+			)
+		}
+	}
+}
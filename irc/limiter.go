@@ -0,0 +1,111 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var errTooManyConnections = errors.New("Too many connections from your IP")
+var errConnectingTooFast = errors.New("Connecting too fast, try again later")
+
+// Tracks how many connections each IP has, and how quickly they are making new ones. Shared by
+// the manager and its clients, since WEBIRC can change which IP a connection belongs to.
+type connectionLimiter struct {
+	lock        sync.Mutex
+	config      *Config
+	connections map[string]int
+	throttles   map[string]*tokenBucket
+	lastPrune   time.Time
+}
+
+func newConnectionLimiter(config *Config) *connectionLimiter {
+	return &connectionLimiter{
+		config:      config,
+		connections: make(map[string]int),
+		throttles:   make(map[string]*tokenBucket),
+		lastPrune:   time.Now(),
+	}
+}
+
+// Reserves a connection for the IP if it is within its limits. It must be given back with
+// release when the connection closes.
+func (limiter *connectionLimiter) allow(ip string) error {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	limiter.prune()
+	max := limiter.config.MaxConnectionsPerIp
+	if max > 0 && limiter.connections[ip] >= max {
+		return errTooManyConnections
+	}
+	if limiter.config.ConnectionsPerIpPerMinute > 0 {
+		throttle, ok := limiter.throttles[ip]
+		if !ok {
+			throttle = newTokenBucket(limiter.config.ConnectionsPerIpPerMinute,
+				limiter.config.ConnectionsPerIpPerMinute)
+			limiter.throttles[ip] = throttle
+		}
+		if !throttle.take() {
+			return errConnectingTooFast
+		}
+	}
+	limiter.connections[ip]++
+	return nil
+}
+
+func (limiter *connectionLimiter) release(ip string) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	limiter.connections[ip]--
+	if limiter.connections[ip] <= 0 {
+		delete(limiter.connections, ip)
+	}
+}
+
+// Moves a connection from one IP to another, for when a gateway tells us who the real user is.
+// The connection still belongs to the old IP if this fails.
+func (limiter *connectionLimiter) move(from string, to string) error {
+	err := limiter.allow(to)
+	if err == nil {
+		limiter.release(from)
+	}
+	return err
+}
+
+// get rid of throttles for IPs that haven't connected in a while so this doesn't grow forever
+func (limiter *connectionLimiter) prune() {
+	if time.Since(limiter.lastPrune) < time.Minute {
+		return
+	}
+	limiter.lastPrune = time.Now()
+	for ip, throttle := range limiter.throttles {
+		if _, ok := limiter.connections[ip]; !ok && throttle.full() {
+			delete(limiter.throttles, ip)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+// Putting together lines to send to clients, so nobody has to remember where the colons go
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// An outgoing line, built up one parameter at a time. Only the last parameter can have spaces in
+// it or be empty, and it gets the colon it needs automatically.
+type lineBuilder struct {
+	tags     string
+	prefix   string
+	command  string
+	params   []string
+	trailing bool
+	raw      string
+}
+
+// Anything that would end the line early can't go in a parameter.
+var lineEscaper = strings.NewReplacer("\r", " ", "\n", " ", "\x00", "")
+
+func newLine(prefix string, command string) *lineBuilder {
+	return &lineBuilder{prefix: prefix, command: command}
+}
+
+// Message tags to put in front of the line, already formatted like the output of accountTag.
+func (line *lineBuilder) withTags(tags string) *lineBuilder {
+	line.tags = strings.TrimSpace(tags)
+	return line
+}
+
+func (line *lineBuilder) param(params ...string) *lineBuilder {
+	for _, param := range params {
+		line.params = append(line.params, lineEscaper.Replace(param))
+	}
+	return line
+}
+
+func (line *lineBuilder) paramf(format string, args ...interface{}) *lineBuilder {
+	return line.param(fmt.Sprintf(format, args...))
+}
+
+// The last parameter, which always gets a colon even if it doesn't need one. Nothing can be added
+// after it.
+func (line *lineBuilder) text(text string) *lineBuilder {
+	line.param(text)
+	line.trailing = true
+	return line
+}
+
+func (line *lineBuilder) textf(format string, args ...interface{}) *lineBuilder {
+	return line.text(fmt.Sprintf(format, args...))
+}
+
+// Parameters that were already formatted by somebody else, colons and all. This is only here for
+// numerics.format.
+func (line *lineBuilder) rawParams(raw string) *lineBuilder {
+	line.raw = lineEscaper.Replace(raw)
+	return line
+}
+
+func (line *lineBuilder) String() string {
+	var out strings.Builder
+	if line.tags != "" {
+		out.WriteString(line.tags)
+		out.WriteByte(' ')
+	}
+	// tags don't count against the length limit
+	start := out.Len()
+	if line.prefix != "" {
+		out.WriteByte(':')
+		out.WriteString(line.prefix)
+		out.WriteByte(' ')
+	}
+	out.WriteString(line.command)
+	last := len(line.params) - 1
+	for i, param := range line.params {
+		out.WriteByte(' ')
+		if i == last && (line.trailing || param == "" || param[0] == ':' ||
+			strings.Contains(param, " ")) {
+			out.WriteByte(':')
+		} else if i != last {
+			// there's nothing better to do with these
+			if param == "" {
+				param = "*"
+			}
+			param = strings.Replace(param, " ", "_", -1)
+		}
+		out.WriteString(param)
+	}
+	if line.raw != "" {
+		out.WriteByte(' ')
+		out.WriteString(line.raw)
+	}
+	// the end of the line is the text if there is any, so that's what gets cut off
+	return truncateLine(out.String(), start+MaxLineLength)
+}
+
+// Cut the line down to at most length bytes, without splitting a character in half.
+func truncateLine(line string, length int) string {
+	if len(line) <= length {
+		return line
+	}
+	for length > 0 && !utf8.RuneStart(line[length]) {
+		length--
+	}
+	return line[:length]
+}
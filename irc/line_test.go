@@ -0,0 +1,68 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+type lineBuilderTestPair struct {
+	line     *lineBuilder
+	expected string
+}
+
+var lineBuilderTests = []lineBuilderTestPair{
+	{newLine("nick!user@host", "AWAY"), ":nick!user@host AWAY"},
+	{newLine("", "PING").param("token"), "PING token"},
+	{newLine("bot", "MODE").param("#pyx", "+v", "nick"), ":bot MODE #pyx +v nick"},
+	{newLine("bot", "PRIVMSG").param("#pyx").text("hi"), ":bot PRIVMSG #pyx :hi"},
+	{newLine("bot", "PRIVMSG").param("#pyx").text(""), ":bot PRIVMSG #pyx :"},
+	{newLine("bot", "PRIVMSG").param("#pyx", "hello there"), ":bot PRIVMSG #pyx :hello there"},
+	{newLine("bot", "PRIVMSG").param("#pyx", ":)"), ":bot PRIVMSG #pyx ::)"},
+	{newLine("bot", "PRIVMSG").param("#pyx", ""), ":bot PRIVMSG #pyx :"},
+	{newLine("bot", "PRIVMSG").param("#pyx").textf("%d cards", 3), ":bot PRIVMSG #pyx :3 cards"},
+	// only the last one can have spaces
+	{newLine("bot", "MODE").param("#a b", "", "+k", "x y"), ":bot MODE #a_b * +k :x y"},
+	{newLine("bot", "PRIVMSG").param("#pyx").text("a\r\nQUIT\x00"), ":bot PRIVMSG #pyx :a  QUIT"},
+	{newLine("nick", "PRIVMSG").withTags("@account=nick ").param("#pyx").text("hi"),
+		"@account=nick :nick PRIVMSG #pyx :hi"},
+	{newLine("server", "001").param("nick").rawParams(":Welcome"), ":server 001 nick :Welcome"},
+	{newLine("bot", "PRIVMSG").param("#pyx").text(strings.Repeat("a", 600)),
+		":bot PRIVMSG #pyx :" + strings.Repeat("a", MaxLineLength-len(":bot PRIVMSG #pyx :"))},
+	{newLine("bot", "PRIVMSG").param("#pyx").text(strings.Repeat("é", 300)),
+		":bot PRIVMSG #pyx :" + strings.Repeat("é", (MaxLineLength-len(":bot PRIVMSG #pyx :"))/2)},
+}
+
+func TestLineBuilder(t *testing.T) {
+	for _, test := range lineBuilderTests {
+		line := test.line.String()
+		if line != test.expected {
+			t.Error("For", test.line.command, test.line.params,
+				"expected", test.expected,
+				"got", line,
+			)
+		}
+	}
+}
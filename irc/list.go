@@ -0,0 +1,105 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+// LIST, which can be a lot of lines on a busy server, so it goes out a bit at a time
+
+package irc
+
+import (
+	"strconv"
+	"time"
+)
+
+// how many RplList lines can be waiting to be sent before LIST waits for the client to catch up
+const ListBurstLines = 50
+
+// how often to check whether the client has caught up
+const ListPaceInterval = 100 * time.Millisecond
+
+// LIST [count [page]]
+// Channel masks aren't supported, but a count only lists that many channels at a time, starting
+// at the given page, which starts at 1.
+func handleList(client *Client, msg Message) {
+	count, page := 0, 1
+	if len(msg.args) > 0 {
+		if n, err := strconv.Atoi(msg.args[0]); err == nil && n > 0 {
+			count = n
+		}
+	}
+	if count > 0 && len(msg.args) > 1 {
+		if n, err := strconv.Atoi(msg.args[1]); err == nil && n > 0 {
+			page = n
+		}
+	}
+
+	channels, err := client.getChannels()
+	if err != nil {
+		log.Errorf("Unable to retrieve game list for /list: %v", err)
+		client.data.push(client.n.format(ErrServiceConfused, client.nick,
+			":Error retrieving game list: %s", err))
+		return
+	}
+	remaining := 0
+	if count > 0 {
+		start := (page - 1) * count
+		if start > len(channels) {
+			start = len(channels)
+		}
+		channels = channels[start:]
+		if len(channels) > count {
+			remaining = len(channels) - count
+			channels = channels[:count]
+		}
+	}
+
+	client.data.push(client.n.format(RplListStart, client.nick, "Channel :Users  Name"))
+	burst := ListBurstLines
+	if half := client.config.SendQueueLength / 2; half < burst {
+		burst = half + 1
+	}
+	for i, channel := range channels {
+		if i > 0 && i%burst == 0 && !client.waitForSendQueue(burst) {
+			return
+		}
+		client.data.push(client.n.format(RplList, client.nick, "%s %d :%s", channel.name,
+			channel.totalUsers, channel.topic))
+	}
+	if remaining > 0 {
+		client.data.push(client.n.format(RplListEnd, client.nick,
+			":End of /LIST (%d more, LIST %d %d for the next page)", remaining, count, page+1))
+	} else {
+		client.data.push(client.n.format(RplListEnd, client.nick, ":End of /LIST"))
+	}
+}
+
+// Wait for what we've sent the client to get down to at most lines, so a long reply doesn't fill
+// the send queue up and get them disconnected. Returns false if they went away first.
+func (client *Client) waitForSendQueue(lines int) bool {
+	for client.data.depth() > lines {
+		select {
+		case <-client.done:
+			return false
+		case <-time.After(ListPaceInterval):
+		}
+	}
+	return true
+}
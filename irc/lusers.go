@@ -0,0 +1,138 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+// How many people are using the bridge and PYX, for LUSERS, STATS, and the metrics
+
+package irc
+
+import (
+	"fmt"
+	"github.com/ajanata/pyx-irc/pyx"
+	"time"
+)
+
+var bridgeStarted = time.Now()
+
+// People using the bridge are local, and everyone on the PYX server is global.
+type userCounts struct {
+	Local     int
+	MaxLocal  int
+	Global    int
+	MaxGlobal int
+}
+
+// Asks the server through client for everyone on it if we don't know yet.
+func (r *roster) counts(client *pyx.Client) (userCounts, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.seed(client); err != nil {
+		return userCounts{}, err
+	}
+	return r.countsLocked(), nil
+}
+
+// Must be called with the lock held.
+func (r *roster) countsLocked() userCounts {
+	return userCounts{
+		Local:     r.clients,
+		MaxLocal:  r.maxClients,
+		Global:    len(r.users),
+		MaxGlobal: r.maxUsers,
+	}
+}
+
+// What we know about every PYX server anyone has used, without asking any of them, by address.
+func rosterCounts() map[string]userCounts {
+	rostersLock.Lock()
+	defer rostersLock.Unlock()
+	counts := make(map[string]userCounts, len(rosters))
+	for address, r := range rosters {
+		r.lock.Lock()
+		counts[address] = r.countsLocked()
+		r.lock.Unlock()
+	}
+	return counts
+}
+
+func (client *Client) sendLUsers() {
+	channels, err := client.getChannels()
+	if err != nil {
+		log.Errorf("Unable to retrieve game list for /lusers: %v", err)
+		client.data.push(client.n.format(ErrServiceConfused, client.nick,
+			":Error retrieving game list: %s", err))
+		return
+	}
+	counts, err := client.roster.counts(client.pyx())
+	if err != nil {
+		log.Errorf("Unable to retrieve user list for /lusers: %v", err)
+		client.data.push(client.n.format(ErrServiceConfused, client.nick,
+			":Error retrieving user list: %s", err))
+		return
+	}
+
+	client.data.push(client.n.format(RplLUserClient, client.nick, ":There are %d users on 1 server",
+		counts.Global))
+	client.data.push(client.n.format(RplLUserOp, client.nick, "%d :operator(s) online", 0))
+	client.data.push(client.n.format(RplLUserChannels, client.nick, "%d :channels formed",
+		len(channels)))
+	client.data.push(client.n.format(RplLUserMe, client.nick,
+		":I have %d clients and %d servers", counts.Local, 0))
+	client.data.push(client.n.format(RplLocalUsers, client.nick,
+		"%d %d :Current Local Users: %d  Max: %d", counts.Local, counts.MaxLocal, counts.Local,
+		counts.MaxLocal))
+	client.data.push(client.n.format(RplGlobalUsers, client.nick,
+		"%d %d :Current Global Users: %d  Max: %d", counts.Global, counts.MaxGlobal, counts.Global,
+		counts.MaxGlobal))
+}
+
+// STATS <query>
+// Only u, for uptime and the most people there have been, is supported.
+func handleStats(client *Client, msg Message) {
+	if len(msg.args) == 0 || msg.args[0] == "" {
+		client.data.push(client.n.formatSimpleReply(ErrNeedMoreParams, msg.cmd,
+			"Not enough parameters"))
+		return
+	}
+	query := msg.args[0][:1]
+	if query == "u" {
+		uptime := time.Since(bridgeStarted)
+		client.data.push(client.n.format(RplStatsUptime, client.nick,
+			":Server Up %d days %s", int(uptime.Hours())/24, formatClock(uptime)))
+		counts, err := client.roster.counts(client.pyx())
+		if err != nil {
+			client.data.push(client.n.format(ErrServiceConfused, client.nick,
+				":Error retrieving user list: %s", err))
+		} else {
+			client.data.push(client.n.format(RplStatsConn, client.nick,
+				":Highest connection count: %d (%d clients), %d PYX users (%d at most)",
+				counts.MaxLocal, counts.Local, counts.Global, counts.MaxGlobal))
+		}
+	}
+	client.data.push(client.n.format(RplEndOfStats, client.nick, "%s :End of /STATS report",
+		query))
+}
+
+// The hours, minutes, and seconds of d past the last whole day, like 1:02:03.
+func formatClock(d time.Duration) string {
+	seconds := int(d.Seconds()) % (24 * 60 * 60)
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
@@ -24,115 +24,479 @@
 package irc
 
 import (
+	"crypto/tls"
+	"fmt"
+	"github.com/ajanata/pyx-irc/pyx"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// how long a client can go without taking what we send before we give up on it
+const SendTimeoutSeconds = 30
+
+// a flush that takes longer than this counts as a stall in the metrics
+const SendStallMillis = 1000
+
 type Manager struct {
-	clients    map[*Client]bool
-	register   chan *Client
-	unregister chan *Client
-	config     *Config
+	// only the listenForConnections goroutine changes this, but others can look at it
+	clientsLock sync.RWMutex
+	clients     map[*Client]bool
+	register    chan *Client
+	unregister  chan *Client
+	config      *Config
+	limiter     *connectionLimiter
+	listener    net.Listener
+	// nil if this isn't a TLS listener
+	tlsConfig *tls.Config
+	// the capabilities offered here
+	caps *capSet
+	// receives the reason when the server is shutting down
+	shutdown chan string
+	// tracks every client that hasn't been unregistered yet
+	active sync.WaitGroup
+	// how many clients are connected; use atomically
+	clientCount int64
+	// registered clients by folded nick, at most one for each PYX server; also uses clientsLock
+	nicks map[string][]*Client
 }
 
 func NewManager(listener net.Listener, config *Config) {
 	manager := Manager{
 		clients:    make(map[*Client]bool),
+		nicks:      make(map[string][]*Client),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		config:     config,
+		limiter:    newConnectionLimiter(config),
+		caps:       newCapSet(config),
+		listener:   listener,
+		shutdown:   make(chan string),
+	}
+	if config.TlsCertFile != "" {
+		tlsConfig, err := newTlsConfig(config)
+		if err != nil {
+			log.Errorf("Unable to set up TLS on %d: %v", config.Port, err)
+			listener.Close()
+			return
+		}
+		manager.tlsConfig = tlsConfig
 	}
+	addManager(&manager)
 	go manager.listenForConnections()
 
 	for {
 		connection, error := listener.Accept()
 		if error != nil {
-			log.Error(error)
+			if isShuttingDown() {
+				log.Infof("Stopped listening on %d", config.Port)
+			} else {
+				log.Error(error)
+			}
 			return
 		}
-		client := NewClient(connection, config)
-		manager.register <- client
-		go manager.receive(client)
-		go manager.send(client)
-		go manager.close(client)
+		go manager.accept(connection)
 	}
 }
 
+func (manager *Manager) accept(connection net.Conn) {
+	if manager.config.ProxyProtocol {
+		proxied, err := newProxyConn(connection)
+		if err != nil {
+			log.Warningf("Unable to read PROXY header from %s on %d: %v", connection.RemoteAddr(),
+				manager.config.Port, err)
+			connection.Close()
+			return
+		}
+		connection = proxied
+	}
+	connection, fingerprint, err := manager.wrapTls(connection)
+	if err != nil {
+		log.Infof("TLS handshake with %s on %d failed: %v", connection.RemoteAddr(),
+			manager.config.Port, err)
+		connection.Close()
+		return
+	}
+	client := NewClient(connection, manager.config)
+	client.certFingerprint = fingerprint
+	client.secure = manager.tlsConfig != nil
+	client.offered = manager.caps
+	if err := manager.limiter.allow(client.addr); err != nil {
+		log.Infof("Rejecting connection from %s on %d: %v", client.remoteAddr(),
+			manager.config.Port, err)
+		// none of the client's goroutines are running, so just write it directly
+		client.writer.WriteString(fmt.Sprintf("ERROR :Closing Link: [%s] (%v)\r\n", client.addr,
+			err))
+		client.writer.Flush()
+		connection.Close()
+		return
+	}
+	client.limiter = manager.limiter
+	client.manager = manager
+	manager.active.Add(1)
+	manager.register <- client
+	go manager.receive(client)
+	go manager.send(client)
+	go manager.close(client)
+	go manager.ping(client)
+}
+
 func (manager *Manager) listenForConnections() {
 	for {
 		select {
 		case client := <-manager.register:
+			manager.clientsLock.Lock()
 			manager.clients[client] = true
-			log.Infof("Received new connection from %s on %d", client.socket.RemoteAddr(),
+			manager.clientsLock.Unlock()
+			atomic.AddInt64(&manager.clientCount, 1)
+			log.Infof("Received new connection from %s on %d", client.remoteAddr(),
 				manager.config.Port)
 		case client := <-manager.unregister:
 			if _, ok := manager.clients[client]; ok {
-				log.Infof("Closed connection for %s on %d", client.socket.RemoteAddr(),
-					manager.config.Port)
-				close(client.data)
-				close(client.close)
+				info := client.snapshot()
+				log.Infof("Closed connection for %s on %d", info.remoteAddr, manager.config.Port)
+				client.limiter.release(info.addr)
+				client.partAdminChannel()
+				client.clearSnomask()
+				// nobody is going to say RAWTRACE OFF for a connection that's gone
+				client.trace.stop()
+				if info.registered {
+					manager.removeNick(client)
+					client.clearWatches()
+					client.clearReachable()
+					client.roster.detach()
+				}
+				if info.registered {
+					go announceToAdmins(SnoConnect, "%s (%s) disconnected from %d", info.nick, info.addr,
+						manager.config.Port)
+				}
+				client.data.close()
+				// everything else waiting on the client stops when this is closed
+				close(client.done)
+				manager.clientsLock.Lock()
 				delete(manager.clients, client)
+				manager.clientsLock.Unlock()
+				atomic.AddInt64(&manager.clientCount, -1)
+				manager.active.Done()
+			}
+		case reason := <-manager.shutdown:
+			for client := range manager.clients {
+				// disconnecting will come back around to unregister, so can't wait on it here
+				go client.disconnect(reason)
 			}
 		}
 	}
 }
 
+// What operators see about a connection.
+type ClientInfo struct {
+	Nick string
+	// the IP they're connecting from, as opposed to Addr which says how
+	Ip         string
+	Addr       string
+	Port       int
+	Registered bool
+	Idle       time.Duration
+	// "none" before logging in, otherwise "active" or "detached"
+	PyxSession string
+}
+
+// A snapshot of every connection this manager has.
+func (manager *Manager) Clients() []ClientInfo {
+	manager.clientsLock.RLock()
+	defer manager.clientsLock.RUnlock()
+	infos := make([]ClientInfo, 0, len(manager.clients))
+	for client := range manager.clients {
+		snapshot := client.snapshot()
+		info := ClientInfo{
+			Nick:       snapshot.nick,
+			Ip:         snapshot.addr,
+			Addr:       snapshot.remoteAddr,
+			Port:       manager.config.Port,
+			Registered: snapshot.registered,
+			Idle:       time.Since(client.idleSince()),
+			PyxSession: "none",
+		}
+		if session := client.pyx(); session != nil {
+			info.PyxSession = "active"
+			if session.IsDetached() {
+				info.PyxSession = "detached"
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Disconnects the registered client with the given nick. Returns false if there isn't one.
+func (manager *Manager) Kill(nick string, reason string) bool {
+	client := manager.clientByNick(nick, nil)
+	if client == nil {
+		return false
+	}
+	log.Infof("Killing %s (%s): %s", client.nick, client.remoteAddr(), reason)
+	// disconnecting will come back around to unregister, so can't wait on it here
+	go client.disconnect(reason)
+	return true
+}
+
+// Makes a client that just registered findable by its nick.
+func (manager *Manager) addNick(client *Client) {
+	if manager == nil {
+		// not a real connection
+		return
+	}
+	manager.clientsLock.Lock()
+	defer manager.clientsLock.Unlock()
+	key := manager.config.fold(client.nick)
+	manager.nicks[key] = append(manager.nicks[key], client)
+}
+
+func (manager *Manager) removeNick(client *Client) {
+	manager.clientsLock.Lock()
+	defer manager.clientsLock.Unlock()
+	key := manager.config.fold(client.nick)
+	var others []*Client
+	for _, other := range manager.nicks[key] {
+		if other != client {
+			others = append(others, other)
+		}
+	}
+	if len(others) == 0 {
+		delete(manager.nicks, key)
+	} else {
+		manager.nicks[key] = others
+	}
+}
+
+// The registered client using nick on the given PYX server, or on any of them if pyxConfig is nil.
+// Returns nil if nobody here is using it.
+func (manager *Manager) clientByNick(nick string, pyxConfig *pyx.Config) *Client {
+	manager.clientsLock.RLock()
+	defer manager.clientsLock.RUnlock()
+	for _, client := range manager.nicks[manager.config.fold(nick)] {
+		if pyxConfig == nil || client.pyxConfig.BaseAddress == pyxConfig.BaseAddress {
+			return client
+		}
+	}
+	return nil
+}
+
+// Reads everything the client sends, and hands it off to be handled.
 func (manager *Manager) receive(client *Client) {
-	defer func() {
-		// this is dumb and really should be refactored to avoid
-		// this is also really bad cuz it'll eat segfaults
-		if r := recover(); r != nil {
-			log.Warningf("Recovered from panic, probably due to PYX server error: %v", r)
-			manager.unregister <- client
-			client.socket.Close()
-		}
-	}()
-	for {
-		if !client.reader.Scan() {
-			log.Debugf("Unable to read from client %s, closing connection on %d.",
-				client.socket.RemoteAddr(), manager.config.Port)
-			manager.unregister <- client
-			client.socket.Close()
+	limit := 0
+	if manager.config.FloodBurst > 0 {
+		limit = manager.config.RecvQueueBytes
+	}
+	queue := newRecvQueue(limit)
+	// the handler cleans up once it's done with what's left
+	defer queue.close()
+	go manager.handle(client, queue)
+	for client.reader.Scan() {
+		client.touch()
+		message := client.reader.Text()
+		client.trace.incoming(message)
+		if len(message) > 0 && !queue.push(message) {
+			log.Infof("Disconnecting %s for flooding", client.remoteAddr())
+			client.disconnect("Excess Flood")
 			return
 		}
-		message := client.reader.Text()
-		if len(message) > 0 {
-			log.Debug("Received: " + message)
-			client.handleIncoming(message)
+	}
+	log.Debugf("Unable to read from client %s, closing connection on %d.", client.remoteAddr(),
+		manager.config.Port)
+	queue.abandon()
+}
+
+// Handles what the client sent, one line at a time. Commands are only ever handled here.
+func (manager *Manager) handle(client *Client, queue *recvQueue) {
+	var flood *tokenBucket
+	if manager.config.FloodBurst > 0 {
+		flood = newTokenBucket(manager.config.FloodBurst, manager.config.FloodLinesPerMinute)
+	}
+	for {
+		message, ok := queue.next()
+		if !ok {
+			break
+		}
+		if client.isDisconnecting() {
+			// nothing else they said matters now
+			continue
+		}
+		if flood != nil {
+			// everything turns into a PYX request, so slow down anyone who's spamming; the rest
+			// of what they send waits in the queue, and they're gone if too much piles up
+			if wait := flood.wait(); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-queue.gone:
+					// next will say there's nothing left
+					continue
+				}
+			}
+			flood.take()
+		}
+		log.Debug("Received: " + message)
+		message, err := cleanLine(message)
+		if err == errIllegalCharacter {
+			client.data.push(client.n.format(ErrUnknownError, client.capTarget(),
+				"* :Illegal character in message"))
+			continue
 		}
+		if err == errLineTooLong {
+			if manager.config.DisconnectLongLines {
+				client.disconnect("Input line was too long")
+				continue
+			}
+			// the rest of it is still handled, as if that was all they sent
+			client.data.push(client.n.formatSimpleReply(ErrInputTooLong, client.capTarget(),
+				"Input line was too long"))
+		}
+		client.handleIncoming(decodeLine(message, manager.config.LegacyEncoding))
+	}
+	if client.registered && !client.isDisconnecting() {
+		// they didn't quit, so let them pick the PYX session back up if they come back
+		go client.pyx().Detach()
 	}
+	manager.unregister <- client
+	client.socket.Close()
 }
 
 func (manager *Manager) send(client *Client) {
 	defer client.socket.Close()
+	// nothing else is going to be sent once we stop, so don't let anything pile up
+	defer client.data.close()
+	// replies to a labeled command being held until we have all of them
+	var labeled *labeledResponse
+	batches := 0
 	for {
-		select {
-		case message, ok := <-client.data:
-			if !ok {
-				log.Debugf("Unable to read from send channel for client %s, stopping goroutine.",
-					client.socket.RemoteAddr())
+		messages, dropped, done := client.data.next()
+		// everything that piled up goes out together, and only has this long to do it
+		client.socket.SetWriteDeadline(time.Now().Add(SendTimeoutSeconds * time.Second))
+		if dropped > 0 {
+			log.Warningf("Dropped %d lines for %s, who isn't keeping up", dropped,
+				client.remoteAddr())
+			// this can't wait behind a labeled response, or it would end up inside it
+			if manager.write(client, fmt.Sprintf(":%s NOTICE %s :%d lines were not sent to you "+
+				"because your connection was falling behind", manager.config.AdvertisedName,
+				client.nick, dropped)) != nil {
 				return
 			}
-			log.Debugf("Sending to %s: %s", client.socket.RemoteAddr(), message)
-			_, error := client.writer.WriteString(message + "\r\n")
-			if error != nil {
-				log.Error(error)
+		}
+		for _, message := range messages {
+			if response, ok := parseLabelStart(message); ok {
+				labeled = response
+				continue
 			}
-			error = client.writer.Flush()
-			if error != nil {
-				log.Error(error)
+			if message == labelEndMarker {
+				if labeled != nil {
+					batches++
+					for _, line := range labeled.finish(manager.config.AdvertisedName, batches) {
+						if manager.write(client, line) != nil {
+							return
+						}
+					}
+				}
+				labeled = nil
+				continue
+			}
+			if labeled != nil {
+				labeled.lines = append(labeled.lines, message)
+				continue
+			}
+			if manager.write(client, message) != nil {
+				return
 			}
 		}
+		if done {
+			final, overflowed := client.data.finalLine()
+			if overflowed {
+				log.Warningf("Disconnecting %s, who isn't keeping up", client.remoteAddr())
+				final = fmt.Sprintf("ERROR :Closing Link: %s[%s] (SendQ exceeded)", client.nick,
+					client.addr)
+			}
+			if final != "" {
+				manager.write(client, final)
+			}
+			manager.flush(client)
+			log.Debugf("Nothing left to send to client %s, stopping goroutine.",
+				client.remoteAddr())
+			return
+		}
+		// there's nothing else to send right now, so don't hold on to any of it
+		if manager.flush(client) != nil {
+			return
+		}
 	}
 }
 
-func (manager *Manager) close(client *Client) {
+// Lines are only buffered here, so a burst like NAMES or LIST goes out in as few writes as the
+// buffer allows. It gets written on its own if it fills up, otherwise send flushes it once the
+// queue is empty.
+func (manager *Manager) write(client *Client, message string) error {
+	log.Debugf("Sending to %s: %s", client.remoteAddr(), message)
+	message = encodeLine(message)
+	client.trace.outgoing(message)
+	_, err := client.writer.WriteString(message + "\r\n")
+	if err != nil {
+		log.Errorf("Unable to send to %s: %v", client.remoteAddr(), err)
+	}
+	return err
+}
+
+// Gives up if the client hasn't taken what's buffered by the write deadline, so a stalled
+// connection can't keep its goroutines around forever.
+func (manager *Manager) flush(client *Client) error {
+	start := time.Now()
+	err := client.writer.Flush()
+	if time.Since(start) >= SendStallMillis*time.Millisecond {
+		atomic.AddInt64(&sendStats.stalls, 1)
+	}
+	if err != nil {
+		log.Errorf("Unable to send to %s: %v", client.remoteAddr(), err)
+	}
+	return err
+}
+
+// Pings the client when it's been quiet for a while, and disconnects it if it doesn't answer, so
+// dead connections don't keep PYX sessions around.
+func (manager *Manager) ping(client *Client) {
+	interval := time.Duration(manager.config.PingIntervalSeconds) * time.Second
+	timeout := time.Duration(manager.config.PingTimeoutSeconds) * time.Second
+	// check every second, so a ping goes out and a timeout is noticed within a second of being due
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var pingSent time.Time
 	for {
-		close, ok := <-client.close
-		if close || !ok {
-			log.Infof("Close requested for client %s (auto: %v)", client.socket.RemoteAddr(), !ok)
-			manager.unregister <- client
-			client.socket.Close()
+		select {
+		case <-client.done:
 			return
+		case now := <-ticker.C:
+			lastActivity := client.idleSince()
+			if !pingSent.IsZero() {
+				if lastActivity.After(pingSent) {
+					pingSent = time.Time{}
+				} else if now.Sub(pingSent) >= timeout {
+					log.Infof("Ping timeout for %s", client.remoteAddr())
+					client.disconnect(fmt.Sprintf("Ping timeout: %d seconds",
+						int(now.Sub(lastActivity).Seconds())))
+					return
+				}
+			} else if now.Sub(lastActivity) >= interval {
+				pingSent = now
+				client.data.push(fmt.Sprintf("PING :%s", manager.config.AdvertisedName))
+			}
 		}
 	}
 }
+
+func (manager *Manager) close(client *Client) {
+	select {
+	case <-client.close:
+		log.Infof("Close requested for client %s", client.remoteAddr())
+		// the sending goroutine closes the socket once whatever is left has gone out
+		manager.unregister <- client
+	case <-client.done:
+		// the connection went away on its own
+	}
+}
@@ -0,0 +1,143 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Remembers what was written to it, and in how many pieces.
+type recordingConn struct {
+	net.Conn
+	writes int
+	data   strings.Builder
+}
+
+func (conn *recordingConn) Write(b []byte) (int, error) {
+	conn.writes++
+	return conn.data.Write(b)
+}
+
+func (conn *recordingConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6667}
+}
+
+func (conn *recordingConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (conn *recordingConn) Close() error {
+	return nil
+}
+
+func TestSendCoalescesWrites(t *testing.T) {
+	config := &Config{}
+	config.EnsureDefaults()
+	conn := &recordingConn{}
+	client := NewClient(conn, config)
+	manager := &Manager{config: config}
+
+	for i := 0; i < 100; i++ {
+		client.data.push("PRIVMSG #global :burst")
+	}
+	client.data.finish("ERROR :done")
+	manager.send(client)
+
+	lines := strings.Count(conn.data.String(), "\r\n")
+	if lines != 101 {
+		t.Error("For 100 lines and an ERROR expected 101 lines, got", lines)
+	}
+	// about 2.4k of lines fits in the default buffer
+	if conn.writes != 1 {
+		t.Error("For a burst expected 1 write, got", conn.writes)
+	}
+}
+
+func TestClientByNick(t *testing.T) {
+	config := &Config{}
+	config.EnsureDefaults()
+	config.Pyx.BaseAddress = "http://pyx-1/"
+	other := &pyx.Config{BaseAddress: "http://pyx-2/"}
+	manager := &Manager{config: config, nicks: make(map[string][]*Client)}
+	first := &Client{nick: "Nick", pyxConfig: &config.Pyx}
+	second := &Client{nick: "nick", pyxConfig: other}
+	manager.addNick(first)
+	manager.addNick(second)
+
+	if found := manager.clientByNick("NICK", &config.Pyx); found != first {
+		t.Error("For NICK on pyx-1 expected", first, "got", found)
+	}
+	if found := manager.clientByNick("NICK", other); found != second {
+		t.Error("For NICK on pyx-2 expected", second, "got", found)
+	}
+	if found := manager.clientByNick("nick", nil); found == nil {
+		t.Error("For nick on any server expected a client, got nil")
+	}
+	if found := manager.clientByNick("someone", nil); found != nil {
+		t.Error("For someone expected nil, got", found)
+	}
+
+	manager.removeNick(first)
+	if found := manager.clientByNick("nick", &config.Pyx); found != nil {
+		t.Error("For nick on pyx-1 after removing expected nil, got", found)
+	}
+	if found := manager.clientByNick("nick", nil); found != second {
+		t.Error("For nick on any server after removing expected", second, "got", found)
+	}
+	manager.removeNick(second)
+	if len(manager.nicks) != 0 {
+		t.Error("For removing everyone expected no nicks, got", manager.nicks)
+	}
+}
+
+// Admins list connections from their own goroutine while the client is still registering.
+func TestClientsWhileRegistering(t *testing.T) {
+	config := &Config{}
+	config.EnsureDefaults()
+	connection, other := net.Pipe()
+	defer other.Close()
+	client := NewClient(connection, config)
+	manager := &Manager{config: config, clients: map[*Client]bool{client: true}}
+
+	done := make(chan bool)
+	go func() {
+		client.setGateway("gateway", "192.0.2.1")
+		client.setNick("alice")
+		client.setRegistered()
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		manager.Clients()
+	}
+	<-done
+	infos := manager.Clients()
+	if len(infos) != 1 || infos[0].Nick != "alice" || !infos[0].Registered ||
+		infos[0].Ip != "192.0.2.1" {
+		t.Error("For a registered client expected alice from 192.0.2.1, got", infos)
+	}
+}
@@ -0,0 +1,115 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+// Numbers for operators to see where things are backing up
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"sort"
+	"sync/atomic"
+)
+
+type ServerMetrics struct {
+	Port    int
+	Clients int
+	// lines waiting to be sent, in total and for the client furthest behind
+	QueuedLines    int
+	MaxQueuedLines int
+	// PYX events waiting for clients to handle them
+	QueuedEvents int
+}
+
+// Local users are the ones using the bridge, and global users are everyone on the PYX server.
+type PyxMetrics struct {
+	Address        string
+	LocalUsers     int
+	MaxLocalUsers  int
+	GlobalUsers    int
+	MaxGlobalUsers int
+}
+
+type Metrics struct {
+	Servers []ServerMetrics
+	Pyx     []PyxMetrics
+	// lines thrown away for clients that weren't keeping up
+	DroppedLines int64
+	// clients disconnected for letting their send queue fill up
+	SendQueueOverflows int64
+	// writes to clients that took a long time to go out
+	SendStalls int64
+	// times the PYX long poll had to wait for a client to take an event
+	PyxEventStalls int64
+}
+
+func (manager *Manager) metrics() ServerMetrics {
+	metrics := ServerMetrics{
+		Port:    manager.config.Port,
+		Clients: int(atomic.LoadInt64(&manager.clientCount)),
+	}
+	manager.clientsLock.RLock()
+	defer manager.clientsLock.RUnlock()
+	for client := range manager.clients {
+		depth := client.data.depth()
+		metrics.QueuedLines += depth
+		if depth > metrics.MaxQueuedLines {
+			metrics.MaxQueuedLines = depth
+		}
+		if client.pyx() != nil {
+			metrics.QueuedEvents += client.pyx().QueuedEvents()
+		}
+	}
+	return metrics
+}
+
+// Metrics for every server that is currently accepting connections.
+func CollectMetrics() Metrics {
+	managersLock.Lock()
+	defer managersLock.Unlock()
+	metrics := Metrics{
+		Servers:            make([]ServerMetrics, len(managers)),
+		DroppedLines:       atomic.LoadInt64(&sendStats.dropped),
+		SendQueueOverflows: atomic.LoadInt64(&sendStats.overflows),
+		SendStalls:         atomic.LoadInt64(&sendStats.stalls),
+		PyxEventStalls:     pyx.EventStalls(),
+	}
+	for i, manager := range managers {
+		metrics.Servers[i] = manager.metrics()
+	}
+	counts := rosterCounts()
+	addresses := make([]string, 0, len(counts))
+	for address := range counts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		metrics.Pyx = append(metrics.Pyx, PyxMetrics{
+			Address:        address,
+			LocalUsers:     counts[address].Local,
+			MaxLocalUsers:  counts[address].MaxLocal,
+			GlobalUsers:    counts[address].Global,
+			MaxGlobalUsers: counts[address].MaxGlobal,
+		})
+	}
+	return metrics
+}
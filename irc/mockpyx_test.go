@@ -0,0 +1,512 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// A fake PYX server for end-to-end tests
+
+package irc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ajanata/pyx-irc/pyx"
+)
+
+// how long a long poll waits for something to happen before returning a no-op
+const mockLongPollWait = 100 * time.Millisecond
+
+type mockPyx struct {
+	server      *httptest.Server
+	lock        sync.Mutex
+	sessions    map[string]*mockSession
+	nextSession int
+	games       map[int]*pyx.GameInfo
+	// the judge of the current round in each game that has one
+	judges map[int]string
+	// when the server started, which changes when it restarts
+	started int64
+	// a proxy in front of it is answering with error pages instead
+	down bool
+	// how many times each AJAX operation was asked for
+	requests map[string]int
+	// nicks that are PYX administrators when they register
+	admins map[string]bool
+	// the persistent ID each nick last registered with, if any
+	persistentIds map[string]string
+}
+
+type mockSession struct {
+	nick   string
+	gameId *int
+	events chan map[string]interface{}
+	// how many long polls to fail before working again
+	failPolls int
+	// how many long polls to fail after taking events off the queue, like a proxy timing out on
+	// PYX after it answered
+	losePolls int
+}
+
+func newMockPyx(t *testing.T) *mockPyx {
+	mock := &mockPyx{
+		sessions:      make(map[string]*mockSession),
+		games:         make(map[int]*pyx.GameInfo),
+		judges:        make(map[int]string),
+		requests:      make(map[string]int),
+		admins:        make(map[string]bool),
+		started:       1,
+		persistentIds: make(map[string]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/game.jsp", mock.handleGameJsp)
+	mux.HandleFunc("/js/cah.config.js", mock.handleConfigJs)
+	mux.HandleFunc("/AjaxServlet", mock.handleAjax)
+	mux.HandleFunc("/LongPollServlet", mock.handleLongPoll)
+	mock.server = httptest.NewServer(mux)
+	t.Cleanup(mock.server.Close)
+	return mock
+}
+
+func (mock *mockPyx) baseAddress() string {
+	return mock.server.URL + "/"
+}
+
+// Add a game hosted by someone who isn't connected through the bridge.
+func (mock *mockPyx) addGame(id int, host string) {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	mock.games[id] = &pyx.GameInfo{
+		Id:      id,
+		Host:    host,
+		Players: []string{host},
+		State:   pyx.GameState_LOBBY,
+		GameOptions: pyx.GameOptionData{
+			PlayerLimit:    10,
+			SpectatorLimit: 10,
+			ScoreLimit:     8,
+			CardSets:       []int{1},
+		},
+	}
+}
+
+// Add a user who is connected with the web client instead of the bridge.
+func (mock *mockPyx) addUser(nick string) {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	mock.sessions["web-"+nick] = &mockSession{
+		nick:   nick,
+		events: make(chan map[string]interface{}, 100),
+	}
+}
+
+// Start a round with the given judge and a black card.
+func (mock *mockPyx) startRound(gameId int, judge string, card pyx.BlackCardData) {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	mock.games[gameId].State = pyx.GameState_PLAYING
+	mock.judges[gameId] = judge
+	mock.broadcast(&gameId, map[string]interface{}{"E": pyx.LongPollEvent_GAME_STATE_CHANGE,
+		"gid": gameId, "gs": pyx.GameState_PLAYING, "bc": card, "Pt": 60000})
+}
+
+// Lose one of nick's long polls, and take them out of their game without telling them.
+func (mock *mockPyx) dropFromGame(nick string) {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	for _, session := range mock.sessions {
+		if session.nick != nick {
+			continue
+		}
+		session.losePolls = 1
+		if session.gameId != nil {
+			game := mock.games[*session.gameId]
+			game.Players = removeString(game.Players, nick)
+			game.Spectators = removeString(game.Spectators, nick)
+			session.gameId = nil
+		}
+	}
+}
+
+// Seat nick in a game without telling the bridge, like they did it from the web client, taking
+// them out of any other game first.
+func (mock *mockPyx) moveToGame(nick string, gameId int) {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	for _, session := range mock.sessions {
+		if session.nick != nick {
+			continue
+		}
+		if session.gameId != nil {
+			game := mock.games[*session.gameId]
+			game.Players = removeString(game.Players, nick)
+		}
+		game := mock.games[gameId]
+		game.Players = append(game.Players, nick)
+		session.gameId = &game.Id
+	}
+}
+
+// Forget every session and game, like the server was restarted. Existing cookies still get a
+// session, it just isn't logged in anymore.
+func (mock *mockPyx) restart() {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	mock.started++
+	for id := range mock.sessions {
+		mock.sessions[id] = &mockSession{events: make(chan map[string]interface{}, 100)}
+	}
+	mock.games = make(map[int]*pyx.GameInfo)
+	mock.judges = make(map[int]string)
+}
+
+// Answer everything with an error page, like a proxy does while the server is down, or stop.
+func (mock *mockPyx) setDown(down bool) {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	mock.down = down
+}
+
+// Sends the error page if the server is down.
+func (mock *mockPyx) isDown(w http.ResponseWriter) bool {
+	mock.lock.Lock()
+	down := mock.down
+	mock.lock.Unlock()
+	if down {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, "<html><body><h1>502 Bad Gateway</h1></body></html>")
+	}
+	return down
+}
+
+func (mock *mockPyx) session(r *http.Request) *mockSession {
+	cookie, err := r.Cookie("JSESSIONID")
+	if err != nil {
+		return nil
+	}
+	return mock.sessions[cookie.Value]
+}
+
+// Queue an event for everyone logged in, or everyone in the game if gameId isn't nil.
+func (mock *mockPyx) broadcast(gameId *int, event map[string]interface{}) {
+	for _, session := range mock.sessions {
+		if session.nick == "" {
+			continue
+		}
+		if gameId != nil && (session.gameId == nil || *session.gameId != *gameId) {
+			continue
+		}
+		select {
+		case session.events <- event:
+		default:
+		}
+	}
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+func mockError(code string) map[string]interface{} {
+	return map[string]interface{}{"e": true, "ec": code}
+}
+
+func (mock *mockPyx) handleGameJsp(w http.ResponseWriter, r *http.Request) {
+	mock.lock.Lock()
+	mock.nextSession++
+	id := fmt.Sprintf("session%d", mock.nextSession)
+	mock.sessions[id] = &mockSession{events: make(chan map[string]interface{}, 100)}
+	mock.lock.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: id, Path: "/"})
+	fmt.Fprint(w, "<html></html>")
+}
+
+func (mock *mockPyx) handleConfigJs(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "cah.GLOBAL_CHAT_ENABLED = true;\ncah.BROADCASTING_USERS = true;\n")
+}
+
+func (mock *mockPyx) handleAjax(w http.ResponseWriter, r *http.Request) {
+	if mock.isDown(w) {
+		return
+	}
+	r.ParseForm()
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	session := mock.session(r)
+	if session == nil {
+		writeJson(w, mockError(pyx.ErrorCode_NO_SESSION))
+		return
+	}
+	op := r.Form.Get(pyx.AjaxRequest_OP)
+	mock.requests[op]++
+	if op != pyx.AjaxOperation_FIRST_LOAD && op != pyx.AjaxOperation_REGISTER &&
+		session.nick == "" {
+		writeJson(w, mockError(pyx.ErrorCode_NOT_REGISTERED))
+		return
+	}
+	gameId, _ := strconv.Atoi(r.Form.Get(pyx.AjaxRequest_GAME_ID))
+
+	switch op {
+	case pyx.AjaxOperation_FIRST_LOAD:
+		resp := map[string]interface{}{
+			"ip": session.nick != "",
+			"SS": mock.started,
+			"css": []pyx.CardSetData{
+				{Id: 1, CardSetName: "Base Set", BaseDeck: true, BlackCardsInDeck: 90,
+					WhiteCardsInDeck: 460},
+			},
+		}
+		if session.nick != "" {
+			resp["n"] = session.nick
+			resp["next"] = pyx.ReconnectNextAction_NONE
+		}
+		writeJson(w, resp)
+	case pyx.AjaxOperation_REGISTER:
+		nick := r.Form.Get(pyx.AjaxRequest_NICKNAME)
+		for _, other := range mock.sessions {
+			if strings.EqualFold(other.nick, nick) {
+				writeJson(w, mockError(pyx.ErrorCode_NICK_IN_USE))
+				return
+			}
+		}
+		resp := map[string]interface{}{"n": nick, "?": pyx.Sigil_NORMAL_USER}
+		if idcode := r.Form.Get(pyx.AjaxRequest_ID_CODE); idcode != "" {
+			resp = map[string]interface{}{"n": nick, "?": pyx.Sigil_ID_CODE, "idc": "abc123"}
+		}
+		if mock.admins[nick] {
+			resp["?"] = pyx.Sigil_ADMIN
+		}
+		// a new one for anyone who doesn't already have one
+		pid := r.Form.Get(pyx.AjaxRequest_PERSISTENT_ID)
+		mock.persistentIds[nick] = pid
+		if pid == "" {
+			pid = "pid-" + nick
+		}
+		resp["pid"] = pid
+		mock.broadcast(nil, map[string]interface{}{"E": pyx.LongPollEvent_NEW_PLAYER, "n": nick,
+			"?": resp["?"], "idc": resp["idc"]})
+		session.nick = nick
+		writeJson(w, resp)
+	case pyx.AjaxOperation_LOG_OUT:
+		session.nick = ""
+		mock.broadcast(nil, map[string]interface{}{"E": pyx.LongPollEvent_PLAYER_LEAVE,
+			"n": r.Form.Get(pyx.AjaxRequest_NICKNAME), "qr": pyx.DisconnectReason_MANUAL})
+		writeJson(w, map[string]interface{}{})
+	case pyx.AjaxOperation_NAMES:
+		names := []string{}
+		for _, other := range mock.sessions {
+			if other.nick != "" {
+				names = append(names, other.nick)
+			}
+		}
+		writeJson(w, map[string]interface{}{"nl": names})
+	case pyx.AjaxOperation_GAME_LIST:
+		games := []*pyx.GameInfo{}
+		for _, game := range mock.games {
+			games = append(games, game)
+		}
+		writeJson(w, map[string]interface{}{"gl": games, "mg": 10})
+	case pyx.AjaxOperation_GET_GAME_INFO:
+		game, ok := mock.games[gameId]
+		if !ok {
+			writeJson(w, mockError(pyx.ErrorCode_INVALID_GAME))
+			return
+		}
+		info := []pyx.GamePlayerInfo{}
+		for _, player := range game.Players {
+			status := pyx.GamePlayerStatus_IDLE
+			if player == mock.judges[gameId] {
+				status = pyx.GamePlayerStatus_JUDGE
+			}
+			info = append(info, pyx.GamePlayerInfo{Name: player, Status: status})
+		}
+		writeJson(w, map[string]interface{}{"gi": game, "pi": info})
+	case pyx.AjaxOperation_WHOIS:
+		nick := r.Form.Get(pyx.AjaxRequest_NICKNAME)
+		for _, other := range mock.sessions {
+			if strings.EqualFold(other.nick, nick) {
+				resp := map[string]interface{}{"n": other.nick, "?": "", "IP": "192.0.2.1",
+					"cn": "PYX-IRC", "ca": 0, "idl": 0}
+				if other.gameId != nil {
+					resp["gid"] = *other.gameId
+					resp["gi"] = mock.games[*other.gameId]
+				}
+				writeJson(w, resp)
+				return
+			}
+		}
+		writeJson(w, mockError(pyx.ErrorCode_NO_SUCH_USER))
+	case pyx.AjaxOperation_JOIN_GAME, pyx.AjaxOperation_SPECTATE_GAME:
+		game, ok := mock.games[gameId]
+		if !ok {
+			writeJson(w, mockError(pyx.ErrorCode_INVALID_GAME))
+			return
+		}
+		if session.gameId != nil {
+			writeJson(w, mockError(pyx.ErrorCode_CANNOT_JOIN_ANOTHER_GAME))
+			return
+		}
+		if game.GameOptions.Password != "" &&
+			r.Form.Get(pyx.AjaxRequest_PASSWORD) != game.GameOptions.Password {
+			writeJson(w, mockError(pyx.ErrorCode_WRONG_PASSWORD))
+			return
+		}
+		event := pyx.LongPollEvent_GAME_PLAYER_JOIN
+		if op == pyx.AjaxOperation_JOIN_GAME {
+			game.Players = append(game.Players, session.nick)
+		} else {
+			event = pyx.LongPollEvent_GAME_SPECTATOR_JOIN
+			game.Spectators = append(game.Spectators, session.nick)
+		}
+		session.gameId = &game.Id
+		mock.broadcast(&game.Id, map[string]interface{}{"E": event, "gid": game.Id,
+			"n": session.nick})
+		mock.broadcast(nil, map[string]interface{}{"E": pyx.LongPollEvent_GAME_LIST_REFRESH})
+		writeJson(w, map[string]interface{}{})
+	case pyx.AjaxOperation_CREATE_GAME:
+		if session.gameId != nil {
+			writeJson(w, mockError(pyx.ErrorCode_CANNOT_JOIN_ANOTHER_GAME))
+			return
+		}
+		id := 1
+		for other := range mock.games {
+			if other >= id {
+				id = other + 1
+			}
+		}
+		mock.games[id] = &pyx.GameInfo{
+			Id:          id,
+			Host:        session.nick,
+			Players:     []string{session.nick},
+			State:       pyx.GameState_LOBBY,
+			GameOptions: pyx.GameOptionData{PlayerLimit: 10, SpectatorLimit: 10, ScoreLimit: 8},
+		}
+		session.gameId = &id
+		writeJson(w, map[string]interface{}{"gid": id})
+	case pyx.AjaxOperation_CHANGE_GAME_OPTIONS:
+		game, ok := mock.games[gameId]
+		if !ok || game.Host != session.nick {
+			writeJson(w, mockError(pyx.ErrorCode_NOT_GAME_HOST))
+			return
+		}
+		json.Unmarshal([]byte(r.Form.Get(pyx.AjaxRequest_GAME_OPTIONS)), &game.GameOptions)
+		game.HasPassword = game.GameOptions.Password != ""
+		writeJson(w, map[string]interface{}{})
+	case pyx.AjaxOperation_LEAVE_GAME:
+		game, ok := mock.games[gameId]
+		if !ok || session.gameId == nil || *session.gameId != gameId {
+			writeJson(w, mockError(pyx.ErrorCode_NOT_IN_THAT_GAME))
+			return
+		}
+		game.Players = removeString(game.Players, session.nick)
+		game.Spectators = removeString(game.Spectators, session.nick)
+		mock.broadcast(&game.Id, map[string]interface{}{"E": pyx.LongPollEvent_GAME_PLAYER_LEAVE,
+			"gid": game.Id, "n": session.nick})
+		session.gameId = nil
+		mock.broadcast(nil, map[string]interface{}{"E": pyx.LongPollEvent_GAME_LIST_REFRESH})
+		writeJson(w, map[string]interface{}{})
+	case pyx.AjaxOperation_GET_CARDS:
+		writeJson(w, map[string]interface{}{"h": []pyx.WhiteCardData{}})
+	case pyx.AjaxOperation_CARDCAST_LIST_CARDSETS:
+		writeJson(w, map[string]interface{}{"css": []pyx.CardSetData{}})
+	case pyx.AjaxOperation_CHAT, pyx.AjaxOperation_GAME_CHAT:
+		event := map[string]interface{}{"E": pyx.LongPollEvent_CHAT, "f": session.nick,
+			"m": r.Form.Get(pyx.AjaxRequest_MESSAGE)}
+		var target *int
+		if op == pyx.AjaxOperation_GAME_CHAT {
+			event["gid"] = gameId
+			target = &gameId
+		}
+		mock.broadcast(target, event)
+		writeJson(w, map[string]interface{}{})
+	default:
+		writeJson(w, mockError(pyx.ErrorCode_BAD_OP))
+	}
+}
+
+func (mock *mockPyx) handleLongPoll(w http.ResponseWriter, r *http.Request) {
+	if mock.isDown(w) {
+		return
+	}
+	mock.lock.Lock()
+	session := mock.session(r)
+	failing := session != nil && session.failPolls > 0
+	if failing {
+		session.failPolls--
+	}
+	losing := !failing && session != nil && session.losePolls > 0
+	if losing {
+		session.losePolls--
+	}
+	registered := session != nil && session.nick != ""
+	mock.lock.Unlock()
+	if session == nil {
+		writeJson(w, mockError(pyx.ErrorCode_NO_SESSION))
+		return
+	}
+	if !registered {
+		writeJson(w, mockError(pyx.ErrorCode_NOT_REGISTERED))
+		return
+	}
+	if failing {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if losing {
+		for len(session.events) > 0 {
+			<-session.events
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusGatewayTimeout)
+		fmt.Fprint(w, "<html><body><h1>504 Gateway Timeout</h1></body></html>")
+		return
+	}
+	select {
+	case event := <-session.events:
+		events := []map[string]interface{}{event}
+		// send everything else that's waiting too
+		for len(session.events) > 0 {
+			events = append(events, <-session.events)
+		}
+		writeJson(w, events)
+	case <-time.After(mockLongPollWait):
+		writeJson(w, map[string]interface{}{"E": pyx.LongPollEvent_NOOP})
+	}
+}
+
+func removeString(list []string, s string) []string {
+	out := []string{}
+	for _, item := range list {
+		if item != s {
+			out = append(out, item)
+		}
+	}
+	return out
+}
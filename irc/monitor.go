@@ -0,0 +1,232 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// ISON and MONITOR, answered from the roster
+
+package irc
+
+import (
+	"strings"
+	"sync"
+)
+
+// advertised in ISUPPORT
+const MaxMonitorEntries = 100
+
+// Who the client asked to hear about, by folded nick. MONITOR changes it on the command goroutine
+// while PYX events check it on theirs.
+type monitorSet struct {
+	lock  sync.Mutex
+	nicks map[string]string
+}
+
+func newMonitorSet() *monitorSet {
+	return &monitorSet{nicks: make(map[string]string)}
+}
+
+// Returns false if it was already there, and if there wasn't room for it.
+func (set *monitorSet) add(key string, nick string) (bool, bool) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	if _, ok := set.nicks[key]; ok {
+		return false, false
+	}
+	if len(set.nicks) >= MaxMonitorEntries {
+		return false, true
+	}
+	set.nicks[key] = nick
+	return true, false
+}
+
+func (set *monitorSet) remove(key string) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	delete(set.nicks, key)
+}
+
+func (set *monitorSet) clear() {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	set.nicks = make(map[string]string)
+}
+
+func (set *monitorSet) has(key string) bool {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	_, ok := set.nicks[key]
+	return ok
+}
+
+func (set *monitorSet) list() []string {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	nicks := []string{}
+	for _, nick := range set.nicks {
+		nicks = append(nicks, nick)
+	}
+	return nicks
+}
+
+// The bot and services are always around.
+func (client *Client) isPseudoClient(nick string) bool {
+	return client.config.equalFold(nick, client.config.BotNick) || client.isNickServ(nick) ||
+		client.isGameServ(nick)
+}
+
+// Returns the nick the way the server has it, without the sigil.
+func (client *Client) isOnline(nick string) (string, bool) {
+	if client.isPseudoClient(nick) {
+		return nick, true
+	}
+	name, ok, err := client.roster.find(client.pyx(), nick)
+	if err != nil {
+		log.Errorf("Unable to look up %s for %s: %v", nick, client.nick, err)
+		return "", false
+	}
+	if !ok {
+		return "", false
+	}
+	_, bare := splitSigil(name)
+	return bare, true
+}
+
+func handleIson(client *Client, msg Message) {
+	if len(msg.args) == 0 {
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"ISON :Not enough parameters"))
+		return
+	}
+	online := []string{}
+	// some clients put all of the nicks in one argument
+	for _, nick := range strings.Fields(strings.Join(msg.args, " ")) {
+		if name, ok := client.isOnline(nick); ok {
+			online = append(online, name)
+		}
+	}
+	client.data.push(client.n.formatSimpleReply(RplIson, client.nick, strings.Join(online, " ")))
+}
+
+func handleMonitor(client *Client, msg Message) {
+	if len(msg.args) == 0 {
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"MONITOR :Not enough parameters"))
+		return
+	}
+	targets := []string{}
+	if len(msg.args) > 1 {
+		for _, target := range strings.Split(msg.args[1], ",") {
+			if target != "" {
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	switch strings.ToUpper(msg.args[0]) {
+	case "+":
+		added := []string{}
+		for i, target := range targets {
+			ok, full := client.monitor.add(client.config.fold(target), target)
+			if full {
+				client.data.push(client.n.format(ErrMonListFull, client.nick,
+					"%d %s :Monitor list is full.", MaxMonitorEntries,
+					strings.Join(targets[i:], ",")))
+				break
+			}
+			if ok {
+				added = append(added, target)
+			}
+		}
+		client.sendMonitorStatus(added)
+	case "-":
+		for _, target := range targets {
+			client.monitor.remove(client.config.fold(target))
+		}
+	case "C":
+		client.monitor.clear()
+	case "L":
+		for _, line := range monitorLines(client.monitor.list()) {
+			client.data.push(client.n.formatSimpleReply(RplMonList, client.nick, line))
+		}
+		client.data.push(client.n.formatSimpleReply(RplEndOfMonList, client.nick,
+			"End of MONITOR list"))
+	case "S":
+		client.sendMonitorStatus(client.monitor.list())
+	default:
+		client.data.push(client.n.format(ErrUnknownCommand, client.nick,
+			"MONITOR :Unknown subcommand %s", msg.args[0]))
+	}
+}
+
+func (client *Client) sendMonitorStatus(nicks []string) {
+	online := []string{}
+	offline := []string{}
+	for _, nick := range nicks {
+		if name, ok := client.isOnline(nick); ok {
+			online = append(online, client.monitorMask(name))
+		} else {
+			offline = append(offline, nick)
+		}
+	}
+	for _, line := range monitorLines(online) {
+		client.data.push(client.n.formatSimpleReply(RplMonOnline, client.nick, line))
+	}
+	for _, line := range monitorLines(offline) {
+		client.data.push(client.n.formatSimpleReply(RplMonOffline, client.nick, line))
+	}
+}
+
+func (client *Client) monitorMask(nick string) string {
+	switch {
+	case client.config.equalFold(nick, client.config.BotNick):
+		return client.botNickUserAtHost()
+	case client.isPseudoClient(nick):
+		return client.serviceNickUserAtHost(nick)
+	default:
+		return client.getNickUserAtHost(nick)
+	}
+}
+
+// Someone came or went, so tell the client if it asked about them.
+func (client *Client) notifyMonitor(nick string, online bool) {
+	if !client.monitor.has(client.config.fold(nick)) {
+		return
+	}
+	if online {
+		client.data.push(client.n.formatSimpleReply(RplMonOnline, client.nick,
+			client.getNickUserAtHost(nick)))
+	} else {
+		client.data.push(client.n.formatSimpleReply(RplMonOffline, client.nick, nick))
+	}
+}
+
+// MONITOR replies are comma-separated lists, so there can't be a comma left at the end of a line.
+func monitorLines(nicks []string) []string {
+	if len(nicks) == 0 {
+		return []string{}
+	}
+	lines := joinIntoLines(300, nicks, ",")
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], ",")
+	}
+	return lines
+}
@@ -0,0 +1,116 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// A NickServ pseudo-service, so people can give their id code after connecting
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (client *Client) nickServReply(format string, args ...interface{}) {
+	client.serviceReplyFunc(client.config.NickServNick)(format, args...)
+}
+
+// NICKSERV and NS commands, for clients that have them as shortcuts.
+func handleNickServ(client *Client, msg Message) {
+	client.handleNickServPrivmsg(strings.Join(msg.args, " "))
+}
+
+func (client *Client) handleNickServPrivmsg(text string) {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		client.nickServReply("Try HELP.")
+		return
+	}
+	switch strings.ToUpper(words[0]) {
+	case "IDENTIFY":
+		args := words[1:]
+		if len(args) == 2 {
+			if !client.config.equalFold(args[0], client.nick) {
+				client.nickServReply("You can only identify for your own nick.")
+				return
+			}
+			args = args[1:]
+		}
+		if len(args) != 1 {
+			client.nickServReply("Usage: IDENTIFY [nick] <id code>")
+			return
+		}
+		client.identify(args[0])
+	case "HELP":
+		client.nickServReply("IDENTIFY [nick] <id code>: Log back in to PYX with an " +
+			"identification code, so other people can tell it's really you.")
+	default:
+		client.nickServReply("Unknown command %s. Try HELP.", words[0])
+	}
+}
+
+// Log out of PYX and back in with an identification code.
+func (client *Client) identify(idcode string) {
+	if client.pyx().User.IdCode != "" {
+		client.nickServReply("You are already identified.")
+		return
+	}
+	if client.inAnyGame() {
+		// logging out would take them out of the game
+		client.nickServReply("You have to leave your game before identifying.")
+		return
+	}
+
+	if client.pyxConfig.ValidateIdCode(idcode) != nil {
+		client.nickServReply("%s.", idCodeRules(client.pyxConfig))
+		return
+	}
+
+	log.Infof("Logging %s back in to PYX with an identification code", client.nick)
+	client.pyx().LogOut()
+	client.password = idcode
+	err := client.logInToPyx()
+	if err != nil {
+		log.Warningf("Unable to log %s in with an identification code: %v", client.nick, err)
+		// get them back the way they were
+		client.password = ""
+		if retryErr := client.logInToPyx(); retryErr != nil {
+			log.Errorf("Unable to log %s back in to PYX: %v", client.nick, retryErr)
+			client.disconnect(retryErr.Error())
+			return
+		}
+		client.nickServReply("Unable to identify: %v", err)
+		return
+	}
+
+	client.updateSigil(client.nick, client.pyx().User.Sigil)
+	// they may have saved some under their id code
+	client.loadPreferences()
+	if client.pyx().User.IdCode != "" {
+		client.data.push(fmt.Sprintf(":%s MODE %s :+r", client.nick, client.nick))
+	}
+	client.nickServReply("You are now identified for %s.", client.nick)
+}
+
+func (client *Client) isNickServ(nick string) bool {
+	return client.config.equalFold(nick, client.config.NickServNick)
+}
@@ -32,16 +32,30 @@ const RplWelcome = "001"
 const RplYourHost = "002"
 const RplMyInfo = "004"
 const RplISupport = "005"
+const RplSnomask = "008"
 
+const RplEndOfStats = "219"
 const RplUModeIs = "221"
+const RplStatsUptime = "242"
+const RplStatsConn = "250"
+const RplSileList = "271"
+const RplEndOfSileList = "272"
 const RplLUserClient = "251"
 const RplLUserOp = "252"
 const RplLUserChannels = "254"
 const RplLUserMe = "255"
+const RplAdminMe = "256"
+const RplAdminLoc1 = "257"
+const RplAdminLoc2 = "258"
+const RplAdminEmail = "259"
+const RplTryAgain = "263"
 const RplLocalUsers = "265"
 const RplGlobalUsers = "266"
 
+const RplAway = "301"
+const RplIson = "303"
 const RplWhoisUser = "311"
+const RplWhowasUser = "314"
 const RplWhoisServer = "312"
 const RplWhoisOperator = "313"
 const RplEndOfWho = "315"
@@ -55,9 +69,14 @@ const RplList = "322"
 const RplListEnd = "323"
 
 const RplChannelModeIs = "324"
+const RplVersion = "351"
+const RplInfo = "371"
+const RplEndOfInfo = "374"
+const RplTime = "391"
 const RplCreationTime = "329"
 const RplTopic = "332"
 const RplTopicWhoTime = "333"
+const RplInviting = "341"
 const RplWhoisBot = "335"
 const RplWho = "352"
 const RplNames = "353"
@@ -66,33 +85,67 @@ const RplBanList = "367"
 const RplEndOfBanList = "368"
 const RplEndOfWhowas = "369"
 const RplWhoisHost = "378"
+const RplRehashing = "382"
 
 // errors
+const ErrUnknownError = "400"
 const ErrNoSuchNick = "401"
 const ErrNoSuchChannel = "403"
 const ErrCannotSendToChan = "404"
 const ErrTooManyChannels = "405"
 const ErrWasNoSuchNick = "406"
 const ErrTooManyTargets = "407"
+const ErrInvalidCapCmd = "410"
 const ErrNoRecipient = "411"
 const ErrNoTextToSend = "412"
+const ErrInputTooLong = "417"
 const ErrUnknownCommand = "421"
 const ErrNoMotd = "422"
+const ErrNoAdminInfo = "423"
 const ErrNoNicknameGiven = "431"
 const ErrErroneousNickname = "432"
 const ErrNicknameInUse = "433"
 const ErrServiceConfused = "435"
 const ErrNickCollision = "436"
 const ErrNotOnChannel = "442"
+const ErrUserOnChannel = "443"
 const ErrNoNickChange = "447"
 const ErrForbiddenChannel = "448"
 const ErrNotRegistered = "451"
 const ErrNeedMoreParams = "461"
 const ErrAlreadyRegistered = "462"
+const ErrPasswdMismatch = "464"
 const ErrKeySet = "467"
 const ErrChannelIsFull = "471"
+const ErrUnknownMode = "472"
 const ErrBadChannelKey = "475"
+const ErrCannotKnock = "480"
+const ErrNoPrivileges = "481"
 const ErrChanOpPrivsNeeded = "482"
+const ErrUModeUnknownFlag = "501"
+const ErrUsersDontMatch = "502"
+const ErrSileListFull = "511"
+const ErrInvalidModeParam = "696"
+
+const RplMonOnline = "730"
+const RplMonOffline = "731"
+const RplMonList = "732"
+const RplEndOfMonList = "733"
+const ErrMonListFull = "734"
+
+const RplKnock = "710"
+const RplKnockDlvr = "711"
+const ErrTooManyKnock = "712"
+const ErrChanOpen = "713"
+const ErrKnockOnChan = "714"
+
+const RplLoggedIn = "900"
+const RplSaslSuccess = "903"
+const ErrSaslFail = "904"
+const ErrSaslTooLong = "905"
+const ErrSaslAborted = "906"
+const ErrSaslAlready = "907"
+const RplSaslMechs = "908"
 
 type numerics struct {
 	config *Config
@@ -102,11 +155,15 @@ func newNumerics(config *Config) *numerics {
 	return &numerics{config: config}
 }
 
+// A reply from the server, to have the rest of its parameters added.
+func (n *numerics) reply(numeric string, target string) *lineBuilder {
+	return newLine(n.config.AdvertisedName, numeric).param(target)
+}
+
 func (n *numerics) formatSimpleReply(numeric string, target string, msg string) string {
-	return n.format(numeric, target, ":%s", msg)
+	return n.reply(numeric, target).text(msg).String()
 }
 
 func (n *numerics) format(numeric string, target string, format string, args ...interface{}) string {
-	return fmt.Sprintf(":%s %s %s %s", n.config.AdvertisedName, numeric, target,
-		fmt.Sprintf(format, args...))
+	return n.reply(numeric, target).rawParams(fmt.Sprintf(format, args...)).String()
 }
@@ -24,34 +24,119 @@
 package irc
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
 	"regexp"
 	"strings"
 )
 
-var whitespaceRegex = regexp.MustCompile("\\s+")
+// the longest line a client may send, not counting tags or the line ending
+const MaxLineLength = 510
+
+// the longest tags a client may send, including the @ and the space after them
+const MaxTagsLength = 4096
+
+var errLineTooLong = errors.New("Input line was too long")
+var errIllegalCharacter = errors.New("Illegal character in message")
+
+// Splits what the client sends into lines, without letting a line get much longer than the limits.
+// The part of a line past that is thrown away, so cleanLine can tell it was too long.
+func splitLines() bufio.SplitFunc {
+	limit := MaxTagsLength + MaxLineLength + 2
+	discarding := false
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			if discarding {
+				discarding = false
+				return i + 1, nil, nil
+			}
+			return i + 1, bytes.TrimSuffix(data[:i], []byte("\r")), nil
+		}
+		if discarding {
+			return len(data), nil, nil
+		}
+		if len(data) > limit {
+			discarding = true
+			return len(data), data, nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), bytes.TrimSuffix(data, []byte("\r")), nil
+		}
+		return 0, nil, nil
+	}
+}
+
+func newLineScanner(connection io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(connection)
+	scanner.Buffer(make([]byte, 0, 4096), 2*(MaxTagsLength+MaxLineLength))
+	scanner.Split(splitLines())
+	return scanner
+}
+
+// Checks a line from a client before it's parsed. A line that's too long comes back cut down to the
+// limits, along with errLineTooLong; one with characters that can't be in a line can't be used at
+// all.
+func cleanLine(raw string) (string, error) {
+	if strings.ContainsAny(raw, "\x00\r\n") {
+		return "", errIllegalCharacter
+	}
+	tags, rest := "", raw
+	if strings.HasPrefix(raw, "@") {
+		tags, rest = raw, ""
+		if i := strings.IndexByte(raw, ' '); i >= 0 {
+			tags, rest = raw[:i+1], raw[i+1:]
+		}
+	}
+	var err error
+	if len(tags) > MaxTagsLength {
+		// there's no sensible way to shorten tags, so they all go
+		tags = ""
+		err = errLineTooLong
+	}
+	if len(rest) > MaxLineLength {
+		rest = rest[:MaxLineLength]
+		err = errLineTooLong
+	}
+	return tags + rest, err
+}
 
 type Message struct {
-	cmd  string
-	args []string
-	orig string
+	// IRCv3 message tags the client sent, if any
+	tags map[string]string
+	// clients aren't supposed to send one, and it's ignored if they do
+	prefix string
+	cmd    string
+	args   []string
+	orig   string
 }
 
+// [@tags] [:prefix] command [params...] [:trailing]
+// A colon only starts the trailing parameter at the beginning of a parameter, so it can be
+// anywhere inside the others.
 func NewMessage(input string) Message {
-	msg := Message{orig: input}
+	msg := Message{orig: input, args: []string{}}
 
 	input = strings.TrimSpace(input)
-	// easy case if we don't have any trail
-	if !strings.Contains(input, ":") {
-		parts := whitespaceRegex.Split(input, -1)
-		msg.cmd = parts[0]
-		msg.args = parts[1:]
-	} else {
-		// have to do this a bit more complicated
-		bigparts := strings.SplitN(input, ":", 2)
-		parts := whitespaceRegex.Split(strings.TrimSpace(bigparts[0]), -1)
-		msg.cmd = parts[0]
-		msg.args = parts[1:]
-		msg.args = append(msg.args, bigparts[1])
+	if strings.HasPrefix(input, "@") {
+		var tags string
+		tags, input = nextParam(input)
+		msg.tags = parseTags(tags[1:])
+	}
+	if strings.HasPrefix(input, ":") {
+		msg.prefix, input = nextParam(input)
+		msg.prefix = msg.prefix[1:]
+	}
+	msg.cmd, input = nextParam(input)
+	for input != "" {
+		if input[0] == ':' {
+			msg.args = append(msg.args, input[1:])
+			break
+		}
+		var arg string
+		arg, input = nextParam(input)
+		msg.args = append(msg.args, arg)
 	}
 
 	msg.cmd = strings.ToUpper(msg.cmd)
@@ -59,3 +144,90 @@ func NewMessage(input string) Message {
 	log.Debugf("Parsed message, cmd: %s args: %s", msg.cmd, msg.args)
 	return msg
 }
+
+// The value of a tag, and whether it was sent at all, since a tag doesn't need a value.
+func (msg *Message) tag(key string) (string, bool) {
+	value, ok := msg.tags[key]
+	return value, ok
+}
+
+// The tags meant for other clients, like typing notifications, with their + still on.
+func (msg *Message) clientTags() map[string]string {
+	tags := make(map[string]string)
+	for key, value := range msg.tags {
+		if strings.HasPrefix(key, "+") {
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+// Splits off everything up to the next space, and skips over however many spaces there are.
+func nextParam(input string) (string, string) {
+	i := strings.IndexByte(input, ' ')
+	if i < 0 {
+		return input, ""
+	}
+	return input[:i], strings.TrimLeft(input[i+1:], " ")
+}
+
+// [+][vendor/]name, where + marks a tag meant for other clients instead of the server
+var tagKeyRegex = regexp.MustCompile(`^\+?([a-zA-Z0-9.-]+/)?[a-zA-Z0-9-]+$`)
+
+// Parse the tags part of a message, without the leading @. Tags with invalid keys are ignored, and
+// if a key is repeated, the last one wins.
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		parts := strings.SplitN(tag, "=", 2)
+		if !tagKeyRegex.MatchString(parts[0]) {
+			continue
+		}
+		value := ""
+		if len(parts) > 1 {
+			value = unescapeTagValue(parts[1])
+		}
+		tags[parts[0]] = value
+	}
+	return tags
+}
+
+var tagValueEscapes = map[byte]string{
+	':':  ";",
+	's':  " ",
+	'\\': "\\",
+	'r':  "\r",
+	'n':  "\n",
+}
+
+func unescapeTagValue(value string) string {
+	var unescaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			unescaped.WriteByte(value[i])
+			continue
+		}
+		i++
+		if i == len(value) {
+			// a trailing backslash is just dropped
+			break
+		}
+		if replacement, ok := tagValueEscapes[value[i]]; ok {
+			unescaped.WriteString(replacement)
+		} else {
+			// unknown escapes are the character itself
+			unescaped.WriteByte(value[i])
+		}
+	}
+	return unescaped.String()
+}
+
+var tagValueEscaper = strings.NewReplacer("\\", "\\\\", ";", "\\:", " ", "\\s", "\r", "\\r",
+	"\n", "\\n")
+
+func escapeTagValue(value string) string {
+	return tagValueEscaper.Replace(value)
+}
@@ -24,6 +24,7 @@
 package irc
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -42,6 +43,18 @@ var parserTests = []parserTestPair{
 	{"privmsg #test :testing 1 2 3", "PRIVMSG", []string{"#test", "testing 1 2 3"}},
 	{"privmsg   #test    :testing 1 2 3   ", "PRIVMSG", []string{"#test", "testing 1 2 3"}},
 	{"privmsg   #test    :", "PRIVMSG", []string{"#test", ""}},
+	{"@label=abc whois bob", "WHOIS", []string{"bob"}},
+	{"@label=a:b;+draft/x privmsg #test :hi", "PRIVMSG", []string{"#test", "hi"}},
+	{"whois some:thing", "WHOIS", []string{"some:thing"}},
+	{"privmsg #global :see http://x", "PRIVMSG", []string{"#global", "see http://x"}},
+	{"privmsg #global ::)", "PRIVMSG", []string{"#global", ":)"}},
+	{"privmsg #global :a  b", "PRIVMSG", []string{"#global", "a  b"}},
+	{":alice PRIVMSG #test :hi", "PRIVMSG", []string{"#test", "hi"}},
+	{"@label=x :alice!a@b whois bob", "WHOIS", []string{"bob"}},
+	{":alice", "", []string{}},
+	{"mode #game-1 +k a:b", "MODE", []string{"#game-1", "+k", "a:b"}},
+	{"cap req :sasl message-tags", "CAP", []string{"req", "sasl message-tags"}},
+	{"ping :", "PING", []string{""}},
 }
 
 func TestNewMessage(t *testing.T) {
@@ -71,3 +84,151 @@ func TestNewMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestNewMessagePrefix(t *testing.T) {
+	for input, prefix := range map[string]string{
+		"whois bob":                  "",
+		":alice whois bob":           "alice",
+		"@label=x :a!b@c whois :bob": "a!b@c",
+	} {
+		if m := NewMessage(input); m.prefix != prefix {
+			t.Error("For", input,
+				"expected prefix", prefix,
+				"got", m.prefix,
+			)
+		}
+	}
+}
+
+type tagsTestPair struct {
+	input string
+	tags  map[string]string
+}
+
+var tagsTests = []tagsTestPair{
+	{"whois bob", map[string]string{}},
+	{"@label=abc whois bob", map[string]string{"label": "abc"}},
+	{"@a=1;b;c= ping", map[string]string{"a": "1", "b": "", "c": ""}},
+	{"@label=a\\:b\\sc\\\\d\\ ping", map[string]string{"label": "a;b c\\d"}},
+	{"@label=a\\x ping", map[string]string{"label": "ax"}},
+	{"@+typing=active;+example.com/x=1 tagmsg #test", map[string]string{"+typing": "active",
+		"+example.com/x": "1"}},
+	{"@bad!key=1;a=1;a=2 ping", map[string]string{"a": "2"}},
+	{"@=1;a!b=2;/c=3;d/=4 ping", map[string]string{}},
+	{"@a=x=y ping", map[string]string{"a": "x=y"}},
+}
+
+func TestMessageTags(t *testing.T) {
+	for _, test := range tagsTests {
+		m := NewMessage(test.input)
+		if len(m.tags) != len(test.tags) {
+			t.Error("For", test.input,
+				"expected tags", test.tags,
+				"got", m.tags,
+			)
+			continue
+		}
+		for key, value := range test.tags {
+			if m.tags[key] != value {
+				t.Error("For", test.input,
+					"expected tag", key,
+					"to be", value,
+					"got", m.tags[key],
+				)
+			}
+		}
+	}
+}
+
+func TestMessageTagAccessors(t *testing.T) {
+	m := NewMessage("@label=abc;+typing=active;draft/x privmsg #test :hi")
+	if value, ok := m.tag("draft/x"); !ok || value != "" {
+		t.Error("For draft/x expected present and empty, got", value, ok)
+	}
+	if _, ok := m.tag("msgid"); ok {
+		t.Error("For msgid expected missing")
+	}
+	if tags := m.clientTags(); len(tags) != 1 || tags["+typing"] != "active" {
+		t.Error("For client tags expected +typing=active, got", tags)
+	}
+}
+
+func TestEscapeTagValue(t *testing.T) {
+	for _, value := range []string{"", "abc", "a;b c\\d", "a\r\nb"} {
+		if unescapeTagValue(escapeTagValue(value)) != value {
+			t.Error("For", value,
+				"expected", value,
+				"got", unescapeTagValue(escapeTagValue(value)),
+			)
+		}
+	}
+}
+
+type cleanLineTestPair struct {
+	input  string
+	output string
+	err    error
+}
+
+var longTags = "@" + strings.Repeat("a", MaxTagsLength) + " "
+
+var cleanLineTests = []cleanLineTestPair{
+	{"privmsg #test :hi", "privmsg #test :hi", nil},
+	{"privmsg #test :a\x00b", "", errIllegalCharacter},
+	{"privmsg #test :a\rb", "", errIllegalCharacter},
+	{strings.Repeat("a", MaxLineLength), strings.Repeat("a", MaxLineLength), nil},
+	{strings.Repeat("a", MaxLineLength+1), strings.Repeat("a", MaxLineLength), errLineTooLong},
+	{"@label=x " + strings.Repeat("a", MaxLineLength), "@label=x " + strings.Repeat("a", MaxLineLength),
+		nil},
+	{longTags + "ping x", "ping x", errLineTooLong},
+}
+
+func TestCleanLine(t *testing.T) {
+	for _, test := range cleanLineTests {
+		output, err := cleanLine(test.input)
+		if output != test.output || err != test.err {
+			t.Error("For", test.input,
+				"expected", test.output, test.err,
+				"got", output, err,
+			)
+		}
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	long := strings.Repeat("x", 3*(MaxTagsLength+MaxLineLength))
+	scanner := newLineScanner(strings.NewReader("nick a\r\n" + long + "\r\nuser b\nquit"))
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Error("For a long line expected no error, got", err)
+	}
+	if len(lines) != 4 || lines[0] != "nick a" || lines[2] != "user b" || lines[3] != "quit" {
+		t.Error("For a long line expected 4 lines, got", len(lines))
+	} else if _, err := cleanLine(lines[1]); err != errLineTooLong {
+		t.Error("For a long line expected", errLineTooLong, "got", err)
+	}
+}
+
+func FuzzNewMessage(f *testing.F) {
+	for _, test := range parserTests {
+		f.Add(test.input)
+	}
+	f.Add("@a=\\ ;b :x y :z")
+	f.Add(":prefix ")
+	f.Fuzz(func(t *testing.T, input string) {
+		line, err := cleanLine(input)
+		if err == errIllegalCharacter {
+			return
+		}
+		if len(line) > MaxTagsLength+MaxLineLength {
+			t.Error("For", input, "expected a line no longer than the limits, got", len(line))
+		}
+		msg := NewMessage(line)
+		if msg.cmd != strings.ToUpper(msg.cmd) {
+			t.Error("For", input, "expected an upper case command, got", msg.cmd)
+		}
+	})
+}
@@ -0,0 +1,362 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Per-user preferences, kept in a file so they survive reconnecting
+
+package irc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/ajanata/pyx-irc/pyx"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Preferences struct {
+	// option=value settings for GameServ CREATE, when none are given
+	GameOptions []string `json:"game_options,omitempty"`
+	// channels to join after registering
+	AutoJoin []string `json:"auto_join,omitempty"`
+	// which chat that PYX filtered out for everyone else to show, one of the FilteredChat values
+	FilteredChat string `json:"filtered_chat,omitempty"`
+	// don't show joins and quits in the global channel for people who don't say anything
+	QuietJoins bool `json:"quiet_joins,omitempty"`
+	// don't relay global chat at all, set with MODE #global +m
+	MuteGlobal bool `json:"mute_global,omitempty"`
+	// mIRC bold, colors and underlines in card announcements
+	Colors bool `json:"colors,omitempty"`
+	// leave the black card out of the game channel topic, so it doesn't change every round
+	HideTopicCard bool `json:"hide_topic_card,omitempty"`
+	// nick!user@host masks to drop chat from, set with SILENCE or IGNORE
+	Silence []string `json:"silence,omitempty"`
+}
+
+// FilteredChat
+const (
+	FilteredChat_ALL  = "all"
+	FilteredChat_MINE = "mine"
+	FilteredChat_NONE = "none"
+)
+
+// A hash of the identification code the preferences were saved with, so someone else using the
+// same nick doesn't get them.
+type preferencesEntry struct {
+	Preferences
+	IdCodeHash string `json:"idc,omitempty"`
+}
+
+// The preferences for one file, or kept only in memory if there isn't one. This is the same kind
+// of JSON file as the K-lines rather than SQLite or BoltDB: it's one small entry per person that
+// only changes when they SET something, and either of those would drag in cgo or another
+// dependency to keep a few kilobytes.
+type preferenceStore struct {
+	lock    sync.Mutex
+	file    jsonFile
+	entries map[string]preferencesEntry
+}
+
+var preferenceStoresLock sync.Mutex
+
+// by file, since servers may share one
+var preferenceStores = make(map[string]*preferenceStore)
+
+func getPreferenceStore(config *Config) *preferenceStore {
+	preferenceStoresLock.Lock()
+	defer preferenceStoresLock.Unlock()
+	store, ok := preferenceStores[config.PreferencesFile]
+	if !ok {
+		store = newPreferenceStore(config.PreferencesFile)
+		preferenceStores[config.PreferencesFile] = store
+	}
+	return store
+}
+
+func newPreferenceStore(path string) *preferenceStore {
+	return &preferenceStore{
+		file:    jsonFile{path: path},
+		entries: make(map[string]preferencesEntry),
+	}
+}
+
+func hashIdCode(idCode string) string {
+	if idCode == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(idCode))
+	return hex.EncodeToString(sum[:])
+}
+
+// Must be called with the lock held.
+func (store *preferenceStore) load() error {
+	err := store.file.load(&store.entries)
+	if err != nil {
+		log.Errorf("Unable to load preferences: %v", err)
+	}
+	return err
+}
+
+func (store *preferenceStore) get(key string, idCode string) Preferences {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.load()
+	entry, ok := store.entries[key]
+	if !ok || entry.IdCodeHash != hashIdCode(idCode) {
+		return Preferences{}
+	}
+	return entry.Preferences
+}
+
+func (store *preferenceStore) put(key string, idCode string, prefs Preferences) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if err := store.load(); err != nil {
+		// so everyone else's aren't replaced with the few we have in memory
+		return err
+	}
+	store.entries[key] = preferencesEntry{Preferences: prefs, IdCodeHash: hashIdCode(idCode)}
+	return store.file.save(store.entries)
+}
+
+// Preferences follow a client certificate if there is one, otherwise the nick and id code.
+func (client *Client) preferencesKey() (string, string) {
+	if client.certFingerprint != "" {
+		return "cert:" + client.certFingerprint, ""
+	}
+	return "nick:" + client.config.fold(client.nick), client.password
+}
+
+func (client *Client) loadPreferences() {
+	key, idCode := client.preferencesKey()
+	client.setPreferences(getPreferenceStore(client.config).get(key, idCode))
+}
+
+// Everything but the silence list is only changed on the command goroutine.
+func (client *Client) setPreferences(prefs Preferences) {
+	client.silenceLock.Lock()
+	defer client.silenceLock.Unlock()
+	client.prefs = prefs
+}
+
+func (client *Client) savePreferences() error {
+	key, idCode := client.preferencesKey()
+	client.silenceLock.Lock()
+	prefs := client.prefs
+	client.silenceLock.Unlock()
+	return getPreferenceStore(client.config).put(key, idCode, prefs)
+}
+
+// Join the channels they asked for, then the ones the server does for everyone. Called after the
+// welcome, so the global channel (and a game we picked back up) are already taken care of.
+func (client *Client) applyAutoJoin() {
+	for _, channel := range append(append([]string{}, client.prefs.AutoJoin...),
+		client.config.AutoJoin...) {
+		if client.config.equalFold(channel, client.config.GlobalChannel) ||
+			client.config.equalFold(channel, client.getGameChannel()) ||
+			(client.isAdminChannel(channel) && client.inAdminChannel()) {
+			continue
+		}
+		if _, _, err := client.getGameFromChannel(channel); err == nil && client.inAnyGame() {
+			// only one game at a time, and the first one they got into wins
+			log.Debugf("Not auto-joining %s to %s, already in %s", client.nick, channel,
+				client.getGameChannel())
+			continue
+		}
+		log.Debugf("Auto-joining %s to %s", client.nick, channel)
+		handleJoin(client, Message{cmd: "JOIN", args: []string{channel}})
+	}
+}
+
+type preferenceSetting struct {
+	help string
+	get  func(*Preferences) string
+	// no args resets it
+	set func(*Preferences, []string) error
+}
+
+var PreferenceSettings = map[string]preferenceSetting{
+	"AUTOJOIN": {
+		help: "Channels to join when you connect, separated by spaces or commas.",
+		get: func(prefs *Preferences) string {
+			return strings.Join(prefs.AutoJoin, ",")
+		},
+		set: func(prefs *Preferences, args []string) error {
+			prefs.AutoJoin = strings.FieldsFunc(strings.Join(args, ","), func(r rune) bool {
+				return r == ','
+			})
+			return nil
+		},
+	},
+	"COLORS": {
+		help: "Use bold, colors and underlines to make cards and winners stand out (on or off).",
+		get: func(prefs *Preferences) string {
+			return onOff(prefs.Colors)
+		},
+		set: func(prefs *Preferences, args []string) error {
+			return parseOnOff(&prefs.Colors, args)
+		},
+	},
+	"FILTERED": {
+		help: "Which chat that the server filtered out for everyone else to show: all, mine " +
+			"(only the global channel and your game), or none.",
+		get: func(prefs *Preferences) string {
+			if prefs.FilteredChat == "" {
+				return FilteredChat_ALL
+			}
+			return prefs.FilteredChat
+		},
+		set: func(prefs *Preferences, args []string) error {
+			if len(args) == 0 {
+				prefs.FilteredChat = ""
+				return nil
+			}
+			value := strings.ToLower(strings.Join(args, " "))
+			switch value {
+			case FilteredChat_ALL, FilteredChat_MINE, FilteredChat_NONE:
+				prefs.FilteredChat = value
+				return nil
+			default:
+				return fmt.Errorf("Expected all, mine, or none, not %s", value)
+			}
+		},
+	},
+	"GAMEOPTIONS": {
+		help: "Options for GameServ CREATE when you don't give any, like score=10 players=6.",
+		get: func(prefs *Preferences) string {
+			return strings.Join(prefs.GameOptions, " ")
+		},
+		set: func(prefs *Preferences, args []string) error {
+			if err := parseGameOptions(&pyx.GameOptionData{}, args); err != nil {
+				return err
+			}
+			prefs.GameOptions = args
+			return nil
+		},
+	},
+	"TOPICCARD": {
+		help: "Show the current black card in the game channel topic, changing it every round " +
+			"(on or off).",
+		get: func(prefs *Preferences) string {
+			return onOff(!prefs.HideTopicCard)
+		},
+		set: func(prefs *Preferences, args []string) error {
+			if len(args) == 0 {
+				prefs.HideTopicCard = false
+				return nil
+			}
+			show := true
+			if err := parseOnOff(&show, args); err != nil {
+				return err
+			}
+			prefs.HideTopicCard = !show
+			return nil
+		},
+	},
+	"MUTEGLOBAL": {
+		help: "Don't show any chat in the global channel, like MODE +m on it (on or off).",
+		get: func(prefs *Preferences) string {
+			return onOff(prefs.MuteGlobal)
+		},
+		set: func(prefs *Preferences, args []string) error {
+			return parseOnOff(&prefs.MuteGlobal, args)
+		},
+	},
+	"QUIETJOINS": {
+		help: "Hide joins and quits in the global channel for people who don't say anything " +
+			"(on or off).",
+		get: func(prefs *Preferences) string {
+			return onOff(prefs.QuietJoins)
+		},
+		set: func(prefs *Preferences, args []string) error {
+			return parseOnOff(&prefs.QuietJoins, args)
+		},
+	},
+}
+
+func onOff(value bool) string {
+	if value {
+		return "on"
+	}
+	return "off"
+}
+
+func parseOnOff(value *bool, args []string) error {
+	if len(args) == 0 {
+		*value = false
+		return nil
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("Expected on or off")
+	}
+	switch strings.ToLower(args[0]) {
+	case "on", "yes", "true", "1":
+		*value = true
+	case "off", "no", "false", "0":
+		*value = false
+	default:
+		return fmt.Errorf("Expected on or off, not %s", args[0])
+	}
+	return nil
+}
+
+func botPreferences(client *Client, reply BotReplyFunc, args []string) {
+	names := []string{}
+	for name := range PreferenceSettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		setting := PreferenceSettings[name]
+		reply("%s = %s: %s", name, setting.get(&client.prefs), setting.help)
+	}
+	if client.config.PreferencesFile == "" {
+		reply("Preferences are not saved on this server, and only last until you disconnect.")
+	}
+}
+
+func botSet(client *Client, reply BotReplyFunc, args []string) {
+	if len(args) == 0 {
+		reply("Usage: SET <preference> [value]. Try PREFERENCES to see them all.")
+		return
+	}
+	name := strings.ToUpper(args[0])
+	setting, ok := PreferenceSettings[name]
+	if !ok {
+		reply("No such preference %s. Try PREFERENCES to see them all.", args[0])
+		return
+	}
+	prefs := client.prefs
+	if err := setting.set(&prefs, args[1:]); err != nil {
+		reply("Unable to set %s: %s", name, err)
+		return
+	}
+	client.setPreferences(prefs)
+	if err := client.savePreferences(); err != nil {
+		log.Errorf("Unable to save preferences for %s: %v", client.nick, err)
+		reply("%s is now %s, but it could not be saved: %s", name, setting.get(&prefs), err)
+		return
+	}
+	reply("%s is now %s.", name, setting.get(&prefs))
+}
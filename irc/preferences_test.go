@@ -0,0 +1,146 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type onOffTestPair struct {
+	args   []string
+	output bool
+	ok     bool
+}
+
+var onOffTests = []onOffTestPair{
+	{[]string{}, false, true},
+	{[]string{"on"}, true, true},
+	{[]string{"YES"}, true, true},
+	{[]string{"off"}, false, true},
+	{[]string{"maybe"}, false, false},
+	{[]string{"on", "off"}, false, false},
+}
+
+func TestParseOnOff(t *testing.T) {
+	for _, pair := range onOffTests {
+		var value bool
+		err := parseOnOff(&value, pair.args)
+		if (err == nil) != pair.ok || (pair.ok && value != pair.output) {
+			t.Error("For", pair.args,
+				"expected", pair.output, pair.ok,
+				"got", value, err,
+			)
+		}
+	}
+}
+
+func TestPreferenceStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prefs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prefs.json")
+
+	prefs := Preferences{AutoJoin: []string{"#game-1"}, QuietJoins: true}
+	store := newPreferenceStore(path)
+	if err := store.put("nick:bob", "secret", prefs); err != nil {
+		t.Fatal(err)
+	}
+
+	// a fresh store has to read them back from the file
+	store = newPreferenceStore(path)
+	if got := store.get("nick:bob", "secret"); !reflect.DeepEqual(got, prefs) {
+		t.Error("For", "the right id code", "expected", prefs, "got", got)
+	}
+	if got := store.get("nick:bob", "guess"); !reflect.DeepEqual(got, Preferences{}) {
+		t.Error("For", "the wrong id code", "expected", Preferences{}, "got", got)
+	}
+	if got := store.get("nick:alice", ""); !reflect.DeepEqual(got, Preferences{}) {
+		t.Error("For", "another nick", "expected", Preferences{}, "got", got)
+	}
+}
+
+func TestPreferenceStoreCorruptFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prefs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prefs.json")
+	corrupt := []byte(`{"nick:bob": {"quiet_joins": true}`)
+	if err = ioutil.WriteFile(path, corrupt, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newPreferenceStore(path)
+	if err = store.put("nick:alice", "", Preferences{Colors: true}); err == nil {
+		t.Error("For", "put with a corrupt file", "expected", "an error", "got", err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != string(corrupt) {
+		t.Error("For", "a corrupt file", "expected", string(corrupt), "got", string(data))
+	}
+
+	// once it's fixed, everyone else's are still there
+	if err = ioutil.WriteFile(path, append(corrupt, '}'), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.put("nick:alice", "", Preferences{Colors: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := newPreferenceStore(path).get("nick:bob", ""); !got.QuietJoins {
+		t.Error("For", "bob after fixing the file", "expected", "quiet joins", "got", got)
+	}
+}
+
+type filteredChatTestPair struct {
+	args   []string
+	output string
+	ok     bool
+}
+
+var filteredChatTests = []filteredChatTestPair{
+	{[]string{}, "all", true},
+	{[]string{"mine"}, "mine", true},
+	{[]string{"NONE"}, "none", true},
+	{[]string{"some"}, "all", false},
+}
+
+func TestFilteredChatSetting(t *testing.T) {
+	setting := PreferenceSettings["FILTERED"]
+	for _, pair := range filteredChatTests {
+		prefs := Preferences{}
+		err := setting.set(&prefs, pair.args)
+		if (err == nil) != pair.ok || setting.get(&prefs) != pair.output {
+			t.Error("For", pair.args,
+				"expected", pair.output, pair.ok,
+				"got", setting.get(&prefs), err,
+			)
+		}
+	}
+}
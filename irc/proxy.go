@@ -0,0 +1,168 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long a load balancer gets to send the PROXY header before we give up on the connection
+const ProxyHeaderTimeout = 10 * time.Second
+
+var proxyV1Prefix = []byte("PROXY ")
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// A connection that has had a PROXY protocol header read off of it, and reports the address that
+// the load balancer told us about instead of the load balancer's own address.
+type proxyConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func (conn *proxyConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// Reads a PROXY v1 or v2 header from the connection, and returns a connection which reports the
+// real client's address.
+func newProxyConn(connection net.Conn) (net.Conn, error) {
+	connection.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout))
+	defer connection.SetReadDeadline(time.Time{})
+
+	reader := bufio.NewReader(connection)
+	addr, err := readProxyHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if addr == nil {
+		// LOCAL or UNKNOWN, which means the load balancer itself is the client
+		addr = connection.RemoteAddr()
+	}
+	return &proxyConn{Conn: connection, reader: reader, remoteAddr: addr}, nil
+}
+
+// Returns a nil address for headers which do not carry the client's address.
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	peek, err := reader.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(peek, proxyV1Prefix) {
+		return readProxyV1Header(reader)
+	}
+	peek, err = reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(peek, proxyV2Signature) {
+		return readProxyV2Header(reader)
+	}
+	return nil, errors.New("missing PROXY header")
+}
+
+func readProxyV1Header(reader *bufio.Reader) (net.Addr, error) {
+	// the spec limits the header to 107 bytes including the CRLF
+	line := make([]byte, 0, 107)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) == cap(line) {
+			return nil, errors.New("PROXY header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("malformed PROXY header")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("malformed PROXY header")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errors.New("malformed PROXY header")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyV2Header(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, errors.New("unsupported PROXY version")
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	if header[12]&0xF == 0 {
+		// LOCAL, e.g. health checks from the load balancer
+		return nil, nil
+	}
+	switch header[13] >> 4 {
+	case 1:
+		// IPv4: source address, destination address, source port, destination port
+		if len(payload) < 12 {
+			return nil, errors.New("truncated PROXY header")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 2:
+		// IPv6: same layout, bigger addresses
+		if len(payload) < 36 {
+			return nil, errors.New("truncated PROXY header")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	default:
+		// UNSPEC or unix sockets
+		return nil, nil
+	}
+}
@@ -0,0 +1,76 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+type proxyHeaderTestPair struct {
+	input  string
+	output string
+	rest   string
+}
+
+var proxyHeaderTests = []proxyHeaderTestPair{
+	{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 6667\r\nNICK a\r\n", "192.0.2.1:56324", "NICK a\r\n"},
+	{"PROXY TCP6 2001:db8::1 2001:db8::2 56324 6667\r\n", "[2001:db8::1]:56324", ""},
+	{"PROXY UNKNOWN\r\nNICK a\r\n", "", "NICK a\r\n"},
+	{"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c\xc0\x00\x02\x01\xc6\x33\x64\x01\xdc\x04\x1a\x0bNICK a\r\n",
+		"192.0.2.1:56324", "NICK a\r\n"},
+	{"\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00NICK a\r\n", "", "NICK a\r\n"},
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	for _, test := range proxyHeaderTests {
+		reader := bufio.NewReader(strings.NewReader(test.input))
+		addr, err := readProxyHeader(reader)
+		if err != nil {
+			t.Error("For", test.input, "got error", err)
+			continue
+		}
+		out := ""
+		if addr != nil {
+			out = addr.String()
+		}
+		if out != test.output {
+			t.Error("For", test.input, "expected", test.output, "got", out)
+		}
+		rest, _ := reader.ReadString(0)
+		if rest != test.rest {
+			t.Error("For", test.input, "expected remaining", test.rest, "got", rest)
+		}
+	}
+}
+
+func TestReadProxyHeaderInvalid(t *testing.T) {
+	for _, input := range []string{"NICK a\r\n", "PROXY TCP4 nope\r\n", "PROXY TCP4 1.2.3.4 5.6.7.8 1 2\n"} {
+		_, err := readProxyHeader(bufio.NewReader(strings.NewReader(input)))
+		if err == nil {
+			t.Error("Expected error for", input)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"time"
+)
+
+// A simple token bucket. Not safe for concurrent use.
+type tokenBucket struct {
+	capacity float64
+	// tokens added per second
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(capacity int, perMinute int) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(capacity),
+		rate:     float64(perMinute) / 60,
+		tokens:   float64(capacity),
+		last:     time.Now(),
+	}
+}
+
+func (bucket *tokenBucket) refill() {
+	now := time.Now()
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	if bucket.tokens > bucket.capacity {
+		bucket.tokens = bucket.capacity
+	}
+	bucket.last = now
+}
+
+// Takes a token if one is available.
+func (bucket *tokenBucket) take() bool {
+	bucket.refill()
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// How long until the next token is available.
+func (bucket *tokenBucket) wait() time.Duration {
+	bucket.refill()
+	if bucket.tokens >= 1 || bucket.rate <= 0 {
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+}
+
+func (bucket *tokenBucket) full() bool {
+	bucket.refill()
+	return bucket.tokens >= bucket.capacity
+}
@@ -0,0 +1,80 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Realnames from USER and SETNAME. PYX doesn't have them, so we only know them for people using the
+// bridge; everyone else's realname is their nick.
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// advertised as NAMELEN, anything longer from USER is cut off
+const MaxRealnameLength = 50
+
+func (r *roster) setRealname(nick string, realname string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if realname == "" {
+		delete(r.realnames, strings.ToLower(nick))
+	} else {
+		r.realnames[strings.ToLower(nick)] = realname
+	}
+}
+
+func (r *roster) realname(nick string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.realnameLocked(nick)
+}
+
+// Must be called with the lock held.
+func (r *roster) realnameLocked(nick string) string {
+	if realname, ok := r.realnames[strings.ToLower(nick)]; ok {
+		return realname
+	}
+	return nick
+}
+
+// SETNAME :new realname
+func handleSetname(client *Client, msg Message) {
+	if len(msg.args) == 0 || msg.args[0] == "" {
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"SETNAME :Not enough parameters"))
+		return
+	}
+	realname := msg.args[0]
+	if len(realname) > MaxRealnameLength {
+		client.data.push(fmt.Sprintf(":%s FAIL SETNAME INVALID_REALNAME :Realname is too long",
+			client.config.AdvertisedName))
+		return
+	}
+	client.realname = realname
+	client.roster.setRealname(client.nick, realname)
+	if client.hasCap("setname") {
+		client.data.push(fmt.Sprintf(":%s SETNAME :%s", client.getNickUserAtHost(client.nick),
+			realname))
+	}
+}
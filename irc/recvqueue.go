@@ -0,0 +1,115 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Lines a client has sent that haven't been handled yet
+
+package irc
+
+import (
+	"sync"
+)
+
+// Everything a client sends is read as soon as it arrives, and waits here while they're being
+// slowed down for flooding. That way a burst of commands, like the ones sent right after
+// connecting, only has to wait its turn, and it's only how much is piling up that gets someone
+// disconnected.
+type recvQueue struct {
+	lock  sync.Mutex
+	lines []string
+	// bytes waiting, and how many there can be, or 0 for no limit
+	size   int
+	limit  int
+	closed bool
+	// has something in it when the handler should look again
+	ready chan bool
+	// closed once the connection is gone, so the handler doesn't wait around for its turn
+	gone chan bool
+}
+
+func newRecvQueue(limit int) *recvQueue {
+	return &recvQueue{limit: limit, ready: make(chan bool, 1), gone: make(chan bool)}
+}
+
+// Must be called with the lock held.
+func (q *recvQueue) wake() {
+	select {
+	case q.ready <- true:
+	default:
+		// it's already going to look
+	}
+}
+
+// Add a line that was read. Returns false without adding it if that would put more than the limit
+// waiting; one line on its own is always let in, however long it is.
+func (q *recvQueue) push(line string) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.limit > 0 && len(q.lines) > 0 && q.size+len(line) > q.limit {
+		return false
+	}
+	q.lines = append(q.lines, line)
+	q.size += len(line)
+	q.wake()
+	return true
+}
+
+// Nothing else is going to be read.
+func (q *recvQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	q.wake()
+}
+
+// The connection is gone, so nobody is around to see what anything still waiting would do. Throws
+// it all away and closes the queue. Must only be called once.
+func (q *recvQueue) abandon() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.lines = nil
+	q.size = 0
+	q.closed = true
+	close(q.gone)
+	q.wake()
+}
+
+// Wait for the next line to handle. Returns false once the queue is closed and there's nothing
+// left in it.
+func (q *recvQueue) next() (string, bool) {
+	for {
+		q.lock.Lock()
+		if len(q.lines) > 0 {
+			line := q.lines[0]
+			q.lines = q.lines[1:]
+			q.size -= len(line)
+			q.lock.Unlock()
+			return line, true
+		}
+		closed := q.closed
+		q.lock.Unlock()
+		if closed {
+			return "", false
+		}
+		<-q.ready
+	}
+}
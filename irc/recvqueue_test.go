@@ -0,0 +1,61 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestRecvQueueLimit(t *testing.T) {
+	q := newRecvQueue(10)
+	if !q.push("a very long line") {
+		t.Error("For one long line expected it to be let in, got false")
+	}
+	if q.push("b") {
+		t.Error("For a line past the limit expected false, got true")
+	}
+	if line, ok := q.next(); line != "a very long line" || !ok {
+		t.Error("For the long line expected it back, got", line, ok)
+	}
+	for _, line := range []string{"abcd", "efgh"} {
+		if !q.push(line) {
+			t.Error("For", line, "expected it to fit, got false")
+		}
+	}
+	if q.push("ijk") {
+		t.Error("For ijk expected false with 8 bytes waiting, got true")
+	}
+}
+
+func TestRecvQueueClose(t *testing.T) {
+	q := newRecvQueue(0)
+	q.push("a")
+	q.close()
+	if line, ok := q.next(); line != "a" || !ok {
+		t.Error("For a closed queue with a in it expected a true, got", line, ok)
+	}
+	if line, ok := q.next(); ok {
+		t.Error("For an empty closed queue expected false, got", line, ok)
+	}
+}
@@ -0,0 +1,92 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Picking up configuration changes without restarting. Only the settings that are safe to change
+// under connected clients are taken; everything else needs a restart.
+
+package irc
+
+import (
+	"errors"
+)
+
+// Set by main to load the configuration file again.
+var ReloadConfig func() ([]Config, error)
+
+// Apply the reloadable settings from configs to the servers they match.
+func Rehash(configs []Config) {
+	managersLock.Lock()
+	defer managersLock.Unlock()
+	for _, manager := range managers {
+		for i := range configs {
+			if configs[i].BindAddress == manager.config.BindAddress &&
+				configs[i].Port == manager.config.Port {
+				manager.rehash(&configs[i])
+				break
+			}
+		}
+	}
+}
+
+func (manager *Manager) rehash(config *Config) {
+	log.Infof("Rehashing server on %d", manager.config.Port)
+	manager.clientsLock.RLock()
+	defer manager.clientsLock.RUnlock()
+	before := make(map[*Client]map[string]string)
+	for client := range manager.clients {
+		before[client] = client.availableCaps()
+	}
+	manager.caps.update(config)
+	for client, caps := range before {
+		client.notifyCapChanges(caps)
+	}
+}
+
+func rehash() error {
+	if ReloadConfig == nil {
+		return errors.New("Reloading isn't supported")
+	}
+	configs, err := ReloadConfig()
+	if err != nil {
+		return err
+	}
+	Rehash(configs)
+	return nil
+}
+
+func handleRehash(client *Client, msg Message) {
+	if !client.pyx().User.IsAdmin() {
+		client.data.push(client.n.formatSimpleReply(ErrNoPrivileges, client.nick,
+			"Permission Denied- You're not a PYX administrator"))
+		return
+	}
+	client.data.push(client.n.format(RplRehashing, client.nick, "pyx-irc.toml :Rehashing"))
+	announceToAdmins(SnoPyx, "%s is rehashing the server configuration", client.nick)
+	// this has to talk to every client, including this one, so it can't block this goroutine
+	go func() {
+		if err := rehash(); err != nil {
+			log.Errorf("Unable to rehash: %v", err)
+			announceToAdmins(SnoPyx, "Unable to rehash: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,172 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Riding out PYX restarts: telling everyone, keeping new people out, and logging them back in
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// how many times to try logging someone back in after PYX went away before giving up on them
+const RelogAttempts = 10
+
+// past this, let people try to log in to a server we think is down, in case we missed it coming
+// back
+const DrainingTimeout = 2 * time.Minute
+
+// PYX servers that went away, by address, and when we noticed. Nobody new gets to log in to them
+// until they're back.
+var drainingServers = struct {
+	lock    sync.Mutex
+	servers map[string]time.Time
+}{servers: make(map[string]time.Time)}
+
+func setDraining(pyxConfig *pyx.Config, draining bool) {
+	drainingServers.lock.Lock()
+	defer drainingServers.lock.Unlock()
+	if !draining {
+		delete(drainingServers.servers, pyxConfig.BaseAddress)
+	} else if _, ok := drainingServers.servers[pyxConfig.BaseAddress]; !ok {
+		drainingServers.servers[pyxConfig.BaseAddress] = time.Now()
+	}
+}
+
+func isDraining(pyxConfig *pyx.Config) bool {
+	drainingServers.lock.Lock()
+	defer drainingServers.lock.Unlock()
+	since, ok := drainingServers.servers[pyxConfig.BaseAddress]
+	return ok && time.Since(since) < DrainingTimeout
+}
+
+// Nothing before the first attempt, since PYX is usually already back by the time we find out our
+// session is gone, then 5s, 10s, 20s, and 30s from then on.
+func relogDelay(attempt int) time.Duration {
+	if attempt <= 1 {
+		return 0
+	}
+	delay := 5 * time.Second << uint(attempt-2)
+	if delay > 30*time.Second || delay <= 0 {
+		delay = 30 * time.Second
+	}
+	return delay
+}
+
+// Commands that don't need PYX, so they still work while we're waiting to log back in.
+var OfflineCommands = map[string]bool{
+	"ADMIN":   true,
+	"CAP":     true,
+	"INFO":    true,
+	"MOTD":    true,
+	"PING":    true,
+	"PONG":    true,
+	"QUIT":    true,
+	"TIME":    true,
+	"VERSION": true,
+}
+
+func (client *Client) isPyxDown() bool {
+	return atomic.LoadInt32(&client.pyxDown) != 0
+}
+
+func (client *Client) setPyxDown(down bool) {
+	if down {
+		atomic.StoreInt32(&client.pyxDown, 1)
+	} else {
+		atomic.StoreInt32(&client.pyxDown, 0)
+	}
+}
+
+// The bot tells them in every channel they're in, since that's where they're looking.
+func (client *Client) noticeChannels(format string, args ...interface{}) {
+	channels := []string{}
+	if client.inGlobalChannel() {
+		channels = append(channels, client.config.GlobalChannel)
+	}
+	if client.inAnyGame() {
+		channels = append(channels, client.getGameChannel())
+	}
+	for _, channel := range channels {
+		client.data.push(newLine(client.botNickUserAtHost(), "NOTICE").param(channel).
+			textf(format, args...).String())
+	}
+}
+
+func eventServerDown(client *Client, e pyx.Event) {
+	event := e.(*pyx.ServerDownEvent)
+	client.setPyxDown(true)
+	if !isDraining(client.pyxConfig) {
+		announceToAdmins(SnoPyx, "PYX at %s stopped responding, not letting anyone new log in",
+			client.pyxConfig.BaseAddress)
+	}
+	setDraining(client.pyxConfig, true)
+	client.noticeChannels("PYX hasn't answered for %s, it may be restarting. You'll be logged "+
+		"back in once it's back.", time.Since(event.Since).Round(time.Second))
+}
+
+func eventServerRestarted(client *Client, e pyx.Event) {
+	client.setPyxDown(true)
+	client.noticeChannels("PYX restarted and forgot about everyone, logging you back in.")
+}
+
+// PYX came back without their session, so log them in again the same way as when they connected.
+// Runs on the event goroutine for the old session, after it's done.
+func (client *Client) logBackIn() {
+	// whatever they were doing on the old session is gone
+	if client.inAnyGame() {
+		client.data.push(newLine(client.botNickUserAtHost(), "KICK").
+			param(client.getGameChannel(), client.nick).
+			text("The game ended when PYX went away.").String())
+		client.leftGame()
+	}
+	client.roster.invalidate()
+
+	for attempt := 1; attempt <= RelogAttempts; attempt++ {
+		select {
+		case <-client.done:
+			return
+		case <-time.After(relogDelay(attempt)):
+		}
+		err := client.logInToPyx()
+		if err == nil {
+			client.setPyxDown(false)
+			setDraining(client.pyxConfig, false)
+			announceToAdmins(SnoPyx, "Logged %s back in to PYX after it went away", client.nick)
+			client.sendServerNotice("Logged back in to PYX.")
+			return
+		}
+		log.Warningf("Unable to log %s back in to PYX (%d/%d): %v", client.nick, attempt,
+			RelogAttempts, err)
+		if pyx.ErrorCode(err) != "" {
+			// it's back, but won't have them, like if someone took their nick in the meantime
+			client.disconnect("Unable to log back in to PYX: " + err.Error())
+			return
+		}
+	}
+	announceToAdmins(SnoPyx, "Gave up logging %s back in to PYX", client.nick)
+	client.disconnect("Unable to log back in to PYX.")
+}
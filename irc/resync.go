@@ -0,0 +1,81 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Getting back in step with PYX when what it tells us doesn't match what we thought was going on
+
+package irc
+
+import (
+	"strconv"
+)
+
+// Which game PYX says we're in, and whether we're only spectating it, or nil if we aren't in one.
+func (client *Client) pyxGameMembership() (*int, bool, error) {
+	games, err := client.pyx().FreshGameList()
+	if err != nil {
+		return nil, false, err
+	}
+	for _, game := range games.Games {
+		id := game.Id
+		if stringSet(game.Players)[client.pyx().User.Name] {
+			return &id, false, nil
+		}
+		if stringSet(game.Spectators)[client.pyx().User.Name] {
+			return &id, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Something showed we don't agree with PYX about which game we're in, so ask it and fix up the
+// channels they're in to match, instead of carrying on with the wrong idea. what says what
+// happened, for the logs.
+func (client *Client) resync(what string) {
+	gameId, spectate, err := client.pyxGameMembership()
+	if err != nil {
+		log.Errorf("Unable to resynchronize %s with PYX (%s): %v", client.nick, what, err)
+		return
+	}
+	current, joined := client.currentGame()
+	if gameId == nil && !joined ||
+		gameId != nil && joined && *gameId == current && spectate == client.gameIsSpectate {
+		log.Debugf("%s for %s, but we already agree with PYX", what, client.nick)
+		return
+	}
+
+	log.Errorf("Desync detected: %s for %s, resynchronizing with PYX", what, client.nick)
+	announceToAdmins(SnoPyx, "%s for %s, resynchronizing with PYX", what, client.nick)
+	if joined {
+		channel := client.getGameChannel()
+		client.leftGame()
+		client.data.push(newLine(client.getNickUserAtHost(client.nick), "PART").param(channel).
+			text("Out of sync with PYX").String())
+	}
+	if gameId != nil {
+		channel := client.config.GameChannelPrefix + strconv.Itoa(*gameId)
+		if spectate {
+			channel = client.config.SpectateGameChannelPrefix + strconv.Itoa(*gameId)
+		}
+		client.enteredGame(channel, *gameId, spectate)
+	}
+}
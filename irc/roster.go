@@ -0,0 +1,202 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Who is on each PYX server, kept up to date from events so we don't have to ask for every NAMES
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type roster struct {
+	lock sync.Mutex
+	// nick with its sigil, by lowercase nick. this is shared by every IRC server using the PYX server,
+	// so it can't use their casemappings, but PYX nicks can't have the characters they disagree on.
+	users map[string]string
+	// if users came from the server, rather than being empty because nobody asked yet
+	seeded bool
+	// registered clients using this server
+	clients int
+	// the most clients and users there have been since we started
+	maxClients int
+	maxUsers   int
+	// people who left, oldest first
+	whowas []whowasEntry
+	// the last game we saw each user join, by lowercase nick
+	games map[string]int
+	// when we last saw each user talk, by lowercase nick
+	active map[string]time.Time
+	// what people using the bridge gave for their realname, by lowercase nick
+	realnames map[string]string
+}
+
+var rostersLock sync.Mutex
+
+// by PYX server, since every client of a server sees the same people
+var rosters = make(map[string]*roster)
+
+func getRoster(config *pyx.Config) *roster {
+	rostersLock.Lock()
+	defer rostersLock.Unlock()
+	r, ok := rosters[config.BaseAddress]
+	if !ok {
+		r = &roster{
+			users:     make(map[string]string),
+			games:     make(map[string]int),
+			active:    make(map[string]time.Time),
+			realnames: make(map[string]string),
+		}
+		rosters[config.BaseAddress] = r
+	}
+	return r
+}
+
+func (r *roster) attach() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.clients++
+	if r.clients > r.maxClients {
+		r.maxClients = r.clients
+	}
+}
+
+// Once nobody is left to see events, the roster can't be kept up to date any more.
+func (r *roster) detach() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.clients--
+	if r.clients <= 0 {
+		r.clients = 0
+		r.invalidateLocked()
+		r.realnames = make(map[string]string)
+	}
+}
+
+// We may have missed events, so start over the next time someone asks.
+func (r *roster) invalidate() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.invalidateLocked()
+}
+
+// Must be called with the lock held.
+func (r *roster) invalidateLocked() {
+	r.seeded = false
+	r.users = make(map[string]string)
+}
+
+// Must be called with the lock held.
+func (r *roster) seed(client *pyx.Client) error {
+	if r.seeded {
+		return nil
+	}
+	names, err := client.Names()
+	if err != nil {
+		return err
+	}
+	r.users = make(map[string]string)
+	for _, name := range names {
+		_, nick := splitSigil(name)
+		r.users[strings.ToLower(nick)] = name
+	}
+	r.seeded = true
+	r.noteUsersLocked()
+	return nil
+}
+
+// Must be called with the lock held.
+func (r *roster) noteUsersLocked() {
+	if len(r.users) > r.maxUsers {
+		r.maxUsers = len(r.users)
+	}
+}
+
+// Everyone on the server, with their sigils, asking the server through client if we don't know yet.
+func (r *roster) names(client *pyx.Client) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.seed(client); err != nil {
+		return []string{}, err
+	}
+	keys := make([]string, 0, len(r.users))
+	for key := range r.users {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, r.users[key])
+	}
+	return names, nil
+}
+
+// Returns the nick with its sigil, if they are on the server.
+func (r *roster) find(client *pyx.Client, nick string) (string, bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.seed(client); err != nil {
+		return "", false, err
+	}
+	name, ok := r.users[strings.ToLower(nick)]
+	return name, ok, nil
+}
+
+// Only what we already know, without asking the server.
+func (r *roster) known(nick string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, ok := r.users[strings.ToLower(nick)]
+	return ok
+}
+
+func (r *roster) add(nick string, sigil string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.seeded {
+		r.users[strings.ToLower(nick)] = sigil + nick
+		r.noteUsersLocked()
+	}
+}
+
+// Only changes someone who is already there.
+func (r *roster) updateSigil(nick string, sigil string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := strings.ToLower(nick)
+	if _, ok := r.users[key]; ok {
+		r.users[key] = sigil + nick
+	}
+}
+
+func (r *roster) remove(nick string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.users, strings.ToLower(nick))
+	delete(r.active, strings.ToLower(nick))
+	delete(r.realnames, strings.ToLower(nick))
+}
@@ -0,0 +1,169 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRosterUpdates(t *testing.T) {
+	r := &roster{users: make(map[string]string)}
+	// nothing sticks until it has been seeded from the server
+	r.add("early", "")
+	if len(r.users) != 0 {
+		t.Error("For", "unseeded add", "expected", 0, "got", len(r.users))
+	}
+
+	r.seeded = true
+	r.add("bob", "")
+	r.add("Alice", "@")
+	r.add("carol", "")
+	r.updateSigil("bob", "+")
+	r.updateSigil("dave", "+")
+	r.remove("CAROL")
+	names, _ := r.names(nil)
+	expected := []string{"@Alice", "+bob"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Error("For", "updates", "expected", expected, "got", names)
+	}
+
+	r.attach()
+	r.detach()
+	if r.seeded || len(r.users) != 0 {
+		t.Error("For", "last detach", "expected", "empty", "got", r.users)
+	}
+}
+
+func TestRosterCounts(t *testing.T) {
+	r := &roster{users: make(map[string]string), seeded: true}
+	r.attach()
+	r.attach()
+	r.add("alice", "")
+	r.add("bob", "")
+	r.add("carol", "")
+	r.detach()
+	r.remove("carol")
+	counts, _ := r.counts(nil)
+	expected := userCounts{Local: 1, MaxLocal: 2, Global: 2, MaxGlobal: 3}
+	if counts != expected {
+		t.Error("For", "counts", "expected", expected, "got", counts)
+	}
+
+	// the maximums last even once nobody is left
+	r.detach()
+	counts = r.countsLocked()
+	expected = userCounts{Local: 0, MaxLocal: 2, Global: 0, MaxGlobal: 3}
+	if counts != expected {
+		t.Error("For", "counts after the last detach", "expected", expected, "got", counts)
+	}
+}
+
+func TestFormatClock(t *testing.T) {
+	d := 50*time.Hour + 3*time.Minute + 4*time.Second
+	if clock := formatClock(d); clock != "2:03:04" {
+		t.Error("For", d, "expected", "2:03:04", "got", clock)
+	}
+}
+
+type monitorLinesTestPair struct {
+	nicks []string
+	lines []string
+}
+
+var monitorLinesTests = []monitorLinesTestPair{
+	{[]string{}, []string{}},
+	{[]string{"a"}, []string{"a"}},
+	{[]string{"a", "b"}, []string{"a,b"}},
+}
+
+func TestMonitorLines(t *testing.T) {
+	for _, pair := range monitorLinesTests {
+		lines := monitorLines(pair.nicks)
+		if !reflect.DeepEqual(lines, pair.lines) {
+			t.Error("For", pair.nicks,
+				"expected", pair.lines,
+				"got", lines,
+			)
+		}
+	}
+}
+
+func TestRosterWhowas(t *testing.T) {
+	r := &roster{users: make(map[string]string), games: make(map[string]int)}
+	r.joinedGame("bob", 3)
+	r.recordQuit("bob", "Leaving", 3)
+	// another client seeing the same quit
+	r.recordQuit("bob", "Leaving", 3)
+	r.recordQuit("alice", "Ping timeout", 3)
+	r.recordQuit("carol", "Leaving", 3)
+	r.recordQuit("dave", "Leaving", 3)
+
+	if found := r.findWhowas("bob", 0); len(found) != 0 {
+		t.Error("For", "bob", "expected", "to have been pushed out", "got", found)
+	}
+	r.joinedGame("carol", 5)
+	r.whowas[1].left = r.whowas[1].left.Add(-time.Minute)
+	r.recordQuit("carol", "Leaving again", 3)
+	found := r.findWhowas("CAROL", 0)
+	if len(found) != 2 || found[0].reason != "Leaving again" || found[0].lastGame != 5 {
+		t.Error("For", "carol", "expected", "two entries, newest first", "got", found)
+	}
+	if found := r.findWhowas("carol", 1); len(found) != 1 {
+		t.Error("For", "carol with a count", "expected", 1, "got", len(found))
+	}
+}
+
+type idleTimeTestPair struct {
+	lastActive time.Duration
+	pyxIdle    time.Duration
+	idle       time.Duration
+	away       bool
+}
+
+var idleTimeTests = []idleTimeTestPair{
+	// never seen them talk
+	{0, 5 * time.Minute, 5 * time.Minute, false},
+	{time.Minute, 5 * time.Minute, time.Minute, false},
+	{10 * time.Minute, 5 * time.Minute, 5 * time.Minute, false},
+	{2 * time.Hour, 3 * time.Hour, 2 * time.Hour, true},
+}
+
+func TestIdleTime(t *testing.T) {
+	for _, pair := range idleTimeTests {
+		r := &roster{active: make(map[string]time.Time)}
+		if pair.lastActive > 0 {
+			r.active["bob"] = time.Now().Add(-pair.lastActive)
+		}
+		client := &Client{config: &Config{AwayIdleSeconds: 1800}, roster: r}
+		idle := client.idleTime("Bob", pair.pyxIdle).Round(time.Second)
+		if idle != pair.idle || client.isAway("Bob") != pair.away {
+			t.Error("For", pair.lastActive, pair.pyxIdle,
+				"expected", pair.idle, pair.away,
+				"got", idle, client.isAway("Bob"),
+			)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Counting rounds, and the black cards left in the deck, for the game we are in
+
+package irc
+
+import (
+	"fmt"
+)
+
+type roundCounter struct {
+	// the round being played, counting from 1, or 0 before the first one
+	number int
+	// if we saw the game start, so number is the real round number and not just how many we've seen
+	fromStart bool
+	// black cards drawn since the black deck was last shuffled, if fromStart
+	blackDrawn int
+}
+
+// We got here before the game started, so we'll see every round.
+func (client *Client) resetRounds() {
+	client.gameRounds = roundCounter{fromStart: true}
+}
+
+func (client *Client) roundStarted() {
+	client.gameRounds.number++
+	client.gameRounds.blackDrawn++
+}
+
+// The discarded black cards were put back, right before the next one is drawn.
+func (client *Client) blackCardsShuffled() {
+	client.gameRounds.blackDrawn = 0
+}
+
+// The current round number, or 0 if we don't know it because we showed up partway through.
+func (client *Client) roundNumber() int {
+	if !client.gameRounds.fromStart {
+		return 0
+	}
+	return client.gameRounds.number
+}
+
+// How many black cards are left to draw, or -1 if we can't tell. The server doesn't say how many
+// white cards are left, and the players' hands make that too hard to work out.
+func (client *Client) blackCardsLeft() int {
+	if !client.gameRounds.fromStart {
+		return -1
+	}
+	resp, err := client.gameInfo()
+	if err != nil {
+		return -1
+	}
+	total := 0
+	for _, id := range resp.GameInfo.GameOptions.CardSets {
+		cardSet, ok := client.pyx().CardSet(id)
+		if !ok {
+			return -1
+		}
+		total += cardSet.BlackCardsInDeck
+	}
+	for _, deck := range client.gameCustomDecks {
+		total += deck.BlackCardsInDeck
+	}
+	if left := total - client.gameRounds.blackDrawn; left >= 0 {
+		return left
+	}
+	return -1
+}
+
+// "Round 3" or "The round", to start a sentence about the current round.
+func (client *Client) roundName() string {
+	if number := client.roundNumber(); number > 0 {
+		return fmt.Sprintf("Round %d", number)
+	}
+	return "The round"
+}
@@ -0,0 +1,53 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestRoundCounter(t *testing.T) {
+	client := &Client{}
+	client.roundStarted()
+	if client.roundNumber() != 0 || client.roundName() != "The round" {
+		t.Error("For", "joining partway", "expected", 0, "The round",
+			"got", client.roundNumber(), client.roundName())
+	}
+
+	client.resetRounds()
+	client.roundStarted()
+	client.roundStarted()
+	if client.roundNumber() != 2 || client.roundName() != "Round 2" ||
+		client.gameRounds.blackDrawn != 2 {
+		t.Error("For", "two rounds", "expected", 2, "Round 2", 2,
+			"got", client.roundNumber(), client.roundName(), client.gameRounds.blackDrawn)
+	}
+
+	client.blackCardsShuffled()
+	client.roundStarted()
+	if client.roundNumber() != 3 || client.gameRounds.blackDrawn != 1 {
+		t.Error("For", "a reshuffle", "expected", 3, 1,
+			"got", client.roundNumber(), client.gameRounds.blackDrawn)
+	}
+}
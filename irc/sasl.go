@@ -0,0 +1,176 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// SASL authentication, so clients can give their id code the standard way instead of with PASS
+
+package irc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// AUTHENTICATE payloads are split into chunks this long.
+const SaslChunkLength = 400
+
+// longest payload we'll put back together
+const MaxSaslLength = 8192
+
+type SaslMechanismFunc func(client *Client, payload []byte) error
+
+// Mechanisms we support, by name.
+var SaslMechanisms = map[string]SaslMechanismFunc{
+	"EXTERNAL": saslExternal,
+	"PLAIN":    saslPlain,
+}
+
+func handleAuthenticate(client *Client, msg Message) {
+	if len(msg.args) == 0 {
+		client.data.push(client.n.formatSimpleReply(ErrNeedMoreParams, msg.cmd,
+			"Not enough parameters"))
+		return
+	}
+	if !client.hasCap("sasl") {
+		client.data.push(client.n.formatSimpleReply(ErrSaslFail, client.capTarget(),
+			"SASL authentication failed"))
+		return
+	}
+	if client.saslDone || client.registered {
+		client.data.push(client.n.formatSimpleReply(ErrSaslAlready, client.capTarget(),
+			"You have already authenticated using SASL"))
+		return
+	}
+	arg := msg.args[0]
+	if arg == "*" {
+		client.resetSasl()
+		client.data.push(client.n.formatSimpleReply(ErrSaslAborted, client.capTarget(),
+			"SASL authentication aborted"))
+		return
+	}
+
+	if client.saslMechanism == "" {
+		mechanism := strings.ToUpper(arg)
+		supported := false
+		for _, name := range client.saslMechanisms() {
+			supported = supported || name == mechanism
+		}
+		if !supported {
+			client.data.push(client.n.format(RplSaslMechs, client.capTarget(),
+				"%s :are available SASL mechanisms", strings.Join(client.saslMechanisms(), ",")))
+			client.data.push(client.n.formatSimpleReply(ErrSaslFail, client.capTarget(),
+				"SASL authentication failed"))
+			return
+		}
+		client.saslMechanism = mechanism
+		client.data.push("AUTHENTICATE +")
+		return
+	}
+
+	// the payload is done when we get a chunk shorter than the maximum, or a + after a full one
+	if arg != "+" {
+		client.saslBuffer.WriteString(arg)
+	}
+	if client.saslBuffer.Len() > MaxSaslLength {
+		client.resetSasl()
+		client.data.push(client.n.formatSimpleReply(ErrSaslTooLong, client.capTarget(),
+			"SASL message too long"))
+		return
+	}
+	if len(arg) == SaslChunkLength {
+		return
+	}
+
+	mechanism := client.saslMechanism
+	encoded := client.saslBuffer.String()
+	client.resetSasl()
+	payload, err := base64.StdEncoding.DecodeString(encoded)
+	if err == nil {
+		err = SaslMechanisms[mechanism](client, payload)
+	}
+	if err != nil {
+		log.Infof("SASL %s failed for %s: %v", mechanism, client.remoteAddr(), err)
+		client.data.push(client.n.formatSimpleReply(ErrSaslFail, client.capTarget(),
+			"SASL authentication failed"))
+		return
+	}
+	client.saslDone = true
+	client.data.push(client.n.format(RplLoggedIn, client.capTarget(),
+		"%s %s :You are now logged in as %s", client.getNickUserAtHost(client.saslAccount),
+		client.saslAccount, client.saslAccount))
+	client.data.push(client.n.formatSimpleReply(RplSaslSuccess, client.capTarget(),
+		"SASL authentication successful"))
+}
+
+func (client *Client) resetSasl() {
+	client.saslMechanism = ""
+	client.saslBuffer.Reset()
+}
+
+// EXTERNAL needs a client certificate, so it's only any use over TLS.
+func (client *Client) saslMechanisms() []string {
+	names := []string{}
+	for name := range SaslMechanisms {
+		if name == "EXTERNAL" && !client.secure {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// authzid NUL authcid NUL password. We don't do anything with authzid.
+func parseSaslPlain(payload []byte) (string, string, error) {
+	parts := bytes.Split(payload, []byte{0})
+	if len(parts) != 3 {
+		return "", "", errors.New("Malformed PLAIN payload")
+	}
+	authcid := string(parts[1])
+	if authcid == "" {
+		return "", "", errors.New("Empty PLAIN authcid")
+	}
+	return authcid, string(parts[2]), nil
+}
+
+// The account is the nick they want, and the password is their id code, optionally prefixed with
+// the PYX server they want like PASS.
+func saslPlain(client *Client, payload []byte) error {
+	account, password, err := parseSaslPlain(payload)
+	if err != nil {
+		return err
+	}
+	if client.nick != "" && !client.config.equalFold(client.nick, account) {
+		return errors.New("Account doesn't match nick")
+	}
+	pyxConfig, idcode := client.config.splitPyxServerPass(password)
+	if pyxConfig.ValidateIdCode(idcode) != nil {
+		return errors.New(idCodeRules(pyxConfig))
+	}
+	client.saslAccount = account
+	client.pyxConfig, client.password = pyxConfig, idcode
+	client.badIdCode = false
+	return nil
+}
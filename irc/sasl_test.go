@@ -0,0 +1,57 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"testing"
+)
+
+type saslPlainTestPair struct {
+	payload  string
+	account  string
+	password string
+	ok       bool
+}
+
+var saslPlainTests = []saslPlainTestPair{
+	{"\x00alice\x00secret", "alice", "secret", true},
+	{"alice\x00alice\x00secret", "alice", "secret", true},
+	{"\x00alice\x00", "alice", "", true},
+	{"\x00alice\x00pyx-2:secret", "alice", "pyx-2:secret", true},
+	{"\x00\x00secret", "", "", false},
+	{"alice\x00secret", "", "", false},
+	{"", "", "", false},
+}
+
+func TestParseSaslPlain(t *testing.T) {
+	for _, pair := range saslPlainTests {
+		account, password, err := parseSaslPlain([]byte(pair.payload))
+		if (err == nil) != pair.ok || account != pair.account || password != pair.password {
+			t.Error("For", []byte(pair.payload),
+				"expected", pair.account, pair.password, pair.ok,
+				"got", account, password, err,
+			)
+		}
+	}
+}
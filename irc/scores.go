@@ -0,0 +1,162 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Scores for the game we are in, kept up to date from events so the scoreboard doesn't depend on
+// asking the server
+
+package irc
+
+import (
+	"fmt"
+	"github.com/ajanata/pyx-irc/pyx"
+	"sync"
+)
+
+type scoreboard struct {
+	lock sync.Mutex
+	// false until we've seen the whole game from the server, or after something didn't add up
+	known bool
+	// in the order the server lists them
+	names  []string
+	scores map[string]int
+	winner string
+	// scores when the round started, to tell if the round's winner has been counted yet
+	roundStart map[string]int
+}
+
+// Start over from what the server says.
+func (s *scoreboard) sync(info []pyx.GamePlayerInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.names = []string{}
+	s.scores = make(map[string]int)
+	s.winner = ""
+	for _, player := range info {
+		s.names = append(s.names, player.Name)
+		s.scores[player.Name] = player.Score
+		if player.Status == pyx.GamePlayerStatus_WINNER {
+			s.winner = player.Name
+		}
+	}
+	s.roundStart = copyScores(s.scores)
+	s.known = true
+}
+
+func (s *scoreboard) forget() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.known = false
+}
+
+func (s *scoreboard) playerJoined(nick string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.scores[nick]; s.known && !ok {
+		s.names = append(s.names, nick)
+		s.scores[nick] = 0
+	}
+}
+
+func (s *scoreboard) playerLeft(nick string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.known {
+		return
+	}
+	s.names = removeNick(s.names, nick)
+	delete(s.scores, nick)
+	if s.winner == nick {
+		s.winner = ""
+	}
+}
+
+func (s *scoreboard) playerInfoChanged(info pyx.GamePlayerInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.known {
+		return
+	}
+	if _, ok := s.scores[info.Name]; !ok {
+		// someone we didn't know was in the game
+		s.known = false
+		return
+	}
+	s.scores[info.Name] = info.Score
+	if info.Status == pyx.GamePlayerStatus_WINNER {
+		s.winner = info.Name
+	} else if s.winner == info.Name {
+		s.winner = ""
+	}
+}
+
+func (s *scoreboard) roundStarted() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.roundStart = copyScores(s.scores)
+	s.winner = ""
+}
+
+// The server normally sends the winner's new score before saying they won, but in case it didn't,
+// count it here.
+func (s *scoreboard) roundWon(nick string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.known {
+		return
+	}
+	if _, ok := s.scores[nick]; !ok {
+		s.known = false
+		return
+	}
+	if s.scores[nick] == s.roundStart[nick] {
+		s.scores[nick]++
+	}
+	s.roundStart = copyScores(s.scores)
+}
+
+// The scores to show, and the winner if the game is over. ok is false if we don't know them.
+func (s *scoreboard) lines() ([]string, string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.known {
+		return nil, "", false
+	}
+	scores := []string{}
+	for _, name := range s.names {
+		score := s.scores[name]
+		plural := "s"
+		if score == 1 {
+			plural = ""
+		}
+		scores = append(scores, fmt.Sprintf("%s with %d point%s", name, score, plural))
+	}
+	return scores, s.winner, true
+}
+
+func copyScores(scores map[string]int) map[string]int {
+	c := make(map[string]int)
+	for name, score := range scores {
+		c[name] = score
+	}
+	return c
+}
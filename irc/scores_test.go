@@ -0,0 +1,64 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"reflect"
+	"testing"
+)
+
+func TestScoreboard(t *testing.T) {
+	s := &scoreboard{}
+	if _, _, ok := s.lines(); ok {
+		t.Error("For", "a new scoreboard", "expected", "unknown", "got", "known")
+	}
+	s.sync([]pyx.GamePlayerInfo{{Name: "alice", Score: 1}, {Name: "bob", Score: 0}})
+	s.playerJoined("carol")
+	s.roundStarted()
+	// the server told us about bob's point first
+	s.playerInfoChanged(pyx.GamePlayerInfo{Name: "bob", Score: 1})
+	s.roundWon("bob")
+	// but not this one
+	s.roundStarted()
+	s.roundWon("carol")
+	s.playerLeft("alice")
+
+	scores, winner, ok := s.lines()
+	expected := []string{"bob with 1 point", "carol with 1 point"}
+	if !ok || winner != "" || !reflect.DeepEqual(scores, expected) {
+		t.Error("For", "two rounds", "expected", expected, "got", scores, winner, ok)
+	}
+
+	s.playerInfoChanged(pyx.GamePlayerInfo{Name: "carol", Score: 8,
+		Status: pyx.GamePlayerStatus_WINNER})
+	if _, winner, _ = s.lines(); winner != "carol" {
+		t.Error("For", "the end of the game", "expected", "carol", "got", winner)
+	}
+
+	s.playerInfoChanged(pyx.GamePlayerInfo{Name: "dave", Score: 3})
+	if _, _, ok = s.lines(); ok {
+		t.Error("For", "an unknown player", "expected", "unknown", "got", "known")
+	}
+}
@@ -0,0 +1,116 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Keeping track of how many cards we still have to play this round, for black cards that want more
+// than one.
+
+package irc
+
+import (
+	"fmt"
+	"github.com/ajanata/pyx-irc/pyx"
+	"strconv"
+	"strings"
+)
+
+type cardSelection struct {
+	// how many cards the black card wants, or 0 if we don't know because we joined mid-round
+	pick int
+	// how many we've played so far this round, in order
+	played []string
+}
+
+// Start over for a new black card.
+func (client *Client) newSelection(card pyx.BlackCardData) {
+	client.gameSelection = &cardSelection{pick: card.Pick}
+}
+
+// How many more cards we can play this round, or -1 if we don't know.
+func (s *cardSelection) remaining() int {
+	if s == nil || s.pick == 0 {
+		return -1
+	}
+	return s.pick - len(s.played)
+}
+
+// Which cards PLAY args are asking for, as indexes into the hand. Only a single blank card can have
+// text, since there'd be no telling where one card's text ends and the next index starts. The text
+// is kept as it was sent, other than one pair of quotes around all of it.
+func parsePlayArgs(args string, hand []pyx.WhiteCardData) ([]int, string, error) {
+	indexes := []int{}
+	rest := args
+	for {
+		arg, next := nextWord(rest)
+		if arg == "" {
+			break
+		}
+		index, err := strconv.Atoi(arg)
+		if err != nil || index < 0 || index >= len(hand) {
+			if len(indexes) == 1 && hand[indexes[0]].WriteIn {
+				text := strings.TrimSpace(rest)
+				if len(text) > 1 && strings.HasPrefix(text, "\"") && strings.HasSuffix(text, "\"") {
+					text = text[1 : len(text)-1]
+				}
+				return indexes, text, nil
+			}
+			if len(indexes) > 0 && err != nil {
+				return nil, "", fmt.Errorf("Only blank cards can have text.")
+			}
+			return nil, "", fmt.Errorf("You don't have a card %s. Use HAND to see your cards.", arg)
+		}
+		for _, other := range indexes {
+			if other == index {
+				return nil, "", fmt.Errorf("You can't play card %d more than once.", index)
+			}
+		}
+		indexes = append(indexes, index)
+		rest = next
+	}
+	for _, index := range indexes {
+		if hand[index].WriteIn {
+			if len(indexes) > 1 {
+				return nil, "", fmt.Errorf("Play blank cards on their own, with PLAY %d \"your text\".",
+					index)
+			}
+			return nil, "", fmt.Errorf("That is a blank card. Use PLAY %d \"your text\" to fill it in.",
+				index)
+		}
+	}
+	return indexes, "", nil
+}
+
+func (client *Client) removeFromHand(cardId int) {
+	for i, card := range client.gameHand {
+		if card.Id == cardId {
+			client.gameHand = append(client.gameHand[:i], client.gameHand[i+1:]...)
+			return
+		}
+	}
+}
+
+func countCards(n int) string {
+	if n == 1 {
+		return "1 card"
+	}
+	return fmt.Sprintf("%d cards", n)
+}
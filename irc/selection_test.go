@@ -0,0 +1,85 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"reflect"
+	"testing"
+)
+
+type playArgsTestPair struct {
+	args    string
+	indexes []int
+	text    string
+	ok      bool
+}
+
+var playArgsHand = []pyx.WhiteCardData{
+	{Id: 10, Text: "A"},
+	{Id: 11, Text: "B"},
+	{Id: 12, WriteIn: true},
+	{Id: 13, Text: "C"},
+}
+
+var playArgsTests = []playArgsTestPair{
+	{"0", []int{0}, "", true},
+	{"3  1", []int{3, 1}, "", true},
+	{"2 \"my card\"", []int{2}, "my card", true},
+	{"2 7", []int{2}, "7", true},
+	{"2   \"my  spaced   card\"  ", []int{2}, "my  spaced   card", true},
+	{"2 \"\"quoted\" words\"", []int{2}, "\"quoted\" words", true},
+	{"2 say \"hi\"", []int{2}, "say \"hi\"", true},
+	{"2 \"", []int{2}, "\"", true},
+	{"2", nil, "", false},
+	{"2 1", nil, "", false},
+	{"1 1", nil, "", false},
+	{"1 text", nil, "", false},
+	{"4", nil, "", false},
+	{"one", nil, "", false},
+}
+
+func TestParsePlayArgs(t *testing.T) {
+	for _, pair := range playArgsTests {
+		indexes, text, err := parsePlayArgs(pair.args, playArgsHand)
+		if (err == nil) != pair.ok || (err == nil && (!reflect.DeepEqual(indexes, pair.indexes) ||
+			text != pair.text)) {
+			t.Error("For", pair.args,
+				"expected", pair.indexes, pair.text, pair.ok,
+				"got", indexes, text, err,
+			)
+		}
+	}
+}
+
+func TestCardSelectionRemaining(t *testing.T) {
+	var selection *cardSelection
+	if selection.remaining() != -1 {
+		t.Error("For nil expected -1 got", selection.remaining())
+	}
+	selection = &cardSelection{pick: 3, played: []string{"[A]"}}
+	if selection.remaining() != 2 {
+		t.Error("For pick 3 with 1 played expected 2 got", selection.remaining())
+	}
+}
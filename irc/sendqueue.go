@@ -0,0 +1,158 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+// What's waiting to be sent to a client. Anything can add to it without blocking, and one goroutine
+// writes it out, so a client that stops reading can't hold up anyone else.
+
+package irc
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// totals across every client, for the metrics; use atomically
+var sendStats struct {
+	dropped   int64
+	overflows int64
+	stalls    int64
+}
+
+type sendQueue struct {
+	lock  sync.Mutex
+	lines []string
+	limit int
+	// when it fills up, lose the oldest lines instead of giving up on the client
+	dropOldest bool
+	// lines lost since the writer last looked
+	dropped int
+	// it filled up and we gave up on the client
+	overflowed bool
+	// sent after everything else, once the queue is finishing
+	final     string
+	finishing bool
+	closed    bool
+	// has something in it when the writer should look again
+	ready chan bool
+}
+
+func newSendQueue(limit int, dropOldest bool) *sendQueue {
+	return &sendQueue{limit: limit, dropOldest: dropOldest, ready: make(chan bool, 1)}
+}
+
+// Must be called with the lock held.
+func (q *sendQueue) wake() {
+	select {
+	case q.ready <- true:
+	default:
+		// it's already going to look
+	}
+}
+
+// Add a line to send. Lines added after the queue has finished or closed are thrown away, since
+// there's nobody left to send them to.
+func (q *sendQueue) push(line string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.finishing || q.closed {
+		return
+	}
+	if len(q.lines) >= q.limit {
+		if !q.dropOldest {
+			// they aren't keeping up, so stop trying
+			q.lines = nil
+			q.overflowed = true
+			atomic.AddInt64(&sendStats.overflows, 1)
+			q.finishing = true
+			q.wake()
+			return
+		}
+		q.dropOldestLine()
+	}
+	q.lines = append(q.lines, line)
+	q.wake()
+}
+
+// Must be called with the lock held. The markers around labeled replies are kept, or the replies
+// would never be let out.
+func (q *sendQueue) dropOldestLine() {
+	for i, line := range q.lines {
+		if !strings.HasPrefix(line, "\x00") {
+			q.lines = append(q.lines[:i], q.lines[i+1:]...)
+			q.dropped++
+			atomic.AddInt64(&sendStats.dropped, 1)
+			return
+		}
+	}
+}
+
+// How many lines are waiting to be sent.
+func (q *sendQueue) depth() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.lines)
+}
+
+// Send line after what's already waiting, and then nothing else.
+func (q *sendQueue) finish(line string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.finishing || q.closed {
+		return
+	}
+	q.final = line
+	q.finishing = true
+	q.wake()
+}
+
+// Throw away anything added from now on. The writer stops once it has sent what's left.
+func (q *sendQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	q.wake()
+}
+
+// Wait for lines to send. Also returns how many were lost since last time, and whether the queue
+// is done, in which case the final line has to be sent after these.
+func (q *sendQueue) next() ([]string, int, bool) {
+	for {
+		q.lock.Lock()
+		lines, dropped := q.lines, q.dropped
+		done := q.finishing || q.closed
+		q.lines, q.dropped = nil, 0
+		q.lock.Unlock()
+		if len(lines) > 0 || dropped > 0 || done {
+			return lines, dropped, done
+		}
+		<-q.ready
+	}
+}
+
+// The last line to send, and whether we gave up on the client for not keeping up. Only
+// meaningful once next says the queue is done.
+func (q *sendQueue) finalLine() (string, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.final, q.overflowed
+}
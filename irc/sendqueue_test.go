@@ -0,0 +1,99 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSendQueueOrder(t *testing.T) {
+	q := newSendQueue(10, false)
+	q.push("a")
+	q.push("b")
+	if depth := q.depth(); depth != 2 {
+		t.Error("For a, b expected depth 2, got", depth)
+	}
+	lines, dropped, done := q.next()
+	if !reflect.DeepEqual(lines, []string{"a", "b"}) || dropped != 0 || done {
+		t.Error("For a, b expected [a b] 0 false, got", lines, dropped, done)
+	}
+}
+
+func TestSendQueueDropOldest(t *testing.T) {
+	q := newSendQueue(3, true)
+	q.push(labelStartMarker + "0 x")
+	for _, line := range []string{"a", "b", "c"} {
+		q.push(line)
+	}
+	lines, dropped, _ := q.next()
+	expected := []string{labelStartMarker + "0 x", "b", "c"}
+	if !reflect.DeepEqual(lines, expected) || dropped != 1 {
+		t.Error("For a full queue expected", expected, 1, "got", lines, dropped)
+	}
+}
+
+func TestSendQueueOverflow(t *testing.T) {
+	q := newSendQueue(2, false)
+	for _, line := range []string{"a", "b", "c", "d"} {
+		q.push(line)
+	}
+	lines, _, done := q.next()
+	_, overflowed := q.finalLine()
+	if len(lines) != 0 || !done || !overflowed {
+		t.Error("For an overflow expected [] true true, got", lines, done, overflowed)
+	}
+}
+
+func TestSendQueueFinish(t *testing.T) {
+	q := newSendQueue(10, false)
+	q.push("a")
+	q.finish("ERROR :bye")
+	q.push("b")
+	lines, _, done := q.next()
+	final, overflowed := q.finalLine()
+	if !reflect.DeepEqual(lines, []string{"a"}) || !done || final != "ERROR :bye" || overflowed {
+		t.Error("For finish expected [a] true ERROR :bye false, got", lines, done, final,
+			overflowed)
+	}
+}
+
+func TestWaitForSendQueue(t *testing.T) {
+	client := &Client{data: newSendQueue(10, false), done: make(chan bool)}
+	for i := 0; i < 5; i++ {
+		client.data.push("line")
+	}
+	if !client.waitForSendQueue(5) {
+		t.Error("For 5 lines waiting on 5 expected true, got false")
+	}
+	go client.data.next()
+	if !client.waitForSendQueue(0) {
+		t.Error("For a drained queue expected true, got false")
+	}
+	client.data.push("line")
+	close(client.done)
+	if client.waitForSendQueue(0) {
+		t.Error("For a client that went away expected false, got true")
+	}
+}
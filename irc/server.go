@@ -24,13 +24,21 @@
 package irc
 
 import (
+	"github.com/ajanata/pyx-irc/pyx"
 	"github.com/op/go-logging"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var log = logging.MustGetLogger("irc")
 
+var managersLock sync.Mutex
+var managers []*Manager
+var shuttingDown bool
+
 func StartServer(config Config) {
 	log.Infof("Starting server on %s:%d...", config.BindAddress, config.Port)
 	listener, error := net.Listen("tcp", config.BindAddress+":"+strconv.Itoa(config.Port))
@@ -41,3 +49,109 @@ func StartServer(config Config) {
 
 	NewManager(listener, &config)
 }
+
+func addManager(manager *Manager) {
+	managersLock.Lock()
+	defer managersLock.Unlock()
+	managers = append(managers, manager)
+}
+
+func isShuttingDown() bool {
+	managersLock.Lock()
+	defer managersLock.Unlock()
+	return shuttingDown
+}
+
+// Stops accepting connections on every server, disconnects every client (which logs them out of
+// PYX), and waits up to timeout for them to all go away.
+func Shutdown(reason string, timeout time.Duration) {
+	managersLock.Lock()
+	shuttingDown = true
+	toStop := managers
+	managersLock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, manager := range toStop {
+		manager.listener.Close()
+		manager.shutdown <- reason
+		wg.Add(1)
+		go func(manager *Manager) {
+			manager.active.Wait()
+			wg.Done()
+		}(manager)
+	}
+
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("All clients disconnected.")
+	case <-time.After(timeout):
+		log.Warning("Timed out waiting for clients to disconnect.")
+	}
+}
+
+type ServerStatus struct {
+	Port    int
+	Clients int
+	// the default server first
+	Pyx []*pyx.Config
+}
+
+// Status of every server that is currently accepting connections.
+func Status() []ServerStatus {
+	managersLock.Lock()
+	defer managersLock.Unlock()
+	status := make([]ServerStatus, len(managers))
+	for i, manager := range managers {
+		status[i] = ServerStatus{
+			Port:    manager.config.Port,
+			Clients: int(atomic.LoadInt64(&manager.clientCount)),
+			Pyx:     []*pyx.Config{&manager.config.Pyx},
+		}
+		for j := range manager.config.PyxServers {
+			status[i].Pyx = append(status[i].Pyx, &manager.config.PyxServers[j])
+		}
+	}
+	return status
+}
+
+// Every connection on every server.
+func ListClients() []ClientInfo {
+	managersLock.Lock()
+	defer managersLock.Unlock()
+	infos := []ClientInfo{}
+	for _, manager := range managers {
+		infos = append(infos, manager.Clients()...)
+	}
+	return infos
+}
+
+// Disconnects the registered client with the given nick on whichever server they're on. Returns
+// false if nobody has that nick.
+func KillClient(nick string, reason string) bool {
+	managersLock.Lock()
+	defer managersLock.Unlock()
+	for _, manager := range managers {
+		if manager.Kill(nick, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// Finds the registered client with the given nick logged in to the given PYX server, or any PYX
+// server if pyxConfig is nil, on any listener.
+func findClient(nick string, pyxConfig *pyx.Config) *Client {
+	managersLock.Lock()
+	defer managersLock.Unlock()
+	for _, manager := range managers {
+		if client := manager.clientByNick(nick, pyxConfig); client != nil {
+			return client
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// SILENCE and the bot's IGNORE, to stop seeing chat from someone
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// advertised in ISUPPORT
+const MaxSilenceEntries = 32
+
+// A bare nick means anyone with that nick.
+func normalizeSilenceMask(mask string) string {
+	if !strings.ContainsAny(mask, "!@") {
+		return mask + "!*@*"
+	}
+	return mask
+}
+
+// The silence list only ever gets replaced, never changed in place, so what this returns can be
+// looked through without holding the lock.
+func (client *Client) silenceList() []string {
+	client.silenceLock.Lock()
+	defer client.silenceLock.Unlock()
+	return client.prefs.Silence
+}
+
+func (client *Client) isSilenced(nick string) bool {
+	silence := client.silenceList()
+	if len(silence) == 0 {
+		return false
+	}
+	full := client.getNickUserAtHost(nick)
+	for _, mask := range silence {
+		if matchMask(mask, full) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns false if it was already there, or there isn't any more room.
+func (client *Client) addSilence(mask string) (bool, error) {
+	client.silenceLock.Lock()
+	for _, existing := range client.prefs.Silence {
+		if client.config.equalFold(existing, mask) {
+			client.silenceLock.Unlock()
+			return false, nil
+		}
+	}
+	if len(client.prefs.Silence) >= MaxSilenceEntries {
+		client.silenceLock.Unlock()
+		return false, fmt.Errorf("Your silence list is full")
+	}
+	client.prefs.Silence = append(append([]string{}, client.prefs.Silence...), mask)
+	client.silenceLock.Unlock()
+	return true, client.savePreferences()
+}
+
+// Returns false if it wasn't there.
+func (client *Client) removeSilence(mask string) (bool, error) {
+	client.silenceLock.Lock()
+	for i, existing := range client.prefs.Silence {
+		if client.config.equalFold(existing, mask) {
+			silence := append([]string{}, client.prefs.Silence[:i]...)
+			client.prefs.Silence = append(silence, client.prefs.Silence[i+1:]...)
+			client.silenceLock.Unlock()
+			return true, client.savePreferences()
+		}
+	}
+	client.silenceLock.Unlock()
+	return false, nil
+}
+
+func handleSilence(client *Client, msg Message) {
+	if len(msg.args) == 0 {
+		for _, mask := range client.silenceList() {
+			client.data.push(client.n.format(RplSileList, client.nick, "%s %s", client.nick, mask))
+		}
+		client.data.push(client.n.formatSimpleReply(RplEndOfSileList, client.nick,
+			"End of Silence List"))
+		return
+	}
+
+	for _, arg := range strings.Split(msg.args[0], ",") {
+		adding := true
+		if strings.HasPrefix(arg, "-") {
+			adding = false
+			arg = arg[1:]
+		} else if strings.HasPrefix(arg, "+") {
+			arg = arg[1:]
+		}
+		if arg == "" {
+			continue
+		}
+		mask := normalizeSilenceMask(arg)
+		var changed bool
+		var err error
+		if adding {
+			changed, err = client.addSilence(mask)
+		} else {
+			changed, err = client.removeSilence(mask)
+		}
+		if err != nil && adding && !changed {
+			client.data.push(client.n.format(ErrSileListFull, client.nick, "%s :%s", mask, err))
+			continue
+		}
+		if err != nil {
+			log.Errorf("Unable to save silence list for %s: %v", client.nick, err)
+		}
+		if changed {
+			sign := "+"
+			if !adding {
+				sign = "-"
+			}
+			client.data.push(fmt.Sprintf(":%s SILENCE %s%s", client.getNickUserAtHost(client.nick),
+				sign, mask))
+		}
+	}
+}
+
+func botIgnore(client *Client, reply BotReplyFunc, args []string) {
+	if len(args) == 0 {
+		silence := client.silenceList()
+		if len(silence) == 0 {
+			reply("You are not ignoring anyone.")
+			return
+		}
+		for _, line := range joinIntoLines(300, silence, ", ") {
+			reply("Ignoring: %s", line)
+		}
+		return
+	}
+	for _, arg := range args {
+		mask := normalizeSilenceMask(arg)
+		changed, err := client.addSilence(mask)
+		if err != nil {
+			reply("Unable to ignore %s: %s", arg, err)
+		} else if changed {
+			reply("Ignoring %s.", arg)
+		} else {
+			reply("You were already ignoring %s.", arg)
+		}
+	}
+}
+
+func botUnignore(client *Client, reply BotReplyFunc, args []string) {
+	if len(args) == 0 {
+		reply("Usage: UNIGNORE <nick> [nick ...]")
+		return
+	}
+	for _, arg := range args {
+		changed, err := client.removeSilence(normalizeSilenceMask(arg))
+		if err != nil {
+			reply("Stopped ignoring %s, but it could not be saved: %s", arg, err)
+		} else if changed {
+			reply("No longer ignoring %s.", arg)
+		} else {
+			reply("You were not ignoring %s.", arg)
+		}
+	}
+}
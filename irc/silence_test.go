@@ -0,0 +1,88 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"sync"
+	"testing"
+)
+
+type silenceTestPair struct {
+	mask     string
+	nick     string
+	silenced bool
+}
+
+var silenceTests = []silenceTestPair{
+	{"bob", "bob", true},
+	{"bob", "BOB", true},
+	{"bob", "bobby", false},
+	{"bob*", "bobby", true},
+	{"*!*@" + cloakHost("bob", "users.localhost"), "bob", true},
+	{"*!*@" + cloakHost("bob", "users.localhost"), "alice", false},
+	{"*!bob@*", "bob", true},
+}
+
+func TestIsSilenced(t *testing.T) {
+	for _, pair := range silenceTests {
+		client := &Client{
+			config: &Config{UserHostname: "users.localhost"},
+			sigils: &sigilMap{sigils: map[string]string{"bob": "+"}},
+			prefs:  Preferences{Silence: []string{normalizeSilenceMask(pair.mask)}},
+		}
+		if client.isSilenced(pair.nick) != pair.silenced {
+			t.Error("For", pair.mask, pair.nick,
+				"expected", pair.silenced,
+				"got", !pair.silenced,
+			)
+		}
+	}
+}
+
+// Other clients check our silence list when they whisper to us, while we change it on ours.
+func TestSilenceWhileWhispered(t *testing.T) {
+	config := &Config{}
+	config.EnsureDefaults()
+	client := &Client{config: config, sigils: newSigilMap()}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			client.addSilence(normalizeSilenceMask("bob"))
+			client.removeSilence(normalizeSilenceMask("bob"))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			client.isSilenced("bob")
+		}
+	}()
+	wg.Wait()
+	if client.isSilenced("bob") {
+		t.Error("For adding and removing bob expected him not to be silenced")
+	}
+}
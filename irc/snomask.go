@@ -0,0 +1,130 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Server notices to operators, filtered by what each of them wants to see
+
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// snomask categories
+const SnoConnect = 'c'
+const SnoFailedLogin = 'f'
+const SnoKill = 'k'
+const SnoPyx = 'p'
+
+// Every category, which is also what operators get by default.
+const SnoAll = "cfkp"
+
+// Operators with user mode +s, and their masks. Like the admin channel, clients are removed when
+// they unregister.
+var snomasks = struct {
+	lock  sync.Mutex
+	masks map[*Client]*snomask
+}{masks: make(map[*Client]*snomask)}
+
+type snomask struct {
+	nick       string
+	categories map[byte]bool
+}
+
+func (mask *snomask) String() string {
+	categories := []string{}
+	for category := range mask.categories {
+		categories = append(categories, string(category))
+	}
+	sort.Strings(categories)
+	return "+" + strings.Join(categories, "")
+}
+
+// Apply changes like "+cf-k" to a mask. Categories we don't know about are ignored.
+func (mask *snomask) apply(changes string) {
+	adding := true
+	for i := 0; i < len(changes); i++ {
+		switch c := changes[i]; c {
+		case '+':
+			adding = true
+		case '-':
+			adding = false
+		default:
+			if strings.IndexByte(SnoAll, c) < 0 {
+				continue
+			}
+			if adding {
+				mask.categories[c] = true
+			} else {
+				delete(mask.categories, c)
+			}
+		}
+	}
+}
+
+// Set or change the snomask for an operator. An empty change gives them every category if they
+// didn't already have a mask.
+func (client *Client) setSnomask(changes string) {
+	snomasks.lock.Lock()
+	mask, ok := snomasks.masks[client]
+	if !ok {
+		mask = &snomask{nick: client.nick, categories: make(map[byte]bool)}
+		snomasks.masks[client] = mask
+		if changes == "" {
+			changes = SnoAll
+		}
+	}
+	mask.apply(changes)
+	current := mask.String()
+	snomasks.lock.Unlock()
+	client.data.push(client.n.format(RplSnomask, client.nick, "%s :Server notice mask", current))
+}
+
+// Returns false if they didn't have one.
+func (client *Client) clearSnomask() bool {
+	snomasks.lock.Lock()
+	defer snomasks.lock.Unlock()
+	_, ok := snomasks.masks[client]
+	delete(snomasks.masks, client)
+	return ok
+}
+
+func (client *Client) hasSnomask() bool {
+	snomasks.lock.Lock()
+	defer snomasks.lock.Unlock()
+	_, ok := snomasks.masks[client]
+	return ok
+}
+
+func sendSnotice(category byte, text string) {
+	snomasks.lock.Lock()
+	defer snomasks.lock.Unlock()
+	for client, mask := range snomasks.masks {
+		if mask.categories[category] {
+			client.data.push(fmt.Sprintf(":%s NOTICE %s :*** %s", client.config.AdvertisedName,
+				mask.nick, text))
+		}
+	}
+}
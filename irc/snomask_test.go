@@ -0,0 +1,58 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"testing"
+)
+
+type snomaskTestPair struct {
+	start   string
+	changes string
+	output  string
+}
+
+var snomaskTests = []snomaskTestPair{
+	{"", "cf", "+cf"},
+	{"", "+fc", "+cf"},
+	{"cfkp", "-k", "+cfp"},
+	{"cfkp", "-kp+z", "+cf"},
+	{"c", "+k-c", "+k"},
+	{"c", "", "+c"},
+	{"", "-c", "+"},
+}
+
+func TestSnomaskApply(t *testing.T) {
+	for _, pair := range snomaskTests {
+		mask := &snomask{categories: make(map[byte]bool)}
+		mask.apply(pair.start)
+		mask.apply(pair.changes)
+		if mask.String() != pair.output {
+			t.Error("For", pair.start, pair.changes,
+				"expected", pair.output,
+				"got", mask.String(),
+			)
+		}
+	}
+}
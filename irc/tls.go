@@ -0,0 +1,155 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// TLS listeners, and logging in with a client certificate
+
+package irc
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// how long a client gets to finish the TLS handshake
+const TlsHandshakeTimeout = 10 * time.Second
+
+// Who a client certificate belongs to.
+type certificateLogin struct {
+	nick string
+	// may have a PYX server prefix like PASS
+	password string
+}
+
+func newTlsConfig(config *Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.TlsCertFile, config.TlsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// we only care about the fingerprint, not who signed it, so self-signed certs are fine
+		ClientAuth: tls.RequestClientCert,
+	}, nil
+}
+
+// Finishes the TLS handshake, returning the SHA-256 fingerprint of the client's certificate, if
+// they sent one.
+func tlsHandshake(conn *tls.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(TlsHandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		return "", err
+	}
+	conn.SetDeadline(time.Time{})
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", nil
+	}
+	sum := sha256.Sum256(certs[0].Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Lowercase hex without any separators.
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(fingerprint))
+}
+
+// Entries look like "fingerprint=nick:idcode".
+func parseCertificateLogin(entry string) (string, *certificateLogin, error) {
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) != 2 {
+		return "", nil, fmt.Errorf("Invalid certificate login %s", entry)
+	}
+	credentials := strings.SplitN(parts[1], ":", 2)
+	if len(credentials) != 2 || credentials[0] == "" {
+		return "", nil, fmt.Errorf("Invalid certificate login %s", entry)
+	}
+	return normalizeFingerprint(parts[0]), &certificateLogin{
+		nick:     credentials[0],
+		password: credentials[1],
+	}, nil
+}
+
+// The login for the given certificate fingerprint, or nil if it isn't one we know.
+func (config *Config) certificateLogin(fingerprint string) *certificateLogin {
+	if fingerprint == "" {
+		return nil
+	}
+	for _, entry := range config.CertificateLogins {
+		entryFingerprint, login, err := parseCertificateLogin(entry)
+		if err != nil {
+			log.Warning(err)
+			continue
+		}
+		if entryFingerprint == fingerprint {
+			return login
+		}
+	}
+	return nil
+}
+
+// If they didn't give an id code any other way, but they have a certificate for the nick they
+// picked, use the id code for it.
+func (client *Client) useCertificateLogin() {
+	if client.password != "" || client.saslDone {
+		return
+	}
+	login := client.config.certificateLogin(client.certFingerprint)
+	if login != nil && client.config.equalFold(login.nick, client.nick) {
+		log.Debugf("Using certificate login for %s", client.nick)
+		client.pyxConfig, client.password = client.config.splitPyxServerPass(login.password)
+	}
+}
+
+// Wraps connections in TLS if the server is configured for it.
+func (manager *Manager) wrapTls(connection net.Conn) (net.Conn, string, error) {
+	if manager.tlsConfig == nil {
+		return connection, "", nil
+	}
+	tlsConn := tls.Server(connection, manager.tlsConfig)
+	fingerprint, err := tlsHandshake(tlsConn)
+	return tlsConn, fingerprint, err
+}
+
+// The client has to have connected with a certificate we know. They can ask to be a specific
+// user, but it has to be the one the certificate is for.
+func saslExternal(client *Client, payload []byte) error {
+	login := client.config.certificateLogin(client.certFingerprint)
+	if login == nil {
+		return errors.New("No known client certificate")
+	}
+	if len(payload) > 0 && !client.config.equalFold(string(payload), login.nick) {
+		return errors.New("Certificate is for someone else")
+	}
+	if client.nick != "" && !client.config.equalFold(client.nick, login.nick) {
+		return errors.New("Certificate doesn't match nick")
+	}
+	client.saslAccount = login.nick
+	client.pyxConfig, client.password = client.config.splitPyxServerPass(login.password)
+	return nil
+}
@@ -0,0 +1,66 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"testing"
+)
+
+type certificateLoginTestPair struct {
+	entry       string
+	fingerprint string
+	nick        string
+	password    string
+	ok          bool
+}
+
+var certificateLoginTests = []certificateLoginTestPair{
+	{"ABCDEF=alice:secret", "abcdef", "alice", "secret", true},
+	{"AB:CD:EF=alice:secret", "abcdef", "alice", "secret", true},
+	{"abcdef=alice:pyx-2:secret", "abcdef", "alice", "pyx-2:secret", true},
+	{"abcdef=alice:", "abcdef", "alice", "", true},
+	{"abcdef=alice", "", "", "", false},
+	{"abcdef=:secret", "", "", "", false},
+	{"abcdef", "", "", "", false},
+}
+
+func TestParseCertificateLogin(t *testing.T) {
+	for _, pair := range certificateLoginTests {
+		fingerprint, login, err := parseCertificateLogin(pair.entry)
+		if (err == nil) != pair.ok {
+			t.Error("For", pair.entry, "expected ok", pair.ok, "got", err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if fingerprint != pair.fingerprint || login.nick != pair.nick ||
+			login.password != pair.password {
+			t.Error("For", pair.entry,
+				"expected", pair.fingerprint, pair.nick, pair.password,
+				"got", fingerprint, login.nick, login.password,
+			)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package irc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var nextConnectionId uint64
+
+// Raw I/O trace for a single client. Every line is tagged with the connection id and the number
+// of the incoming line that caused it, so PYX requests can be matched up with IRC commands.
+type tracer struct {
+	lock    sync.Mutex
+	enabled bool
+	file    *os.File
+	id      uint64
+	// how many lines we've received from the client
+	line int
+}
+
+func newTracer() *tracer {
+	return &tracer{id: atomic.AddUint64(&nextConnectionId, 1)}
+}
+
+// Starts tracing, to a file in directory if it is set or the log otherwise.
+func (t *tracer) start(directory string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.enabled {
+		return nil
+	}
+	if directory != "" {
+		name := fmt.Sprintf("trace-c%d-%s.log", t.id, time.Now().Format("20060102-150405"))
+		file, err := os.OpenFile(filepath.Join(directory, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+			0600)
+		if err != nil {
+			return err
+		}
+		t.file = file
+	}
+	t.enabled = true
+	return nil
+}
+
+func (t *tracer) stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+	t.enabled = false
+}
+
+func (t *tracer) incoming(line string) {
+	t.lock.Lock()
+	t.line++
+	t.lock.Unlock()
+	t.printf("<- %s", line)
+}
+
+func (t *tracer) outgoing(line string) {
+	t.printf("-> %s", line)
+}
+
+func (t *tracer) printf(format string, args ...interface{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.enabled {
+		return
+	}
+	msg := fmt.Sprintf("[trace c%d.%d] %s", t.id, t.line, fmt.Sprintf(format, args...))
+	if t.file != nil {
+		fmt.Fprintf(t.file, "%s %s\n", time.Now().Format("15:04:05.000"), msg)
+	} else {
+		log.Info(msg)
+	}
+}
+
+// RAWTRACE nick ON|OFF
+// Admin-only, turns the raw trace on or off for a user.
+func handleRawTrace(client *Client, msg Message) {
+	if !client.pyx().User.IsAdmin() {
+		client.data.push(client.n.formatSimpleReply(ErrNoPrivileges, client.nick,
+			"Permission Denied- You're not a PYX administrator"))
+		return
+	}
+	if len(msg.args) < 2 {
+		client.data.push(client.n.formatSimpleReply(ErrNeedMoreParams, msg.cmd,
+			"Not enough parameters"))
+		return
+	}
+	target := findClient(msg.args[0], nil)
+	if target == nil {
+		client.data.push(client.n.format(ErrNoSuchNick, client.nick, "%s :No such nick",
+			msg.args[0]))
+		return
+	}
+
+	switch strings.ToUpper(msg.args[1]) {
+	case "ON":
+		err := target.trace.start(client.config.TraceDirectory)
+		if err != nil {
+			log.Errorf("Unable to start trace for %s: %v", target.nick, err)
+			client.sendServerNotice("Unable to start trace for %s: %v", target.nick, err)
+			return
+		}
+		log.Infof("%s started a raw trace of %s (c%d)", client.nick, target.nick, target.trace.id)
+		client.sendServerNotice("Tracing %s as c%d", target.nick, target.trace.id)
+	case "OFF":
+		target.trace.stop()
+		log.Infof("%s stopped the raw trace of %s (c%d)", client.nick, target.nick, target.trace.id)
+		client.sendServerNotice("No longer tracing %s", target.nick)
+	default:
+		client.sendServerNotice("Usage: RAWTRACE <nick> ON|OFF")
+	}
+}
@@ -0,0 +1,141 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Round-by-round history of a game
+
+package irc
+
+import (
+	"fmt"
+	"github.com/ajanata/pyx-irc/pyx"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type transcriptRound struct {
+	blackCard    string
+	plays        []string
+	winner       string
+	winningCards string
+	scores       []string
+}
+
+type transcript struct {
+	gameId  int
+	started time.Time
+	rounds  []*transcriptRound
+	// the game has been won, so the next round starts a new transcript
+	finished bool
+}
+
+func (t *transcript) currentRound() *transcriptRound {
+	if len(t.rounds) == 0 {
+		// we joined in the middle of a round
+		t.rounds = append(t.rounds, &transcriptRound{blackCard: "(unknown)"})
+	}
+	return t.rounds[len(t.rounds)-1]
+}
+
+func (t *transcript) lines() []string {
+	lines := []string{}
+	for i, round := range t.rounds {
+		lines = append(lines, fmt.Sprintf("Round %d: %s", i+1, round.blackCard))
+		for _, play := range round.plays {
+			lines = append(lines, "  Played: "+play)
+		}
+		if round.winner != "" {
+			lines = append(lines, fmt.Sprintf("  Won by %s with %s", round.winner,
+				round.winningCards))
+		}
+		if len(round.scores) > 0 {
+			lines = append(lines, "  Scores: "+strings.Join(round.scores, ", "))
+		}
+	}
+	return lines
+}
+
+func (client *Client) transcriptNewRound(blackCard string) {
+	gameId, ok := client.currentGame()
+	if !ok {
+		return
+	}
+	if client.gameTranscript == nil || client.gameTranscript.finished {
+		client.gameTranscript = &transcript{
+			gameId:  gameId,
+			started: time.Now(),
+		}
+	}
+	client.gameTranscript.rounds = append(client.gameTranscript.rounds,
+		&transcriptRound{blackCard: blackCard})
+}
+
+func (client *Client) transcriptPlays(plays [][]pyx.WhiteCardData) {
+	if client.gameTranscript == nil {
+		return
+	}
+	round := client.gameTranscript.currentRound()
+	round.plays = nil
+	for _, cards := range plays {
+		texts := []string{}
+		for _, card := range cards {
+			texts = append(texts, "["+whiteCardText(card)+"]")
+		}
+		round.plays = append(round.plays, strings.Join(texts, " "))
+	}
+}
+
+// Record the result of a round. If the game is over, the transcript is written out if configured.
+func (client *Client) transcriptRoundComplete(roundWinner string, winningCards string,
+	scores []string, gameWinner string) {
+	if client.gameTranscript == nil {
+		return
+	}
+	round := client.gameTranscript.currentRound()
+	round.winner = roundWinner
+	round.winningCards = winningCards
+	round.scores = scores
+	if gameWinner != "" {
+		client.gameTranscript.finished = true
+		if client.config.TranscriptDirectory != "" {
+			err := client.writeTranscript(gameWinner)
+			if err != nil {
+				log.Errorf("Unable to write transcript for game %d: %v",
+					client.gameTranscript.gameId, err)
+			}
+		}
+	}
+}
+
+func (client *Client) writeTranscript(gameWinner string) error {
+	t := client.gameTranscript
+	name := fmt.Sprintf("game-%d-%s-%s.txt", t.gameId, t.started.Format("20060102-150405"),
+		client.nick)
+	lines := append([]string{fmt.Sprintf("Game %d on %s, started %s", t.gameId,
+		client.pyxConfig.BaseAddress, t.started.Format(time.RFC1123))}, t.lines()...)
+	lines = append(lines, fmt.Sprintf("The game was won by %s.", gameWinner))
+	path := filepath.Join(client.config.TranscriptDirectory, name)
+	log.Infof("Writing transcript for game %d to %s", t.gameId, path)
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
@@ -24,11 +24,14 @@
 package irc
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/ajanata/pyx-irc/pyx"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 const CtcpMagic byte = 1
@@ -59,21 +62,168 @@ func (client *Client) botNickUserAtHost() string {
 		client.config.BotHostname)
 }
 
+// Services like NickServ all share the bot's host.
+func (client *Client) serviceNickUserAtHost(nick string) string {
+	return fmt.Sprintf("%s!services@%s", nick, client.config.BotHostname)
+}
+
+// Services answer with notices, like they do on real networks.
+func (client *Client) serviceReplyFunc(nick string) BotReplyFunc {
+	return func(format string, args ...interface{}) {
+		client.data.push(newLine(client.serviceNickUserAtHost(nick), "NOTICE").param(client.nick).
+			textf(format, args...).String())
+	}
+}
+
+func (client *Client) whoisService(nick string, realName string) {
+	client.data.push(client.n.format(RplWhoisUser, client.nick, "%s services %s * :%s", nick,
+		client.config.BotHostname, realName))
+	client.data.push(client.n.format(RplWhoisServer, client.nick, "%s %s :%s", nick,
+		client.config.AdvertisedName, client.pyxConfig.BaseAddress))
+	client.data.push(client.n.format(RplWhoisBot, client.nick, "%s :is a Bot", nick))
+	client.data.push(client.n.format(RplEndOfWhois, client.nick, "%s :End of /WHOIS list.", nick))
+}
+
 func (client *Client) getNickUserAtHost(nick string) string {
 	return fmt.Sprintf("%s!%s@%s", nick, getUser(nick), client.getHost(nick))
 }
 
 func getUser(nick string) string {
-	user := nick
+	user := strings.TrimLeft(nick, pyx.Sigil_ADMIN+pyx.Sigil_ID_CODE)
 	if len(user) > 10 {
 		user = user[:10]
 	}
 	return strings.ToLower(user)
 }
 
+// The host only depends on the nick, so bans and ignores keep working when someone gains or loses
+// admin or verification; that shows up as a mode in the global channel instead. nick may include
+// a sigil.
 func (client *Client) getHost(nick string) string {
-	// TODO unique hosts per user? idk.
-	return "users." + client.config.AdvertisedName
+	return cloakHost(nick, client.config.UserHostname)
+}
+
+// Split the sigil, if any, off the front of a nick from PYX.
+func splitSigil(nick string) (string, string) {
+	if strings.HasPrefix(nick, pyx.Sigil_ADMIN) || strings.HasPrefix(nick, pyx.Sigil_ID_CODE) {
+		return nick[0:1], nick[1:]
+	}
+	return pyx.Sigil_NORMAL_USER, nick
+}
+
+// The last sigil seen for each user, by folded nick. NAMES and WHOIS update these on the receive
+// goroutine while PYX events do on theirs.
+type sigilMap struct {
+	lock   sync.Mutex
+	sigils map[string]string
+}
+
+func newSigilMap() *sigilMap {
+	return &sigilMap{sigils: make(map[string]string)}
+}
+
+func (sigils *sigilMap) get(key string) string {
+	sigils.lock.Lock()
+	defer sigils.lock.Unlock()
+	return sigils.sigils[key]
+}
+
+// Returns what it was before, and if we knew it at all.
+func (sigils *sigilMap) set(key string, sigil string) (string, bool) {
+	sigils.lock.Lock()
+	defer sigils.lock.Unlock()
+	old, known := sigils.sigils[key]
+	sigils.sigils[key] = sigil
+	return old, known
+}
+
+func (sigils *sigilMap) remove(key string) {
+	sigils.lock.Lock()
+	defer sigils.lock.Unlock()
+	delete(sigils.sigils, key)
+}
+
+// Folded nicks that both NAMES and PYX events change, like the quiet joins we're holding back.
+type nickSet struct {
+	lock  sync.Mutex
+	nicks map[string]bool
+}
+
+func newNickSet() *nickSet {
+	return &nickSet{nicks: make(map[string]bool)}
+}
+
+func (set *nickSet) add(key string) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	set.nicks[key] = true
+}
+
+func (set *nickSet) has(key string) bool {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	return set.nicks[key]
+}
+
+// Removes key, returning if it was there.
+func (set *nickSet) take(key string) bool {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	if !set.nicks[key] {
+		return false
+	}
+	delete(set.nicks, key)
+	return true
+}
+
+func (set *nickSet) clear() {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	set.nicks = make(map[string]bool)
+}
+
+// Record the sigil we've seen for a user, and tell the IRC client if it changed. nick must not
+// include the sigil.
+func (client *Client) updateSigil(nick string, sigil string) {
+	key := client.config.fold(nick)
+	old, known := client.sigils.set(key, sigil)
+	if known && old == sigil {
+		return
+	}
+	if client.roster != nil {
+		client.roster.updateSigil(nick, sigil)
+	}
+	if !known || !client.isRegistered() || client.quietNicks.has(key) || !client.inGlobalChannel() {
+		return
+	}
+
+	log.Debugf("Sigil for %s changed from '%s' to '%s'", nick, old, sigil)
+	modes := ""
+	if old == pyx.Sigil_ADMIN {
+		modes = modes + "-o"
+	} else if old == pyx.Sigil_ID_CODE {
+		modes = modes + "-v"
+	}
+	if sigil == pyx.Sigil_ADMIN {
+		modes = modes + "+o"
+	} else if sigil == pyx.Sigil_ID_CODE {
+		modes = modes + "+v"
+	}
+	if len(modes) > 2 {
+		client.data.push(newLine(client.botNickUserAtHost(), "MODE").
+			param(client.config.GlobalChannel, modes, nick, nick).String())
+	} else if len(modes) > 0 {
+		client.data.push(newLine(client.botNickUserAtHost(), "MODE").
+			param(client.config.GlobalChannel, modes, nick).String())
+	}
+}
+
+// Make a hostname that is unique to, and stable for, a nick, so that clients can tell users apart
+// for ignores and the like. Any sigil on the nick is ignored.
+func cloakHost(nick string, suffix string) string {
+	nick = strings.ToLower(strings.TrimLeft(nick, pyx.Sigil_ADMIN+pyx.Sigil_ID_CODE))
+	sum := sha1.Sum([]byte(nick))
+	return hex.EncodeToString(sum[:])[:10] + "." + suffix
 }
 
 func isEmote(msg string) (bool, string) {
@@ -93,27 +243,67 @@ func totalUserCount(game *pyx.GameInfo) int {
 	return len(game.Players) + len(game.Spectators)
 }
 
-func makeGameTopic(game *pyx.GameInfo) string {
-	// TODO include information about card sets, but cardcast stuff isn't included in this data set
-	// at all...
+// past this, the card sets in a topic are just counted instead of listed
+const MaxTopicCardSetsLength = 100
+
+// Cardcast decks aren't included in the game info, so they have to be provided separately. They can
+// only be retrieved for the game the user is in, so customDecks may be nil for other games.
+func makeGameTopic(game *pyx.GameInfo, cardSets []string,
+	customDecks []pyx.CardSetData) string {
 	passwdLabel := ""
 	if game.HasPassword {
 		passwdLabel = "(Has password.) "
 	}
-	return fmt.Sprintf("%s's game (%s). %s%d score goal. %d/%d players, %d/%d spectators.",
+	cardSetsLabel := ""
+	if len(cardSets) > 0 {
+		names := strings.Join(cardSets, ", ")
+		if len(names) > MaxTopicCardSetsLength {
+			// topics have a length limit, and some games have every set turned on
+			names = fmt.Sprintf("%d sets", len(cardSets))
+		}
+		cardSetsLabel = fmt.Sprintf(" Card sets: %s.", names)
+	}
+	decksLabel := ""
+	if len(customDecks) > 0 {
+		names := []string{}
+		for _, deck := range customDecks {
+			names = append(names, deck.CardSetName)
+		}
+		decksLabel = fmt.Sprintf(" Custom decks: %s.", strings.Join(names, ", "))
+	}
+	return fmt.Sprintf("%s's game (%s). %s%d score goal. %d/%d players, %d/%d spectators.%s%s",
 		game.Host, pyx.GameStateMsgs[game.State], passwdLabel, game.GameOptions.ScoreLimit,
 		len(game.Players), game.GameOptions.PlayerLimit, len(game.Spectators),
-		game.GameOptions.SpectatorLimit)
+		game.GameOptions.SpectatorLimit, cardSetsLabel, decksLabel)
+}
+
+// Put the black card on the end of a game topic, cutting it short if it would make the topic too
+// long.
+func addTopicCard(topic string, card pyx.BlackCardData) string {
+	label := fmt.Sprintf(" Black card (pick %d): ", card.Pick)
+	text := []rune(card.Text)
+	room := MaxTopicLength - len(topic) - len(label)
+	if room <= len("...") {
+		return topic
+	}
+	if len(string(text)) > room {
+		// cut at a rune boundary, so we don't send half a character
+		for len(string(text)) > room-len("...") {
+			text = text[:len(text)-1]
+		}
+		return topic + label + string(text) + "..."
+	}
+	return topic + label + string(text)
 }
 
 func (client *Client) getGameFromChannel(channel string) (int, bool, error) {
-	if strings.HasPrefix(channel, client.config.GameChannelPrefix) {
+	if client.config.hasPrefixFold(channel, client.config.GameChannelPrefix) {
 		id, err := strconv.Atoi(channel[len(client.config.GameChannelPrefix):])
 		if err != nil {
 			goto badChannel
 		}
 		return id, false, nil
-	} else if strings.HasPrefix(channel, client.config.SpectateGameChannelPrefix) {
+	} else if client.config.hasPrefixFold(channel, client.config.SpectateGameChannelPrefix) {
 		id, err := strconv.Atoi(channel[len(client.config.SpectateGameChannelPrefix):])
 		if err != nil {
 			goto badChannel
@@ -125,13 +315,14 @@ badChannel:
 }
 
 func (client *Client) getGameChannel() string {
-	if client.gameId == nil {
+	gameId, ok := client.currentGame()
+	if !ok {
 		return ""
 	}
 	if client.gameIsSpectate {
-		return client.config.SpectateGameChannelPrefix + strconv.Itoa(*client.gameId)
+		return client.config.SpectateGameChannelPrefix + strconv.Itoa(gameId)
 	} else {
-		return client.config.GameChannelPrefix + strconv.Itoa(*client.gameId)
+		return client.config.GameChannelPrefix + strconv.Itoa(gameId)
 	}
 }
 
@@ -144,15 +335,22 @@ func whiteCardText(card pyx.WhiteCardData) string {
 }
 
 func getJudge(playerInfo *[]pyx.GamePlayerInfo) string {
-	for _, player := range *playerInfo {
+	judge := findJudge(*playerInfo)
+	if judge == "" {
+		// This should be impossible
+		log.Error("getJudge called without a judge in the player info?!")
+	}
+	return judge
+}
+
+// Like getJudge, but for when not having a judge is expected.
+func findJudge(playerInfo []pyx.GamePlayerInfo) string {
+	for _, player := range playerInfo {
 		if player.Status == pyx.GamePlayerStatus_JUDGE ||
 			player.Status == pyx.GamePlayerStatus_JUDGING {
 			return player.Name
-			break
 		}
 	}
-	// This should be impossible
-	log.Error("getJudge called without a judge in the player info?!")
 	return ""
 }
 
@@ -160,3 +358,8 @@ func getJudge(playerInfo *[]pyx.GamePlayerInfo) string {
 func strEqCI(left string, right string) bool {
 	return strings.ToLower(left) == strings.ToLower(right)
 }
+
+func (client *Client) sendServerNotice(format string, args ...interface{}) {
+	client.data.push(newLine(client.config.AdvertisedName, "NOTICE").param(client.nick).
+		textf(format, args...).String())
+}
@@ -24,6 +24,10 @@
 package irc
 
 import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -68,3 +72,186 @@ func TestJoinIntoLines(t *testing.T) {
 		}
 	}
 }
+
+func TestCloakHost(t *testing.T) {
+	host := cloakHost("Test", "users.localhost")
+	if !strings.HasSuffix(host, ".users.localhost") {
+		t.Error("Expected users.localhost suffix, got", host)
+	}
+	for _, nick := range []string{"test", "TEST", "@Test", "+test"} {
+		if cloakHost(nick, "users.localhost") != host {
+			t.Error("Expected", nick, "to have host", host,
+				"got", cloakHost(nick, "users.localhost"))
+		}
+	}
+	if cloakHost("other", "users.localhost") == host {
+		t.Error("Expected a different host for a different nick, got", host)
+	}
+}
+
+func TestHostIgnoresSigil(t *testing.T) {
+	client := &Client{config: &Config{UserHostname: "users.localhost"}, sigils: newSigilMap()}
+	host := client.getHost("bob")
+	for _, sigil := range []string{pyx.Sigil_ID_CODE, pyx.Sigil_ADMIN} {
+		client.sigils.set("bob", sigil)
+		if client.getHost("bob") != host || client.getHost(sigil+"bob") != host {
+			t.Error("For", sigil, "expected", host, "got", client.getHost(sigil+"bob"))
+		}
+	}
+}
+
+type pyxServerPassTestPair struct {
+	pass    string
+	address string
+	idcode  string
+}
+
+var pyxServerPassTests = []pyxServerPassTestPair{
+	{"secret", "http://default/", "secret"},
+	{"other:secret", "http://other/", "secret"},
+	{"OTHER:secret", "http://other/", "secret"},
+	{"other:", "http://other/", ""},
+	{"nope:secret", "http://default/", "nope:secret"},
+	{"main:secret", "http://default/", "secret"},
+	{"other:a:b", "http://other/", "a:b"},
+}
+
+func TestSplitPyxServerPass(t *testing.T) {
+	config := &Config{}
+	config.Pyx.Name = "main"
+	config.Pyx.BaseAddress = "http://default/"
+	config.PyxServers = append(config.PyxServers, pyx.Config{Name: "other",
+		BaseAddress: "http://other/"})
+	for _, pair := range pyxServerPassTests {
+		pyxConfig, idcode := config.splitPyxServerPass(pair.pass)
+		if pyxConfig.BaseAddress != pair.address || idcode != pair.idcode {
+			t.Error("For", pair.pass,
+				"expected", pair.address, pair.idcode,
+				"got", pyxConfig.BaseAddress, idcode,
+			)
+		}
+	}
+}
+
+type parsePassTestPair struct {
+	bridgePassword string
+	pass           string
+	bridgePass     string
+	address        string
+	idcode         string
+}
+
+var parsePassTests = []parsePassTestPair{
+	{"", "secret", "", "http://default/", "secret"},
+	{"", "other:secret", "", "http://default/", "secret"},
+	{"", "pyx-2:secret", "", "http://pyx-2/", "secret"},
+	{"", "alice:secret", "", "http://default/", "secret"},
+	{"", "bridge/secret", "", "http://default/", "bridge/secret"},
+	{"bridge", "bridge/secret", "bridge", "http://default/", "secret"},
+	{"bridge", "bridge/pyx-2:secret", "bridge", "http://pyx-2/", "secret"},
+	{"bridge", "bridge/alice:secret", "bridge", "http://default/", "secret"},
+	{"bridge", "bridge", "bridge", "http://default/", ""},
+	{"bridge", "secret", "secret", "http://default/", ""},
+}
+
+func TestParsePass(t *testing.T) {
+	config := &Config{}
+	config.Pyx.BaseAddress = "http://default/"
+	config.PyxServers = append(config.PyxServers, pyx.Config{Name: "pyx-2",
+		BaseAddress: "http://pyx-2/"})
+	for _, pair := range parsePassTests {
+		config.Password = pair.bridgePassword
+		bridgePass, pyxConfig, idcode := config.parsePass(pair.pass)
+		if bridgePass != pair.bridgePass || pyxConfig.BaseAddress != pair.address ||
+			idcode != pair.idcode {
+			t.Error("For", pair.bridgePassword, pair.pass,
+				"expected", pair.bridgePass, pair.address, pair.idcode,
+				"got", bridgePass, pyxConfig.BaseAddress, idcode,
+			)
+		}
+	}
+}
+
+func TestAddTopicCard(t *testing.T) {
+	card := pyx.BlackCardData{Pick: 2, Text: "Step 1: ____. Step 2: ____. Step 3: Profit."}
+	topic := addTopicCard("alice's game.", card)
+	expected := "alice's game. Black card (pick 2): " + card.Text
+	if topic != expected {
+		t.Error("For", card.Text, "expected", expected, "got", topic)
+	}
+
+	long := strings.Repeat("a", MaxTopicLength-40)
+	card.Text = strings.Repeat("é", 50)
+	topic = addTopicCard(long, card)
+	if len(topic) > MaxTopicLength || !strings.HasSuffix(topic, "é...") {
+		t.Error("For a long topic expected at most", MaxTopicLength, "ending in é... got", topic)
+	}
+
+	full := strings.Repeat("a", MaxTopicLength)
+	if topic = addTopicCard(full, card); topic != full {
+		t.Error("For a full topic expected it unchanged, got", topic)
+	}
+}
+
+func TestSigilMap(t *testing.T) {
+	sigils := newSigilMap()
+	if old, known := sigils.set("bob", "+"); known || old != "" {
+		t.Error("For a new nick expected '' false, got", old, known)
+	}
+	if old, known := sigils.set("bob", "@"); !known || old != "+" {
+		t.Error("For a changed sigil expected + true, got", old, known)
+	}
+	sigils.remove("bob")
+	if sigil := sigils.get("bob"); sigil != "" {
+		t.Error("For a removed nick expected '', got", sigil)
+	}
+
+	// NAMES on the receive goroutine while PYX events come in on theirs
+	var wg sync.WaitGroup
+	for g := 0; g < 2; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i % 10)
+				sigils.set(key, strconv.Itoa(g))
+				sigils.get(key)
+				sigils.remove(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestNickSet(t *testing.T) {
+	set := newNickSet()
+	set.add("bob")
+	if !set.has("bob") || !set.take("bob") || set.has("bob") || set.take("bob") {
+		t.Error("For taking bob expected him to be there once")
+	}
+	set.add("carol")
+	set.clear()
+	if set.has("carol") {
+		t.Error("For a cleared set expected carol to be gone")
+	}
+
+	// NAMES clears it on the receive goroutine while joins and quits come in on the event one
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			set.clear()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			key := strconv.Itoa(i % 10)
+			set.add(key)
+			set.has(key)
+			set.take(key)
+		}
+	}()
+	wg.Wait()
+}
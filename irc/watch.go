@@ -0,0 +1,365 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+// Watching games read-only, on top of the one game PYX lets us have a seat in
+
+package irc
+
+import (
+	"github.com/ajanata/pyx-irc/pyx"
+	"strconv"
+	"sync"
+)
+
+// What we last told the client about a game it's watching.
+type watchedGame struct {
+	channel    string
+	host       string
+	created    int64
+	players    []string
+	spectators []string
+	topic      string
+}
+
+// The games a client is watching, by id. Both commands and PYX events get at it.
+type watchList struct {
+	lock  sync.Mutex
+	games map[int]watchedGame
+}
+
+func newWatchList() *watchList {
+	return &watchList{games: make(map[int]watchedGame)}
+}
+
+func (list *watchList) get(gameId int) (watchedGame, bool) {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	watched, ok := list.games[gameId]
+	return watched, ok
+}
+
+func (list *watchList) count() int {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	return len(list.games)
+}
+
+// Returns false if we're already watching the game.
+func (list *watchList) add(gameId int, watched watchedGame) bool {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	if _, ok := list.games[gameId]; ok {
+		return false
+	}
+	list.games[gameId] = watched
+	return true
+}
+
+// Replaces what we know about a game, if we're still watching it.
+func (list *watchList) update(gameId int, watched watchedGame) {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	if _, ok := list.games[gameId]; ok {
+		list.games[gameId] = watched
+	}
+}
+
+func (list *watchList) remove(gameId int) {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	delete(list.games, gameId)
+}
+
+func (list *watchList) all() map[int]watchedGame {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	games := make(map[int]watchedGame, len(list.games))
+	for gameId, watched := range list.games {
+		games[gameId] = watched
+	}
+	return games
+}
+
+// Everyone watching each game without a seat in it, and who is passing each game's chat along to
+// them, by PYX server and game id. PYX only sends game chat to people in the game, so whoever on the
+// bridge sees it first keeps relaying it until they leave.
+var gameWatchers = struct {
+	lock     sync.Mutex
+	watchers map[string]map[*Client]bool
+	feeders  map[string]*Client
+}{watchers: make(map[string]map[*Client]bool), feeders: make(map[string]*Client)}
+
+func watchKey(pyxConfig *pyx.Config, gameId int) string {
+	return pyxConfig.BaseAddress + " " + strconv.Itoa(gameId)
+}
+
+// How many games someone can watch besides the one they are in.
+func (config *Config) watchLimit() int {
+	if config.MaxWatchedGames < 0 {
+		return 0
+	}
+	return config.MaxWatchedGames
+}
+
+// The game we're watching in channel, if we are.
+func (client *Client) watchedGame(channel string) (int, *watchedGame) {
+	gameId, spectate, err := client.getGameFromChannel(channel)
+	if err != nil || !spectate {
+		return -1, nil
+	}
+	if watched, ok := client.watching.get(gameId); ok {
+		return gameId, &watched
+	}
+	return -1, nil
+}
+
+// JOIN a game's spectate channel while already in another game. We can't actually spectate it, so
+// the game list is used to show who's there, and anyone on the bridge in the game relays the chat.
+func (client *Client) watchGame(gameId int) {
+	channel := client.config.SpectateGameChannelPrefix + strconv.Itoa(gameId)
+	if _, ok := client.watching.get(gameId); ok {
+		return
+	}
+	if client.watching.count() >= client.config.watchLimit() {
+		client.data.push(client.n.format(ErrTooManyChannels, client.nick,
+			"%s :Too many joined channels.", channel))
+		return
+	}
+	games, err := client.pyx().GameList()
+	if err != nil {
+		client.data.push(client.n.format(ErrServiceConfused, client.nick,
+			"%s :Cannot watch game: %s", channel, err))
+		return
+	}
+	info := findGame(games.Games, gameId)
+	if info == nil {
+		client.data.push(client.n.format(ErrNoSuchChannel, client.nick, "%s :No such channel",
+			channel))
+		return
+	}
+	if info.HasPassword {
+		// nobody gets to see it without the password, and there's no way to check it from here
+		client.data.push(client.n.format(ErrBadChannelKey, client.nick,
+			"%s :Cannot watch a game with a password, leave your game to spectate it", channel))
+		return
+	}
+
+	watched := watchedGame{
+		channel:    channel,
+		host:       info.Host,
+		created:    info.Created,
+		players:    append([]string{}, info.Players...),
+		spectators: append([]string{}, info.Spectators...),
+		topic:      client.watchTopic(info),
+	}
+	if !client.watching.add(gameId, watched) {
+		return
+	}
+	gameWatchers.lock.Lock()
+	key := watchKey(client.pyxConfig, gameId)
+	if gameWatchers.watchers[key] == nil {
+		gameWatchers.watchers[key] = make(map[*Client]bool)
+	}
+	gameWatchers.watchers[key][client] = true
+	gameWatchers.lock.Unlock()
+
+	client.data.push(client.joinLine(client.nick, channel))
+	client.watchTopicReply(&watched)
+	client.watchNames(&watched)
+}
+
+// PART a watched game, or the game went away.
+func (client *Client) unwatchGame(gameId int) {
+	client.watching.remove(gameId)
+	gameWatchers.lock.Lock()
+	defer gameWatchers.lock.Unlock()
+	key := watchKey(client.pyxConfig, gameId)
+	delete(gameWatchers.watchers[key], client)
+	if len(gameWatchers.watchers[key]) == 0 {
+		delete(gameWatchers.watchers, key)
+	}
+}
+
+// Stop watching everything and relaying anything, when disconnecting.
+func (client *Client) clearWatches() {
+	gameWatchers.lock.Lock()
+	for key, watchers := range gameWatchers.watchers {
+		delete(watchers, client)
+		if len(watchers) == 0 {
+			delete(gameWatchers.watchers, key)
+		}
+	}
+	gameWatchers.lock.Unlock()
+	client.stopRelaying()
+}
+
+// Let someone else in the game take over relaying its chat, after leaving it.
+func (client *Client) stopRelaying() {
+	gameWatchers.lock.Lock()
+	defer gameWatchers.lock.Unlock()
+	for key, feeder := range gameWatchers.feeders {
+		if feeder == client {
+			delete(gameWatchers.feeders, key)
+		}
+	}
+}
+
+// Pass chat in our game along to everyone watching it, unless someone else already is.
+func (client *Client) relayGameChat(event *pyx.ChatEvent) {
+	key := watchKey(client.pyxConfig, *event.GameId)
+	gameWatchers.lock.Lock()
+	defer gameWatchers.lock.Unlock()
+	watchers := gameWatchers.watchers[key]
+	if len(watchers) == 0 {
+		return
+	}
+	if feeder, ok := gameWatchers.feeders[key]; ok && feeder != client {
+		return
+	}
+	gameWatchers.feeders[key] = client
+	from := client.getNickUserAtHost(event.From)
+	text := event.Message
+	if event.Emote {
+		text = makeEmote(text)
+	}
+	for watcher := range watchers {
+		if watcher.isSilenced(event.From) {
+			continue
+		}
+		watcher.data.push(newLine(from, "PRIVMSG").
+			param(watcher.config.SpectateGameChannelPrefix + strconv.Itoa(*event.GameId)).
+			text(text).String())
+	}
+}
+
+// The game list changed, so see what changed in the games we're watching.
+func (client *Client) refreshWatches() {
+	watching := client.watching.all()
+	if len(watching) == 0 {
+		return
+	}
+	games, err := client.pyx().GameList()
+	if err != nil {
+		log.Errorf("Unable to retrieve game list to update watched games for %s: %v", client.nick,
+			err)
+		return
+	}
+	for gameId, watched := range watching {
+		info := findGame(games.Games, gameId)
+		if info == nil {
+			client.data.push(newLine(client.botNickUserAtHost(), "KICK").
+				param(watched.channel, client.nick).text("The game has ended.").String())
+			client.unwatchGame(gameId)
+			continue
+		}
+		client.updateWatch(&watched, info)
+		client.watching.update(gameId, watched)
+	}
+}
+
+// Show everyone coming, going, and changing seats since the last time we looked.
+func (client *Client) updateWatch(watched *watchedGame, info *pyx.GameInfo) {
+	wasPlayer := stringSet(watched.players)
+	wasSpectator := stringSet(watched.spectators)
+	isPlayer := stringSet(info.Players)
+	isSpectator := stringSet(info.Spectators)
+	bot := client.botNickUserAtHost()
+
+	for _, nick := range append(append([]string{}, info.Players...), info.Spectators...) {
+		if !wasPlayer[nick] && !wasSpectator[nick] {
+			client.data.push(client.joinLine(nick, watched.channel))
+		}
+	}
+	for _, nick := range info.Players {
+		if !wasPlayer[nick] {
+			client.data.push(newLine(bot, "MODE").param(watched.channel, "+v", nick).String())
+		}
+	}
+	for _, nick := range watched.players {
+		if !isPlayer[nick] && isSpectator[nick] {
+			client.data.push(newLine(bot, "MODE").param(watched.channel, "-v", nick).String())
+		}
+	}
+	for _, nick := range append(append([]string{}, watched.players...), watched.spectators...) {
+		if !isPlayer[nick] && !isSpectator[nick] {
+			client.data.push(newLine(client.getNickUserAtHost(nick), "PART").
+				param(watched.channel).text("Leaving").String())
+		}
+	}
+	watched.host = info.Host
+	watched.players = append([]string{}, info.Players...)
+	watched.spectators = append([]string{}, info.Spectators...)
+
+	if topic := client.watchTopic(info); topic != watched.topic {
+		watched.topic = topic
+		client.data.push(newLine(bot, "TOPIC").param(watched.channel).text(topic).String())
+	}
+}
+
+// What the topic would be if we were in the game. We don't know about its custom decks or the
+// current black card.
+func (client *Client) watchTopic(info *pyx.GameInfo) string {
+	return makeGameTopic(info, client.pyx().CardSetNames(info.GameOptions.CardSets), nil)
+}
+
+func (client *Client) watchTopicReply(watched *watchedGame) {
+	client.data.push(client.n.reply(RplTopic, client.nick).param(watched.channel).
+		text(watched.topic).String())
+	client.data.push(client.n.reply(RplTopicWhoTime, client.nick).param(watched.channel,
+		client.getNickUserAtHost(watched.host), strconv.FormatInt(watched.created/1000, 10)).
+		String())
+}
+
+func (client *Client) watchNames(watched *watchedGame) {
+	names := []string{}
+	for _, player := range watched.players {
+		if player == watched.host {
+			names = append(names, "@"+player)
+		} else {
+			names = append(names, "+"+player)
+		}
+	}
+	names = append(append(names, watched.spectators...), "&"+client.config.BotNick)
+	// TODO a proper length based on 512 minus broilerplate
+	for _, line := range joinIntoLines(300, names, " ") {
+		client.data.push(client.n.format(RplNames, client.nick, "= %s :%s", watched.channel, line))
+	}
+	client.data.push(client.n.format(RplEndNames, client.nick, "%s :End of /NAMES list",
+		watched.channel))
+}
+
+func findGame(games []pyx.GameInfo, gameId int) *pyx.GameInfo {
+	for i := range games {
+		if games[i].Id == gameId {
+			return &games[i]
+		}
+	}
+	return nil
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
@@ -0,0 +1,54 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+// Private messages between people using the bridge. PYX has no way to send them, so anyone on the
+// web can't be reached like this.
+
+package irc
+
+import (
+	"fmt"
+)
+
+// Pass a PRIVMSG straight to someone else connected to the bridge with the same PYX server.
+func (client *Client) sendWhisper(nick string, text string) {
+	from := client.getNickUserAtHost(client.nick)
+	delivered := client.sendToUser(nick, func(target *Client) string {
+		if target.isSilenced(client.nick) {
+			// they don't get told, same as a real server
+			return ""
+		}
+		return fmt.Sprintf(":%s PRIVMSG %s :%s", from, target.nick, text)
+	})
+	if !delivered {
+		client.data.push(client.n.format(ErrNoSuchNick, client.nick,
+			"%s :No such nick (they aren't using IRC)", nick))
+		return
+	}
+	if client.hasCap("echo-message") {
+		client.data.push(fmt.Sprintf("%s:%s PRIVMSG %s :%s", client.accountTag(client.nick), from,
+			nick, text))
+	}
+	if client.isAway(nick) {
+		client.data.push(client.n.format(RplAway, client.nick, "%s :Idle", nick))
+	}
+}
@@ -0,0 +1,132 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// WHOWAS, from the people we've seen leave each PYX server
+
+package irc
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type whowasEntry struct {
+	nick     string
+	left     time.Time
+	reason   string
+	realname string
+	// the last game we saw them join, or 0
+	lastGame int
+}
+
+// every client sees the same people leave, so only keep one of them
+const whowasDuplicateWindow = 10 * time.Second
+
+// Remember that someone left. Newest entries are at the end.
+func (r *roster) recordQuit(nick string, reason string, size int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := strings.ToLower(nick)
+	now := time.Now()
+	for i := len(r.whowas) - 1; i >= 0; i-- {
+		entry := r.whowas[i]
+		if now.Sub(entry.left) > whowasDuplicateWindow {
+			break
+		}
+		if strings.ToLower(entry.nick) == key {
+			return
+		}
+	}
+	r.whowas = append(r.whowas, whowasEntry{
+		nick:     nick,
+		left:     now,
+		reason:   reason,
+		realname: r.realnameLocked(nick),
+		lastGame: r.games[key],
+	})
+	delete(r.games, key)
+	if len(r.whowas) > size {
+		r.whowas = r.whowas[len(r.whowas)-size:]
+	}
+}
+
+func (r *roster) joinedGame(nick string, gameId int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.games[strings.ToLower(nick)] = gameId
+}
+
+// Up to count entries for nick, newest first. count <= 0 means all of them.
+func (r *roster) findWhowas(nick string, count int) []whowasEntry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	found := []whowasEntry{}
+	for i := len(r.whowas) - 1; i >= 0; i-- {
+		if strEqCI(r.whowas[i].nick, nick) {
+			found = append(found, r.whowas[i])
+			if count > 0 && len(found) >= count {
+				break
+			}
+		}
+	}
+	return found
+}
+
+func handleWhowas(client *Client, msg Message) {
+	if len(msg.args) == 0 {
+		client.data.push(client.n.format(ErrNeedMoreParams, client.nick,
+			"WHOWAS :Not enough parameters"))
+		return
+	}
+	count := 0
+	if len(msg.args) > 1 {
+		count, _ = strconv.Atoi(msg.args[1])
+	}
+
+	for _, nick := range strings.Split(msg.args[0], ",") {
+		entries := client.roster.findWhowas(nick, count)
+		if len(entries) == 0 {
+			client.data.push(client.n.format(ErrWasNoSuchNick, client.nick,
+				"%s :There was no such nickname", nick))
+		}
+		for _, entry := range entries {
+			client.data.push(client.n.format(RplWhowasUser, client.nick, "%s %s %s * :%s",
+				entry.nick,
+				getUser(entry.nick), client.getHost(entry.nick),
+				entry.realname))
+			client.data.push(client.n.format(RplWhoisServer, client.nick, "%s %s :%s", entry.nick,
+				client.config.AdvertisedName, entry.left.UTC().Format(time.RFC1123)))
+			if entry.lastGame != 0 {
+				client.data.push(client.n.format(RplWhoisSpecial, client.nick,
+					"%s :Was last in %s%d",
+					entry.nick, client.config.GameChannelPrefix, entry.lastGame))
+			}
+			if entry.reason != "" {
+				client.data.push(client.n.format(RplWhoisSpecial, client.nick, "%s :Left: %s",
+					entry.nick, entry.reason))
+			}
+		}
+		client.data.push(client.n.format(RplEndOfWhowas, client.nick, "%s :End of WHOWAS", nick))
+	}
+}
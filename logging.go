@@ -0,0 +1,133 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/op/go-logging"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var logFormat = logging.MustStringFormatter(`%{color}%{time:15:04:05.000} %{level:.5s} %{id:03x} %{shortfunc} (%{shortfile}) %{color:reset}>%{message}`)
+
+// same thing without the colors, for places that aren't a terminal
+var plainLogFormat = logging.MustStringFormatter(`%{time:2006-01-02 15:04:05.000} %{level:.5s} %{id:03x} %{shortfunc} (%{shortfile}) >%{message}`)
+
+// One JSON object per line, for log aggregators.
+type jsonFormatter struct{}
+
+type jsonRecord struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Module  string `json:"module"`
+	Id      uint64 `json:"id"`
+	File    string `json:"file"`
+	Message string `json:"message"`
+}
+
+func (f jsonFormatter) Format(calldepth int, r *logging.Record, output io.Writer) error {
+	file := "???"
+	if _, path, line, ok := runtime.Caller(calldepth + 1); ok {
+		file = fmt.Sprintf("%s:%d", filepath.Base(path), line)
+	}
+	b, err := json.Marshal(jsonRecord{
+		Time:    r.Time.Format(time.RFC3339Nano),
+		Level:   r.Level.String(),
+		Module:  r.Module,
+		Id:      r.ID,
+		File:    file,
+		Message: r.Message(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(b)
+	return err
+}
+
+func setUpLogging(config *Config) error {
+	var backend logging.Backend
+	var format logging.Formatter
+	switch strings.ToLower(config.LogOutput) {
+	case "stderr":
+		backend = logging.NewLogBackend(os.Stderr, "", 0)
+		format = logFormat
+	case "file":
+		if config.LogFile == "" {
+			return fmt.Errorf("log_file is required when logging to a file")
+		}
+		file, err := os.OpenFile(config.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		backend = logging.NewLogBackend(file, "", 0)
+		format = plainLogFormat
+	case "syslog":
+		syslog, err := logging.NewSyslogBackend("pyx-irc")
+		if err != nil {
+			return err
+		}
+		backend = syslog
+		// syslog adds its own timestamp
+		format = logging.MustStringFormatter(`%{level:.5s} %{id:03x} %{shortfunc} (%{shortfile}) >%{message}`)
+	default:
+		return fmt.Errorf("unknown log_output %s", config.LogOutput)
+	}
+
+	switch strings.ToLower(config.LogFormat) {
+	case "text":
+	case "json":
+		format = jsonFormatter{}
+	default:
+		return fmt.Errorf("unknown log_format %s", config.LogFormat)
+	}
+
+	leveled := logging.AddModuleLevel(logging.NewBackendFormatter(backend, format))
+	level, err := logging.LogLevel(config.LogLevel)
+	if err != nil {
+		return err
+	}
+	leveled.SetLevel(level, "")
+	// e.g. "irc=DEBUG"
+	for _, moduleLevel := range config.LogModuleLevels {
+		parts := strings.SplitN(moduleLevel, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("log level %s should look like module=LEVEL", moduleLevel)
+		}
+		module := parts[0]
+		level, err := logging.LogLevel(parts[1])
+		if err != nil {
+			return fmt.Errorf("log level for %s: %v", module, err)
+		}
+		leveled.SetLevel(level, module)
+	}
+	logging.SetBackend(leveled)
+	return nil
+}
@@ -28,13 +28,16 @@ import (
 	"github.com/ajanata/pyx-irc/irc"
 	"github.com/ajanata/pyx-irc/util"
 	"github.com/op/go-logging"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var log = logging.MustGetLogger("main")
-var logFormat = logging.MustStringFormatter(`%{color}%{time:15:04:05.000} %{level:.5s} %{id:03x} %{shortfunc} (%{shortfile}) %{color:reset}>%{message}`)
 
 var GitBranch = "(unknown)"
 var GitSummary = "(unknown)"
@@ -42,16 +45,11 @@ var GitSummary = "(unknown)"
 func main() {
 	config := loadConfig()
 
-	backendStdErr := logging.NewLogBackend(os.Stderr, "", 0)
-	formattedStdErr := logging.NewBackendFormatter(backendStdErr, logFormat)
-	stdErrLeveled := logging.AddModuleLevel(formattedStdErr)
-	level, err := logging.LogLevel(config.LogLevel)
+	err := setUpLogging(config)
 	if err != nil {
 		fmt.Printf("Unable to configure logging: %s", err)
 		return
 	}
-	stdErrLeveled.SetLevel(level, "")
-	logging.SetBackend(stdErrLeveled)
 
 	log.Infof("Starting pyx-irc-%s-%s...", GitBranch, GitSummary)
 	// govvv says that -pkg will set the ldflags to set these in the packag directly, but I never
@@ -60,15 +58,40 @@ func main() {
 	util.GitSummary = GitSummary
 
 	if config.RunDebugServer {
+		// pprof registers itself on the default mux
+		host, _, err := net.SplitHostPort(config.DebugServerAddress)
+		if ip := net.ParseIP(host); err == nil && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			log.Warningf("Debug server is listening on non-loopback address %s, anyone who can reach it can profile this process!",
+				config.DebugServerAddress)
+		}
+		log.Infof("Starting debug server on %s", config.DebugServerAddress)
 		go func() {
-			log.Info(http.ListenAndServe("localhost:6680", nil))
+			log.Info(http.ListenAndServe(config.DebugServerAddress, nil))
 		}()
 	}
 
+	if config.HealthAddress != "" {
+		go runHealthServer(config.HealthAddress)
+	}
+
+	irc.ReloadConfig = reloadServerConfigs
 	for _, server := range config.Servers {
 		log.Debugf("server config: %+v", server)
 		go irc.StartServer(server)
 	}
 
-	select {}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	sig := <-signals
+	for sig == syscall.SIGHUP {
+		log.Info("Received SIGHUP, rehashing...")
+		if servers, err := reloadServerConfigs(); err != nil {
+			log.Errorf("Unable to reload configuration: %v", err)
+		} else {
+			irc.Rehash(servers)
+		}
+		sig = <-signals
+	}
+	log.Infof("Received %v, shutting down...", sig)
+	irc.Shutdown("Server shutting down", time.Duration(config.ShutdownTimeoutSeconds)*time.Second)
 }
@@ -0,0 +1,91 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+// Prometheus-style metrics, served next to the health check
+
+package main
+
+import (
+	"fmt"
+	"github.com/ajanata/pyx-irc/irc"
+	"io"
+	"net/http"
+)
+
+func writeMetric(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// One line for each server, labeled with its port.
+func writePerServer(w io.Writer, name string, help string, servers []irc.ServerMetrics,
+	value func(irc.ServerMetrics) int) {
+	writeMetric(w, name, "gauge", help)
+	for _, server := range servers {
+		fmt.Fprintf(w, "%s{port=\"%d\"} %d\n", name, server.Port, value(server))
+	}
+}
+
+// One line for each PYX server, labeled with its address.
+func writePerPyx(w io.Writer, name string, help string, servers []irc.PyxMetrics,
+	value func(irc.PyxMetrics) int) {
+	writeMetric(w, name, "gauge", help)
+	for _, server := range servers {
+		fmt.Fprintf(w, "%s{pyx=%q} %d\n", name, server.Address, value(server))
+	}
+}
+
+func writeCounter(w io.Writer, name string, help string, value int64) {
+	writeMetric(w, name, "counter", help)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics := irc.CollectMetrics()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writePerServer(w, "pyxirc_clients", "Connected clients.", metrics.Servers,
+		func(server irc.ServerMetrics) int { return server.Clients })
+	writePerServer(w, "pyxirc_send_queue_lines", "Lines waiting to be sent to clients.",
+		metrics.Servers, func(server irc.ServerMetrics) int { return server.QueuedLines })
+	writePerServer(w, "pyxirc_send_queue_max_lines",
+		"Lines waiting to be sent to the client furthest behind.", metrics.Servers,
+		func(server irc.ServerMetrics) int { return server.MaxQueuedLines })
+	writePerServer(w, "pyxirc_pyx_queued_events", "PYX events waiting for clients to handle them.",
+		metrics.Servers, func(server irc.ServerMetrics) int { return server.QueuedEvents })
+	writePerPyx(w, "pyxirc_local_users", "People using PYX through the bridge.", metrics.Pyx,
+		func(server irc.PyxMetrics) int { return server.LocalUsers })
+	writePerPyx(w, "pyxirc_local_users_max",
+		"The most people there have been using PYX through the bridge.", metrics.Pyx,
+		func(server irc.PyxMetrics) int { return server.MaxLocalUsers })
+	writePerPyx(w, "pyxirc_global_users", "People on the PYX server, as far as the bridge knows.",
+		metrics.Pyx, func(server irc.PyxMetrics) int { return server.GlobalUsers })
+	writePerPyx(w, "pyxirc_global_users_max",
+		"The most people there have been on the PYX server, as far as the bridge knows.",
+		metrics.Pyx, func(server irc.PyxMetrics) int { return server.MaxGlobalUsers })
+	writeCounter(w, "pyxirc_send_dropped_lines_total",
+		"Lines thrown away for clients that weren't keeping up.", metrics.DroppedLines)
+	writeCounter(w, "pyxirc_send_queue_overflows_total",
+		"Clients disconnected for letting their send queue fill up.", metrics.SendQueueOverflows)
+	writeCounter(w, "pyxirc_send_stalls_total", "Writes to clients that were slow to go out.",
+		metrics.SendStalls)
+	writeCounter(w, "pyxirc_pyx_event_stalls_total",
+		"Times the PYX long poll waited for a client to take an event.", metrics.PyxEventStalls)
+}
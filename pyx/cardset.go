@@ -0,0 +1,68 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package pyx
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Cardcast decks are given negative IDs by the server, which are their deck code in base 36.
+func (data *CardSetData) IsCardcast() bool {
+	return data.Id < 0
+}
+
+// The Cardcast deck code for this card set, or an empty string if it is not from Cardcast.
+func (data *CardSetData) CardcastCode() string {
+	if !data.IsCardcast() {
+		return ""
+	}
+	return strings.ToUpper(strconv.FormatInt(int64(-data.Id), 36))
+}
+
+// The built-in card sets available on the server.
+func (client *Client) CardSets() []CardSetData {
+	return client.cardSets
+}
+
+func (client *Client) CardSet(id int) (CardSetData, bool) {
+	for _, cardSet := range client.cardSets {
+		if cardSet.Id == id {
+			return cardSet, true
+		}
+	}
+	return CardSetData{}, false
+}
+
+// Names of the built-in card sets with the given ids, in the same order. Cardcast decks and
+// anything else we don't know about are skipped.
+func (client *Client) CardSetNames(ids []int) []string {
+	names := []string{}
+	for _, id := range ids {
+		if cardSet, ok := client.CardSet(id); ok {
+			names = append(names, cardSet.CardSetName)
+		}
+	}
+	return names
+}
@@ -27,10 +27,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"gopkg.in/resty.v1"
+	"net"
+	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -42,46 +46,114 @@ var broadcastingUsersRegex = regexp.MustCompile("cah.BROADCASTING_USERS = (true|
 type Client struct {
 	BroadcastingUsers bool
 	GlobalChatEnabled bool
-	IncomingEvents    chan *LongPollResponse
 	ServerStarted     int64
 	User              *User
-	stop              chan bool
-	stopped           bool
-	pollWg            sync.WaitGroup
-	http              *resty.Client
-	sessionId         string
-	serial            int
-	config            *Config
+	// if we picked up a session that was already logged in, and the game it was in
+	Resumed       bool
+	ResumedGameId *int
+	cookies       []*http.Cookie
+	detached      bool
+	loggedOut     bool
+	// the built-in card sets the server has
+	cardSets []CardSetData
+	bus      eventBus
+	// timestamp of the last event the server sent
+	lastEventTimestamp int64
+	stop               chan bool
+	// the long poll, the keepalive, and whoever is done with us can all try to close at once
+	closeOnce sync.Once
+	pollWg    sync.WaitGroup
+	http      *resty.Client
+	limiter   *requestLimiter
+	sessionId string
+	// the keepalive makes requests alongside everything else; use atomically
+	serial int64
+	// when we last made a request, in unix nanoseconds; use atomically
+	lastRequest int64
+	config      *Config
+	// if set, called with every request and response, for debugging a single client
+	Trace func(format string, args ...interface{})
+	// if set, called when a long poll fails and is going to be retried
+	PollFailed func(failures int, err error)
 }
 
 func NewClient(nick string, idcode string, config *Config) (*Client, error) {
+	http, err := newHttpClient(config)
+	if err != nil {
+		return nil, err
+	}
 	client := &Client{
-		IncomingEvents: make(chan *LongPollResponse),
-		stop:           make(chan bool, 1),
-		http:           resty.New(),
-		config:         config,
+		stop:    make(chan bool, 1),
+		http:    http,
+		limiter: newRequestLimiter(config),
+		config:  config,
 	}
 
-	client.http.
+	saved := takeSavedSession(config, nick, idcode)
+	if saved != nil {
+		log.Debugf("Trying to resume session %s for %s", saved.sessionId, nick)
+		client.sessionId = saved.sessionId
+		client.cookies = saved.cookies
+		client.http.SetCookies(saved.cookies)
+	}
+	firstLoad, err := client.prepare()
+	if err != nil {
+		return client, err
+	}
+	if firstLoad.InProgress {
+		if !strings.EqualFold(firstLoad.Nickname, nick) {
+			return client, fmt.Errorf("Session %s belongs to %s", client.sessionId,
+				firstLoad.Nickname)
+		}
+		return client, client.resume(firstLoad, idcode)
+	}
+	if saved != nil {
+		// the old session expired, so start over with a clean slate
+		log.Debugf("Session %s for %s has expired", saved.sessionId, nick)
+		return NewClient(nick, idcode, config)
+	}
+	return client, client.login(nick, idcode)
+}
+
+func newHttpClient(config *Config) (*resty.Client, error) {
+	transport, err := sharedTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	http := resty.New().
 		SetHeader("User-Agent", "PYX-IRC").
 		SetHostURL(config.BaseAddress).
+		SetTransport(transport).
 		SetRetryCount(3).
 		SetTimeout(time.Duration(1 * time.Minute))
 	if config.HttpDebug {
-		client.http.SetDebug(true)
+		http.SetDebug(true)
 	}
+	return http, nil
+}
 
-	err := client.prepare()
+// Checks that the server is up by doing everything short of logging in. Returns when the server
+// was started.
+func Probe(config *Config) (int64, error) {
+	http, err := newHttpClient(config)
 	if err != nil {
-		return client, err
+		return 0, err
 	}
-	return client, client.login(nick, idcode)
+	client := &Client{
+		http:   http,
+		config: config,
+	}
+	_, err = client.prepare()
+	return client.ServerStarted, err
 }
 
 // long poll goroutine
 func (client *Client) receive() {
 	log.Debugf("Starting long poll routine for session %s", client.sessionId)
 	client.pollWg.Add(1)
+	failures := 0
+	gap := false
+	var failedAt time.Time
 	for {
 		select {
 		case <-client.stop:
@@ -89,122 +161,274 @@ func (client *Client) receive() {
 			client.pollWg.Done()
 			return
 		default:
-			resp, err := client.http.NewRequest().
-				Post("/LongPollServlet")
-
-			if err != nil {
-				log.Errorf("Long poll for session %s received error: %+v", client.sessionId, err)
-				// order matters here!
-				client.pollWg.Done()
-				client.Close()
-				return
+			fatal, lost, err := client.poll()
+			if err == nil {
+				if failures > 0 {
+					client.bus.publish(&ReconnectedEvent{
+						EventHeader:   EventHeader{EventType: LocalEvent_RECONNECTED},
+						Down:          time.Since(failedAt),
+						Gap:           gap,
+						LastTimestamp: client.lastEventTimestamp,
+					}, client.stop)
+				}
+				failures = 0
+				gap = false
+				continue
 			}
-
-			var res interface{}
-			// this is dumb but I can't figure out another way to do it
-			if !strings.HasPrefix(resp.Header().Get("Content-Type"), "application/json") {
-				// probably an error of some description
-				log.Errorf("Didn't get JSON response for long poll for session %s, body: %s",
-					client.sessionId, resp.String())
-				// order matters here!
-				client.pollWg.Done()
-				client.Close()
-				return
+			if failures == 0 {
+				failedAt = time.Now()
 			}
-			if strings.HasPrefix(resp.String(), "[") {
-				// array of LongPollResponse
-				var t []*LongPollResponse
-				err = json.Unmarshal(resp.Body(), &t)
-				res = t
-			} else {
-				var t *LongPollResponse
-				err = json.Unmarshal(resp.Body(), &t)
-				res = t
+			failures++
+			gap = gap || lost
+			if !fatal && failures == serverDownFailures {
+				client.bus.publish(&ServerDownEvent{
+					EventHeader: EventHeader{EventType: LocalEvent_SERVER_DOWN},
+					Since:       failedAt,
+				}, client.stop)
 			}
-
-			switch v := res.(type) {
-			case *LongPollResponse:
-				// bare object, likely an error or no-op
-				singleResult := v
-				err = checkPollForError(singleResult, err)
-				if err != nil {
-					log.Errorf("Long poll for session %s received error: %+v", client.sessionId,
-						err)
-					// order matters here!
+			if !fatal && failures <= client.config.MaxPollFailures {
+				backoff := pollBackoff(failures)
+				log.Warningf("Long poll for session %s failed (%d/%d), retrying in %s: %+v",
+					client.sessionId, failures, client.config.MaxPollFailures, backoff, err)
+				if hook := client.PollFailed; hook != nil {
+					hook(failures, err)
+				}
+				select {
+				case <-client.stop:
+					log.Infof("Stopping long poll for client %s", client.sessionId)
 					client.pollWg.Done()
-					client.Close()
 					return
+				case <-time.After(backoff):
 				}
-				client.dispatchSinglePyxEvent(singleResult)
-			case []*LongPollResponse:
-				// array of objects, so can't be an error
-				for _, event := range v {
-					client.dispatchSinglePyxEvent(event)
+				// if the server restarted, our session is gone and there's no point in retrying
+				fatal, err = client.revalidate()
+				if !fatal {
+					continue
 				}
-			default:
-				log.Errorf("No idea what the type of this is: %+v", res)
 			}
+
+			log.Errorf("Long poll for session %s received error: %+v", client.sessionId, err)
+			if started, restarted := client.restartedSince(); restarted {
+				log.Infof("Server restarted at %d, session %s is gone", started, client.sessionId)
+				client.bus.publish(&ServerRestartedEvent{
+					EventHeader:   EventHeader{EventType: LocalEvent_SERVER_RESTARTED},
+					ServerStarted: started,
+				}, client.stop)
+			}
+			// order matters here!
+			client.pollWg.Done()
+			client.Close()
+			return
 		}
 	}
 }
 
+// Make a request every so often if nothing else has, so the session doesn't expire while the user
+// is idle.
+func (client *Client) keepalive() {
+	interval := time.Duration(client.config.KeepaliveSeconds) * time.Second
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.stop:
+			return
+		case now := <-ticker.C:
+			last := time.Unix(0, atomic.LoadInt64(&client.lastRequest))
+			if now.Sub(last) < interval {
+				continue
+			}
+			log.Debugf("Sending keepalive for session %s", client.sessionId)
+			// the cheapest thing PYX will answer, and it doesn't use up the user's requests
+			resp, err := client.sendNoErrorCheck(map[string]string{
+				AjaxRequest_OP: AjaxOperation_NAMES,
+			})
+			if err = checkForError(resp, err); sessionGone(err) {
+				log.Errorf("Keepalive for session %s failed: %+v", client.sessionId, err)
+				client.Close()
+				return
+			} else if err != nil {
+				log.Warningf("Keepalive for session %s failed: %+v", client.sessionId, err)
+			}
+		}
+	}
+}
+
+// 1s, 2s, 4s, ... up to 30s
+func pollBackoff(failures int) time.Duration {
+	backoff := time.Second << uint(failures-1)
+	if backoff > 30*time.Second || backoff <= 0 {
+		backoff = 30 * time.Second
+	}
+	return backoff
+}
+
+// Do a single long poll and dispatch the events it returns. Errors reported by PYX are fatal,
+// since they mean something is wrong with our session; anything else may be a transient problem.
+// Also returns whether events may have been lost with a failed poll: PYX hands events over as soon
+// as it answers, so if the answer went missing after PYX got the request, so did they.
+func (client *Client) poll() (bool, bool, error) {
+	resp, err := client.http.NewRequest().
+		Post("/LongPollServlet")
+	if err != nil {
+		return false, !neverSent(err), err
+	}
+
+	var res interface{}
+	// this is dumb but I can't figure out another way to do it
+	if !strings.HasPrefix(resp.Header().Get("Content-Type"), "application/json") {
+		// probably an error of some description. a proxy saying PYX isn't there means PYX never
+		// saw the poll, but if it gave up waiting on PYX, PYX may have answered anyway.
+		unavailable := resp.StatusCode() == http.StatusBadGateway ||
+			resp.StatusCode() == http.StatusServiceUnavailable
+		return false, !unavailable, fmt.Errorf("Didn't get JSON response for long poll, "+
+			"status: %s, body: %s", resp.Status(), resp.String())
+	}
+	if strings.HasPrefix(resp.String(), "[") {
+		// array of LongPollResponse
+		var t []*LongPollResponse
+		err = json.Unmarshal(resp.Body(), &t)
+		res = t
+	} else {
+		var t *LongPollResponse
+		err = json.Unmarshal(resp.Body(), &t)
+		res = t
+	}
+	if err != nil {
+		// whatever was in there is gone
+		return false, true, err
+	}
+
+	switch v := res.(type) {
+	case *LongPollResponse:
+		// bare object, likely an error or no-op
+		err = checkPollForError(v, nil)
+		if err != nil {
+			return true, false, err
+		}
+		client.dispatchSinglePyxEvent(v)
+	case []*LongPollResponse:
+		// array of objects, so can't be an error
+		for _, event := range v {
+			client.dispatchSinglePyxEvent(event)
+		}
+	default:
+		log.Errorf("No idea what the type of this is: %+v", res)
+	}
+	return false, false, nil
+}
+
+// Make sure the server still knows who we are after a failure. Only returns fatal if the server
+// told us the session is gone; we'll find out about any other problem on the next poll.
+func (client *Client) revalidate() (bool, error) {
+	resp, err := client.sendNoErrorCheck(map[string]string{
+		AjaxRequest_OP: AjaxOperation_FIRST_LOAD,
+	})
+	if err = checkForError(resp, err); err != nil {
+		return sessionGone(err), err
+	}
+	if !resp.InProgress {
+		return true, fmt.Errorf("Session %s is no longer registered", client.sessionId)
+	}
+	return false, nil
+}
+
+// Whether PYX itself told us it doesn't know who we are anymore. Anything else, like the server
+// being down or a proxy answering for it, may clear up on its own.
+func sessionGone(err error) bool {
+	switch ErrorCode(err) {
+	case ErrorCode_NOT_REGISTERED, ErrorCode_NO_SESSION, ErrorCode_SESSION_EXPIRED:
+		return true
+	}
+	return false
+}
+
+// Whether a request failed before it ever got to the server, so the server can't have done
+// anything with it.
+func neverSent(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
 func (client *Client) dispatchSinglePyxEvent(event *LongPollResponse) {
 	log.Debugf("Received long poll for session %s: %+v", client.sessionId, event)
+	if trace := client.Trace; trace != nil {
+		trace("PYX event: %+v", event)
+	}
 	if event.Event == LongPollEvent_NOOP {
 		return
 	}
-	client.IncomingEvents <- event
+	client.invalidateShared(event)
+	client.lastEventTimestamp = event.Timestamp
+	client.bus.publish(NewEvent(event), client.stop)
 }
 
-// Make initial contact with PYX and obtain a session. Obtain server configuration information.
-// Does not log in. Logging in should be done within half a minute of this call so that the session
-// does not expire.
-func (client *Client) prepare() error {
-	resp, err := client.http.NewRequest().Get("/game.jsp")
-	if err != nil {
-		return err
-	}
-	for _, c := range resp.Cookies() {
-		if "JSESSIONID" == c.Name {
-			client.sessionId = c.Value
-			break
+// Make initial contact with PYX and obtain a session, unless we already have one we are trying to
+// resume. Obtain server configuration information. Does not log in. Logging in should be done
+// within half a minute of this call so that the session does not expire. Returns the FIRST_LOAD
+// response so the caller can tell if the session is already logged in.
+func (client *Client) prepare() (*AjaxResponse, error) {
+	if client.sessionId == "" {
+		resp, err := client.http.NewRequest().Get("/game.jsp")
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range resp.Cookies() {
+			if "JSESSIONID" == c.Name {
+				client.sessionId = c.Value
+				break
+			}
 		}
+		client.cookies = resp.Cookies()
+		client.http.SetCookies(resp.Cookies())
 	}
-	client.http.SetCookies(resp.Cookies())
 
-	resp, err = client.http.NewRequest().Get("/js/cah.config.js")
+	err := client.loadServerConfig()
 	if err != nil {
-		return err
-	}
-	matches := globalChatEnabledRegex.FindStringSubmatch(resp.String())
-	if len(matches) > 1 {
-		client.GlobalChatEnabled, _ = strconv.ParseBool(matches[1])
-	}
-	matches = broadcastingUsersRegex.FindStringSubmatch(resp.String())
-	if len(matches) > 1 {
-		client.BroadcastingUsers, _ = strconv.ParseBool(matches[1])
+		return nil, err
 	}
 
 	flResp, err := client.send(map[string]string{
 		AjaxRequest_OP: AjaxOperation_FIRST_LOAD,
 	})
+	if err != nil {
+		return nil, err
+	}
+	client.ServerStarted = flResp.ServerStarted
+	client.cardSets = flResp.CardSets
+
+	return flResp, nil
+}
+
+// Pick up a session that is already logged in, and start the long poll goroutine.
+func (client *Client) resume(firstLoad *AjaxResponse, idcode string) error {
+	// first load doesn't tell us our sigil
+	resp, err := client.Whois(firstLoad.Nickname)
 	if err != nil {
 		return err
 	}
-	if flResp.InProgress {
-		return fmt.Errorf("Session %s already in progress, not yet implemented (next=%s)",
-			client.sessionId, flResp.Next)
+	client.User = newUser(firstLoad.Nickname, resp.Sigil, idcode)
+	client.Resumed = true
+	if firstLoad.Next == ReconnectNextAction_GAME {
+		client.ResumedGameId = firstLoad.GameId
 	}
-	client.ServerStarted = flResp.ServerStarted
-	// TODO save the card sets somewhere
-	log.Debugf("Cards: %+v", flResp.CardSets)
+	log.Infof("Resumed session %s for %s (next=%s)", client.sessionId, client.User.Name,
+		firstLoad.Next)
 
 	return nil
 }
 
-// Log in to the server and start the long poll goroutine
+// Start the long poll and keepalive goroutines. Subscribe to events before calling this.
+func (client *Client) Start() {
+	go client.receive()
+	go client.keepalive()
+}
+
+// Log in to the server
 func (client *Client) login(nick string, idcode string) error {
-	// TODO persistent ID?
 	req := map[string]string{
 		AjaxRequest_OP:       AjaxOperation_REGISTER,
 		AjaxRequest_NICKNAME: nick,
@@ -212,6 +436,9 @@ func (client *Client) login(nick string, idcode string) error {
 	if len(idcode) > 0 {
 		req[AjaxRequest_ID_CODE] = idcode
 	}
+	if pid := getPersistentId(client.config, nick, idcode); pid != "" {
+		req[AjaxRequest_PERSISTENT_ID] = pid
+	}
 	resp, err := client.send(req)
 	err = checkForError(resp, err)
 	if err != nil {
@@ -219,20 +446,18 @@ func (client *Client) login(nick string, idcode string) error {
 	}
 
 	client.User = newUser(resp.Nickname, resp.Sigil, resp.IdCode)
-
-	go client.receive()
+	storePersistentId(client.config, nick, idcode, resp.PersistentId)
 
 	return nil
 }
 
 func (client *Client) Names() ([]string, error) {
-	resp, err := client.send(map[string]string{
-		AjaxRequest_OP: AjaxOperation_NAMES,
-	})
+	resp, err := client.sendShared(AjaxOperation_NAMES)
 	if err != nil {
 		return []string{}, err
 	}
-	return resp.Names, nil
+	// the response is shared, so callers get their own copy to append to
+	return append([]string{}, resp.Names...), nil
 }
 
 func (client *Client) SendGlobalChat(msg string, emote bool) error {
@@ -263,27 +488,69 @@ func (client *Client) sendChat(msg string, emote bool, wall bool, gameId int) er
 	return err
 }
 
-func (client *Client) Whois(nick string) (*AjaxResponse, error) {
-	return client.send(map[string]string{
+func (client *Client) Whois(nick string) (*WhoisResult, error) {
+	resp, err := client.send(map[string]string{
 		AjaxRequest_OP:       AjaxOperation_WHOIS,
 		AjaxRequest_NICKNAME: nick,
 	})
+	if err != nil {
+		return nil, err
+	}
+	return newWhoisResult(resp), nil
 }
 
-func (client *Client) GameList() (*AjaxResponse, error) {
-	return client.send(map[string]string{
-		AjaxRequest_OP: AjaxOperation_GAME_LIST,
-	})
+// The result may be shared with other clients, so don't modify it.
+func (client *Client) GameList() (*GameListResult, error) {
+	resp, err := client.sendShared(AjaxOperation_GAME_LIST)
+	if err != nil {
+		return nil, err
+	}
+	return newGameListResult(resp), nil
 }
 
-func (client *Client) GameInfo(gameId int) (*AjaxResponse, error) {
-	return client.send(map[string]string{
+// Like GameList, but never uses a shared response that may be out of date.
+func (client *Client) FreshGameList() (*GameListResult, error) {
+	sharedFetcher.invalidate(fetchKey(client.config, AjaxOperation_GAME_LIST))
+	return client.GameList()
+}
+
+func (client *Client) GameInfo(gameId int) (*GameInfoResult, error) {
+	resp, err := client.send(map[string]string{
 		AjaxRequest_OP:      AjaxOperation_GET_GAME_INFO,
 		AjaxRequest_GAME_ID: strconv.Itoa(gameId),
 	})
+	if err != nil {
+		return nil, err
+	}
+	return newGameInfoResult(resp), nil
+}
+
+// Stop talking to the server without logging out, so the session can be resumed by a new
+// connection for the same user before it expires.
+func (client *Client) Detach() {
+	client.detached = true
+	// sessions without an identification code are up for grabs by anyone using the nick, so
+	// don't let those get resumed
+	if client.User != nil && client.User.IdCode != "" {
+		saveSession(client.config, client.User.Name, &savedSession{
+			sessionId: client.sessionId,
+			cookies:   client.cookies,
+			idCode:    client.User.IdCode,
+			saved:     time.Now(),
+		})
+		log.Infof("Detached session %s for %s", client.sessionId, client.User.Name)
+	}
+	// nobody might be listening anymore, so make sure the long poll doesn't get stuck
+	client.bus.drain()
+	client.Close()
+}
+
+func (client *Client) IsDetached() bool {
+	return client.detached
 }
 
 func (client *Client) LogOut() {
+	client.loggedOut = true
 	// disregard result since we're throwing the user away anyway
 	client.send(map[string]string{
 		AjaxRequest_OP: AjaxOperation_LOG_OUT,
@@ -291,6 +558,24 @@ func (client *Client) LogOut() {
 	client.Close()
 }
 
+func (client *Client) IsLoggedOut() bool {
+	return client.loggedOut
+}
+
+// Create a new game, hosted by this user. The server puts us in the game and returns its id.
+func (client *Client) CreateGame() (int, error) {
+	resp, err := client.send(map[string]string{
+		AjaxRequest_OP: AjaxOperation_CREATE_GAME,
+	})
+	if err != nil {
+		return NoGameIdSentinel, err
+	}
+	if resp.GameId == nil {
+		return NoGameIdSentinel, fmt.Errorf("Server did not send the new game's id")
+	}
+	return *resp.GameId, nil
+}
+
 func (client *Client) LeaveGame(gameId int) (*AjaxResponse, error) {
 	return client.send(map[string]string{
 		AjaxRequest_OP:      AjaxOperation_LEAVE_GAME,
@@ -314,8 +599,73 @@ func (client *Client) JoinGame(gameId int, password string) (*AjaxResponse, erro
 	})
 }
 
+// Change the options for a game. Only the host of the game may do this, and only while the game is
+// in the lobby. All of the options are sent, so start from the game's current options.
+func (client *Client) ChangeGameOptions(gameId int, options GameOptionData) (*AjaxResponse, error) {
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return &AjaxResponse{}, err
+	}
+	return client.send(map[string]string{
+		AjaxRequest_OP:           AjaxOperation_CHANGE_GAME_OPTIONS,
+		AjaxRequest_GAME_ID:      strconv.Itoa(gameId),
+		AjaxRequest_GAME_OPTIONS: string(encoded),
+	})
+}
+
+func (client *Client) CardcastListCardsets(gameId int) (*AjaxResponse, error) {
+	return client.send(map[string]string{
+		AjaxRequest_OP:      AjaxOperation_CARDCAST_LIST_CARDSETS,
+		AjaxRequest_GAME_ID: strconv.Itoa(gameId),
+	})
+}
+
+func (client *Client) CardcastAddCardset(gameId int, code string) (*AjaxResponse, error) {
+	return client.send(map[string]string{
+		AjaxRequest_OP:          AjaxOperation_CARDCAST_ADD_CARDSET,
+		AjaxRequest_GAME_ID:     strconv.Itoa(gameId),
+		AjaxRequest_CARDCAST_ID: code,
+	})
+}
+
+func (client *Client) CardcastRemoveCardset(gameId int, code string) (*AjaxResponse, error) {
+	return client.send(map[string]string{
+		AjaxRequest_OP:          AjaxOperation_CARDCAST_REMOVE_CARDSET,
+		AjaxRequest_GAME_ID:     strconv.Itoa(gameId),
+		AjaxRequest_CARDCAST_ID: code,
+	})
+}
+
+// Retrieve the current hand, black card, and played white cards for a game.
+func (client *Client) GetCards(gameId int) (*AjaxResponse, error) {
+	return client.send(map[string]string{
+		AjaxRequest_OP:      AjaxOperation_GET_CARDS,
+		AjaxRequest_GAME_ID: strconv.Itoa(gameId),
+	})
+}
+
+// Play a card from our hand. message is the text to use for a blank card, and must be empty for any
+// other card.
+func (client *Client) PlayCard(gameId int, cardId int, message string) (*AjaxResponse, error) {
+	req := map[string]string{
+		AjaxRequest_OP:      AjaxOperation_PLAY_CARD,
+		AjaxRequest_GAME_ID: strconv.Itoa(gameId),
+		AjaxRequest_CARD_ID: strconv.Itoa(cardId),
+	}
+	if len(message) > 0 {
+		req[AjaxRequest_MESSAGE] = message
+	}
+	return client.send(req)
+}
+
 // Make the request on the server, and check for PYX application errors.
 func (client *Client) send(request map[string]string) (*AjaxResponse, error) {
+	// always let people leave
+	if request[AjaxRequest_OP] != AjaxOperation_LOG_OUT {
+		if err := client.limiter.wait(); err != nil {
+			return nil, err
+		}
+	}
 	resp, err := client.sendNoErrorCheck(request)
 	return resp, checkForError(resp, err)
 }
@@ -329,7 +679,7 @@ func checkForError(response *AjaxResponse, reqError error) error {
 		return reqError
 	}
 	if response.Error {
-		return fmt.Errorf("PYX error: %s", ErrorCodeMsgs[response.ErrorCode])
+		return &Error{Code: response.ErrorCode}
 	}
 	return nil
 }
@@ -341,7 +691,7 @@ func checkPollForError(response *LongPollResponse, reqError error) error {
 		return reqError
 	}
 	if response.Error {
-		return fmt.Errorf("PYX error: %s", ErrorCodeMsgs[response.ErrorCode])
+		return &Error{Code: response.ErrorCode}
 	}
 	return nil
 }
@@ -352,30 +702,44 @@ func (client *Client) sendNoErrorCheck(request map[string]string) (*AjaxResponse
 	for k, v := range request {
 		reqCopy[k] = v
 	}
-	reqCopy[AjaxRequest_SERIAL] = strconv.Itoa(client.serial)
-	client.serial++
+	reqCopy[AjaxRequest_SERIAL] = strconv.FormatInt(atomic.AddInt64(&client.serial, 1)-1, 10)
+	atomic.StoreInt64(&client.lastRequest, time.Now().UnixNano())
 
+	trace := client.Trace
+	if trace != nil {
+		trace("PYX request: %v", reqCopy)
+	}
 	resp, err := client.http.NewRequest().
 		SetResult(AjaxResponse{}).
 		SetFormData(reqCopy).Post("/AjaxServlet")
 	if err != nil {
 		log.Errorf("Request %+v failed: %+v", request, err)
 		// TODO do we have to return here or will the Result call always do something sane enough?
+	} else if resp.IsError() ||
+		!strings.HasPrefix(resp.Header().Get("Content-Type"), "application/json") {
+		// a proxy in front of the server, or the server falling over; nothing PYX said, so there's
+		// nothing to parse
+		err = fmt.Errorf("Didn't get a JSON response for request, status: %s", resp.Status())
+		log.Errorf("Request %+v failed: %+v", request, err)
+	}
+	if trace != nil {
+		if err != nil {
+			trace("PYX error: %v", err)
+		} else {
+			trace("PYX response: %s", resp.String())
+		}
 	}
 
 	return resp.Result().(*AjaxResponse), err
 }
 
+// Safe to call more than once, from any goroutine; the later calls wait for the first to finish.
 func (client *Client) Close() {
-	// make sure we only do this once, got a panic in an edge case before
-	if client.stopped {
-		return
-	}
-	client.stopped = true
-	log.Infof("Stopping client for session %s", client.sessionId)
-	client.stop <- true
-	close(client.stop)
-	client.pollWg.Wait()
-	close(client.IncomingEvents)
-	log.Infof("Client for session %s stopped", client.sessionId)
+	client.closeOnce.Do(func() {
+		log.Infof("Stopping client for session %s", client.sessionId)
+		close(client.stop)
+		client.pollWg.Wait()
+		client.bus.close()
+		log.Infof("Client for session %s stopped", client.sessionId)
+	})
 }
@@ -26,12 +26,115 @@ package pyx
 import ()
 
 type Config struct {
-	BaseAddress string `toml:"base_address"`
-	HttpDebug   bool   `toml:"debug"`
+	// used to pick this server when the bridge knows about more than one
+	Name                  string `toml:"name"`
+	BaseAddress           string `toml:"base_address"`
+	HttpDebug             bool   `toml:"debug"`
+	MaxPollFailures       int    `toml:"max_poll_failures"`
+	KeepaliveSeconds      int    `toml:"keepalive_interval"`
+	PersistentIdFile      string `toml:"persistent_id_file"`
+	CacheTtlSeconds       int    `toml:"cache_ttl"`
+	RequestBurst          int    `toml:"request_burst"`
+	RequestsPerMinute     int    `toml:"requests_per_minute"`
+	MaxRequestDelayMillis int    `toml:"max_request_delay"`
+	EventBufferSize       int    `toml:"event_buffer"`
+	// these have to match what the PYX server is configured with, or it'll have the final say
+	NickPattern     string   `toml:"nick_pattern"`
+	ReservedNicks   []string `toml:"reserved_nicks"`
+	MinIdCodeLength int      `toml:"min_id_code_length"`
+	MaxIdCodeLength int      `toml:"max_id_code_length"`
+	// PYX quietly clamps game options to its limits instead of complaining
+	MinPlayerLimit    int `toml:"min_player_limit"`
+	MaxPlayerLimit    int `toml:"max_player_limit"`
+	MinSpectatorLimit int `toml:"min_spectator_limit"`
+	MaxSpectatorLimit int `toml:"max_spectator_limit"`
+	// tuning for the connections shared by every client on the same server
+	MaxIdleConns               int  `toml:"max_idle_conns"`
+	MaxIdleConnsPerHost        int  `toml:"max_idle_conns_per_host"`
+	MaxConnsPerHost            int  `toml:"max_conns_per_host"`
+	IdleConnTimeoutSeconds     int  `toml:"idle_conn_timeout"`
+	DisableKeepAlives          bool `toml:"disable_keepalives"`
+	DialTimeoutSeconds         int  `toml:"dial_timeout"`
+	TlsHandshakeTimeoutSeconds int  `toml:"tls_handshake_timeout"`
+	Http2                      bool `toml:"http2"`
+	// http://, https://, or socks5:// URL; otherwise the usual environment variables are used
+	Proxy string `toml:"proxy"`
+	// PEM bundle of extra CAs to trust, for servers with private certificates
+	CaFile string `toml:"ca_file"`
+	// don't check the server's certificate at all; only for testing
+	InsecureSkipVerify bool `toml:"insecure_skip_verify"`
 }
 
 func (config *Config) EnsureDefaults() {
 	if config.BaseAddress == "" {
 		config.BaseAddress = "http://localhost:8080/"
 	}
+	if config.KeepaliveSeconds <= 0 {
+		config.KeepaliveSeconds = 60
+	}
+	// negative turns off caching
+	if config.CacheTtlSeconds == 0 {
+		config.CacheTtlSeconds = 10
+	}
+	if config.MaxPollFailures == 0 {
+		config.MaxPollFailures = 5
+	}
+	// negative burst turns off request rate limiting
+	if config.RequestBurst == 0 {
+		config.RequestBurst = 10
+	}
+	if config.RequestsPerMinute <= 0 {
+		config.RequestsPerMinute = 60
+	}
+	if config.MaxRequestDelayMillis <= 0 {
+		config.MaxRequestDelayMillis = 2000
+	}
+	// events are handed over one at a time unless this is set
+	if config.EventBufferSize < 0 {
+		config.EventBufferSize = 0
+	}
+	if config.NickPattern == "" {
+		config.NickPattern = DefaultNickPattern
+	}
+	if config.ReservedNicks == nil {
+		config.ReservedNicks = []string{"xyzzy"}
+	}
+	if config.MinIdCodeLength <= 0 {
+		config.MinIdCodeLength = 8
+	}
+	if config.MaxIdCodeLength <= 0 {
+		config.MaxIdCodeLength = 100
+	}
+	if config.MinPlayerLimit <= 0 {
+		config.MinPlayerLimit = 3
+	}
+	if config.MaxPlayerLimit <= 0 {
+		config.MaxPlayerLimit = 20
+	}
+	// no spectators at all is allowed
+	if config.MinSpectatorLimit < 0 {
+		config.MinSpectatorLimit = 0
+	}
+	if config.MaxSpectatorLimit <= 0 {
+		config.MaxSpectatorLimit = 20
+	}
+	if config.MaxIdleConns <= 0 {
+		config.MaxIdleConns = 1000
+	}
+	if config.MaxIdleConnsPerHost <= 0 {
+		config.MaxIdleConnsPerHost = 1000
+	}
+	// 0 is unlimited
+	if config.MaxConnsPerHost < 0 {
+		config.MaxConnsPerHost = 0
+	}
+	if config.IdleConnTimeoutSeconds <= 0 {
+		config.IdleConnTimeoutSeconds = 90
+	}
+	if config.DialTimeoutSeconds <= 0 {
+		config.DialTimeoutSeconds = 10
+	}
+	if config.TlsHandshakeTimeoutSeconds <= 0 {
+		config.TlsHandshakeTimeoutSeconds = 10
+	}
 }
@@ -0,0 +1,317 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Typed long poll events, and the subscriptions that deliver them
+
+package pyx
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// how many times an event had to wait for a subscriber, across every client; use atomically
+var eventStalls int64
+
+// How many times the long poll has been held up by a subscriber that wasn't ready for an event.
+func EventStalls() int64 {
+	return atomic.LoadInt64(&eventStalls)
+}
+
+// Something that happened on the server. Use a type switch or assertion to get at the details.
+type Event interface {
+	// one of the LongPollEvent constants
+	Type() string
+}
+
+// Common to every event.
+type EventHeader struct {
+	EventType string
+	Timestamp int64
+}
+
+func (header EventHeader) Type() string {
+	return header.EventType
+}
+
+// Global or game chat. Also used for filtered chat, which is only sent to admins.
+type ChatEvent struct {
+	EventHeader
+	From      string
+	Message   string
+	Emote     bool
+	Wall      bool
+	FromAdmin bool
+	Filtered  bool
+	// nil for global chat
+	GameId *int
+}
+
+// A user connecting to or disconnecting from the server.
+type PlayerEvent struct {
+	EventHeader
+	Nickname string
+	Sigil    string
+	IdCode   string
+	// why they left, for PLAYER_LEAVE
+	Reason string
+}
+
+// Something happening to one user in a game: joining, leaving, being skipped, etc.
+type GamePlayerEvent struct {
+	EventHeader
+	GameId   *int
+	Nickname string
+}
+
+type GameStateChangeEvent struct {
+	EventHeader
+	GameId    *int
+	GameState string
+	// only for PLAYING
+	BlackCard BlackCardData
+	// only for JUDGING
+	WhiteCards [][]WhiteCardData
+	// in milliseconds
+	PlayTimer int
+}
+
+type GameRoundCompleteEvent struct {
+	EventHeader
+	GameId      *int
+	RoundWinner string
+	WinningCard int
+	// in milliseconds
+	Intermission int
+}
+
+type GamePlayerInfoChangeEvent struct {
+	EventHeader
+	GameId     *int
+	PlayerInfo GamePlayerInfo
+}
+
+type HandDealEvent struct {
+	EventHeader
+	GameId *int
+	Hand   []WhiteCardData
+}
+
+// A Cardcast deck being added to or removed from a game.
+type CardcastEvent struct {
+	EventHeader
+	GameId  *int
+	CardSet CardSetData
+}
+
+type GameOptionsChangedEvent struct {
+	EventHeader
+	GameId   *int
+	GameInfo GameInfo
+}
+
+// Something happening to a game that has no other details, like a deck being reshuffled.
+type GameEvent struct {
+	EventHeader
+	GameId *int
+}
+
+// Something with no details at all, like being kicked or the game list changing.
+type SimpleEvent struct {
+	EventHeader
+}
+
+// Converts the wire format into the typed event for its type.
+func NewEvent(raw *LongPollResponse) Event {
+	header := EventHeader{EventType: raw.Event, Timestamp: raw.Timestamp}
+	switch raw.Event {
+	case LongPollEvent_CHAT, LongPollEvent_FILTERED_CHAT:
+		return &ChatEvent{
+			EventHeader: header,
+			From:        raw.From,
+			Message:     raw.Message,
+			Emote:       raw.Emote,
+			Wall:        raw.Wall,
+			FromAdmin:   raw.FromAdmin,
+			Filtered:    raw.Event == LongPollEvent_FILTERED_CHAT,
+			GameId:      raw.GameId,
+		}
+	case LongPollEvent_NEW_PLAYER, LongPollEvent_PLAYER_LEAVE:
+		return &PlayerEvent{
+			EventHeader: header,
+			Nickname:    raw.Nickname,
+			Sigil:       raw.Sigil,
+			IdCode:      raw.IdCode,
+			Reason:      raw.Reason,
+		}
+	case LongPollEvent_GAME_PLAYER_JOIN, LongPollEvent_GAME_SPECTATOR_JOIN,
+		LongPollEvent_GAME_PLAYER_LEAVE, LongPollEvent_GAME_SPECTATOR_LEAVE,
+		LongPollEvent_GAME_PLAYER_KICKED_IDLE, LongPollEvent_GAME_PLAYER_SKIPPED,
+		LongPollEvent_GAME_JUDGE_LEFT, LongPollEvent_GAME_JUDGE_SKIPPED:
+		return &GamePlayerEvent{
+			EventHeader: header,
+			GameId:      raw.GameId,
+			Nickname:    raw.Nickname,
+		}
+	case LongPollEvent_GAME_STATE_CHANGE:
+		return &GameStateChangeEvent{
+			EventHeader: header,
+			GameId:      raw.GameId,
+			GameState:   raw.GameState,
+			BlackCard:   raw.BlackCard,
+			WhiteCards:  raw.WhiteCards,
+			PlayTimer:   raw.PlayTimer,
+		}
+	case LongPollEvent_GAME_ROUND_COMPLETE:
+		return &GameRoundCompleteEvent{
+			EventHeader:  header,
+			GameId:       raw.GameId,
+			RoundWinner:  raw.RoundWinner,
+			WinningCard:  raw.WinningCard,
+			Intermission: raw.Intermission,
+		}
+	case LongPollEvent_GAME_PLAYER_INFO_CHANGE:
+		return &GamePlayerInfoChangeEvent{
+			EventHeader: header,
+			GameId:      raw.GameId,
+			PlayerInfo:  raw.PlayerInfo,
+		}
+	case LongPollEvent_HAND_DEAL:
+		return &HandDealEvent{
+			EventHeader: header,
+			GameId:      raw.GameId,
+			Hand:        raw.Hand,
+		}
+	case LongPollEvent_CARDCAST_ADD_CARDSET, LongPollEvent_CARDCAST_REMOVE_CARDSET:
+		return &CardcastEvent{
+			EventHeader: header,
+			GameId:      raw.GameId,
+			CardSet:     raw.CardcastDeckInfo,
+		}
+	case LongPollEvent_GAME_OPTIONS_CHANGED:
+		return &GameOptionsChangedEvent{
+			EventHeader: header,
+			GameId:      raw.GameId,
+			GameInfo:    raw.GameInfo,
+		}
+	case LongPollEvent_GAME_BLACK_RESHUFFLE, LongPollEvent_GAME_WHITE_RESHUFFLE,
+		LongPollEvent_HURRY_UP, LongPollEvent_KICKED_FROM_GAME_IDLE:
+		return &GameEvent{
+			EventHeader: header,
+			GameId:      raw.GameId,
+		}
+	default:
+		return &SimpleEvent{EventHeader: header}
+	}
+}
+
+type subscription struct {
+	// empty for every event
+	types  map[string]bool
+	events chan Event
+}
+
+// Bookkeeping for who wants which events.
+type eventBus struct {
+	lock          sync.Mutex
+	subscriptions []*subscription
+	closed        bool
+}
+
+// Subscribe to the given types of events, or every event if none are given. Subscribe before
+// calling Start to make sure nothing is missed. Events are delivered in order, and the long poll
+// waits for every subscriber to take each event, so subscribers must keep reading until the
+// channel is closed when the client stops.
+func (client *Client) Subscribe(eventTypes ...string) <-chan Event {
+	sub := &subscription{
+		types:  make(map[string]bool),
+		events: make(chan Event, client.config.EventBufferSize),
+	}
+	for _, eventType := range eventTypes {
+		sub.types[eventType] = true
+	}
+	client.bus.lock.Lock()
+	defer client.bus.lock.Unlock()
+	if client.bus.closed {
+		close(sub.events)
+	} else {
+		client.bus.subscriptions = append(client.bus.subscriptions, sub)
+	}
+	return sub.events
+}
+
+// How many events are waiting for subscribers to take them.
+func (client *Client) QueuedEvents() int {
+	client.bus.lock.Lock()
+	defer client.bus.lock.Unlock()
+	queued := 0
+	for _, sub := range client.bus.subscriptions {
+		queued += len(sub.events)
+	}
+	return queued
+}
+
+// Hand the event to everyone who wants it. Once stop is closed, nobody is waiting for it anymore:
+// a subscriber may well be the one closing the client, waiting on this long poll to finish.
+func (bus *eventBus) publish(event Event, stop <-chan bool) {
+	bus.lock.Lock()
+	subs := bus.subscriptions
+	bus.lock.Unlock()
+	for _, sub := range subs {
+		if len(sub.types) == 0 || sub.types[event.Type()] {
+			select {
+			case sub.events <- event:
+			default:
+				// they're still busy with something else, which holds up the long poll
+				atomic.AddInt64(&eventStalls, 1)
+				select {
+				case sub.events <- event:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (bus *eventBus) close() {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	bus.closed = true
+	for _, sub := range bus.subscriptions {
+		close(sub.events)
+	}
+	bus.subscriptions = nil
+}
+
+// Throw away everything still being sent to subscribers, for when nobody is listening anymore.
+func (bus *eventBus) drain() {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	for _, sub := range bus.subscriptions {
+		go func(events chan Event) {
+			for range events {
+			}
+		}(sub.events)
+	}
+}
@@ -0,0 +1,178 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package pyx
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how long to use the server's javascript configuration before fetching it again
+const serverConfigTtl = 5 * time.Minute
+
+// Fetches data that is the same for every user on a server once on behalf of every client on the
+// bridge, instead of each client asking the server for it. Requests are piggybacked on whichever
+// client asks first, and anyone else who asks while that is in flight waits for its result.
+// Responses are shared, so they must not be modified.
+type fetcher struct {
+	lock    sync.Mutex
+	entries map[string]*fetchEntry
+}
+
+type fetchEntry struct {
+	// closed once the request has finished
+	done    chan struct{}
+	fetched time.Time
+	resp    *AjaxResponse
+	config  *serverConfig
+	err     error
+}
+
+// Values from cah.config.js.
+type serverConfig struct {
+	globalChatEnabled bool
+	broadcastingUsers bool
+}
+
+var sharedFetcher = fetcher{entries: make(map[string]*fetchEntry)}
+
+func fetchKey(config *Config, what string) string {
+	return config.BaseAddress + " " + what
+}
+
+// Returns the entry to use and true if it is fresh or in flight, or a new entry that the caller
+// must fill in and finish and false otherwise.
+func (f *fetcher) start(key string, ttl time.Duration) (*fetchEntry, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	entry, ok := f.entries[key]
+	if ok {
+		select {
+		case <-entry.done:
+			if time.Since(entry.fetched) <= ttl {
+				return entry, true
+			}
+		default:
+			// somebody else is already fetching it
+			return entry, true
+		}
+	}
+	entry = &fetchEntry{done: make(chan struct{})}
+	f.entries[key] = entry
+	return entry, false
+}
+
+func (f *fetcher) finish(key string, entry *fetchEntry) {
+	entry.fetched = time.Now()
+	close(entry.done)
+	if entry.err != nil {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if f.entries[key] == entry {
+			delete(f.entries, key)
+		}
+	}
+}
+
+func (f *fetcher) invalidate(key string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.entries, key)
+}
+
+// Make a request for data that is the same for everyone, using the shared response if it's still
+// fresh.
+func (client *Client) sendShared(op string) (*AjaxResponse, error) {
+	request := map[string]string{
+		AjaxRequest_OP: op,
+	}
+	if client.config.CacheTtlSeconds < 0 {
+		return client.send(request)
+	}
+	key := fetchKey(client.config, op)
+	entry, shared := sharedFetcher.start(key,
+		time.Duration(client.config.CacheTtlSeconds)*time.Second)
+	if shared {
+		<-entry.done
+		if entry.err == nil {
+			return entry.resp, nil
+		}
+		// whoever made the request may have had a problem with their own session
+		return client.send(request)
+	}
+	entry.resp, entry.err = client.send(request)
+	sharedFetcher.finish(key, entry)
+	return entry.resp, entry.err
+}
+
+// Load the server's javascript configuration, or use the one another client loaded recently.
+func (client *Client) loadServerConfig() error {
+	key := fetchKey(client.config, "cah.config.js")
+	entry, shared := sharedFetcher.start(key, serverConfigTtl)
+	if !shared {
+		entry.config, entry.err = client.fetchServerConfig()
+		sharedFetcher.finish(key, entry)
+	}
+	<-entry.done
+	if entry.err != nil {
+		return entry.err
+	}
+	client.GlobalChatEnabled = entry.config.globalChatEnabled
+	client.BroadcastingUsers = entry.config.broadcastingUsers
+	return nil
+}
+
+// Pick up changes to the server's javascript configuration, like global chat being turned off,
+// once what we have is old enough.
+func (client *Client) RefreshServerConfig() error {
+	return client.loadServerConfig()
+}
+
+func (client *Client) fetchServerConfig() (*serverConfig, error) {
+	resp, err := client.http.NewRequest().Get("/js/cah.config.js")
+	if err != nil {
+		return nil, err
+	}
+	config := &serverConfig{}
+	matches := globalChatEnabledRegex.FindStringSubmatch(resp.String())
+	if len(matches) > 1 {
+		config.globalChatEnabled, _ = strconv.ParseBool(matches[1])
+	}
+	matches = broadcastingUsersRegex.FindStringSubmatch(resp.String())
+	if len(matches) > 1 {
+		config.broadcastingUsers, _ = strconv.ParseBool(matches[1])
+	}
+	return config, nil
+}
+
+// Throw away shared responses that the event tells us are out of date.
+func (client *Client) invalidateShared(event *LongPollResponse) {
+	switch event.Event {
+	case LongPollEvent_GAME_LIST_REFRESH:
+		sharedFetcher.invalidate(fetchKey(client.config, AjaxOperation_GAME_LIST))
+	case LongPollEvent_NEW_PLAYER, LongPollEvent_PLAYER_LEAVE:
+		sharedFetcher.invalidate(fetchKey(client.config, AjaxOperation_NAMES))
+	}
+}
@@ -0,0 +1,111 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package pyx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// A user's persistent ID, and a hash of the identification code they had when we got it, so
+// someone else using the same nick doesn't get their stats and preferences.
+type persistentIdEntry struct {
+	Id         string `json:"id"`
+	IdCodeHash string `json:"idc"`
+}
+
+// serializes access to the file, which may be shared by every server
+var persistentIdsLock sync.Mutex
+
+func hashIdCode(idCode string) string {
+	if idCode == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(idCode))
+	return hex.EncodeToString(sum[:])
+}
+
+func loadPersistentIds(path string) (map[string]persistentIdEntry, error) {
+	ids := make(map[string]persistentIdEntry)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ids, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return ids, json.Unmarshal(data, &ids)
+}
+
+// Returns the persistent ID we previously got for this user, or an empty string.
+func getPersistentId(config *Config, nick string, idCode string) string {
+	if config.PersistentIdFile == "" {
+		return ""
+	}
+	persistentIdsLock.Lock()
+	defer persistentIdsLock.Unlock()
+	ids, err := loadPersistentIds(config.PersistentIdFile)
+	if err != nil {
+		log.Errorf("Unable to load persistent IDs from %s: %v", config.PersistentIdFile, err)
+		return ""
+	}
+	entry, ok := ids[strings.ToLower(nick)]
+	if !ok || entry.IdCodeHash != hashIdCode(idCode) {
+		return ""
+	}
+	return entry.Id
+}
+
+func storePersistentId(config *Config, nick string, idCode string, id string) {
+	if config.PersistentIdFile == "" || id == "" {
+		return
+	}
+	persistentIdsLock.Lock()
+	defer persistentIdsLock.Unlock()
+	ids, err := loadPersistentIds(config.PersistentIdFile)
+	if err != nil {
+		log.Errorf("Unable to load persistent IDs from %s: %v", config.PersistentIdFile, err)
+		return
+	}
+	entry := persistentIdEntry{Id: id, IdCodeHash: hashIdCode(idCode)}
+	if ids[strings.ToLower(nick)] == entry {
+		return
+	}
+	ids[strings.ToLower(nick)] = entry
+	data, err := json.Marshal(ids)
+	if err == nil {
+		// write it all somewhere else first, so a crash or a full disk can't leave it half written
+		temp := config.PersistentIdFile + ".tmp"
+		if err = ioutil.WriteFile(temp, data, 0600); err == nil {
+			err = os.Rename(temp, config.PersistentIdFile)
+		}
+	}
+	if err != nil {
+		log.Errorf("Unable to save persistent IDs to %s: %v", config.PersistentIdFile, err)
+	}
+}
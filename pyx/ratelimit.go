@@ -0,0 +1,100 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package pyx
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Returned instead of making a request when a session is sending them too quickly.
+var ErrRateLimited = errors.New("Sending too quickly, slow down")
+
+// Keeps a single session from sending requests fast enough for the server to ban it. Requests over
+// the limit are held back for a little while, and rejected if they'd have to wait too long.
+type requestLimiter struct {
+	lock     sync.Mutex
+	capacity float64
+	// tokens added per second
+	rate    float64
+	tokens  float64
+	last    time.Time
+	maxWait time.Duration
+}
+
+// Returns nil if rate limiting is turned off.
+func newRequestLimiter(config *Config) *requestLimiter {
+	if config.RequestBurst < 0 {
+		return nil
+	}
+	return &requestLimiter{
+		capacity: float64(config.RequestBurst),
+		rate:     float64(config.RequestsPerMinute) / 60,
+		tokens:   float64(config.RequestBurst),
+		last:     time.Now(),
+		maxWait:  time.Duration(config.MaxRequestDelayMillis) * time.Millisecond,
+	}
+}
+
+// Reserves a spot for a request, returning how long to hold it back, or false if it would have to
+// wait longer than allowed.
+func (limiter *requestLimiter) reserve() (time.Duration, bool) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	now := time.Now()
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if limiter.tokens > limiter.capacity {
+		limiter.tokens = limiter.capacity
+	}
+	limiter.last = now
+
+	if limiter.tokens >= 1 {
+		limiter.tokens--
+		return 0, true
+	}
+	wait := time.Duration((1 - limiter.tokens) / limiter.rate * float64(time.Second))
+	if wait > limiter.maxWait {
+		return 0, false
+	}
+	// go into debt so anything else that's waiting lines up behind this one
+	limiter.tokens--
+	return wait, true
+}
+
+// Blocks until a request may be sent, or returns ErrRateLimited.
+func (limiter *requestLimiter) wait() error {
+	if limiter == nil {
+		return nil
+	}
+	wait, ok := limiter.reserve()
+	if !ok {
+		return ErrRateLimited
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Noticing when the server goes away, and when it comes back as a new instance that has forgotten us
+
+package pyx
+
+import (
+	"time"
+)
+
+// Not from the server: published when the long poll recovers after failing, saying whether anything
+// the server tried to send in the meantime may be gone.
+const LocalEvent_RECONNECTED = "_reconnected"
+
+// Not from the server: published once the long poll has failed a few times in a row, which usually
+// means the server is going down, probably to restart.
+const LocalEvent_SERVER_DOWN = "_server_down"
+
+// Not from the server: published right before the client stops when the server came back as a new
+// instance that doesn't know about our session, so the user has to log in all over again.
+const LocalEvent_SERVER_RESTARTED = "_server_restarted"
+
+// how many long polls in a row have to fail before we call the server down
+const serverDownFailures = 2
+
+type ReconnectedEvent struct {
+	EventHeader
+	// how long we weren't hearing from the server
+	Down time.Duration
+	// whether a poll failed in a way that could have lost events, rather than never getting to the
+	// server at all
+	Gap bool
+	// the timestamp of the last event from the server before things went wrong, or 0 if there
+	// wasn't one
+	LastTimestamp int64
+}
+
+type ServerDownEvent struct {
+	EventHeader
+	// when the long poll started failing
+	Since time.Time
+}
+
+type ServerRestartedEvent struct {
+	EventHeader
+	// when the new instance started, in milliseconds
+	ServerStarted int64
+}
+
+// Whether the server has restarted since we logged in, going by when it says it started. Returns
+// when the new instance started if it has.
+func (client *Client) restartedSince() (int64, bool) {
+	resp, err := client.sendNoErrorCheck(map[string]string{
+		AjaxRequest_OP: AjaxOperation_FIRST_LOAD,
+	})
+	if err != nil || resp.Error || resp.ServerStarted == 0 {
+		return 0, false
+	}
+	return resp.ServerStarted, resp.ServerStarted != client.ServerStarted
+}
@@ -0,0 +1,101 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Typed results for operations, so callers only see the fields that operation fills in
+
+package pyx
+
+import (
+	"fmt"
+)
+
+// An error reported by the server, as opposed to a problem talking to it.
+type Error struct {
+	Code string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("PYX error: %s", ErrorCodeMsgs[err.Code])
+}
+
+// The server's error code for err, or an empty string if the server didn't report it.
+func ErrorCode(err error) string {
+	if pyxErr, ok := err.(*Error); ok {
+		return pyxErr.Code
+	}
+	return ""
+}
+
+type WhoisResult struct {
+	Nickname    string
+	Sigil       string
+	IdCode      string
+	ClientName  string
+	IpAddress   string
+	ConnectedAt int64
+	Idle        int64
+	// both nil if they aren't in a game
+	GameId   *int
+	GameInfo *GameInfo
+}
+
+func newWhoisResult(resp *AjaxResponse) *WhoisResult {
+	result := &WhoisResult{
+		Nickname:    resp.Nickname,
+		Sigil:       resp.Sigil,
+		IdCode:      resp.IdCode,
+		ClientName:  resp.ClientName,
+		IpAddress:   resp.IpAddress,
+		ConnectedAt: resp.ConnectedAt,
+		Idle:        resp.Idle,
+		GameId:      resp.GameId,
+	}
+	if resp.GameId != nil {
+		result.GameInfo = &resp.GameInfo
+	}
+	return result
+}
+
+type GameListResult struct {
+	Games    []GameInfo
+	MaxGames int
+}
+
+func newGameListResult(resp *AjaxResponse) *GameListResult {
+	return &GameListResult{
+		Games:    resp.Games,
+		MaxGames: resp.MaxGames,
+	}
+}
+
+type GameInfoResult struct {
+	GameInfo   GameInfo
+	PlayerInfo []GamePlayerInfo
+}
+
+func newGameInfoResult(resp *AjaxResponse) *GameInfoResult {
+	return &GameInfoResult{
+		GameInfo:   resp.GameInfo,
+		PlayerInfo: resp.PlayerInfo,
+	}
+}
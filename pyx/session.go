@@ -0,0 +1,84 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package pyx
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PYX will have expired the session by this point anyway
+const savedSessionLifetime = 10 * time.Minute
+
+// A logged in session that nobody is using right now.
+type savedSession struct {
+	sessionId string
+	cookies   []*http.Cookie
+	idCode    string
+	saved     time.Time
+}
+
+var savedSessionsLock sync.Mutex
+var savedSessions = make(map[string]*savedSession)
+
+func savedSessionKey(config *Config, nick string) string {
+	return config.BaseAddress + " " + strings.ToLower(nick)
+}
+
+func saveSession(config *Config, nick string, session *savedSession) {
+	savedSessionsLock.Lock()
+	defer savedSessionsLock.Unlock()
+	savedSessions[savedSessionKey(config, nick)] = session
+}
+
+// If someone could pick a session for nick back up, so the nick being in use doesn't mean it's
+// taken.
+func HasSavedSession(config *Config, nick string) bool {
+	savedSessionsLock.Lock()
+	defer savedSessionsLock.Unlock()
+	session, ok := savedSessions[savedSessionKey(config, nick)]
+	return ok && time.Since(session.saved) <= savedSessionLifetime
+}
+
+// Removes and returns the saved session for nick, if there is one and it was logged in with the
+// same identification code. Anyone can ask for a nick, so the code is the only thing stopping
+// them from picking up somebody else's session.
+func takeSavedSession(config *Config, nick string, idCode string) *savedSession {
+	savedSessionsLock.Lock()
+	defer savedSessionsLock.Unlock()
+	for key, session := range savedSessions {
+		if time.Since(session.saved) > savedSessionLifetime {
+			delete(savedSessions, key)
+		}
+	}
+	key := savedSessionKey(config, nick)
+	session, ok := savedSessions[key]
+	if !ok || session.idCode != idCode {
+		return nil
+	}
+	delete(savedSessions, key)
+	return session
+}
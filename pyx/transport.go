@@ -0,0 +1,117 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package pyx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Every client talking to the same server shares one transport, so connections can be reused
+// between them instead of each user opening their own.
+var transports = struct {
+	lock     sync.Mutex
+	byServer map[string]*http.Transport
+}{byServer: make(map[string]*http.Transport)}
+
+func sharedTransport(config *Config) (*http.Transport, error) {
+	transports.lock.Lock()
+	defer transports.lock.Unlock()
+	if transport, ok := transports.byServer[config.BaseAddress]; ok {
+		return transport, nil
+	}
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	transports.byServer[config.BaseAddress] = transport
+	return transport, nil
+}
+
+func newTransport(config *Config) (*http.Transport, error) {
+	proxy := http.ProxyFromEnvironment
+	if config.Proxy != "" {
+		// http, https, and socks5 are all understood by the transport
+		proxyUrl, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy %s: %v", config.Proxy, err)
+		}
+		proxy = http.ProxyURL(proxyUrl)
+	}
+	tlsConfig, err := newTlsConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(config.DialTimeoutSeconds) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		Proxy:               proxy,
+		TLSClientConfig:     tlsConfig,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: time.Duration(config.TlsHandshakeTimeoutSeconds) * time.Second,
+		// every user has a long poll open all the time, plus whatever else they're doing
+		MaxIdleConns:        config.MaxIdleConns,
+		MaxIdleConnsPerHost: config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     config.MaxConnsPerHost,
+		IdleConnTimeout:     time.Duration(config.IdleConnTimeoutSeconds) * time.Second,
+		DisableKeepAlives:   config.DisableKeepAlives,
+		// a custom dialer turns this off unless we ask for it
+		ForceAttemptHTTP2:     config.Http2,
+		ExpectContinueTimeout: time.Second,
+	}, nil
+}
+
+func newTlsConfig(config *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if config.CaFile != "" {
+		pem, err := ioutil.ReadFile(config.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read CA file: %v", err)
+		}
+		// trust the system's CAs too, in case the bundle only has the private one
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", config.CaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.InsecureSkipVerify {
+		log.Warningf("Not verifying the certificate for %s, this is insecure!", config.BaseAddress)
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
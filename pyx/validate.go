@@ -0,0 +1,143 @@
+/**
+ * Copyright (c) 2018, Andy Janata
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted
+ * provided that the following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions
+ *   and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of
+ *   conditions and the following disclaimer in the documentation and/or other materials provided
+ *   with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND
+ * FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+ * CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY
+ * WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// The rules PYX applies to nicks and id codes, so they can be checked before we try to log in
+
+package pyx
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+)
+
+// what PYX itself uses, unless it's been changed in its configuration
+const DefaultNickPattern = "[a-zA-Z_][a-zA-Z0-9_]{2,29}"
+
+var defaultNickRegex = regexp.MustCompile("^(?:" + DefaultNickPattern + ")$")
+
+// pattern -> compiled regex, so each client doesn't have to compile it again
+var nickRegexes sync.Map
+
+func (config *Config) nickRegex() *regexp.Regexp {
+	if re, ok := nickRegexes.Load(config.NickPattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile("^(?:" + config.NickPattern + ")$")
+	if err != nil {
+		log.Errorf("Invalid nick pattern %s, using the default: %v", config.NickPattern, err)
+		re = defaultNickRegex
+	}
+	nickRegexes.Store(config.NickPattern, re)
+	return re
+}
+
+// Checks nick the same way PYX will when we register with it. Returns nil if it's fine, or an
+// *Error with the code PYX would have given us.
+func (config *Config) ValidateNick(nick string) error {
+	if nick == "" {
+		return &Error{Code: ErrorCode_NO_NICK_SPECIFIED}
+	}
+	if !config.nickRegex().MatchString(nick) {
+		return &Error{Code: ErrorCode_INVALID_NICK}
+	}
+	for _, reserved := range config.ReservedNicks {
+		if strings.EqualFold(nick, reserved) {
+			return &Error{Code: ErrorCode_RESERVED_NICK}
+		}
+	}
+	return nil
+}
+
+// Checks an id code the same way PYX will. An empty one is always fine since it's optional.
+func (config *Config) ValidateIdCode(idCode string) error {
+	if idCode == "" {
+		return nil
+	}
+	// pyx counts characters, not bytes
+	length := len([]rune(idCode))
+	if length < config.MinIdCodeLength || length > config.MaxIdCodeLength {
+		return &Error{Code: ErrorCode_INVALID_ID_CODE}
+	}
+	return nil
+}
+
+// The longest nick the pattern allows, or 0 if it doesn't have a limit.
+func (config *Config) MaxNickLength() int {
+	re, err := syntax.Parse(config.nickRegex().String(), syntax.Perl)
+	if err != nil {
+		return 0
+	}
+	length := maxMatchLength(re.Simplify())
+	if length < 0 {
+		return 0
+	}
+	return length
+}
+
+// -1 if there's no limit
+func maxMatchLength(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune)
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return 1
+	case syntax.OpCapture:
+		return maxMatchLength(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus:
+		return -1
+	case syntax.OpQuest:
+		return maxMatchLength(re.Sub[0])
+	case syntax.OpRepeat:
+		sub := maxMatchLength(re.Sub[0])
+		if sub < 0 || re.Max < 0 {
+			return -1
+		}
+		return sub * re.Max
+	case syntax.OpConcat:
+		total := 0
+		for _, sub := range re.Sub {
+			length := maxMatchLength(sub)
+			if length < 0 {
+				return -1
+			}
+			total += length
+		}
+		return total
+	case syntax.OpAlternate:
+		longest := 0
+		for _, sub := range re.Sub {
+			length := maxMatchLength(sub)
+			if length < 0 {
+				return -1
+			}
+			if length > longest {
+				longest = length
+			}
+		}
+		return longest
+	}
+	// anchors and empty matches
+	return 0
+}